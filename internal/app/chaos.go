@@ -0,0 +1,146 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/BarrettBr/RWND/internal/config"
+	"github.com/BarrettBr/RWND/internal/datastore"
+	"github.com/BarrettBr/RWND/internal/diff"
+	"github.com/BarrettBr/RWND/internal/logpath"
+	"github.com/BarrettBr/RWND/internal/replay"
+)
+
+// RunChaos replays each record of a log that passes the tag and --filter
+// filters with seeded perturbations: independent requests shuffled, some sent
+// twice or retried, and random delays. It prints every request as it is sent
+// with what differs from the recording.
+func RunChaos(cfg config.AppConfig) error {
+	logPath, err := logpath.ResolveReplayPath(cfg.LogPath)
+	if err != nil {
+		return err
+	}
+	keep, err := recordFilter(cfg)
+	if err != nil {
+		return err
+	}
+	captures, err := loadCaptures(cfg)
+	if err != nil {
+		return err
+	}
+	auth, err := replayAuth(cfg, logPath)
+	if err != nil {
+		return err
+	}
+
+	store, err := datastore.OpenFileStore(logPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	engine, err := replay.NewWithOptions(store, replay.Options{
+		Filter:      keep,
+		Target:      cfg.ReplayTarget,
+		Captures:    captures,
+		CookieJar:   cfg.CookieJar,
+		DropCookies: cfg.DropCookies,
+		Auth:        auth,
+		Diff: diff.Rules{
+			IgnoreHeaders: cfg.IgnoreHeaders,
+			IgnoreJSON:    cfg.IgnoreJSON,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	seed := cfg.ChaosSeed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	// Printed first so a run that hangs or crashes can still be repeated
+	fmt.Printf("Chaos seed %d (--seed %d repeats it)\n", seed, seed)
+
+	var sent, differ, notIdempotent, failed int
+	err = engine.Chaos(replay.Chaos{
+		Seed:      seed,
+		Shuffle:   cfg.Shuffle,
+		Duplicate: cfg.Duplicate,
+		Retry:     cfg.Retry,
+		Jitter:    cfg.Jitter,
+	}, func(step replay.ChaosStep) {
+		sent++
+		switch {
+		case step.Err != nil:
+			failed++
+		case len(step.Differences) > 0:
+			differ++
+		}
+		if len(step.Duplicate) > 0 {
+			notIdempotent++
+		}
+		writeChaosStep(step)
+	})
+	if err != nil {
+		return err
+	}
+	if sent == 0 {
+		return fmt.Errorf("No matching records")
+	}
+
+	summary := fmt.Sprintf("Chaos replay of %d requests with seed %d: %d differ from the recording, %d differ when sent twice", sent, seed, differ, notIdempotent)
+	if failed > 0 {
+		summary += fmt.Sprintf(", %d failed", failed)
+	}
+	fmt.Fprintln(os.Stderr, summary)
+
+	if differ > 0 || notIdempotent > 0 || failed > 0 {
+		return fmt.Errorf("Chaos replay found problems, --seed %d repeats the run", seed)
+	}
+	return nil
+}
+
+func writeChaosStep(step replay.ChaosStep) {
+	// One line per request with what was done to it, then what went wrong
+	var notes []string
+	if step.Index != step.Order {
+		notes = append(notes, fmt.Sprintf("recorded %d", step.Index))
+	}
+	if step.Delay > 0 {
+		notes = append(notes, "delayed "+step.Delay.Round(time.Millisecond).String())
+	}
+	if step.Retried {
+		notes = append(notes, "retried")
+	}
+	if step.Duplicated {
+		notes = append(notes, "duplicated")
+	}
+
+	status := "failed"
+	if step.Response != nil {
+		status = fmt.Sprint(step.Response.Response.Status)
+	}
+	line := fmt.Sprintf("%4d  #%d %s %s -> %s", step.Order, step.Record.ID, step.Record.Request.Method, step.Record.Request.URL, status)
+	if len(notes) > 0 {
+		line += " (" + strings.Join(notes, ", ") + ")"
+	}
+	fmt.Println(line)
+
+	if step.Err != nil {
+		fmt.Printf("        replay failed: %v\n", step.Err)
+	}
+	writeChaosDiffs("", step.Differences)
+	writeChaosDiffs("sent twice, ", step.Duplicate)
+}
+
+func writeChaosDiffs(prefix string, diffs []diff.Difference) {
+	for i, d := range diffs {
+		if i == maxViolationLines {
+			fmt.Printf("        ... %d more\n", len(diffs)-i)
+			return
+		}
+		fmt.Printf("        %s%s\n", prefix, d)
+	}
+}
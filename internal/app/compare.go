@@ -0,0 +1,273 @@
+package app
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/BarrettBr/RWND/internal/capture"
+	"github.com/BarrettBr/RWND/internal/config"
+	"github.com/BarrettBr/RWND/internal/datastore"
+	"github.com/BarrettBr/RWND/internal/diff"
+	"github.com/BarrettBr/RWND/internal/logpath"
+	"github.com/BarrettBr/RWND/internal/model"
+	"github.com/BarrettBr/RWND/internal/replay"
+	"github.com/BarrettBr/RWND/internal/route"
+)
+
+// maxFieldsShown caps the differing fields listed per route in the table.
+const maxFieldsShown = 3
+
+// CompareReport is the result of replaying a log against two deployments.
+type CompareReport struct {
+	Log        string
+	A, B       string
+	Records    int
+	Mismatched int // Requests whose two responses differ
+	Failed     int // Requests that couldn't be replayed against one of the deployments
+	Routes     []CompareRoute
+}
+
+// CompareRoute counts the mismatches of one method and templated route.
+type CompareRoute struct {
+	Method     string
+	Route      string // Path with numeric and UUID segments replaced, see package route
+	Count      int
+	Mismatched int
+	Failed     int
+	Fields     map[string]int   // Differing field -> requests it differed in
+	Examples   []CompareExample `json:",omitempty"`
+}
+
+// CompareExample is one request whose responses differ, or that failed.
+type CompareExample struct {
+	ID          uint64
+	Method      string
+	URL         string
+	Differences []diff.Difference `json:",omitempty"` // Old is deployment A's value, New is B's
+	Error       string            `json:",omitempty"`
+}
+
+func (r *CompareRoute) example(ex CompareExample, limit int) {
+	if len(r.Examples) < limit {
+		r.Examples = append(r.Examples, ex)
+	}
+}
+
+// ------------
+
+// RunCompare replays each record of a log that passes the tag and --filter
+// filters against two deployments and compares their live responses with each
+// other, skipping the same fields as replay's diff.
+func RunCompare(cfg config.AppConfig) error {
+	logPath, err := logpath.ResolveReplayPath(cfg.LogPath)
+	if err != nil {
+		return err
+	}
+	keep, err := recordFilter(cfg)
+	if err != nil {
+		return err
+	}
+	captures, err := loadCaptures(cfg)
+	if err != nil {
+		return err
+	}
+
+	store, err := datastore.OpenFileStore(logPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	// One engine per deployment so each keeps its own tokens, cookies and
+	// captured values. Only a steps through the store, b just replays.
+	a, err := compareEngine(cfg, store, logPath, cfg.CompareA, keep, captures)
+	if err != nil {
+		return err
+	}
+	b, err := compareEngine(cfg, store, logPath, cfg.CompareB, nil, captures)
+	if err != nil {
+		return err
+	}
+	rules := diff.Rules{IgnoreHeaders: cfg.IgnoreHeaders, IgnoreJSON: cfg.IgnoreJSON}
+
+	report := CompareReport{Log: logPath, A: cfg.CompareA.String(), B: cfg.CompareB.String()}
+	routes := make(map[string]*CompareRoute)
+	for {
+		rec, err := a.Step()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		report.Records++
+
+		tmpl := route.FromURL(rec.Request.URL)
+		key := rec.Request.Method + " " + tmpl
+		r, ok := routes[key]
+		if !ok {
+			r = &CompareRoute{Method: rec.Request.Method, Route: tmpl, Fields: make(map[string]int)}
+			routes[key] = r
+		}
+		r.Count++
+		ex := CompareExample{ID: rec.ID, Method: rec.Request.Method, URL: rec.Request.URL}
+
+		fromA, errA := a.Replay(*rec)
+		fromB, errB := b.Replay(*rec)
+		if errA != nil || errB != nil {
+			report.Failed++
+			r.Failed++
+			var msgs []string
+			if errA != nil {
+				msgs = append(msgs, "a: "+errA.Error())
+			}
+			if errB != nil {
+				msgs = append(msgs, "b: "+errB.Error())
+			}
+			ex.Error = strings.Join(msgs, "; ")
+			r.example(ex, cfg.Examples)
+			continue
+		}
+
+		diffs := diff.Compare(fromA.Response, fromB.Response, rules)
+		if len(diffs) == 0 {
+			continue
+		}
+		report.Mismatched++
+		r.Mismatched++
+		for _, d := range diffs {
+			r.Fields[d.Field]++
+		}
+		ex.Differences = diffs
+		r.example(ex, cfg.Examples)
+	}
+
+	report.Routes = make([]CompareRoute, 0, len(routes))
+	for _, r := range routes {
+		report.Routes = append(report.Routes, *r)
+	}
+	slices.SortFunc(report.Routes, func(x, y CompareRoute) int {
+		// Most mismatches first, then route and method so output is stable
+		c := (y.Mismatched + y.Failed) - (x.Mismatched + x.Failed)
+		if c == 0 {
+			c = strings.Compare(x.Route, y.Route)
+		}
+		if c == 0 {
+			c = strings.Compare(x.Method, y.Method)
+		}
+		return c
+	})
+
+	if report.Records == 0 {
+		return fmt.Errorf("No matching records")
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	if cfg.Format == "json" {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(report)
+	} else {
+		err = writeCompareReport(out, report)
+	}
+	if err != nil {
+		return err
+	}
+	if err := out.Flush(); err != nil {
+		return err
+	}
+
+	summary := fmt.Sprintf("Compared %d requests between %s and %s: %d mismatched", report.Records, report.A, report.B, report.Mismatched)
+	if report.Failed > 0 {
+		summary += fmt.Sprintf(", %d failed", report.Failed)
+	}
+	fmt.Fprintln(os.Stderr, summary)
+
+	if report.Mismatched > 0 || report.Failed > 0 {
+		return fmt.Errorf("%d of %d requests differ between %s and %s", report.Mismatched+report.Failed, report.Records, report.A, report.B)
+	}
+	return nil
+}
+
+func compareEngine(cfg config.AppConfig, store replay.Store, logPath string, target *url.URL, keep func(model.Record) bool, captures []capture.Rule) (*replay.Engine, error) {
+	auth, err := replayAuth(cfg, logPath)
+	if err != nil {
+		return nil, err
+	}
+	return replay.NewWithOptions(store, replay.Options{
+		Filter:      keep,
+		Target:      target,
+		Captures:    captures,
+		CookieJar:   cfg.CookieJar,
+		DropCookies: cfg.DropCookies,
+		Auth:        auth,
+	})
+}
+
+func writeCompareReport(w io.Writer, report CompareReport) error {
+	fmt.Fprintf(w, "%s: %s (a) vs %s (b), %d requests, %d routes\n\n", report.Log, report.A, report.B, report.Records, len(report.Routes))
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "METHOD\tROUTE\tCOUNT\tMISMATCH\tFAILED\tFIELDS")
+	for _, r := range report.Routes {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\t%s\n", r.Method, r.Route, r.Count, r.Mismatched, r.Failed, dash(topFields(r.Fields)))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	for _, r := range report.Routes {
+		if len(r.Examples) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "\n%s %s\n", r.Method, r.Route)
+		for _, ex := range r.Examples {
+			fmt.Fprintf(w, "  #%d %s %s\n", ex.ID, ex.Method, ex.URL)
+			if ex.Error != "" {
+				fmt.Fprintf(w, "    replay failed: %s\n", ex.Error)
+			}
+			for i, d := range ex.Differences {
+				if i == maxViolationLines {
+					fmt.Fprintf(w, "    ... %d more\n", len(ex.Differences)-i)
+					break
+				}
+				fmt.Fprintf(w, "    %s: a %q, b %q\n", d.Field, d.Old, d.New)
+			}
+		}
+	}
+	return nil
+}
+
+func topFields(fields map[string]int) string {
+	// The most common differing fields with their counts, "status 4, json total 2"
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	slices.SortFunc(names, func(x, y string) int {
+		if c := fields[y] - fields[x]; c != 0 {
+			return c
+		}
+		return strings.Compare(x, y)
+	})
+
+	parts := make([]string, 0, maxFieldsShown+1)
+	for i, name := range names {
+		if i == maxFieldsShown {
+			parts = append(parts, "+"+strconv.Itoa(len(names)-i)+" more")
+			break
+		}
+		parts = append(parts, fmt.Sprintf("%s %d", name, fields[name]))
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,64 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/BarrettBr/RWND/internal/config"
+	"github.com/BarrettBr/RWND/internal/datastore"
+	"github.com/BarrettBr/RWND/internal/logpath"
+	"github.com/BarrettBr/RWND/internal/model"
+)
+
+// RunConvert copies every record of one log into another, re-encoding by the
+// output extension (.jsonl or .rwb, optionally .gz / .zst).
+func RunConvert(cfg config.AppConfig) error {
+	in, out := cfg.Args[0], cfg.Args[1]
+	if _, err := os.Stat(in); err != nil {
+		return err
+	}
+	// Refuse to append onto an existing log, converting twice would duplicate records.
+	// Leftover parts count too, the store would carry on in the latest one
+	parts, err := logpath.SessionFiles(logpath.PartPath(out, 1))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if len(parts) > 0 {
+		return fmt.Errorf("Output %s already exists", parts[0])
+	}
+
+	// Keep what the source header says about the recording, the store restamps schema and
+	// version and the layout comes from the output
+	h, _, err := datastore.ReadHeader(in)
+	if err != nil {
+		return err
+	}
+	h.Capture = datastore.WithLayout(h.Capture, out, cfg.DedupBodies)
+	opts := datastore.FileOptions{DedupBodies: cfg.DedupBodies, Header: &h}
+
+	dst, err := datastore.NewFileStoreWithOptions(out, opts)
+	if err != nil {
+		return err
+	}
+
+	keep := tagFilter(cfg.Tags)
+	count := 0
+	convErr := eachRecord(in, func(rec model.Record) error {
+		if keep != nil && !keep(rec) {
+			return nil
+		}
+		count++
+		return dst.Append(rec)
+	})
+	closeErr := dst.Close()
+	if convErr != nil {
+		return convErr
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+
+	fmt.Printf("Converted %d records to %s\n", count, out)
+	return nil
+}
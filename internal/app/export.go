@@ -0,0 +1,59 @@
+package app
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+
+	"github.com/BarrettBr/RWND/internal/config"
+	"github.com/BarrettBr/RWND/internal/datastore"
+	"github.com/BarrettBr/RWND/internal/logpath"
+)
+
+// RunExport writes the records of a log that pass the tag and --filter filters as JSONL.
+func RunExport(cfg config.AppConfig) error {
+	logPath, err := logpath.ResolveReplayPath(cfg.LogPath)
+	if err != nil {
+		return err
+	}
+
+	keep, err := recordFilter(cfg)
+	if err != nil {
+		return err
+	}
+
+	store, err := datastore.OpenFileStore(logPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	var out io.Writer = os.Stdout
+	if cfg.OutPath != "" {
+		f, err := os.OpenFile(cfg.OutPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+
+	buf := bufio.NewWriter(out)
+	enc := json.NewEncoder(buf)
+
+	recCh, errCh := store.Stream()
+	for rec := range recCh {
+		if keep != nil && !keep(rec) {
+			continue
+		}
+		if err := enc.Encode(rec); err != nil {
+			return err
+		}
+	}
+	if err := <-errCh; err != nil {
+		return err
+	}
+
+	return buf.Flush()
+}
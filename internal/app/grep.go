@@ -0,0 +1,198 @@
+package app
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/BarrettBr/RWND/internal/config"
+	"github.com/BarrettBr/RWND/internal/datastore"
+	"github.com/BarrettBr/RWND/internal/logpath"
+	"github.com/BarrettBr/RWND/internal/model"
+	"github.com/BarrettBr/RWND/internal/search"
+)
+
+// indexFile is the name of the search index inside a session's index directory.
+const indexFile = "trigrams"
+
+// RunGrep prints the records of a log whose bodies, headers or URL match the pattern
+// in cfg.Args and that pass the tag and --filter filters. A fresh search index
+// narrows the records that are searched, --index builds or refreshes it.
+func RunGrep(cfg config.AppConfig) error {
+	logPath, err := logpath.ResolveReplayPath(cfg.LogPath)
+	if err != nil {
+		return err
+	}
+	keep, err := recordFilter(cfg)
+	if err != nil {
+		return err
+	}
+
+	var pattern string
+	var searcher *search.Searcher
+	if len(cfg.Args) == 1 {
+		pattern = cfg.Args[0]
+		if cfg.FixedString {
+			pattern = regexp.QuoteMeta(pattern)
+		}
+		if cfg.IgnoreCase {
+			pattern = "(?i)" + pattern
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("Invalid pattern: %v", err)
+		}
+		if searcher, err = search.New(re, cfg.SearchFields, cfg.Context); err != nil {
+			return err
+		}
+	}
+
+	size, err := sessionSize(logPath)
+	if err != nil {
+		return err
+	}
+	// The index of an encrypted log is sealed with the log's key
+	var key []byte
+	if strings.HasSuffix(logPath, datastore.EncryptExt) {
+		if key, err = datastore.LoadKey(); err != nil {
+			return err
+		}
+	}
+	indexPath := filepath.Join(logpath.IndexDir(logPath), indexFile)
+	ix := loadIndex(indexPath, key, size, cfg.UseIndex)
+
+	var build *search.Index
+	if cfg.UseIndex && ix == nil {
+		build = search.NewIndex(size)
+	}
+
+	var candidates []uint32
+	narrowed := false
+	if ix != nil && searcher != nil {
+		candidates, narrowed = ix.Candidates(pattern)
+	}
+	if narrowed && len(candidates) == 0 {
+		return fmt.Errorf("No matching records")
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	enc := json.NewEncoder(out)
+
+	n, next, matches := 0, 0, 0
+	err = eachRecord(logPath, func(rec model.Record) error {
+		pos := uint32(n)
+		n++
+		if build != nil {
+			build.Add(rec)
+		}
+		// Records appended after the index was built aren't in it and are always searched
+		if narrowed && int(pos) < ix.Records {
+			for next < len(candidates) && candidates[next] < pos {
+				next++
+			}
+			if next == len(candidates) || candidates[next] != pos {
+				return nil
+			}
+		}
+		if keep != nil && !keep(rec) {
+			return nil
+		}
+
+		var hits []search.Hit
+		if searcher != nil {
+			if hits = searcher.Search(rec); len(hits) == 0 {
+				return nil
+			}
+		}
+		matches++
+
+		switch cfg.Format {
+		case "ids":
+			_, err := fmt.Fprintln(out, rec.ID)
+			return err
+		case "json":
+			return enc.Encode(rec)
+		default:
+			return writeGrepMatch(out, rec, hits)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	if build != nil {
+		if err := os.MkdirAll(filepath.Dir(indexPath), 0700); err != nil {
+			return err
+		}
+		if err := build.WriteFile(indexPath, key); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Indexed %d records in %s\n", build.Records, filepath.Dir(indexPath))
+	}
+
+	if matches == 0 {
+		return fmt.Errorf("No matching records")
+	}
+	return nil
+}
+
+func loadIndex(path string, key []byte, size int64, rebuild bool) *search.Index {
+	// Returns the index at path if it covers the log as it is now. A stale or
+	// unreadable index is ignored, and rebuilt when rebuild is set.
+	ix, err := search.ReadIndex(path, key)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err == nil && ix.Size == size {
+		return ix
+	}
+	if !rebuild {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Ignoring search index: %v\n", err)
+		} else {
+			fmt.Fprintln(os.Stderr, "Search index is out of date, ignoring it (rebuild with --index)")
+		}
+	}
+	return nil
+}
+
+func sessionSize(path string) (int64, error) {
+	// Total size of every part of a session, an index is fresh while this is unchanged
+	parts, err := logpath.SessionFiles(path)
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, part := range parts {
+		info, err := os.Stat(part)
+		if err != nil {
+			return 0, err
+		}
+		total += info.Size()
+	}
+	return total, nil
+}
+
+func writeGrepMatch(w io.Writer, rec model.Record, hits []search.Hit) error {
+	if err := writeRecordLine(w, rec); err != nil {
+		return err
+	}
+	for _, hit := range hits {
+		label := hit.Field
+		if hit.Count > 1 {
+			label = fmt.Sprintf("%s (%d)", hit.Field, hit.Count)
+		}
+		if _, err := fmt.Fprintf(w, "    %s: %s\n", label, hit.Context); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/BarrettBr/RWND/internal/config"
+	"github.com/BarrettBr/RWND/internal/logpath"
+	"github.com/BarrettBr/RWND/internal/model"
+	"github.com/BarrettBr/RWND/internal/openapi"
+)
+
+// RunInferOpenAPI writes an OpenAPI document inferred from the records of a log
+// that pass the tag and --filter filters.
+func RunInferOpenAPI(cfg config.AppConfig) error {
+	logPath, err := logpath.ResolveReplayPath(cfg.LogPath)
+	if err != nil {
+		return err
+	}
+	keep, err := recordFilter(cfg)
+	if err != nil {
+		return err
+	}
+
+	b := openapi.NewBuilder()
+	err = eachRecord(logPath, func(rec model.Record) error {
+		if keep == nil || keep(rec) {
+			b.Add(rec)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if b.Records() == 0 {
+		return fmt.Errorf("No matching records")
+	}
+
+	title := cfg.Title
+	if title == "" {
+		title = filepath.Base(logPath)
+	}
+	doc := b.Document(openapi.Info{
+		Title:       title,
+		Description: fmt.Sprintf("Inferred by rwnd from %d recorded requests in %s", b.Records(), filepath.Base(logPath)),
+		Version:     "0.0.0",
+	})
+	data, err := openapi.Marshal(doc, cfg.Format == "json")
+	if err != nil {
+		return err
+	}
+
+	if cfg.OutPath == "" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+	if err := os.WriteFile(cfg.OutPath, data, 0600); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Wrote %d paths from %d records to %s\n", len(doc.Paths), b.Records(), cfg.OutPath)
+	return nil
+}
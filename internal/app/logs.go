@@ -0,0 +1,470 @@
+package app
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/BarrettBr/RWND/internal/config"
+	"github.com/BarrettBr/RWND/internal/datastore"
+	"github.com/BarrettBr/RWND/internal/logpath"
+	"github.com/BarrettBr/RWND/internal/model"
+)
+
+// SessionSummary aggregates the records of one log file.
+type SessionSummary struct {
+	Records       int
+	First, Last   time.Time
+	Methods       map[string]int
+	StatusClasses map[string]int // "2xx", "4xx", ...
+	Tags          map[string]int
+	Errors        int // Responses with status >= 500
+	TotalLatency  time.Duration
+	MaxLatency    time.Duration
+	RequestBytes  int64
+	ResponseBytes int64
+}
+
+func (s *SessionSummary) add(rec model.Record) {
+	if s.Records == 0 || rec.Timestamp.Before(s.First) {
+		s.First = rec.Timestamp
+	}
+	if rec.Timestamp.After(s.Last) {
+		s.Last = rec.Timestamp
+	}
+	s.Records++
+	s.Methods[rec.Request.Method]++
+	s.StatusClasses[fmt.Sprintf("%dxx", rec.Response.Status/100)]++
+	for _, tag := range rec.Tags {
+		s.Tags[tag]++
+	}
+	if rec.Response.Status >= 500 {
+		s.Errors++
+	}
+	s.TotalLatency += rec.Latency
+	if rec.Latency > s.MaxLatency {
+		s.MaxLatency = rec.Latency
+	}
+	s.RequestBytes += int64(len(rec.Request.Body))
+	s.ResponseBytes += int64(len(rec.Response.Body))
+}
+
+// Summarize reads every record of a log file into a SessionSummary.
+func Summarize(path string) (SessionSummary, error) {
+	s := SessionSummary{
+		Methods:       make(map[string]int),
+		StatusClasses: make(map[string]int),
+		Tags:          make(map[string]int),
+	}
+	err := eachRecord(path, func(rec model.Record) error {
+		s.add(rec)
+		return nil
+	})
+	return s, err
+}
+
+func eachRecord(path string, fn func(model.Record) error) error {
+	// Streams every record of a log file into fn
+	store, err := datastore.OpenFileStore(path)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	recCh, errCh := store.Stream()
+	for rec := range recCh {
+		if err := fn(rec); err != nil {
+			// Drain so the stream goroutine can exit
+			for range recCh {
+			}
+			return err
+		}
+	}
+	return <-errCh
+}
+
+func logDir(path string) string {
+	// Log commands work on directories, a file path means its parent
+	if info, err := os.Stat(path); err == nil && !info.IsDir() {
+		return filepath.Dir(path)
+	}
+	return path
+}
+
+func seqArg(args []string) (int, error) {
+	if len(args) != 1 {
+		return 0, fmt.Errorf("Expected a log number, e.g. rwnd logs show 3")
+	}
+	n, err := strconv.Atoi(strings.TrimLeft(args[0], "#"))
+	if err != nil {
+		return 0, fmt.Errorf("Invalid log number %q", args[0])
+	}
+	return n, nil
+}
+
+// ------------
+
+// RunLogsList prints one line per log file with its metadata, record count and size.
+func RunLogsList(cfg config.AppConfig) error {
+	files, err := logpath.ListLogFiles(logDir(cfg.LogPath))
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		fmt.Println("No log files found")
+		return nil
+	}
+
+	// Encrypted logs can't be counted without the key, that doesn't make them corrupt
+	_, keyErr := datastore.LoadKey()
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "#\tSTARTED\tTARGET\tLISTEN\tRECORDS\tSIZE")
+	for _, lf := range files {
+		count := 0
+		countErr := eachRecord(lf.Path, func(model.Record) error {
+			count++
+			return nil
+		})
+		records := strconv.Itoa(count)
+		switch {
+		case countErr == nil:
+		case keyErr != nil && strings.HasSuffix(lf.Path, datastore.EncryptExt):
+			records = "encrypted"
+		default:
+			records += " (corrupt)"
+		}
+		fmt.Fprintf(tw, "%03d\t%s\t%s\t%s\t%s\t%s\n",
+			lf.Seq, formatTime(lf.Start), dash(lf.Target), dash(lf.Listen), records, formatSize(lf.Size))
+	}
+	return tw.Flush()
+}
+
+// RunLogsShow prints the records of one numbered log file.
+func RunLogsShow(cfg config.AppConfig) error {
+	seq, err := seqArg(cfg.Args)
+	if err != nil {
+		return err
+	}
+	lf, err := logpath.FindLogFile(logDir(cfg.LogPath), seq)
+	if err != nil {
+		return err
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+
+	if cfg.Format == "json" {
+		enc := json.NewEncoder(out)
+		return eachRecord(lf.Path, func(rec model.Record) error { return enc.Encode(rec) })
+	}
+	return eachRecord(lf.Path, func(rec model.Record) error {
+		return writeRecordLine(out, rec)
+	})
+}
+
+// FormatRecordLine renders a record as one compact line: id, time, method, url, status, latency and tags.
+func FormatRecordLine(rec model.Record) string {
+	line := fmt.Sprintf("#%d %s %s %s -> %d %s",
+		rec.ID, formatTime(rec.Timestamp), rec.Request.Method, rec.Request.URL, rec.Response.Status, rec.Latency.Round(time.Millisecond))
+	if len(rec.Tags) > 0 {
+		line += " [" + strings.Join(rec.Tags, ",") + "]"
+	}
+	return line
+}
+
+func writeRecordLine(w io.Writer, rec model.Record) error {
+	_, err := fmt.Fprintln(w, FormatRecordLine(rec))
+	return err
+}
+
+// RunLogsInfo prints summary stats for one log file, or every log file without an argument.
+func RunLogsInfo(cfg config.AppConfig) error {
+	dir := logDir(cfg.LogPath)
+
+	var files []logpath.LogFile
+	if len(cfg.Args) > 0 {
+		seq, err := seqArg(cfg.Args)
+		if err != nil {
+			return err
+		}
+		lf, err := logpath.FindLogFile(dir, seq)
+		if err != nil {
+			return err
+		}
+		files = []logpath.LogFile{lf}
+	} else {
+		var err error
+		if files, err = logpath.ListLogFiles(dir); err != nil {
+			return err
+		}
+	}
+
+	for i, lf := range files {
+		if i > 0 {
+			fmt.Println()
+		}
+		s, err := Summarize(lf.Path)
+		if err != nil {
+			return fmt.Errorf("%s: %w", lf.Name, err)
+		}
+		printSummary(lf, s)
+
+		h, ok, err := datastore.ReadHeader(lf.Path)
+		if err != nil {
+			return fmt.Errorf("%s: %w", lf.Name, err)
+		}
+		printHeader(h, ok)
+	}
+	return nil
+}
+
+func printHeader(h model.SessionHeader, ok bool) {
+	if !ok {
+		fmt.Printf("  Schema:    1 (no header)\n")
+		return
+	}
+	fmt.Printf("  Schema:    %d, written by rwnd %s\n", h.Schema, h.Version)
+	c := h.Capture
+	var opts []string
+	if c.Format != "" {
+		opts = append(opts, "format="+c.Format)
+	}
+	if c.Compress != "" {
+		opts = append(opts, "compress="+c.Compress)
+	}
+	if c.Encrypt {
+		opts = append(opts, "encrypted")
+	}
+	if len(c.RedactHeaders) > 0 {
+		opts = append(opts, "redact="+strings.Join(c.RedactHeaders, ","))
+	}
+	if c.MaxBodyBytes > 0 {
+		opts = append(opts, "max-body="+formatSize(c.MaxBodyBytes))
+	}
+	if c.DedupBodies {
+		opts = append(opts, "dedup-bodies")
+	}
+	if c.Rules != "" {
+		opts = append(opts, "rules="+c.Rules)
+	}
+	if c.Sync != "" {
+		opts = append(opts, "sync="+c.Sync)
+	}
+	if len(opts) > 0 {
+		fmt.Printf("  Capture:   %s\n", strings.Join(opts, " "))
+	}
+}
+
+func printSummary(lf logpath.LogFile, s SessionSummary) {
+	fmt.Printf("Log %03d  %s\n", lf.Seq, lf.Name)
+	fmt.Printf("  Target:    %s (listen %s)\n", dash(lf.Target), dash(lf.Listen))
+	fmt.Printf("  Size:      %s\n", formatSize(lf.Size))
+	fmt.Printf("  Records:   %d\n", s.Records)
+	if s.Records == 0 {
+		return
+	}
+	fmt.Printf("  Span:      %s -> %s (%s)\n", formatTime(s.First), formatTime(s.Last), s.Last.Sub(s.First).Round(time.Second))
+	fmt.Printf("  Methods:   %s\n", formatCounts(s.Methods))
+	fmt.Printf("  Statuses:  %s\n", formatCounts(s.StatusClasses))
+	fmt.Printf("  Errors:    %d\n", s.Errors)
+	fmt.Printf("  Latency:   avg %s, max %s\n",
+		(s.TotalLatency / time.Duration(s.Records)).Round(time.Millisecond), s.MaxLatency.Round(time.Millisecond))
+	fmt.Printf("  Bodies:    %s requests, %s responses\n", formatSize(s.RequestBytes), formatSize(s.ResponseBytes))
+	if len(s.Tags) > 0 {
+		fmt.Printf("  Tags:      %s\n", formatCounts(s.Tags))
+	}
+}
+
+// RunLogsVerify decodes one log, or every log without an argument, and reports
+// corrupt records with their byte offsets. It fails if any log is corrupt.
+func RunLogsVerify(cfg config.AppConfig) error {
+	dir := logDir(cfg.LogPath)
+
+	var files []logpath.LogFile
+	if len(cfg.Args) > 0 {
+		seq, err := seqArg(cfg.Args)
+		if err != nil {
+			return err
+		}
+		lf, err := logpath.FindLogFile(dir, seq)
+		if err != nil {
+			return err
+		}
+		files = []logpath.LogFile{lf}
+	} else {
+		var err error
+		if files, err = logpath.ListLogFiles(dir); err != nil {
+			return err
+		}
+	}
+
+	bad := 0
+	for _, lf := range files {
+		res, err := datastore.Verify(lf.Path)
+		if err != nil {
+			return fmt.Errorf("%s: %w", lf.Name, err)
+		}
+		if len(res.Corrupt) == 0 {
+			fmt.Printf("%s: %d records, OK\n", lf.Name, res.Records)
+			continue
+		}
+
+		bad++
+		fmt.Printf("%s: %d records, %d corrupt\n", lf.Name, res.Records, len(res.Corrupt))
+		for _, cre := range res.Corrupt {
+			kind := "corrupt"
+			if cre.Torn {
+				kind = "torn (cut off at end of file)"
+			}
+			fmt.Printf("  %s offset %d: %s: %v\n", filepath.Base(cre.Path), cre.Offset, kind, cre.Err)
+		}
+	}
+
+	if bad > 0 {
+		return fmt.Errorf("%d of %d logs have corrupt records", bad, len(files))
+	}
+	return nil
+}
+
+// RunLogsDecrypt writes every record of an encrypted log as plaintext JSONL, to
+// cfg.OutPath or stdout. Deduplicated bodies are put back inline.
+func RunLogsDecrypt(cfg config.AppConfig) error {
+	seq, err := seqArg(cfg.Args)
+	if err != nil {
+		return err
+	}
+	lf, err := logpath.FindLogFile(logDir(cfg.LogPath), seq)
+	if err != nil {
+		return err
+	}
+	if !strings.HasSuffix(lf.Path, datastore.EncryptExt) {
+		return fmt.Errorf("%s is not encrypted", lf.Path)
+	}
+
+	var out io.Writer = os.Stdout
+	if cfg.OutPath != "" {
+		// Refuse to overwrite, the output holds the plaintext
+		f, err := os.OpenFile(cfg.OutPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+
+	buf := bufio.NewWriter(out)
+	enc := json.NewEncoder(buf)
+	count := 0
+	err = eachRecord(lf.Path, func(rec model.Record) error {
+		count++
+		return enc.Encode(rec)
+	})
+	if err != nil {
+		return err
+	}
+	if err := buf.Flush(); err != nil {
+		return err
+	}
+	if cfg.OutPath != "" {
+		fmt.Printf("Decrypted %d records to %s\n", count, cfg.OutPath)
+	}
+	return nil
+}
+
+// RunLogsPrune removes old log files, always keeping the newest cfg.Keep.
+func RunLogsPrune(cfg config.AppConfig) error {
+	files, err := logpath.ListLogFiles(logDir(cfg.LogPath))
+	if err != nil {
+		return err
+	}
+
+	// Newest first so the first Keep entries are the ones to hold on to
+	sort.SliceStable(files, func(i, j int) bool { return files[i].Seq > files[j].Seq })
+
+	cutoff := time.Now().Add(-cfg.OlderThan)
+	removed := 0
+	for i, lf := range files {
+		if i < cfg.Keep {
+			continue
+		}
+		if cfg.OlderThan > 0 && !lf.Start.Before(cutoff) {
+			continue
+		}
+		if cfg.DryRun {
+			fmt.Printf("Would remove %s\n", lf.Name)
+			removed++
+			continue
+		}
+		for _, part := range lf.Parts {
+			if err := os.Remove(part); err != nil {
+				return err
+			}
+		}
+		for _, sidecar := range []string{lf.Blobs, lf.Index, lf.Edits} {
+			if sidecar == "" {
+				continue
+			}
+			if err := os.RemoveAll(sidecar); err != nil {
+				return err
+			}
+		}
+		fmt.Printf("Removed %s\n", lf.Name)
+		removed++
+	}
+
+	if removed == 0 {
+		fmt.Println("Nothing to prune")
+	}
+	return nil
+}
+
+// ------------
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%cB", float64(n)/float64(div), "KMGT"[exp])
+}
+
+func formatCounts(counts map[string]int) string {
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, fmt.Sprintf("%s=%d", k, counts[k]))
+	}
+	return strings.Join(parts, " ")
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
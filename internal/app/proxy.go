@@ -3,37 +3,137 @@ package app
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"github.com/BarrettBr/RWND/internal/config"
 	"github.com/BarrettBr/RWND/internal/datastore"
+	"github.com/BarrettBr/RWND/internal/diff"
+	"github.com/BarrettBr/RWND/internal/fault"
 	"github.com/BarrettBr/RWND/internal/logger"
 	"github.com/BarrettBr/RWND/internal/logpath"
+	"github.com/BarrettBr/RWND/internal/model"
 	"github.com/BarrettBr/RWND/internal/proxy"
+	"github.com/BarrettBr/RWND/internal/rules"
 )
 
 const proxyShutdownTimeout = 10 * time.Second
 
 // RunProxy starts the proxy and blocks until it exits or the context is canceled.
 func RunProxy(ctx context.Context, cfg config.AppConfig) error {
-	logPath, err := logpath.ResolveRecordPath(cfg.LogPath, cfg.ListenAddr, cfg.TargetURL)
+	compression, err := datastore.ParseCompression(cfg.Compress)
 	if err != nil {
 		return err
 	}
+	format, err := datastore.ParseFormat(cfg.LogFormat)
+	if err != nil {
+		return err
+	}
+	syncPolicy, err := datastore.ParseSyncPolicy(cfg.Sync)
+	if err != nil {
+		return err
+	}
+	recovery, err := datastore.ParseRecovery(cfg.Recover)
+	if err != nil {
+		return err
+	}
+	ext := format.Ext() + compression.Ext()
+	if cfg.Encrypt {
+		ext += datastore.EncryptExt
+	}
+	logPath, err := logpath.ResolveRecordPathWithExt(cfg.LogPath, cfg.ListenAddr, cfg.TargetURL, ext)
+	if err != nil {
+		return err
+	}
+
+	var faults *fault.Injector
+	var faultSeed int64
+	if cfg.FaultsPath != "" {
+		file, err := fault.Load(cfg.FaultsPath)
+		if err != nil {
+			return err
+		}
+		faults = fault.NewInjector(file, cfg.FaultSeed)
+		faultSeed = faults.Seed()
+		fmt.Printf("Injecting faults from %s with seed %d (--fault-seed %d repeats it)\n", cfg.FaultsPath, faultSeed, faultSeed)
+	}
 
-	store, err := datastore.NewFileStore(logPath, 500*time.Millisecond)
+	store, err := datastore.NewFileStoreWithOptions(logPath, datastore.FileOptions{
+		FlushInterval: 500 * time.Millisecond,
+		Rotate: datastore.RotateOptions{
+			MaxBytes:   cfg.RotateBytes,
+			MaxRecords: cfg.RotateRecords,
+			MaxAge:     cfg.RotateAge,
+		},
+		DedupBodies: cfg.DedupBodies,
+		Sync:        syncPolicy,
+		Recovery:    recovery,
+		Header: &model.SessionHeader{
+			Target: cfg.TargetURL.String(),
+			Listen: cfg.ListenAddr,
+			Capture: model.CaptureOptions{
+				Format:        string(format),
+				Compress:      string(compression),
+				Encrypt:       cfg.Encrypt,
+				RedactHeaders: cfg.RedactHeaders,
+				MaxBodyBytes:  cfg.MaxBodyBytes,
+				DedupBodies:   cfg.DedupBodies,
+				Rules:         cfg.RulesPath,
+				Sync:          cfg.Sync,
+				Shadow:        shadowTarget(cfg),
+				Faults:        cfg.FaultsPath,
+				FaultSeed:     faultSeed,
+			},
+		},
+	})
 	if err != nil {
 		return err
 	}
-	logr := logger.New(store)
+
+	// Rules sit between the logger and the store so they see IDs and run off the request path
+	var sink logger.Store = store
+	var ruleEngine *rules.Engine
+	if cfg.RulesPath != "" {
+		ruleCfg, err := rules.Load(cfg.RulesPath)
+		if err != nil {
+			_ = store.Close()
+			return err
+		}
+		ruleEngine, err = rules.New(ruleCfg, store)
+		if err != nil {
+			_ = store.Close()
+			return err
+		}
+		sink = ruleEngine
+	}
+	closeRules := func() {
+		if ruleEngine != nil {
+			_ = ruleEngine.Close()
+		}
+	}
+
+	logr := logger.New(sink)
 
 	pxy, err := proxy.New(proxy.Options{
 		ListenAddr: cfg.ListenAddr,
 		Target:     cfg.TargetURL,
 		Logger:     logr,
+
+		RedactHeaders: cfg.RedactHeaders,
+		MaxBodyBytes:  cfg.MaxBodyBytes,
+
+		Shadow:      cfg.ShadowURL,
+		ShadowQueue: cfg.ShadowQueue,
+		ShadowDiff: diff.Rules{
+			IgnoreHeaders: cfg.IgnoreHeaders,
+			IgnoreJSON:    cfg.IgnoreJSON,
+		},
+
+		Faults: faults,
 	})
 	if err != nil {
 		logr.Close()
+		closeRules()
 		_ = store.Close()
 		return err
 	}
@@ -44,6 +144,7 @@ func RunProxy(ctx context.Context, cfg config.AppConfig) error {
 	select {
 	case err := <-runErrCh:
 		logr.Close()
+		closeRules()
 		_ = store.Close()
 		return err
 	case <-ctx.Done():
@@ -52,6 +153,7 @@ func RunProxy(ctx context.Context, cfg config.AppConfig) error {
 
 		_ = pxy.Shutdown(shutdownCtx)
 		logr.Close()
+		closeRules()
 		storeErr := store.Close()
 		runErr := <-runErrCh
 
@@ -61,3 +163,10 @@ func RunProxy(ctx context.Context, cfg config.AppConfig) error {
 		return runErr
 	}
 }
+
+func shadowTarget(cfg config.AppConfig) string {
+	if cfg.ShadowURL == nil {
+		return ""
+	}
+	return cfg.ShadowURL.String()
+}
@@ -1,11 +1,21 @@
 package app
 
 import (
-	"time"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 
+	"github.com/BarrettBr/RWND/internal/capture"
 	"github.com/BarrettBr/RWND/internal/config"
 	"github.com/BarrettBr/RWND/internal/datastore"
+	"github.com/BarrettBr/RWND/internal/diff"
 	"github.com/BarrettBr/RWND/internal/logpath"
+	"github.com/BarrettBr/RWND/internal/model"
+	"github.com/BarrettBr/RWND/internal/mutate"
+	"github.com/BarrettBr/RWND/internal/query"
 	"github.com/BarrettBr/RWND/internal/replay"
 )
 
@@ -15,12 +25,53 @@ func RunReplay(cfg config.AppConfig) error {
 	if err != nil {
 		return err
 	}
+	auth, err := replayAuth(cfg, logPath)
+	if err != nil {
+		return err
+	}
+	edits := &editLog{path: editsPath(logPath)}
+	defer edits.close()
+	if cfg.Edits {
+		if _, err := os.Stat(edits.path); err != nil {
+			return fmt.Errorf("No saved edits for %s", logPath)
+		}
+		logPath = edits.path
+	}
+
+	keep, err := recordFilter(cfg)
+	if err != nil {
+		return err
+	}
 
-	store, err := datastore.NewFileStore(logPath, 500*time.Millisecond)
+	var mutations []mutate.Mutation
+	if cfg.MutatePath != "" {
+		if mutations, err = mutate.Load(cfg.MutatePath); err != nil {
+			return err
+		}
+	}
+	captures, err := loadCaptures(cfg)
 	if err != nil {
 		return err
 	}
-	engine, err := replay.New(store)
+
+	store, err := datastore.OpenFileStore(logPath)
+	if err != nil {
+		return err
+	}
+	engine, err := replay.NewWithOptions(store, replay.Options{
+		Filter:      keep,
+		Target:      cfg.ReplayTarget,
+		Mutations:   mutations,
+		Captures:    captures,
+		CookieJar:   cfg.CookieJar,
+		DropCookies: cfg.DropCookies,
+		Auth:        auth,
+		SaveEdit:    edits.save,
+		Diff: diff.Rules{
+			IgnoreHeaders: cfg.IgnoreHeaders,
+			IgnoreJSON:    cfg.IgnoreJSON,
+		},
+	})
 	if err != nil {
 		_ = store.Close()
 		return err
@@ -28,3 +79,151 @@ func RunReplay(cfg config.AppConfig) error {
 
 	return engine.StepLoop()
 }
+
+func loadCaptures(cfg config.AppConfig) ([]capture.Rule, error) {
+	if cfg.CapturePath == "" {
+		return nil, nil
+	}
+	return capture.Load(cfg.CapturePath)
+}
+
+// errFound stops a record scan once the record looked for turned up.
+var errFound = errors.New("found")
+
+func replayAuth(cfg config.AppConfig, logPath string) (replay.Auth, error) {
+	// Returns the auth provider the config picks, or nil to send recorded headers
+	switch {
+	case cfg.AuthEnv != "":
+		token := os.Getenv(cfg.AuthEnv)
+		if token == "" {
+			return nil, fmt.Errorf("Auth env var %s is empty", cfg.AuthEnv)
+		}
+		return &replay.StaticAuth{Token: token, Scheme: cfg.AuthScheme}, nil
+	case cfg.AuthCommand != "":
+		return &replay.CommandAuth{Command: cfg.AuthCommand, Scheme: cfg.AuthScheme}, nil
+	case cfg.AuthLogin != "":
+		q, err := query.Parse(cfg.AuthLogin)
+		if err != nil {
+			return nil, fmt.Errorf("Auth login filter: %w", err)
+		}
+		spec := cfg.AuthToken
+		if spec == "" {
+			spec = "json:access_token"
+		}
+		token, err := capture.ParseRule(spec)
+		if err != nil {
+			return nil, err
+		}
+
+		var login *model.Record
+		err = eachRecord(logPath, func(rec model.Record) error {
+			if q.Match(rec) {
+				login = &rec
+				return errFound
+			}
+			return nil
+		})
+		if err != nil && err != errFound {
+			return nil, err
+		}
+		if login == nil {
+			return nil, fmt.Errorf("No login request matching %q in %s", cfg.AuthLogin, logPath)
+		}
+		return &replay.LoginAuth{Login: *login, Token: token, Scheme: cfg.AuthScheme}, nil
+	}
+	return nil, nil
+}
+
+// editsFile is the name of the edited records log inside a session's edits directory.
+const editsFile = "edits"
+
+func editsPath(logPath string) string {
+	// Edits keep the log's extension so a compressed or encrypted log gets
+	// compressed or encrypted edits
+	name := filepath.Base(logPath)
+	ext := ".jsonl"
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		ext = name[i:]
+	}
+	return filepath.Join(logpath.EditsDir(logPath), editsFile+ext)
+}
+
+// editLog appends records edited during replay to a session's edits log. The
+// log is opened on the first save so replays without edits leave no files.
+type editLog struct {
+	path   string
+	mu     sync.Mutex
+	store  *datastore.FileStore
+	nextID uint64
+}
+
+func (l *editLog) save(rec model.Record) (model.Record, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.store == nil {
+		// Continue numbering after the edits saved by earlier runs
+		var last uint64
+		if _, err := os.Stat(l.path); err == nil {
+			if err := eachRecord(l.path, func(r model.Record) error {
+				last = max(last, r.ID)
+				return nil
+			}); err != nil {
+				return model.Record{}, err
+			}
+		}
+		store, err := datastore.NewFileStoreWithOptions(l.path, datastore.FileOptions{Sync: datastore.SyncAlways})
+		if err != nil {
+			return model.Record{}, err
+		}
+		l.store, l.nextID = store, last+1
+	}
+
+	rec.ID = l.nextID
+	if err := l.store.Append(rec); err != nil {
+		return model.Record{}, err
+	}
+	l.nextID++
+	return rec, nil
+}
+
+func (l *editLog) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.store != nil {
+		_ = l.store.Close()
+	}
+}
+
+func recordFilter(cfg config.AppConfig) (func(model.Record) bool, error) {
+	// Combines the tag filter with the --filter expression, or nil to keep everything
+	tags := tagFilter(cfg.Tags)
+	if cfg.Filter == "" {
+		return tags, nil
+	}
+	q, err := query.Parse(cfg.Filter)
+	if err != nil {
+		return nil, err
+	}
+	if tags == nil {
+		return q.Match, nil
+	}
+	return func(rec model.Record) bool {
+		return tags(rec) && q.Match(rec)
+	}, nil
+}
+
+func tagFilter(tags []string) func(model.Record) bool {
+	// Returns a filter keeping records with any of the tags, or nil to keep everything
+	if len(tags) == 0 {
+		return nil
+	}
+	return func(rec model.Record) bool {
+		for _, tag := range tags {
+			if rec.HasTag(tag) {
+				return true
+			}
+		}
+		return false
+	}
+}
@@ -0,0 +1,258 @@
+package app
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/BarrettBr/RWND/internal/config"
+	"github.com/BarrettBr/RWND/internal/logpath"
+	"github.com/BarrettBr/RWND/internal/model"
+	"github.com/BarrettBr/RWND/internal/route"
+)
+
+// StatsReport is the endpoint inventory of one log.
+type StatsReport struct {
+	Log         string
+	Records     int
+	First, Last time.Time
+	Routes      []RouteStats
+}
+
+// RouteStats aggregates the records of one method and templated route.
+type RouteStats struct {
+	Method        string
+	Route         string // Path with numeric and UUID segments replaced, see package route
+	Count         int
+	Statuses      map[string]int // Status code -> records
+	Errors        int            // Responses with status >= 500
+	LatencyMs     Percentiles
+	RequestBytes  Sizes
+	ResponseBytes Sizes
+
+	latencies []time.Duration
+}
+
+// Percentiles of a route's capture latencies, in milliseconds.
+type Percentiles struct {
+	P50, P90, P99, Max float64
+}
+
+// Sizes of a route's captured bodies, in bytes.
+type Sizes struct {
+	Total, Avg, Max int64
+}
+
+func (r *RouteStats) add(rec model.Record) {
+	r.Count++
+	r.Statuses[strconv.Itoa(rec.Response.Status)]++
+	if rec.Response.Status >= 500 {
+		r.Errors++
+	}
+	r.latencies = append(r.latencies, rec.Latency)
+	r.RequestBytes.add(int64(len(rec.Request.Body)))
+	r.ResponseBytes.add(int64(len(rec.Response.Body)))
+}
+
+func (r *RouteStats) finish() {
+	slices.Sort(r.latencies)
+	r.LatencyMs = Percentiles{
+		P50: millis(percentile(r.latencies, 50)),
+		P90: millis(percentile(r.latencies, 90)),
+		P99: millis(percentile(r.latencies, 99)),
+		Max: millis(percentile(r.latencies, 100)),
+	}
+	r.RequestBytes.Avg = r.RequestBytes.Total / int64(r.Count)
+	r.ResponseBytes.Avg = r.ResponseBytes.Total / int64(r.Count)
+}
+
+func (s *Sizes) add(n int64) {
+	s.Total += n
+	s.Max = max(s.Max, n)
+}
+
+func percentile(sorted []time.Duration, p int) time.Duration {
+	// Nearest rank, the smallest value at least p percent of values are at or below
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := (p*len(sorted) + 99) / 100
+	return sorted[max(rank-1, 0)]
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// Stats groups the records of a log that pass keep by method and templated route.
+// A nil keep counts every record.
+func Stats(path string, keep func(model.Record) bool, sortBy string) (StatsReport, error) {
+	report := StatsReport{Log: path}
+	routes := make(map[string]*RouteStats)
+	err := eachRecord(path, func(rec model.Record) error {
+		if keep != nil && !keep(rec) {
+			return nil
+		}
+		if report.Records == 0 || rec.Timestamp.Before(report.First) {
+			report.First = rec.Timestamp
+		}
+		if rec.Timestamp.After(report.Last) {
+			report.Last = rec.Timestamp
+		}
+		report.Records++
+
+		tmpl := route.FromURL(rec.Request.URL)
+		key := rec.Request.Method + " " + tmpl
+		r, ok := routes[key]
+		if !ok {
+			r = &RouteStats{Method: rec.Request.Method, Route: tmpl, Statuses: make(map[string]int)}
+			routes[key] = r
+		}
+		r.add(rec)
+		return nil
+	})
+	if err != nil {
+		return StatsReport{}, err
+	}
+
+	report.Routes = make([]RouteStats, 0, len(routes))
+	for _, r := range routes {
+		r.finish()
+		report.Routes = append(report.Routes, *r)
+	}
+	sortRoutes(report.Routes, sortBy)
+	return report, nil
+}
+
+func sortRoutes(routes []RouteStats, by string) {
+	// Ties fall back to route then method so output is stable
+	slices.SortFunc(routes, func(a, b RouteStats) int {
+		var c int
+		switch by {
+		case "count":
+			c = b.Count - a.Count
+		case "p99":
+			c = int(b.LatencyMs.P99*1000) - int(a.LatencyMs.P99*1000)
+		case "errors":
+			c = b.Errors - a.Errors
+		}
+		if c == 0 {
+			c = strings.Compare(a.Route, b.Route)
+		}
+		if c == 0 {
+			c = strings.Compare(a.Method, b.Method)
+		}
+		return c
+	})
+}
+
+// ------------
+
+// RunStats prints per route traffic statistics for a log as a table, JSON or Markdown.
+func RunStats(cfg config.AppConfig) error {
+	logPath, err := logpath.ResolveReplayPath(cfg.LogPath)
+	if err != nil {
+		return err
+	}
+	keep, err := recordFilter(cfg)
+	if err != nil {
+		return err
+	}
+
+	report, err := Stats(logPath, keep, cfg.SortBy)
+	if err != nil {
+		return err
+	}
+	if report.Records == 0 && cfg.Format != "json" {
+		return fmt.Errorf("No matching records")
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+
+	switch cfg.Format {
+	case "json":
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	case "markdown":
+		return writeStatsMarkdown(out, report)
+	default:
+		return writeStatsTable(out, report)
+	}
+}
+
+// statsColumns are the per route columns of the table and Markdown reports.
+var statsColumns = []string{"METHOD", "ROUTE", "COUNT", "STATUS", "ERR%", "P50", "P90", "P99", "MAX", "REQ AVG", "RESP AVG"}
+
+func statsRow(r RouteStats) []string {
+	return []string{
+		r.Method,
+		r.Route,
+		strconv.Itoa(r.Count),
+		formatCounts(r.Statuses),
+		fmt.Sprintf("%.1f", 100*float64(r.Errors)/float64(r.Count)),
+		formatMillis(r.LatencyMs.P50),
+		formatMillis(r.LatencyMs.P90),
+		formatMillis(r.LatencyMs.P99),
+		formatMillis(r.LatencyMs.Max),
+		formatSize(r.RequestBytes.Avg),
+		formatSize(r.ResponseBytes.Avg),
+	}
+}
+
+func formatMillis(ms float64) string {
+	// Whole milliseconds once latencies get there, microseconds below
+	d := time.Duration(ms * float64(time.Millisecond))
+	if d >= time.Millisecond {
+		return d.Round(time.Millisecond).String()
+	}
+	return d.Round(time.Microsecond).String()
+}
+
+func writeStatsTable(w io.Writer, report StatsReport) error {
+	fmt.Fprintf(w, "%s: %d records, %d routes, %s to %s\n\n",
+		report.Log, report.Records, len(report.Routes), formatTime(report.First), formatTime(report.Last))
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(statsColumns, "\t"))
+	for _, r := range report.Routes {
+		fmt.Fprintln(tw, strings.Join(statsRow(r), "\t"))
+	}
+	return tw.Flush()
+}
+
+func writeStatsMarkdown(w io.Writer, report StatsReport) error {
+	fmt.Fprintf(w, "%d records, %d routes in `%s` (%s to %s)\n\n",
+		report.Records, len(report.Routes), report.Log, formatTime(report.First), formatTime(report.Last))
+
+	header := make([]string, len(statsColumns))
+	align := make([]string, len(statsColumns))
+	for i, col := range statsColumns {
+		header[i] = strings.ToUpper(col[:1]) + strings.ToLower(col[1:])
+		align[i] = "---:"
+		if i < 2 {
+			align[i] = "---"
+		}
+	}
+	fmt.Fprintf(w, "| %s |\n| %s |\n", strings.Join(header, " | "), strings.Join(align, " | "))
+
+	for _, r := range report.Routes {
+		row := statsRow(r)
+		row[1] = "`" + r.Route + "`"
+		for i, cell := range row {
+			row[i] = strings.ReplaceAll(cell, "|", `\|`)
+		}
+		if _, err := fmt.Fprintf(w, "| %s |\n", strings.Join(row, " | ")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
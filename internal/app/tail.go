@@ -0,0 +1,153 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/BarrettBr/RWND/internal/config"
+	"github.com/BarrettBr/RWND/internal/datastore"
+	"github.com/BarrettBr/RWND/internal/logpath"
+	"github.com/BarrettBr/RWND/internal/model"
+	"github.com/BarrettBr/RWND/internal/rules"
+)
+
+// FollowRecords streams the last cfg.Lines matching records of a log and then every
+// matching record appended after, until ctx is canceled. The returned channels
+// close once following stops.
+func FollowRecords(ctx context.Context, cfg config.AppConfig) (<-chan model.Record, <-chan error, error) {
+	logPath, err := logpath.ResolveReplayPath(cfg.LogPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	keep, err := tailFilter(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	store, err := datastore.OpenFileStore(logPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Read what is already there to find the last N matches, then follow and
+	// skip the records already read. Records are append only, so counting is enough.
+	var backlog []model.Record
+	seen := 0
+	recCh, errCh := store.Stream()
+	for rec := range recCh {
+		seen++
+		if !keep(rec) || cfg.Lines == 0 {
+			continue
+		}
+		backlog = append(backlog, rec)
+		if len(backlog) > cfg.Lines {
+			backlog = backlog[1:]
+		}
+	}
+	if err := <-errCh; err != nil {
+		_ = store.Close()
+		return nil, nil, err
+	}
+
+	out := make(chan model.Record)
+	outErr := make(chan error, 1)
+	followCh, followErr := store.Follow(ctx)
+	go func() {
+		defer close(out)
+		defer close(outErr)
+		defer store.Close()
+
+		send := func(rec model.Record) bool {
+			select {
+			case out <- rec:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		for _, rec := range backlog {
+			if !send(rec) {
+				return
+			}
+		}
+		skip := seen
+		for rec := range followCh {
+			if skip > 0 {
+				skip--
+				continue
+			}
+			if keep(rec) && !send(rec) {
+				break
+			}
+		}
+		// Drain so the follow goroutine can exit
+		for range followCh {
+		}
+		if err := <-followErr; err != nil {
+			outErr <- err
+		}
+	}()
+
+	return out, outErr, nil
+}
+
+// RunTail prints records of a log as they are recorded until ctx is canceled.
+func RunTail(ctx context.Context, cfg config.AppConfig) error {
+	recCh, errCh, err := FollowRecords(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	enc := json.NewEncoder(out)
+
+	for {
+		select {
+		case rec, ok := <-recCh:
+			if !ok {
+				return <-errCh
+			}
+			if cfg.Format == "json" {
+				err = enc.Encode(rec)
+			} else {
+				err = writeRecordLine(out, rec)
+			}
+			if err != nil {
+				return err
+			}
+		case <-time.After(100 * time.Millisecond):
+			// Push lines out while idle so the terminal doesn't lag behind
+			if err := out.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func tailFilter(cfg config.AppConfig) (func(model.Record) bool, error) {
+	// Combines the tag and --filter filters with rule style method / path / status / latency conditions
+	match, err := rules.Matcher(rules.Match{
+		Method:  cfg.FilterMethod,
+		Path:    cfg.FilterPath,
+		Status:  cfg.FilterStatus,
+		Latency: cfg.FilterLatency,
+	})
+	if err != nil {
+		return nil, err
+	}
+	keep, err := recordFilter(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return func(rec model.Record) bool {
+		if keep != nil && !keep(rec) {
+			return false
+		}
+		return match(rec)
+	}, nil
+}
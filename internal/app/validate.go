@@ -0,0 +1,166 @@
+package app
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/BarrettBr/RWND/internal/capture"
+	"github.com/BarrettBr/RWND/internal/config"
+	"github.com/BarrettBr/RWND/internal/datastore"
+	"github.com/BarrettBr/RWND/internal/logpath"
+	"github.com/BarrettBr/RWND/internal/model"
+	"github.com/BarrettBr/RWND/internal/openapi"
+	"github.com/BarrettBr/RWND/internal/replay"
+)
+
+// maxViolationLines caps the violations printed per record, --json prints them all.
+const maxViolationLines = 10
+
+// validateResult is the --json line for a record that breaks the spec.
+type validateResult struct {
+	ID         uint64
+	Method     string
+	URL        string
+	Status     int
+	Violations []openapi.Violation `json:",omitempty"`
+	Error      string              `json:",omitempty"` // Replay failure
+}
+
+// RunValidate checks the records of a log that pass the tag and --filter filters
+// against an OpenAPI document and reports violations by record ID. With --replay
+// every request is re-sent and the live response is checked instead of the recorded one.
+func RunValidate(cfg config.AppConfig) error {
+	doc, err := openapi.Load(cfg.SpecPath)
+	if err != nil {
+		return err
+	}
+	validator := openapi.NewValidator(doc)
+
+	logPath, err := logpath.ResolveReplayPath(cfg.LogPath)
+	if err != nil {
+		return err
+	}
+	keep, err := recordFilter(cfg)
+	if err != nil {
+		return err
+	}
+	var captures []capture.Rule
+	var auth replay.Auth
+	if cfg.Replay {
+		if captures, err = loadCaptures(cfg); err != nil {
+			return err
+		}
+		if auth, err = replayAuth(cfg, logPath); err != nil {
+			return err
+		}
+	}
+
+	store, err := datastore.OpenFileStore(logPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	engine, err := replay.NewWithOptions(store, replay.Options{
+		Filter:      keep,
+		Target:      cfg.ReplayTarget,
+		Captures:    captures,
+		CookieJar:   cfg.CookieJar,
+		DropCookies: cfg.DropCookies,
+		Auth:        auth,
+	})
+	if err != nil {
+		return err
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	enc := json.NewEncoder(out)
+
+	checked, bad, failed := 0, 0, 0
+	kinds := make(map[string]int)
+	for {
+		rec, err := engine.Step()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		checked++
+
+		if cfg.Replay {
+			replayed, err := engine.Replay(*rec)
+			if err != nil {
+				failed++
+				if cfg.Format == "json" {
+					err = enc.Encode(validateResult{ID: rec.ID, Method: rec.Request.Method, URL: rec.Request.URL, Error: err.Error()})
+				} else {
+					_, err = fmt.Fprintf(out, "#%d %s %s: replay failed: %v\n", rec.ID, rec.Request.Method, rec.Request.URL, err)
+				}
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			rec = replayed
+		}
+
+		violations := validator.Validate(*rec)
+		if len(violations) == 0 {
+			continue
+		}
+		bad++
+		for _, v := range violations {
+			kinds[v.Kind]++
+		}
+
+		if cfg.Format == "json" {
+			err = enc.Encode(validateResult{ID: rec.ID, Method: rec.Request.Method, URL: rec.Request.URL, Status: rec.Response.Status, Violations: violations})
+		} else {
+			err = writeViolations(out, *rec, violations)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := out.Flush(); err != nil {
+		return err
+	}
+
+	if checked == 0 {
+		return fmt.Errorf("No matching records")
+	}
+	summary := fmt.Sprintf("Checked %d records against %s: %d with violations", checked, cfg.SpecPath, bad)
+	if len(kinds) > 0 {
+		summary += " (" + formatCounts(kinds) + ")"
+	}
+	if failed > 0 {
+		summary += fmt.Sprintf(", %d replays failed", failed)
+	}
+	fmt.Fprintln(os.Stderr, summary)
+
+	if bad > 0 || failed > 0 {
+		return fmt.Errorf("%d of %d records don't match %s", bad+failed, checked, cfg.SpecPath)
+	}
+	return nil
+}
+
+func writeViolations(w io.Writer, rec model.Record, violations []openapi.Violation) error {
+	if err := writeRecordLine(w, rec); err != nil {
+		return err
+	}
+	for i, v := range violations {
+		if i == maxViolationLines {
+			_, err := fmt.Fprintf(w, "    ... %d more\n", len(violations)-i)
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "    %s\n", v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
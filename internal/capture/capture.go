@@ -0,0 +1,340 @@
+// Package capture chains values across replayed requests. Rules pull a value,
+// like a fresh token or a new resource ID, out of both the recorded and the
+// replayed response, and later requests get the live value wherever the
+// recorded one appeared.
+package capture
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/BarrettBr/RWND/internal/model"
+	"github.com/BarrettBr/RWND/internal/query"
+)
+
+// File is the on-disk capture rules layout.
+type File struct {
+	Captures []Rule `yaml:"captures"`
+}
+
+// Rule extracts one value from a response. Set one of JSON, Header or Regex, or
+// Header and Regex together to match the regex against that header's value.
+type Rule struct {
+	Name   string `yaml:"name"`
+	When   string `yaml:"when"`   // Filter expression the recorded record must match, empty matches all
+	JSON   string `yaml:"json"`   // Dotted path into the JSON body, "data.items.0.id"
+	Header string `yaml:"header"` // Response header name
+	Regex  string `yaml:"regex"`  // Matched against the body, or the header; the first group is the value if it has one
+
+	when *query.Filter
+	re   *regexp.Regexp
+}
+
+// Load reads a capture rules file from disk.
+func Load(path string) ([]Rule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// Parse decodes a YAML capture rules document and compiles its filters and regexes.
+func Parse(data []byte) ([]Rule, error) {
+	var f File
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("Captures parse error: %w", err)
+	}
+	for i := range f.Captures {
+		r := &f.Captures[i]
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("#%d", i+1)
+		}
+		if err := r.compile(); err != nil {
+			return nil, fmt.Errorf("Capture %s: %w", r.Name, err)
+		}
+	}
+	return f.Captures, nil
+}
+
+// ParseRule builds a rule from a one line spec: "json:data.token",
+// "header:X-Auth-Token" or "regex:token=(\w+)". The rule is named after the spec.
+func ParseRule(spec string) (Rule, error) {
+	kind, value, ok := strings.Cut(spec, ":")
+	r := Rule{Name: spec}
+	switch {
+	case !ok || value == "":
+		return Rule{}, fmt.Errorf("Expected json:<path>, header:<name> or regex:<expr>, got %q", spec)
+	case kind == "json":
+		r.JSON = value
+	case kind == "header":
+		r.Header = value
+	case kind == "regex":
+		r.Regex = value
+	default:
+		return Rule{}, fmt.Errorf("Unknown token location %q, use json, header or regex", kind)
+	}
+	if err := r.compile(); err != nil {
+		return Rule{}, fmt.Errorf("%s: %w", spec, err)
+	}
+	return r, nil
+}
+
+func (r *Rule) compile() error {
+	switch {
+	case r.JSON != "" && (r.Header != "" || r.Regex != ""):
+		return fmt.Errorf("json can't be combined with header or regex")
+	case r.JSON == "" && r.Header == "" && r.Regex == "":
+		return fmt.Errorf("set json, header or regex")
+	}
+	if r.When != "" {
+		q, err := query.Parse(r.When)
+		if err != nil {
+			return fmt.Errorf("when: %w", err)
+		}
+		r.when = q
+	}
+	if r.Regex != "" {
+		re, err := regexp.Compile(r.Regex)
+		if err != nil {
+			return fmt.Errorf("regex: %w", err)
+		}
+		r.re = re
+	}
+	return nil
+}
+
+// Matches reports whether the rule applies to rec.
+func (r *Rule) Matches(rec model.Record) bool {
+	return r.when == nil || r.when.Match(rec)
+}
+
+// Extract returns the rule's value in resp.
+func (r *Rule) Extract(resp model.Response) (string, bool) {
+	switch {
+	case r.JSON != "":
+		return jsonValue(resp.Body, r.JSON)
+	case r.Header != "":
+		value := resp.Headers.Get(r.Header)
+		if value == "" {
+			return "", false
+		}
+		if r.re == nil {
+			return value, true
+		}
+		return r.match(value)
+	default:
+		return r.match(string(resp.Body))
+	}
+}
+
+func (r *Rule) match(s string) (string, bool) {
+	m := r.re.FindStringSubmatch(s)
+	if m == nil {
+		return "", false
+	}
+	if len(m) > 1 {
+		return m[1], m[1] != ""
+	}
+	return m[0], m[0] != ""
+}
+
+func jsonValue(body []byte, path string) (string, bool) {
+	// Strings come back without quotes, numbers as written and other values as JSON
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var v any
+	if dec.Decode(&v) != nil {
+		return "", false
+	}
+	for _, key := range strings.Split(path, ".") {
+		switch node := v.(type) {
+		case map[string]any:
+			child, ok := node[key]
+			if !ok {
+				return "", false
+			}
+			v = child
+		case []any:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return "", false
+			}
+			v = node[i]
+		default:
+			return "", false
+		}
+	}
+	switch val := v.(type) {
+	case nil:
+		return "", false
+	case string:
+		return val, val != ""
+	default:
+		data, _ := json.Marshal(val)
+		return string(data), true
+	}
+}
+
+// ------------
+
+// Vars maps values seen in recorded responses to the values the replayed
+// responses returned instead.
+type Vars struct {
+	live  map[string]string // Recorded value -> live value
+	names map[string]string // Recorded value -> rule name
+}
+
+// NewVars returns an empty set of captured values.
+func NewVars() *Vars {
+	return &Vars{live: make(map[string]string), names: make(map[string]string)}
+}
+
+// Len returns the number of captured values.
+func (v *Vars) Len() int {
+	return len(v.live)
+}
+
+// Capture runs every rule matching the recorded record against its recorded
+// and live responses and remembers values that changed. It returns a line per
+// value captured or missing from the live response.
+func (v *Vars) Capture(rules []Rule, recorded model.Record, live model.Response) []string {
+	var notes []string
+	for i := range rules {
+		r := &rules[i]
+		if !r.Matches(recorded) {
+			continue
+		}
+		old, ok := r.Extract(recorded.Response)
+		if !ok {
+			continue
+		}
+		value, ok := r.Extract(live)
+		if !ok {
+			notes = append(notes, fmt.Sprintf("captured %s: missing from the replayed response", r.Name))
+			continue
+		}
+		if value == old {
+			delete(v.live, old)
+			delete(v.names, old)
+			continue
+		}
+		v.live[old] = value
+		v.names[old] = r.Name
+		notes = append(notes, fmt.Sprintf("captured %s = %s (recorded %s)", r.Name, shorten(value), shorten(old)))
+	}
+	return notes
+}
+
+// Apply returns req with every recorded value replaced by its live value, and
+// a line per place a value was substituted. Values only match whole, so a
+// recorded ID 7 doesn't change 17.
+func (v *Vars) Apply(req model.Request) (model.Request, []string) {
+	if len(v.live) == 0 {
+		return req, nil
+	}
+
+	// Longest first so a value inside a longer one doesn't break it up
+	olds := make([]string, 0, len(v.live))
+	for old := range v.live {
+		olds = append(olds, old)
+	}
+	sort.Slice(olds, func(i, j int) bool {
+		if len(olds[i]) != len(olds[j]) {
+			return len(olds[i]) > len(olds[j])
+		}
+		return olds[i] < olds[j]
+	})
+
+	var notes []string
+	note := func(old, where string) {
+		notes = append(notes, fmt.Sprintf("substituted %s in %s", v.names[old], where))
+	}
+
+	out := req
+	for _, old := range olds {
+		if s, ok := replace(out.URL, old, v.live[old]); ok {
+			out.URL = s
+			note(old, "url")
+		}
+	}
+
+	if req.Headers != nil {
+		out.Headers = req.Headers.Clone()
+		names := make([]string, 0, len(out.Headers))
+		for name := range out.Headers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, old := range olds {
+			for _, name := range names {
+				changed := false
+				for i, value := range out.Headers[name] {
+					if s, ok := replace(value, old, v.live[old]); ok {
+						out.Headers[name][i] = s
+						changed = true
+					}
+				}
+				if changed {
+					note(old, "header "+name)
+				}
+			}
+		}
+	}
+
+	body := string(req.Body)
+	for _, old := range olds {
+		if s, ok := replace(body, old, v.live[old]); ok {
+			body = s
+			note(old, "body")
+		}
+	}
+	if body != string(req.Body) {
+		out.Body = []byte(body)
+	}
+	return out, notes
+}
+
+func replace(s, old, new string) (string, bool) {
+	// Replaces whole occurrences of old, ones not directly next to a letter or digit
+	var b strings.Builder
+	changed := false
+	for {
+		i := strings.Index(s, old)
+		if i < 0 {
+			b.WriteString(s)
+			break
+		}
+		end := i + len(old)
+		if (i > 0 && isWordByte(s[i-1])) || (end < len(s) && isWordByte(s[end])) {
+			b.WriteString(s[:i+1])
+			s = s[i+1:]
+			continue
+		}
+		b.WriteString(s[:i])
+		b.WriteString(new)
+		s = s[end:]
+		changed = true
+	}
+	return b.String(), changed
+}
+
+func isWordByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+func shorten(s string) string {
+	const n = 24
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
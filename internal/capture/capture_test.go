@@ -0,0 +1,112 @@
+package capture_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/BarrettBr/RWND/internal/capture"
+	"github.com/BarrettBr/RWND/internal/model"
+)
+
+const rules = `
+captures:
+  - name: token
+    when: path = /login
+    json: auth.token
+  - name: order
+    when: method = POST
+    header: Location
+    regex: /orders/(\d+)
+  - name: csrf
+    regex: csrf=([a-z0-9]+)
+`
+
+func response(status int, headers http.Header, body string) model.Response {
+	return model.Response{Status: status, Headers: headers, Body: []byte(body)}
+}
+
+func TestVars_CaptureAndApply(t *testing.T) {
+	rs, err := capture.Parse([]byte(rules))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	vars := capture.NewVars()
+
+	var login model.Record
+	login.Request.Method, login.Request.URL = "POST", "http://api/login"
+	login.Response = response(200, nil, `{"auth":{"token":"old-tok"}} csrf=abc1`)
+	notes := vars.Capture(rs, login, response(200, nil, `{"auth":{"token":"new-tok"}} csrf=abc1`))
+	if len(notes) != 1 || !strings.Contains(notes[0], "token = new-tok") {
+		t.Fatalf("Unexpected capture notes: %v", notes)
+	}
+
+	var create model.Record
+	create.Request.Method, create.Request.URL = "POST", "http://api/orders"
+	create.Response = response(201, http.Header{"Location": {"/orders/7"}}, "")
+	vars.Capture(rs, create, response(201, http.Header{"Location": {"/orders/42"}}, ""))
+	if vars.Len() != 2 {
+		t.Fatalf("Expected 2 captured values, got %d", vars.Len())
+	}
+
+	req := model.Request{
+		Method:  "PUT",
+		URL:     "http://api/orders/7?ref=17",
+		Headers: http.Header{"Authorization": {"Bearer old-tok"}},
+		Body:    []byte(`{"id":7,"qty":70,"note":"order 7"}`),
+	}
+	got, notes := vars.Apply(req)
+	if got.URL != "http://api/orders/42?ref=17" {
+		t.Errorf("Unexpected URL: %s", got.URL)
+	}
+	if got.Headers.Get("Authorization") != "Bearer new-tok" || req.Headers.Get("Authorization") != "Bearer old-tok" {
+		t.Errorf("Expected the header replaced on a copy, got %v and %v", got.Headers, req.Headers)
+	}
+	if string(got.Body) != `{"id":42,"qty":70,"note":"order 42"}` {
+		t.Errorf("Unexpected body: %s", got.Body)
+	}
+	if len(notes) != 3 {
+		t.Errorf("Expected a note per place, got %v", notes)
+	}
+
+	// Values that come back unchanged stop being substituted
+	vars.Capture(rs, create, response(201, http.Header{"Location": {"/orders/7"}}, ""))
+	if vars.Len() != 1 {
+		t.Errorf("Expected the order value dropped, got %d values", vars.Len())
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	for _, doc := range []string{
+		"captures: [{name: a}]",
+		"captures: [{json: id, header: Location}]",
+		"captures: [{regex: '('}]",
+		"captures: [{json: id, when: 'status >'}]",
+	} {
+		if _, err := capture.Parse([]byte(doc)); err == nil {
+			t.Errorf("Expected an error for %q", doc)
+		}
+	}
+}
+
+func TestParseRule(t *testing.T) {
+	r, err := capture.ParseRule("json:auth.token")
+	if err != nil {
+		t.Fatalf("ParseRule: %v", err)
+	}
+	if v, ok := r.Extract(response(200, nil, `{"auth":{"token":"t1"}}`)); !ok || v != "t1" {
+		t.Fatalf("Unexpected value %q %v", v, ok)
+	}
+	r, err = capture.ParseRule("regex:token=(\\w+)")
+	if err != nil {
+		t.Fatalf("ParseRule: %v", err)
+	}
+	if v, ok := r.Extract(response(200, nil, "ok token=abc1;")); !ok || v != "abc1" {
+		t.Fatalf("Unexpected value %q %v", v, ok)
+	}
+	for _, bad := range []string{"token", "json:", "cookie:x", "regex:("} {
+		if _, err := capture.ParseRule(bad); err == nil {
+			t.Errorf("Expected an error for %q", bad)
+		}
+	}
+}
@@ -0,0 +1,16 @@
+package cli
+
+import (
+	"github.com/BarrettBr/RWND/internal/app"
+	"github.com/BarrettBr/RWND/internal/config"
+)
+
+func runChaos(args []string) error {
+	cfg, err := config.FromChaosArgs(args, config.Load())
+	if err != nil {
+		PrintHelp()
+		return err
+	}
+
+	return app.RunChaos(cfg)
+}
@@ -0,0 +1,16 @@
+package cli
+
+import (
+	"github.com/BarrettBr/RWND/internal/app"
+	"github.com/BarrettBr/RWND/internal/config"
+)
+
+func runConvert(args []string) error {
+	cfg, err := config.FromConvertArgs(args, config.Load())
+	if err != nil {
+		PrintHelp()
+		return err
+	}
+
+	return app.RunConvert(cfg)
+}
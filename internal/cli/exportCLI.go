@@ -0,0 +1,16 @@
+package cli
+
+import (
+	"github.com/BarrettBr/RWND/internal/app"
+	"github.com/BarrettBr/RWND/internal/config"
+)
+
+func runExport(args []string) error {
+	cfg, err := config.FromExportArgs(args, config.Load())
+	if err != nil {
+		PrintHelp()
+		return err
+	}
+
+	return app.RunExport(cfg)
+}
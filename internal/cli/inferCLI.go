@@ -0,0 +1,31 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/BarrettBr/RWND/internal/app"
+	"github.com/BarrettBr/RWND/internal/config"
+)
+
+func runInfer(args []string) error {
+	if len(args) == 0 {
+		PrintHelp()
+		return fmt.Errorf("No infer subcommand specified, e.g. rwnd infer openapi")
+	}
+
+	run, ok := map[string]func(config.AppConfig) error{
+		"openapi": app.RunInferOpenAPI,
+	}[args[0]]
+	if !ok {
+		PrintHelp()
+		return fmt.Errorf("Unknown infer subcommand: %s", args[0])
+	}
+
+	cfg, err := config.FromInferArgs(args[0], args[1:], config.Load())
+	if err != nil {
+		PrintHelp()
+		return err
+	}
+
+	return run(cfg)
+}
@@ -0,0 +1,36 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/BarrettBr/RWND/internal/app"
+	"github.com/BarrettBr/RWND/internal/config"
+)
+
+func runLogs(args []string) error {
+	if len(args) == 0 {
+		PrintHelp()
+		return fmt.Errorf("No logs subcommand specified")
+	}
+
+	run, ok := map[string]func(config.AppConfig) error{
+		"ls":      app.RunLogsList,
+		"show":    app.RunLogsShow,
+		"info":    app.RunLogsInfo,
+		"prune":   app.RunLogsPrune,
+		"verify":  app.RunLogsVerify,
+		"decrypt": app.RunLogsDecrypt,
+	}[args[0]]
+	if !ok {
+		PrintHelp()
+		return fmt.Errorf("Unknown logs subcommand: %s", args[0])
+	}
+
+	cfg, err := config.FromLogsArgs(args[0], args[1:], config.Load())
+	if err != nil {
+		PrintHelp()
+		return err
+	}
+
+	return run(cfg)
+}
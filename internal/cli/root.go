@@ -12,12 +12,47 @@ func PrintHelp() {
 Usage:
   rwnd proxy  [options]   Start reverse proxy and record traffic
   rwnd replay [options]   Replay recorded traffic
+  rwnd export [options]   Write recorded traffic as JSONL
+  rwnd convert <in> <out> Re-encode a log between JSONL (.jsonl) and binary (.rwb)
+  rwnd tail   [options]   Print records of the latest log live as they are recorded
+  rwnd grep [options] <pattern>
+                          Search bodies, headers and URLs of the latest log (--index for large logs)
+  rwnd stats  [options]   Per route counts, statuses, latency percentiles and sizes (--json, --markdown)
+  rwnd infer openapi [options]
+                          Write an OpenAPI 3 document inferred from the latest log (--out file)
+  rwnd validate --spec <file> [options]
+                          Check recorded (or --replay live) traffic against an OpenAPI spec
+  rwnd compare --a <url> --b <url> [options]
+                          Replay the log against two deployments and diff their responses
+  rwnd chaos  [options]   Replay the log shuffled, duplicated and retried from a printed seed
+  rwnd logs ls            List recorded log files
+  rwnd logs show <n>      Print the records of log n
+  rwnd logs info [n]      Print summary stats for log n (or every log)
+  rwnd logs prune         Remove old logs (--keep N, --older-than 7d)
+  rwnd logs verify [n]    Report corrupt records in log n (or every log) with offsets
+  rwnd logs decrypt <n>   Write encrypted log n as plaintext JSONL (--out file before n)
   rwnd help               Show this help
 
 Examples:
   rwnd proxy --listen :8080 --target http://localhost:3000
   rwnd proxy -h
-  rwnd replay --step`)
+  rwnd proxy --target http://localhost:3000 --rules .rwnd/rules.yaml
+  rwnd proxy --target http://localhost:3000 --shadow http://localhost:3001
+  rwnd proxy --target http://localhost:3000 --faults .rwnd/faults.yaml --fault-seed 42
+  rwnd replay --tag unauthorized
+  rwnd replay --filter 'path ~ ^/orders' --mutate what-if.yaml
+  rwnd replay --edits
+  rwnd export --tag unauthorized --out unauthorized.jsonl
+  rwnd convert .rwnd/logs/001_....jsonl archive.rwb.zst
+  rwnd tail --status 5xx --path ^/api
+  rwnd grep -i ORD-1234
+  rwnd grep --filter 'resp.json.order.id = 1234'
+  rwnd stats --sort p99 --filter 'method = GET'
+  rwnd infer openapi --filter 'path ~ ^/api' --out openapi.yaml
+  rwnd validate --spec openapi.yaml --replay --target http://localhost:4000
+  rwnd compare --a http://old:3000 --b http://new:3000 --ignore-header Server
+  rwnd chaos --target http://localhost:4000 --jitter 50ms --seed 42
+  rwnd logs prune --keep 5 --older-than 7d`)
 }
 
 // Run runs CLI subcommands based on args.
@@ -32,6 +67,26 @@ func Run(args []string) error {
 		return runProxy(args[1:])
 	case "replay":
 		return runReplay(args[1:])
+	case "export":
+		return runExport(args[1:])
+	case "tail":
+		return runTail(args[1:])
+	case "convert":
+		return runConvert(args[1:])
+	case "grep":
+		return runGrep(args[1:])
+	case "stats":
+		return runStats(args[1:])
+	case "infer":
+		return runInfer(args[1:])
+	case "validate":
+		return runValidate(args[1:])
+	case "compare":
+		return runCompare(args[1:])
+	case "chaos":
+		return runChaos(args[1:])
+	case "logs":
+		return runLogs(args[1:])
 	case "help", "-h", "--help":
 		PrintHelp()
 		return nil
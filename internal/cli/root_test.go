@@ -19,3 +19,9 @@ func TestRun_UnknownCommand(t *testing.T) {
 		t.Fatalf("expected error for unknown command")
 	}
 }
+
+func TestRun_UnknownLogsSubcommand(t *testing.T) {
+	if err := Run([]string{"logs", "nope"}); err == nil {
+		t.Fatalf("expected error for unknown logs subcommand")
+	}
+}
@@ -0,0 +1,39 @@
+package cli
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/BarrettBr/RWND/internal/app"
+	"github.com/BarrettBr/RWND/internal/config"
+	"github.com/BarrettBr/RWND/internal/tui"
+)
+
+func runTail(args []string) error {
+	cfg, err := config.FromTailArgs(args, config.Load())
+	if err != nil {
+		PrintHelp()
+		return err
+	}
+
+	// Follow until interrupted
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if cfg.Format == "tui" {
+		recCh, errCh, err := app.FollowRecords(ctx, cfg)
+		if err != nil {
+			return err
+		}
+		err = tui.RunFollow(cfg.LogPath, recCh, errCh)
+		// Stop following so the stream goroutines exit
+		stop()
+		for range recCh {
+		}
+		return err
+	}
+
+	return app.RunTail(ctx, cfg)
+}
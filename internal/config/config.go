@@ -1,17 +1,93 @@
 // Package config defines application configuration and CLI argument parsing.
+//
+// Settings are layered as defaults < config file profile < env vars < flags.
 package config
 
 import (
 	"flag"
 	"fmt"
 	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 )
 
-// AppConfig holds configuration from defaults and CLI flags.
+// AppConfig holds configuration from defaults, the config file, env vars and CLI flags.
 type AppConfig struct {
 	ListenAddr string // ":8080"
 	TargetURL  *url.URL
 	LogPath    string // ".rwnd/logs"
+	RulesPath  string // Invariant rules file, empty disables rules
+	Tags       []string
+	OutPath    string // Export destination, empty writes to stdout
+	Profile    string // Name of the config file profile in use
+
+	RedactHeaders []string // Header values replaced before records are stored
+	MaxBodyBytes  int64    // Body capture limit, 0 captures everything
+	DedupBodies   bool     // Store repeated large bodies once in a sidecar blob directory
+
+	RotateBytes   int64         // Start a new log part past this size, 0 disables
+	RotateRecords int           // Start a new log part past this many records, 0 disables
+	RotateAge     time.Duration // Start a new log part after this long, 0 disables
+	Compress      string        // "gzip" or "zstd" for new numbered logs, empty writes plain JSONL
+	LogFormat     string        // "json" or "binary" record encoding for new numbered logs
+	Encrypt       bool          // Encrypt new numbered logs with the key from RWND_LOG_KEY or a key file
+	Sync          string        // "always", "interval" or "never" fsync policy
+	Recover       string        // "skip" or "truncate" a torn trailing record when appending
+	ShadowURL     *url.URL      // The proxy mirrors every request here and records both responses
+	ShadowQueue   int           // Mirrored requests waiting for the shadow before more are dropped
+	FaultsPath    string        // Fault rules the proxy injects failures with
+	FaultSeed     int64         // Seed for the fault rules' random choices, 0 uses the file's or the clock
+
+	ReplayTarget  *url.URL // Overrides the scheme and host of recorded URLs on replay
+	MutatePath    string   // Mutations file applied to requests before they are replayed
+	CapturePath   string   // Capture rules chaining values from replayed responses into later requests
+	CookieJar     bool     // Keep cookies set by replayed responses for later replayed requests
+	DropCookies   bool     // Send only the jar's cookies, never the recorded Cookie headers
+	AuthEnv       string   // Env var holding the token replayed requests are authorized with
+	AuthCommand   string   // Shell command printing that token, rerun on a 401
+	AuthLogin     string   // Filter expression picking the log's login request, replayed for a token
+	AuthToken     string   // Where the login response holds the token: json:<path>, header:<name> or regex:<expr>
+	AuthScheme    string   // Prefix for bare tokens, "Bearer" if empty
+	Edits         bool     // Replay the requests edited during earlier replays instead of the log
+	IgnoreHeaders []string // Response headers skipped when diffing
+	IgnoreJSON    []string // JSON body paths skipped when diffing
+
+	Filter        string   // Query expression records must match (see package query)
+	FilterMethod  string   // Only show records with this method
+	FilterPath    string   // Only show records whose path matches this regex
+	FilterStatus  []string // Only show records with one of these statuses ("404", "5xx", ">=400")
+	FilterLatency string   // Only show records whose latency matches (">500ms")
+	Lines         int      // Existing records tail prints before following
+
+	IgnoreCase   bool     // Match the grep pattern case insensitively
+	FixedString  bool     // The grep pattern is a literal string, not a regex
+	SearchFields []string // Fields grep searches, every field if empty
+	Context      int      // Bytes of context grep shows around a match
+	UseIndex     bool     // Build or refresh the log's search index before grepping
+
+	SortBy string // Column stats orders routes by: "count", "route", "p99" or "errors"
+	Title  string // Title of an inferred OpenAPI document
+
+	SpecPath string // OpenAPI document records are validated against
+	Replay   bool   // Validate replayed responses instead of recorded ones
+
+	CompareA *url.URL // Scheme and host compare sends every request to first
+	CompareB *url.URL // Scheme and host its responses are compared against
+	Examples int      // Mismatching requests compare shows per route
+
+	ChaosSeed int64         // Seed for chaos replay's random choices, 0 picks one from the clock
+	Shuffle   int           // Consecutive records chaos replay reorders together
+	Duplicate float64       // Chance chaos replay sends a request twice in a row
+	Retry     float64       // Chance chaos replay throws a response away and sends the request again
+	Jitter    time.Duration // Upper bound of chaos replay's random delay before each request
+
+	Args      []string      // Positional arguments left after flags
+	Format    string        // Output format for reporting commands
+	Keep      int           // Number of newest logs prune keeps
+	OlderThan time.Duration // Prune only removes logs older than this
+	DryRun    bool          // Report what would change without changing it
 }
 
 // Load returns the default application configuration.
@@ -20,7 +96,88 @@ func Load() AppConfig {
 	return AppConfig{
 		ListenAddr: ":8080",
 		LogPath:    ".rwnd/logs",
+		Sync:       "never",
+		Recover:    "skip",
+	}
+}
+
+// layerFlags holds the flags every command uses to pick a config file and profile.
+type layerFlags struct {
+	config  *string
+	profile *string
+}
+
+func addLayerFlags(fs *flag.FlagSet) layerFlags {
+	return layerFlags{
+		config:  fs.String("config", "", "Path to config file (default .rwnd/config.yaml)"),
+		profile: fs.String("profile", "", "Config file profile to use"),
+	}
+}
+
+func (l layerFlags) apply(cfg AppConfig) (AppConfig, error) {
+	return applyFileAndEnv(cfg, *l.config, *l.profile)
+}
+
+// authFlags holds the flags picking an auth provider for replayed requests.
+type authFlags struct {
+	env     *string
+	command *string
+	login   *string
+	token   *string
+	scheme  *string
+}
+
+func addAuthFlags(fs *flag.FlagSet, cfg AppConfig) authFlags {
+	return authFlags{
+		env:     fs.String("auth-env", cfg.AuthEnv, "Authorize replayed requests with the token in this env var"),
+		command: fs.String("auth-command", cfg.AuthCommand, "Authorize replayed requests with the token this shell command prints, rerun on a 401"),
+		login:   fs.String("auth-login", cfg.AuthLogin, "Authorize replayed requests by replaying the log's login request matching this filter, again on a 401"),
+		token:   fs.String("auth-token", cfg.AuthToken, "Where the login response holds the token: json:<path>, header:<name> or regex:<expr> (default json:access_token)"),
+		scheme:  fs.String("auth-scheme", cfg.AuthScheme, "Prefix for bare tokens (default Bearer)"),
+	}
+}
+
+func (a authFlags) apply(set map[string]bool, cfg AppConfig) (AppConfig, error) {
+	// A provider flag replaces whichever provider the file or env picked
+	providers := 0
+	for _, name := range []string{"auth-env", "auth-command", "auth-login"} {
+		if set[name] {
+			providers++
+		}
+	}
+	if providers > 1 {
+		return AppConfig{}, fmt.Errorf("Pick one of --auth-env, --auth-command and --auth-login")
+	}
+	switch {
+	case set["auth-env"]:
+		cfg.AuthEnv, cfg.AuthCommand, cfg.AuthLogin, cfg.AuthToken = *a.env, "", "", ""
+	case set["auth-command"]:
+		cfg.AuthEnv, cfg.AuthCommand, cfg.AuthLogin, cfg.AuthToken = "", *a.command, "", ""
+	case set["auth-login"]:
+		cfg.AuthEnv, cfg.AuthCommand, cfg.AuthLogin = "", "", *a.login
+	}
+	if set["auth-token"] {
+		cfg.AuthToken = *a.token
 	}
+	if set["auth-scheme"] {
+		cfg.AuthScheme = *a.scheme
+	}
+
+	if cfg.AuthToken != "" && cfg.AuthLogin == "" {
+		return AppConfig{}, fmt.Errorf("--auth-token only applies with --auth-login")
+	}
+	return cfg, nil
+}
+
+func (a authFlags) set(set map[string]bool) bool {
+	return set["auth-env"] || set["auth-command"] || set["auth-login"] || set["auth-token"] || set["auth-scheme"]
+}
+
+func setFlags(fs *flag.FlagSet) map[string]bool {
+	// Returns the flags explicitly passed so they can override file and env values
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	return set
 }
 
 // FromProxyArgs parses proxy CLI arguments and applies them to cfg.
@@ -29,6 +186,7 @@ func FromProxyArgs(args []string, cfg AppConfig) (AppConfig, error) {
 	// Logic in this function is referencing this Go by Example page
 	// https://gobyexample.com/command-line-flags
 	fs := flag.NewFlagSet("proxy", flag.ExitOnError)
+	layers := addLayerFlags(fs)
 
 	listen := fs.String(
 		"listen",
@@ -48,22 +206,220 @@ func FromProxyArgs(args []string, cfg AppConfig) (AppConfig, error) {
 		"Path to log file or directory",
 	)
 
+	rulesPath := fs.String(
+		"rules",
+		cfg.RulesPath,
+		"Path to an invariant rules YAML file",
+	)
+
+	redact := fs.String(
+		"redact-header",
+		strings.Join(cfg.RedactHeaders, ","),
+		"Comma separated headers whose values are redacted in the log",
+	)
+
+	maxBody := fs.Int64(
+		"max-body",
+		cfg.MaxBodyBytes,
+		"Maximum body bytes captured per request/response (0 = unlimited)",
+	)
+
+	dedup := fs.Bool(
+		"dedup-bodies",
+		cfg.DedupBodies,
+		"Store each unique large body once, referenced by hash from records",
+	)
+
+	rotateSize := fs.String(
+		"rotate-size",
+		"",
+		"Start a new log part once the current one reaches this size (e.g. 100MB)",
+	)
+
+	rotateRecords := fs.Int(
+		"rotate-records",
+		cfg.RotateRecords,
+		"Start a new log part once the current one holds this many records",
+	)
+
+	rotateAge := fs.String(
+		"rotate-age",
+		"",
+		"Start a new log part once the current one is this old (e.g. 1h, 1d)",
+	)
+
+	compress := fs.String(
+		"compress",
+		cfg.Compress,
+		"Compress new log files with gzip or zstd",
+	)
+
+	logFormat := fs.String(
+		"format",
+		cfg.LogFormat,
+		"Record encoding for new log files: json or binary",
+	)
+
+	encrypt := fs.Bool(
+		"encrypt",
+		cfg.Encrypt,
+		"Encrypt new log files (key from RWND_LOG_KEY, RWND_LOG_KEY_FILE or .rwnd/log.key)",
+	)
+
+	syncPolicy := fs.String(
+		"sync",
+		cfg.Sync,
+		"When to fsync the log: always (every record), interval or never",
+	)
+
+	recovery := fs.String(
+		"recover",
+		cfg.Recover,
+		"What to do with a torn last record when appending to a log: skip or truncate",
+	)
+
+	shadow := fs.String(
+		"shadow",
+		"",
+		"Also mirror every request to this target and record both responses",
+	)
+
+	shadowQueue := fs.Int(
+		"shadow-queue",
+		cfg.ShadowQueue,
+		"Mirrored requests waiting for the shadow target before more are dropped (default 100)",
+	)
+
+	faults := fs.String(
+		"faults",
+		cfg.FaultsPath,
+		"Path to fault rules injecting latency, statuses, drops and broken bodies",
+	)
+
+	faultSeed := fs.Int64(
+		"fault-seed",
+		cfg.FaultSeed,
+		"Seed for the fault rules' random choices, printed at startup when picked",
+	)
+
 	if err := fs.Parse(args); err != nil {
 		return AppConfig{}, err
 	}
 
-	if *target == "" {
-		return AppConfig{}, fmt.Errorf("Missing required --target")
+	cfg, err := layers.apply(cfg)
+	if err != nil {
+		return AppConfig{}, err
 	}
 
-	u, err := url.Parse(*target)
-	if err != nil {
-		return AppConfig{}, fmt.Errorf("Invalid target URL: %v", err)
+	set := setFlags(fs)
+	if set["listen"] {
+		cfg.ListenAddr = *listen
+	}
+	if set["target"] {
+		u, err := url.Parse(*target)
+		if err != nil {
+			return AppConfig{}, fmt.Errorf("Invalid target URL: %v", err)
+		}
+		cfg.TargetURL = u
+	}
+	if set["log"] {
+		cfg.LogPath = *logPath
+	}
+	if set["rules"] {
+		cfg.RulesPath = *rulesPath
+	}
+	if set["redact-header"] {
+		cfg.RedactHeaders = splitList(*redact)
+	}
+	if set["max-body"] {
+		cfg.MaxBodyBytes = *maxBody
+	}
+	if set["dedup-bodies"] {
+		cfg.DedupBodies = *dedup
+	}
+	if set["rotate-size"] {
+		if cfg.RotateBytes, err = parseSize(*rotateSize); err != nil {
+			return AppConfig{}, err
+		}
+	}
+	if set["rotate-records"] {
+		cfg.RotateRecords = *rotateRecords
+	}
+	if set["rotate-age"] {
+		if cfg.RotateAge, err = parseAge(*rotateAge); err != nil {
+			return AppConfig{}, err
+		}
+	}
+	if set["compress"] {
+		cfg.Compress = *compress
+	}
+	if set["format"] {
+		cfg.LogFormat = *logFormat
+	}
+	if set["encrypt"] {
+		cfg.Encrypt = *encrypt
+	}
+	if set["sync"] {
+		cfg.Sync = *syncPolicy
+	}
+	if set["recover"] {
+		cfg.Recover = *recovery
+	}
+	if set["shadow"] {
+		u, err := url.Parse(*shadow)
+		if err != nil {
+			return AppConfig{}, fmt.Errorf("Invalid shadow URL: %v", err)
+		}
+		cfg.ShadowURL = u
+	}
+	if set["shadow-queue"] {
+		cfg.ShadowQueue = *shadowQueue
+	}
+	if set["faults"] {
+		cfg.FaultsPath = *faults
+	}
+	if set["fault-seed"] {
+		cfg.FaultSeed = *faultSeed
 	}
 
-	cfg.ListenAddr = *listen
-	cfg.TargetURL = u
-	cfg.LogPath = *logPath
+	if cfg.TargetURL == nil || cfg.TargetURL.String() == "" {
+		return AppConfig{}, fmt.Errorf("Missing required --target")
+	}
+	if cfg.MaxBodyBytes < 0 {
+		return AppConfig{}, fmt.Errorf("--max-body must not be negative")
+	}
+	switch cfg.Compress {
+	case "", "gzip", "zstd":
+	default:
+		return AppConfig{}, fmt.Errorf("--compress must be gzip or zstd, got %q", cfg.Compress)
+	}
+	switch cfg.LogFormat {
+	case "", "json", "binary":
+	default:
+		return AppConfig{}, fmt.Errorf("--format must be json or binary, got %q", cfg.LogFormat)
+	}
+	switch cfg.Sync {
+	case "", "always", "interval", "never":
+	default:
+		return AppConfig{}, fmt.Errorf("--sync must be always, interval or never, got %q", cfg.Sync)
+	}
+	switch cfg.Recover {
+	case "", "skip", "truncate":
+	default:
+		return AppConfig{}, fmt.Errorf("--recover must be skip or truncate, got %q", cfg.Recover)
+	}
+	if cfg.RotateRecords < 0 {
+		return AppConfig{}, fmt.Errorf("--rotate-records must not be negative")
+	}
+	if cfg.ShadowURL != nil && (!cfg.ShadowURL.IsAbs() || cfg.ShadowURL.Host == "") {
+		return AppConfig{}, fmt.Errorf("--shadow must be an absolute URL, got %q", cfg.ShadowURL)
+	}
+	if cfg.ShadowQueue < 0 {
+		return AppConfig{}, fmt.Errorf("--shadow-queue must not be negative")
+	}
+	if cfg.FaultSeed != 0 && cfg.FaultsPath == "" {
+		return AppConfig{}, fmt.Errorf("--fault-seed needs --faults")
+	}
 
 	return cfg, nil
 }
@@ -73,6 +429,7 @@ func FromReplayArgs(args []string, cfg AppConfig) (AppConfig, error) {
 	// Function to parse arguments for the replay command out
 	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
 	fs.SetOutput(nil) // Set to nil so os.StdErr is used by default
+	layers := addLayerFlags(fs)
 
 	logPath := fs.String(
 		"log",
@@ -80,10 +437,991 @@ func FromReplayArgs(args []string, cfg AppConfig) (AppConfig, error) {
 		"Path to log file or directory",
 	)
 
+	tags := fs.String(
+		"tag",
+		strings.Join(cfg.Tags, ","),
+		"Only step through records with one of these comma separated tags",
+	)
+
+	filter := fs.String(
+		"filter",
+		cfg.Filter,
+		"Only include records matching this filter expression, e.g. 'method = POST and status >= 500'",
+	)
+
+	target := fs.String(
+		"target",
+		"",
+		"Send replayed requests to this scheme and host instead of the recorded one",
+	)
+
+	edits := fs.Bool(
+		"edits",
+		false,
+		"Step through requests edited and saved with e in earlier replays of the log",
+	)
+
+	mutatePath := fs.String(
+		"mutate",
+		cfg.MutatePath,
+		"Path to a mutations YAML file applied to requests before they are replayed",
+	)
+
+	capturePath := fs.String(
+		"capture",
+		cfg.CapturePath,
+		"Path to a capture rules YAML file chaining values from replayed responses into later requests",
+	)
+
+	cookieJar := fs.Bool(
+		"cookie-jar",
+		cfg.CookieJar,
+		"Keep cookies set by replayed responses and send them in place of recorded ones",
+	)
+
+	dropCookies := fs.Bool(
+		"drop-cookies",
+		cfg.DropCookies,
+		"Leave out recorded Cookie headers and only send the cookie jar's (implies --cookie-jar)",
+	)
+
+	auth := addAuthFlags(fs, cfg)
+
+	ignoreHeaders := fs.String(
+		"ignore-header",
+		strings.Join(cfg.IgnoreHeaders, ","),
+		"Comma separated response headers ignored when diffing",
+	)
+
+	ignoreJSON := fs.String(
+		"ignore-json",
+		strings.Join(cfg.IgnoreJSON, ","),
+		"Comma separated JSON body paths ignored when diffing",
+	)
+
+	if err := fs.Parse(args); err != nil {
+		return AppConfig{}, err
+	}
+
+	cfg, err := layers.apply(cfg)
+	if err != nil {
+		return AppConfig{}, err
+	}
+
+	set := setFlags(fs)
+	if set["log"] {
+		cfg.LogPath = *logPath
+	}
+	if set["tag"] {
+		cfg.Tags = splitList(*tags)
+	}
+	if set["filter"] {
+		cfg.Filter = *filter
+	}
+	if set["target"] {
+		u, err := url.Parse(*target)
+		if err != nil {
+			return AppConfig{}, fmt.Errorf("Invalid replay target URL: %v", err)
+		}
+		cfg.ReplayTarget = u
+	}
+	if set["mutate"] {
+		cfg.MutatePath = *mutatePath
+	}
+	if set["capture"] {
+		cfg.CapturePath = *capturePath
+	}
+	if set["cookie-jar"] {
+		cfg.CookieJar = *cookieJar
+	}
+	if set["drop-cookies"] {
+		cfg.DropCookies = *dropCookies
+	}
+	if cfg, err = auth.apply(set, cfg); err != nil {
+		return AppConfig{}, err
+	}
+	cfg.Edits = *edits
+	if set["ignore-header"] {
+		cfg.IgnoreHeaders = splitList(*ignoreHeaders)
+	}
+	if set["ignore-json"] {
+		cfg.IgnoreJSON = splitList(*ignoreJSON)
+	}
+
+	if cfg.ReplayTarget != nil && !cfg.ReplayTarget.IsAbs() {
+		return AppConfig{}, fmt.Errorf("Replay target must be an absolute URL, got %q", cfg.ReplayTarget)
+	}
+	return cfg, nil
+}
+
+// FromExportArgs parses export CLI arguments and applies them to cfg.
+func FromExportArgs(args []string, cfg AppConfig) (AppConfig, error) {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	fs.SetOutput(nil)
+	layers := addLayerFlags(fs)
+
+	logPath := fs.String(
+		"log",
+		cfg.LogPath,
+		"Path to log file or directory",
+	)
+
+	tags := fs.String(
+		"tag",
+		strings.Join(cfg.Tags, ","),
+		"Only export records with one of these comma separated tags",
+	)
+
+	filter := fs.String(
+		"filter",
+		cfg.Filter,
+		"Only include records matching this filter expression, e.g. 'method = POST and status >= 500'",
+	)
+
+	out := fs.String(
+		"out",
+		cfg.OutPath,
+		"File to write exported JSONL to (default stdout)",
+	)
+
+	if err := fs.Parse(args); err != nil {
+		return AppConfig{}, err
+	}
+
+	cfg, err := layers.apply(cfg)
+	if err != nil {
+		return AppConfig{}, err
+	}
+
+	set := setFlags(fs)
+	if set["log"] {
+		cfg.LogPath = *logPath
+	}
+	if set["tag"] {
+		cfg.Tags = splitList(*tags)
+	}
+	if set["filter"] {
+		cfg.Filter = *filter
+	}
+	if set["out"] {
+		cfg.OutPath = *out
+	}
+	return cfg, nil
+}
+
+// FromConvertArgs parses `rwnd convert <in> <out>` arguments and applies them to cfg.
+func FromConvertArgs(args []string, cfg AppConfig) (AppConfig, error) {
+	fs := flag.NewFlagSet("convert", flag.ContinueOnError)
+	fs.SetOutput(nil)
+	layers := addLayerFlags(fs)
+
+	tags := fs.String(
+		"tag",
+		strings.Join(cfg.Tags, ","),
+		"Only convert records with one of these comma separated tags",
+	)
+
+	dedup := fs.Bool(
+		"dedup-bodies",
+		cfg.DedupBodies,
+		"Store each unique large body of the output once, referenced by hash",
+	)
+
+	if err := fs.Parse(args); err != nil {
+		return AppConfig{}, err
+	}
+
+	cfg, err := layers.apply(cfg)
+	if err != nil {
+		return AppConfig{}, err
+	}
+
+	set := setFlags(fs)
+	if set["tag"] {
+		cfg.Tags = splitList(*tags)
+	}
+	if set["dedup-bodies"] {
+		cfg.DedupBodies = *dedup
+	}
+	cfg.Args = fs.Args()
+	if len(cfg.Args) != 2 {
+		return AppConfig{}, fmt.Errorf("Expected an input and output log, e.g. rwnd convert in.jsonl out.rwb")
+	}
+	return cfg, nil
+}
+
+// FromGrepArgs parses `rwnd grep` arguments and applies them to cfg.
+func FromGrepArgs(args []string, cfg AppConfig) (AppConfig, error) {
+	fs := flag.NewFlagSet("grep", flag.ContinueOnError)
+	fs.SetOutput(nil)
+	layers := addLayerFlags(fs)
+
+	logPath := fs.String(
+		"log",
+		cfg.LogPath,
+		"Log file or directory to search (defaults to the latest log)",
+	)
+
+	tags := fs.String(
+		"tag",
+		strings.Join(cfg.Tags, ","),
+		"Only search records with one of these comma separated tags",
+	)
+
+	filter := fs.String(
+		"filter",
+		cfg.Filter,
+		"Only search records matching this filter expression, e.g. 'resp.json.order.id = 42'",
+	)
+
+	in := fs.String(
+		"in",
+		"",
+		"Comma separated fields to search: url, req.header, resp.header, req.body, resp.body (default all)",
+	)
+
+	ignoreCase := fs.Bool("i", false, "Match case insensitively")
+	fixed := fs.Bool("F", false, "Treat the pattern as a literal string")
+	context := fs.Int("C", 40, "Bytes of context to show around a match")
+	useIndex := fs.Bool("index", false, "Build or refresh the log's search index and use it")
+	ids := fs.Bool("ids", false, "Only print the IDs of matching records")
+	asJSON := fs.Bool("json", false, "Print matching records as JSONL")
+
 	if err := fs.Parse(args); err != nil {
 		return AppConfig{}, err
 	}
 
-	cfg.LogPath = *logPath
+	cfg, err := layers.apply(cfg)
+	if err != nil {
+		return AppConfig{}, err
+	}
+
+	set := setFlags(fs)
+	if set["log"] {
+		cfg.LogPath = *logPath
+	}
+	if set["tag"] {
+		cfg.Tags = splitList(*tags)
+	}
+	if set["filter"] {
+		cfg.Filter = *filter
+	}
+	cfg.SearchFields = splitList(*in)
+	cfg.IgnoreCase = *ignoreCase
+	cfg.FixedString = *fixed
+	cfg.Context = *context
+	cfg.UseIndex = *useIndex
+
+	switch {
+	case *ids && *asJSON:
+		return AppConfig{}, fmt.Errorf("--ids and --json can't be combined")
+	case *ids:
+		cfg.Format = "ids"
+	case *asJSON:
+		cfg.Format = "json"
+	}
+	if cfg.Context < 0 {
+		return AppConfig{}, fmt.Errorf("-C must not be negative")
+	}
+
+	cfg.Args = fs.Args()
+	if len(cfg.Args) > 1 {
+		return AppConfig{}, fmt.Errorf("Expected one pattern, quote patterns with spaces")
+	}
+	if len(cfg.Args) == 0 && cfg.Filter == "" {
+		return AppConfig{}, fmt.Errorf("Expected a pattern or --filter, e.g. rwnd grep ORD-1234")
+	}
 	return cfg, nil
 }
+
+// FromStatsArgs parses `rwnd stats` arguments and applies them to cfg.
+func FromStatsArgs(args []string, cfg AppConfig) (AppConfig, error) {
+	fs := flag.NewFlagSet("stats", flag.ContinueOnError)
+	fs.SetOutput(nil)
+	layers := addLayerFlags(fs)
+
+	logPath := fs.String(
+		"log",
+		cfg.LogPath,
+		"Log file or directory to report on (defaults to the latest log)",
+	)
+
+	tags := fs.String(
+		"tag",
+		strings.Join(cfg.Tags, ","),
+		"Only count records with one of these comma separated tags",
+	)
+
+	filter := fs.String(
+		"filter",
+		cfg.Filter,
+		"Only count records matching this filter expression, e.g. 'method = POST'",
+	)
+
+	sortBy := fs.String("sort", "count", "Order routes by count, route, p99 or errors")
+	asJSON := fs.Bool("json", false, "Print the report as JSON")
+	markdown := fs.Bool("markdown", false, "Print the report as a Markdown table")
+
+	if err := fs.Parse(args); err != nil {
+		return AppConfig{}, err
+	}
+
+	cfg, err := layers.apply(cfg)
+	if err != nil {
+		return AppConfig{}, err
+	}
+
+	set := setFlags(fs)
+	if set["log"] {
+		cfg.LogPath = *logPath
+	}
+	if set["tag"] {
+		cfg.Tags = splitList(*tags)
+	}
+	if set["filter"] {
+		cfg.Filter = *filter
+	}
+
+	switch *sortBy {
+	case "count", "route", "p99", "errors":
+		cfg.SortBy = *sortBy
+	default:
+		return AppConfig{}, fmt.Errorf("Invalid --sort %q (use count, route, p99 or errors)", *sortBy)
+	}
+	switch {
+	case *asJSON && *markdown:
+		return AppConfig{}, fmt.Errorf("--json and --markdown can't be combined")
+	case *asJSON:
+		cfg.Format = "json"
+	case *markdown:
+		cfg.Format = "markdown"
+	}
+
+	if fs.NArg() > 0 {
+		return AppConfig{}, fmt.Errorf("Unexpected argument %q, pick a log with --log", fs.Arg(0))
+	}
+	return cfg, nil
+}
+
+// FromInferArgs parses `rwnd infer <sub>` arguments and applies them to cfg.
+func FromInferArgs(sub string, args []string, cfg AppConfig) (AppConfig, error) {
+	fs := flag.NewFlagSet("infer "+sub, flag.ContinueOnError)
+	fs.SetOutput(nil)
+	layers := addLayerFlags(fs)
+
+	logPath := fs.String(
+		"log",
+		cfg.LogPath,
+		"Log file or directory to infer from (defaults to the latest log)",
+	)
+
+	tags := fs.String(
+		"tag",
+		strings.Join(cfg.Tags, ","),
+		"Only use records with one of these comma separated tags",
+	)
+
+	filter := fs.String(
+		"filter",
+		cfg.Filter,
+		"Only use records matching this filter expression, e.g. 'path ~ ^/api'",
+	)
+
+	outPath := fs.String("out", "", "File to write the document to (defaults to stdout)")
+	title := fs.String("title", "", "Title of the document (defaults to the log name)")
+	asJSON := fs.Bool("json", false, "Write JSON instead of YAML, implied by an --out ending in .json")
+
+	if err := fs.Parse(args); err != nil {
+		return AppConfig{}, err
+	}
+
+	cfg, err := layers.apply(cfg)
+	if err != nil {
+		return AppConfig{}, err
+	}
+
+	set := setFlags(fs)
+	if set["log"] {
+		cfg.LogPath = *logPath
+	}
+	if set["tag"] {
+		cfg.Tags = splitList(*tags)
+	}
+	if set["filter"] {
+		cfg.Filter = *filter
+	}
+	cfg.OutPath = *outPath
+	cfg.Title = *title
+	if *asJSON || strings.EqualFold(filepath.Ext(cfg.OutPath), ".json") {
+		cfg.Format = "json"
+	}
+
+	if fs.NArg() > 0 {
+		return AppConfig{}, fmt.Errorf("Unexpected argument %q, pick a log with --log", fs.Arg(0))
+	}
+	return cfg, nil
+}
+
+// FromValidateArgs parses `rwnd validate` arguments and applies them to cfg.
+func FromValidateArgs(args []string, cfg AppConfig) (AppConfig, error) {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	fs.SetOutput(nil)
+	layers := addLayerFlags(fs)
+
+	spec := fs.String("spec", "", "OpenAPI 3 document to validate against (required)")
+
+	logPath := fs.String(
+		"log",
+		cfg.LogPath,
+		"Log file or directory to validate (defaults to the latest log)",
+	)
+
+	tags := fs.String(
+		"tag",
+		strings.Join(cfg.Tags, ","),
+		"Only validate records with one of these comma separated tags",
+	)
+
+	filter := fs.String(
+		"filter",
+		cfg.Filter,
+		"Only validate records matching this filter expression, e.g. 'path ~ ^/api'",
+	)
+
+	replay := fs.Bool("replay", false, "Re-send every request and validate the live responses")
+	target := fs.String(
+		"target",
+		"",
+		"With --replay, send requests to this scheme and host instead of the recorded one",
+	)
+	capturePath := fs.String(
+		"capture",
+		cfg.CapturePath,
+		"With --replay, capture rules chaining values from replayed responses into later requests",
+	)
+	cookieJar := fs.Bool("cookie-jar", cfg.CookieJar, "With --replay, keep cookies set by replayed responses")
+	dropCookies := fs.Bool("drop-cookies", cfg.DropCookies, "With --replay, only send the cookie jar's cookies (implies --cookie-jar)")
+	auth := addAuthFlags(fs, cfg)
+	asJSON := fs.Bool("json", false, "Print one JSON line per record with violations")
+
+	if err := fs.Parse(args); err != nil {
+		return AppConfig{}, err
+	}
+
+	cfg, err := layers.apply(cfg)
+	if err != nil {
+		return AppConfig{}, err
+	}
+
+	set := setFlags(fs)
+	if set["log"] {
+		cfg.LogPath = *logPath
+	}
+	if set["tag"] {
+		cfg.Tags = splitList(*tags)
+	}
+	if set["filter"] {
+		cfg.Filter = *filter
+	}
+	if set["target"] {
+		u, err := url.Parse(*target)
+		if err != nil || !u.IsAbs() {
+			return AppConfig{}, fmt.Errorf("Replay target must be an absolute URL, got %q", *target)
+		}
+		cfg.ReplayTarget = u
+	}
+	if set["capture"] {
+		cfg.CapturePath = *capturePath
+	}
+	if set["cookie-jar"] {
+		cfg.CookieJar = *cookieJar
+	}
+	if set["drop-cookies"] {
+		cfg.DropCookies = *dropCookies
+	}
+	if cfg, err = auth.apply(set, cfg); err != nil {
+		return AppConfig{}, err
+	}
+	cfg.SpecPath = *spec
+	cfg.Replay = *replay
+	if *asJSON {
+		cfg.Format = "json"
+	}
+
+	switch {
+	case cfg.SpecPath == "":
+		return AppConfig{}, fmt.Errorf("--spec is required, e.g. rwnd validate --spec openapi.yaml")
+	case set["target"] && !cfg.Replay:
+		return AppConfig{}, fmt.Errorf("--target only applies with --replay")
+	case set["capture"] && !cfg.Replay:
+		return AppConfig{}, fmt.Errorf("--capture only applies with --replay")
+	case (set["cookie-jar"] || set["drop-cookies"]) && !cfg.Replay:
+		return AppConfig{}, fmt.Errorf("--cookie-jar and --drop-cookies only apply with --replay")
+	case auth.set(set) && !cfg.Replay:
+		return AppConfig{}, fmt.Errorf("--auth flags only apply with --replay")
+	case fs.NArg() > 0:
+		return AppConfig{}, fmt.Errorf("Unexpected argument %q, pick a log with --log", fs.Arg(0))
+	}
+	return cfg, nil
+}
+
+// FromCompareArgs parses `rwnd compare` arguments and applies them to cfg.
+func FromCompareArgs(args []string, cfg AppConfig) (AppConfig, error) {
+	fs := flag.NewFlagSet("compare", flag.ContinueOnError)
+	fs.SetOutput(nil)
+	layers := addLayerFlags(fs)
+
+	logPath := fs.String(
+		"log",
+		cfg.LogPath,
+		"Log file or directory to compare (defaults to the latest log)",
+	)
+
+	tags := fs.String(
+		"tag",
+		strings.Join(cfg.Tags, ","),
+		"Only compare records with one of these comma separated tags",
+	)
+
+	filter := fs.String(
+		"filter",
+		cfg.Filter,
+		"Only compare records matching this filter expression, e.g. 'method = GET'",
+	)
+
+	a := fs.String("a", "", "Scheme and host of the first deployment, e.g. http://old:3000 (required)")
+	b := fs.String("b", "", "Scheme and host of the deployment compared against it (required)")
+	examples := fs.Int("examples", 3, "Mismatching requests shown per route")
+	capturePath := fs.String(
+		"capture",
+		cfg.CapturePath,
+		"Capture rules chaining values from each deployment's responses into its later requests",
+	)
+	cookieJar := fs.Bool("cookie-jar", cfg.CookieJar, "Keep a cookie jar per deployment")
+	dropCookies := fs.Bool("drop-cookies", cfg.DropCookies, "Only send the cookie jars' cookies (implies --cookie-jar)")
+	auth := addAuthFlags(fs, cfg)
+
+	ignoreHeaders := fs.String(
+		"ignore-header",
+		strings.Join(cfg.IgnoreHeaders, ","),
+		"Comma separated response headers ignored when comparing",
+	)
+
+	ignoreJSON := fs.String(
+		"ignore-json",
+		strings.Join(cfg.IgnoreJSON, ","),
+		"Comma separated JSON body paths ignored when comparing",
+	)
+
+	asJSON := fs.Bool("json", false, "Print the report as JSON")
+
+	if err := fs.Parse(args); err != nil {
+		return AppConfig{}, err
+	}
+
+	cfg, err := layers.apply(cfg)
+	if err != nil {
+		return AppConfig{}, err
+	}
+
+	set := setFlags(fs)
+	if set["log"] {
+		cfg.LogPath = *logPath
+	}
+	if set["tag"] {
+		cfg.Tags = splitList(*tags)
+	}
+	if set["filter"] {
+		cfg.Filter = *filter
+	}
+	if set["capture"] {
+		cfg.CapturePath = *capturePath
+	}
+	if set["cookie-jar"] {
+		cfg.CookieJar = *cookieJar
+	}
+	if set["drop-cookies"] {
+		cfg.DropCookies = *dropCookies
+	}
+	if cfg, err = auth.apply(set, cfg); err != nil {
+		return AppConfig{}, err
+	}
+	if set["ignore-header"] {
+		cfg.IgnoreHeaders = splitList(*ignoreHeaders)
+	}
+	if set["ignore-json"] {
+		cfg.IgnoreJSON = splitList(*ignoreJSON)
+	}
+	if *asJSON {
+		cfg.Format = "json"
+	}
+
+	if *a == "" || *b == "" {
+		return AppConfig{}, fmt.Errorf("--a and --b are required, e.g. rwnd compare --a http://old:3000 --b http://new:3000")
+	}
+	if cfg.CompareA, err = compareURL("a", *a); err != nil {
+		return AppConfig{}, err
+	}
+	if cfg.CompareB, err = compareURL("b", *b); err != nil {
+		return AppConfig{}, err
+	}
+
+	switch {
+	case *examples < 0:
+		return AppConfig{}, fmt.Errorf("--examples can't be negative")
+	case fs.NArg() > 0:
+		return AppConfig{}, fmt.Errorf("Unexpected argument %q, pick a log with --log", fs.Arg(0))
+	}
+	cfg.Examples = *examples
+	return cfg, nil
+}
+
+func compareURL(flagName, value string) (*url.URL, error) {
+	u, err := url.Parse(value)
+	if err != nil || !u.IsAbs() || u.Host == "" {
+		return nil, fmt.Errorf("--%s must be an absolute URL, got %q", flagName, value)
+	}
+	return u, nil
+}
+
+// FromChaosArgs parses `rwnd chaos` arguments and applies them to cfg.
+func FromChaosArgs(args []string, cfg AppConfig) (AppConfig, error) {
+	fs := flag.NewFlagSet("chaos", flag.ContinueOnError)
+	fs.SetOutput(nil)
+	layers := addLayerFlags(fs)
+
+	logPath := fs.String(
+		"log",
+		cfg.LogPath,
+		"Log file or directory to replay (defaults to the latest log)",
+	)
+
+	tags := fs.String(
+		"tag",
+		strings.Join(cfg.Tags, ","),
+		"Only replay records with one of these comma separated tags",
+	)
+
+	filter := fs.String(
+		"filter",
+		cfg.Filter,
+		"Only replay records matching this filter expression, e.g. 'path ~ ^/orders'",
+	)
+
+	target := fs.String(
+		"target",
+		"",
+		"Send replayed requests to this scheme and host instead of the recorded one",
+	)
+
+	seed := fs.Int64("seed", 0, "Seed for every random choice, printed at the start when picked")
+	shuffle := fs.Int("shuffle", 8, "Consecutive records reordered together, 1 keeps the recorded order")
+	duplicate := fs.Float64("duplicate", 0.1, "Chance a request is sent twice in a row")
+	retry := fs.Float64("retry", 0.1, "Chance a request's response is thrown away and it is sent again")
+	jitter := fs.Duration("jitter", 0, "Upper bound of a random delay before each request, e.g. 50ms")
+	capturePath := fs.String(
+		"capture",
+		cfg.CapturePath,
+		"Capture rules chaining values from replayed responses into later requests",
+	)
+	cookieJar := fs.Bool("cookie-jar", cfg.CookieJar, "Keep cookies set by replayed responses")
+	dropCookies := fs.Bool("drop-cookies", cfg.DropCookies, "Only send the cookie jar's cookies (implies --cookie-jar)")
+	auth := addAuthFlags(fs, cfg)
+
+	ignoreHeaders := fs.String(
+		"ignore-header",
+		strings.Join(cfg.IgnoreHeaders, ","),
+		"Comma separated response headers ignored when comparing",
+	)
+
+	ignoreJSON := fs.String(
+		"ignore-json",
+		strings.Join(cfg.IgnoreJSON, ","),
+		"Comma separated JSON body paths ignored when comparing",
+	)
+
+	if err := fs.Parse(args); err != nil {
+		return AppConfig{}, err
+	}
+
+	cfg, err := layers.apply(cfg)
+	if err != nil {
+		return AppConfig{}, err
+	}
+
+	set := setFlags(fs)
+	if set["log"] {
+		cfg.LogPath = *logPath
+	}
+	if set["tag"] {
+		cfg.Tags = splitList(*tags)
+	}
+	if set["filter"] {
+		cfg.Filter = *filter
+	}
+	if set["target"] {
+		u, err := url.Parse(*target)
+		if err != nil {
+			return AppConfig{}, fmt.Errorf("Invalid replay target URL: %v", err)
+		}
+		cfg.ReplayTarget = u
+	}
+	if set["capture"] {
+		cfg.CapturePath = *capturePath
+	}
+	if set["cookie-jar"] {
+		cfg.CookieJar = *cookieJar
+	}
+	if set["drop-cookies"] {
+		cfg.DropCookies = *dropCookies
+	}
+	if cfg, err = auth.apply(set, cfg); err != nil {
+		return AppConfig{}, err
+	}
+	if set["ignore-header"] {
+		cfg.IgnoreHeaders = splitList(*ignoreHeaders)
+	}
+	if set["ignore-json"] {
+		cfg.IgnoreJSON = splitList(*ignoreJSON)
+	}
+
+	switch {
+	case *shuffle < 0:
+		return AppConfig{}, fmt.Errorf("--shuffle can't be negative")
+	case *duplicate < 0 || *duplicate > 1:
+		return AppConfig{}, fmt.Errorf("--duplicate must be between 0 and 1, got %v", *duplicate)
+	case *retry < 0 || *retry > 1:
+		return AppConfig{}, fmt.Errorf("--retry must be between 0 and 1, got %v", *retry)
+	case *jitter < 0:
+		return AppConfig{}, fmt.Errorf("--jitter can't be negative")
+	case fs.NArg() > 0:
+		return AppConfig{}, fmt.Errorf("Unexpected argument %q, pick a log with --log", fs.Arg(0))
+	}
+	cfg.ChaosSeed = *seed
+	cfg.Shuffle = *shuffle
+	cfg.Duplicate = *duplicate
+	cfg.Retry = *retry
+	cfg.Jitter = *jitter
+	return cfg, nil
+}
+
+// FromTailArgs parses `rwnd tail` arguments and applies them to cfg.
+func FromTailArgs(args []string, cfg AppConfig) (AppConfig, error) {
+	fs := flag.NewFlagSet("tail", flag.ContinueOnError)
+	fs.SetOutput(nil)
+	layers := addLayerFlags(fs)
+
+	logPath := fs.String(
+		"log",
+		cfg.LogPath,
+		"Path to log file or directory (default latest log)",
+	)
+
+	tags := fs.String(
+		"tag",
+		strings.Join(cfg.Tags, ","),
+		"Only show records with one of these comma separated tags",
+	)
+
+	filter := fs.String(
+		"filter",
+		cfg.Filter,
+		"Only include records matching this filter expression, e.g. 'method = POST and status >= 500'",
+	)
+
+	method := fs.String(
+		"method",
+		"",
+		"Only show records with this HTTP method",
+	)
+
+	path := fs.String(
+		"path",
+		"",
+		"Only show records whose path matches this regex",
+	)
+
+	status := fs.String(
+		"status",
+		"",
+		"Only show records with one of these comma separated statuses (404, 5xx, >=400)",
+	)
+
+	latency := fs.String(
+		"latency",
+		"",
+		"Only show records whose latency matches, e.g. >500ms",
+	)
+
+	lines := fs.Int(
+		"n",
+		10,
+		"Number of existing records to print before following",
+	)
+
+	asJSON := fs.Bool("json", false, "Print records as JSONL")
+	asTUI := fs.Bool("tui", false, "Show the live stream in the terminal UI")
+
+	if err := fs.Parse(args); err != nil {
+		return AppConfig{}, err
+	}
+
+	cfg, err := layers.apply(cfg)
+	if err != nil {
+		return AppConfig{}, err
+	}
+
+	set := setFlags(fs)
+	if set["log"] {
+		cfg.LogPath = *logPath
+	}
+	if set["tag"] {
+		cfg.Tags = splitList(*tags)
+	}
+	if set["filter"] {
+		cfg.Filter = *filter
+	}
+	cfg.FilterMethod = *method
+	cfg.FilterPath = *path
+	cfg.FilterStatus = splitList(*status)
+	cfg.FilterLatency = *latency
+	cfg.Lines = *lines
+
+	switch {
+	case *asJSON && *asTUI:
+		return AppConfig{}, fmt.Errorf("--json and --tui can't be combined")
+	case *asJSON:
+		cfg.Format = "json"
+	case *asTUI:
+		cfg.Format = "tui"
+	}
+	if cfg.Lines < 0 {
+		return AppConfig{}, fmt.Errorf("-n must not be negative")
+	}
+	return cfg, nil
+}
+
+// FromLogsArgs parses arguments for a `rwnd logs` subcommand and applies them to cfg.
+func FromLogsArgs(sub string, args []string, cfg AppConfig) (AppConfig, error) {
+	fs := flag.NewFlagSet("logs "+sub, flag.ContinueOnError)
+	fs.SetOutput(nil)
+	layers := addLayerFlags(fs)
+
+	logPath := fs.String(
+		"log",
+		cfg.LogPath,
+		"Log directory",
+	)
+
+	var asJSON *bool
+	var keep *int
+	var olderThan *string
+	var dryRun *bool
+	var outPath *string
+	switch sub {
+	case "show":
+		asJSON = fs.Bool("json", false, "Print records as JSONL")
+	case "prune":
+		keep = fs.Int("keep", 0, "Always keep the newest N logs")
+		olderThan = fs.String("older-than", "", "Only remove logs older than this (e.g. 36h, 7d, 2w)")
+		dryRun = fs.Bool("dry-run", false, "Print what would be removed without removing it")
+	case "decrypt":
+		outPath = fs.String("out", "", "Write the plaintext records here instead of stdout")
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return AppConfig{}, err
+	}
+
+	cfg, err := layers.apply(cfg)
+	if err != nil {
+		return AppConfig{}, err
+	}
+
+	if setFlags(fs)["log"] {
+		cfg.LogPath = *logPath
+	}
+	cfg.Args = fs.Args()
+
+	if asJSON != nil && *asJSON {
+		cfg.Format = "json"
+	}
+	if outPath != nil {
+		cfg.OutPath = *outPath
+	}
+	if keep != nil {
+		if *keep < 0 {
+			return AppConfig{}, fmt.Errorf("--keep must not be negative")
+		}
+		cfg.Keep = *keep
+		cfg.DryRun = *dryRun
+		if *olderThan != "" {
+			age, err := parseAge(*olderThan)
+			if err != nil {
+				return AppConfig{}, err
+			}
+			cfg.OlderThan = age
+		}
+		if cfg.Keep == 0 && cfg.OlderThan == 0 {
+			return AppConfig{}, fmt.Errorf("prune needs --keep and/or --older-than")
+		}
+	}
+	return cfg, nil
+}
+
+func parseAge(value string) (time.Duration, error) {
+	// Parses durations like "90m", "36h", "7d" or "2w"
+	v := strings.TrimSpace(value)
+	units := []struct {
+		suffix string
+		unit   time.Duration
+	}{{"d", 24 * time.Hour}, {"w", 7 * 24 * time.Hour}}
+	for _, u := range units {
+		if n, ok := strings.CutSuffix(v, u.suffix); ok {
+			f, err := strconv.ParseFloat(n, 64)
+			if err != nil || f < 0 {
+				return 0, fmt.Errorf("Invalid age %q", value)
+			}
+			return time.Duration(f * float64(u.unit)), nil
+		}
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("Invalid age %q", value)
+	}
+	return d, nil
+}
+
+func parseSize(value string) (int64, error) {
+	// Parses sizes like "512", "64KB", "10MB" or "1GB"
+	v := strings.ToUpper(strings.TrimSpace(value))
+	mult := int64(1)
+	units := []struct {
+		suffix string
+		mult   int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}}
+	for _, u := range units {
+		if n, ok := strings.CutSuffix(v, u.suffix); ok {
+			v = strings.TrimSpace(n)
+			mult = u.mult
+			break
+		}
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("Invalid size %q", value)
+	}
+	return n * mult, nil
+}
+
+func splitList(value string) []string {
+	// Splits a comma separated flag value and drops empty entries
+	var out []string
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
@@ -1,7 +1,10 @@
 package config_test
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/BarrettBr/RWND/internal/config"
 )
@@ -60,3 +63,510 @@ func TestFromReplayArgs_AppliesLogOverride(t *testing.T) {
 		t.Fatalf("Expected LogPath=x.jsonl, got %q", cfg.LogPath)
 	}
 }
+
+func TestFromProxyArgs_RulesPath(t *testing.T) {
+	cfg, err := config.FromProxyArgs([]string{
+		"--target", "http://localhost:3000",
+		"--rules", ".rwnd/rules.yaml",
+	}, config.Load())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if cfg.RulesPath != ".rwnd/rules.yaml" {
+		t.Fatalf("Expected RulesPath=.rwnd/rules.yaml, got %q", cfg.RulesPath)
+	}
+}
+
+func TestFromReplayArgs_SplitsTags(t *testing.T) {
+	cfg, err := config.FromReplayArgs([]string{"--tag", "auth, slow,,"}, config.Load())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(cfg.Tags) != 2 || cfg.Tags[0] != "auth" || cfg.Tags[1] != "slow" {
+		t.Fatalf("Expected Tags=[auth slow], got %v", cfg.Tags)
+	}
+}
+
+func TestFromExportArgs_AppliesOverrides(t *testing.T) {
+	cfg, err := config.FromExportArgs([]string{"--log", "x.jsonl", "--tag", "auth", "--out", "out.jsonl"}, config.Load())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if cfg.LogPath != "x.jsonl" || cfg.OutPath != "out.jsonl" || len(cfg.Tags) != 1 {
+		t.Fatalf("Unexpected config: %+v", cfg)
+	}
+}
+
+func writeConfig(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(body), 0644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	return path
+}
+
+const profileConfig = `
+default_profile: local
+profiles:
+  local:
+    listen: ":7000"
+    target: http://localhost:3000
+  checkout:
+    listen: ":9000"
+    target: http://checkout:8080
+    log: .rwnd/logs/checkout
+    rules: checkout-rules.yaml
+    redact:
+      headers: [Authorization]
+    filters:
+      tags: [checkout]
+    capture:
+      max_body_bytes: 1024
+    replay:
+      target: http://staging:8080
+      mutations: what-if.yaml
+    diff:
+      ignore_headers: [X-Request-Id]
+      ignore_json: [meta.requestId]
+`
+
+func TestFromProxyArgs_ProfileFromFile(t *testing.T) {
+	path := writeConfig(t, profileConfig)
+
+	cfg, err := config.FromProxyArgs([]string{"--config", path, "--profile", "checkout"}, config.Load())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if cfg.Profile != "checkout" || cfg.ListenAddr != ":9000" || cfg.TargetURL.String() != "http://checkout:8080" {
+		t.Fatalf("Profile not applied: %+v", cfg)
+	}
+	if cfg.LogPath != ".rwnd/logs/checkout" || cfg.RulesPath != "checkout-rules.yaml" || cfg.MaxBodyBytes != 1024 {
+		t.Fatalf("Profile not applied: %+v", cfg)
+	}
+	if len(cfg.RedactHeaders) != 1 || cfg.RedactHeaders[0] != "Authorization" {
+		t.Fatalf("Expected redacted Authorization, got %v", cfg.RedactHeaders)
+	}
+}
+
+func TestFromProxyArgs_DefaultProfile(t *testing.T) {
+	path := writeConfig(t, profileConfig)
+
+	cfg, err := config.FromProxyArgs([]string{"--config", path}, config.Load())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if cfg.Profile != "local" || cfg.ListenAddr != ":7000" {
+		t.Fatalf("Expected default profile local, got %+v", cfg)
+	}
+}
+
+func TestFromProxyArgs_Precedence(t *testing.T) {
+	path := writeConfig(t, profileConfig)
+	t.Setenv("RWND_PROFILE", "checkout")
+	t.Setenv("RWND_LISTEN", ":9100")
+	t.Setenv("RWND_LOG", "env-logs")
+
+	cfg, err := config.FromProxyArgs([]string{"--config", path, "--log", "flag-logs"}, config.Load())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if cfg.Profile != "checkout" {
+		t.Fatalf("Expected env profile checkout, got %q", cfg.Profile)
+	}
+	if cfg.ListenAddr != ":9100" {
+		t.Fatalf("Expected env to override file listen, got %q", cfg.ListenAddr)
+	}
+	if cfg.LogPath != "flag-logs" {
+		t.Fatalf("Expected flag to override env log, got %q", cfg.LogPath)
+	}
+	if cfg.TargetURL.String() != "http://checkout:8080" {
+		t.Fatalf("Expected file target, got %v", cfg.TargetURL)
+	}
+}
+
+func TestFromProxyArgs_UnknownProfile(t *testing.T) {
+	path := writeConfig(t, profileConfig)
+	if _, err := config.FromProxyArgs([]string{"--config", path, "--profile", "nope"}, config.Load()); err == nil {
+		t.Fatalf("Expected error for unknown profile")
+	}
+}
+
+func TestFromProxyArgs_MissingExplicitConfig(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing.yaml")
+	if _, err := config.FromProxyArgs([]string{"--config", missing, "--target", "http://x"}, config.Load()); err == nil {
+		t.Fatalf("Expected error for missing explicit config file")
+	}
+}
+
+func TestFromReplayArgs_ProfileReplaySettings(t *testing.T) {
+	path := writeConfig(t, profileConfig)
+
+	cfg, err := config.FromReplayArgs([]string{"--config", path, "--profile", "checkout", "--ignore-json", "id"}, config.Load())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if cfg.ReplayTarget == nil || cfg.ReplayTarget.Host != "staging:8080" {
+		t.Fatalf("Expected replay target from profile, got %v", cfg.ReplayTarget)
+	}
+	if cfg.MutatePath != "what-if.yaml" {
+		t.Fatalf("Expected mutations file from profile, got %q", cfg.MutatePath)
+	}
+	if len(cfg.Tags) != 1 || cfg.Tags[0] != "checkout" {
+		t.Fatalf("Expected filter tags from profile, got %v", cfg.Tags)
+	}
+	if len(cfg.IgnoreHeaders) != 1 || cfg.IgnoreHeaders[0] != "X-Request-Id" {
+		t.Fatalf("Expected ignore headers from profile, got %v", cfg.IgnoreHeaders)
+	}
+	if len(cfg.IgnoreJSON) != 1 || cfg.IgnoreJSON[0] != "id" {
+		t.Fatalf("Expected flag ignore-json to win, got %v", cfg.IgnoreJSON)
+	}
+}
+
+func TestFromReplayArgs_FlagsOverrideEnv(t *testing.T) {
+	t.Setenv("RWND_REPLAY_MUTATIONS", "env.yaml")
+	t.Setenv("RWND_REPLAY_COOKIE_JAR", "true")
+	cfg, err := config.FromReplayArgs([]string{}, config.Load())
+	if err != nil || cfg.MutatePath != "env.yaml" || !cfg.CookieJar {
+		t.Fatalf("Expected RWND_REPLAY_MUTATIONS, got %q err=%v", cfg.MutatePath, err)
+	}
+	cfg, err = config.FromReplayArgs([]string{"--mutate", "flag.yaml", "--edits", "--capture", "chain.yaml", "--cookie-jar=false", "--drop-cookies"}, config.Load())
+	if err != nil || cfg.MutatePath != "flag.yaml" || !cfg.Edits || cfg.CapturePath != "chain.yaml" || cfg.CookieJar || !cfg.DropCookies {
+		t.Fatalf("Expected --mutate to win, got %q err=%v", cfg.MutatePath, err)
+	}
+}
+
+func TestFromReplayArgs_Auth(t *testing.T) {
+	t.Setenv("RWND_REPLAY_AUTH_COMMAND", "get-token")
+	cfg, err := config.FromReplayArgs([]string{}, config.Load())
+	if err != nil || cfg.AuthCommand != "get-token" {
+		t.Fatalf("Expected RWND_REPLAY_AUTH_COMMAND, got %q err=%v", cfg.AuthCommand, err)
+	}
+
+	// A provider flag replaces the provider from the environment
+	cfg, err = config.FromReplayArgs([]string{"--auth-login", "path = /login", "--auth-token", "header:X-Token"}, config.Load())
+	if err != nil || cfg.AuthCommand != "" || cfg.AuthLogin != "path = /login" || cfg.AuthToken != "header:X-Token" {
+		t.Fatalf("Expected --auth-login to win, got %+v err=%v", cfg, err)
+	}
+
+	if _, err := config.FromReplayArgs([]string{"--auth-env", "TOKEN", "--auth-command", "get-token"}, config.Load()); err == nil {
+		t.Fatalf("Expected error for two auth providers")
+	}
+	if _, err := config.FromReplayArgs([]string{"--auth-env", "TOKEN", "--auth-token", "json:token"}, config.Load()); err == nil {
+		t.Fatalf("Expected error for --auth-token without --auth-login")
+	}
+}
+
+func TestFromLogsArgs_Prune(t *testing.T) {
+	cfg, err := config.FromLogsArgs("prune", []string{"--keep", "3", "--older-than", "7d", "--dry-run"}, config.Load())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if cfg.Keep != 3 || cfg.OlderThan != 7*24*time.Hour || !cfg.DryRun {
+		t.Fatalf("Unexpected prune config: %+v", cfg)
+	}
+
+	if _, err := config.FromLogsArgs("prune", []string{}, config.Load()); err == nil {
+		t.Fatalf("Expected error when prune has no criteria")
+	}
+	if _, err := config.FromLogsArgs("prune", []string{"--older-than", "soon"}, config.Load()); err == nil {
+		t.Fatalf("Expected error for invalid age")
+	}
+}
+
+func TestFromLogsArgs_ShowPositional(t *testing.T) {
+	cfg, err := config.FromLogsArgs("show", []string{"--json", "7"}, config.Load())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if cfg.Format != "json" || len(cfg.Args) != 1 || cfg.Args[0] != "7" {
+		t.Fatalf("Unexpected show config: %+v", cfg)
+	}
+}
+
+func TestFromProxyArgs_Rotation(t *testing.T) {
+	cfg, err := config.FromProxyArgs([]string{
+		"--target", "http://localhost:3000",
+		"--rotate-size", "10MB",
+		"--rotate-records", "500",
+		"--rotate-age", "1d",
+	}, config.Load())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if cfg.RotateBytes != 10<<20 || cfg.RotateRecords != 500 || cfg.RotateAge != 24*time.Hour {
+		t.Fatalf("Unexpected rotation config: %+v", cfg)
+	}
+
+	if _, err := config.FromProxyArgs([]string{"--target", "http://x", "--rotate-size", "big"}, config.Load()); err == nil {
+		t.Fatalf("Expected error for invalid rotate size")
+	}
+}
+
+func TestFromProxyArgs_Compress(t *testing.T) {
+	cfg, err := config.FromProxyArgs([]string{"--target", "http://x", "--compress", "zstd"}, config.Load())
+	if err != nil || cfg.Compress != "zstd" {
+		t.Fatalf("Expected zstd compression, got %q err=%v", cfg.Compress, err)
+	}
+	if _, err := config.FromProxyArgs([]string{"--target", "http://x", "--compress", "lz4"}, config.Load()); err == nil {
+		t.Fatalf("Expected error for unknown compression")
+	}
+}
+
+func TestFromProxyArgs_Format(t *testing.T) {
+	cfg, err := config.FromProxyArgs([]string{"--target", "http://x", "--format", "binary"}, config.Load())
+	if err != nil || cfg.LogFormat != "binary" {
+		t.Fatalf("Expected binary format, got %q err=%v", cfg.LogFormat, err)
+	}
+	if _, err := config.FromProxyArgs([]string{"--target", "http://x", "--format", "xml"}, config.Load()); err == nil {
+		t.Fatalf("Expected error for unknown format")
+	}
+}
+
+func TestFromConvertArgs(t *testing.T) {
+	cfg, err := config.FromConvertArgs([]string{"--tag", "slow", "in.jsonl", "out.rwb"}, config.Load())
+	if err != nil {
+		t.Fatalf("FromConvertArgs: %v", err)
+	}
+	if len(cfg.Args) != 2 || cfg.Args[1] != "out.rwb" || len(cfg.Tags) != 1 {
+		t.Fatalf("Unexpected convert config: %+v", cfg)
+	}
+	if _, err := config.FromConvertArgs([]string{"in.jsonl"}, config.Load()); err == nil {
+		t.Fatalf("Expected error without an output path")
+	}
+}
+
+func TestFromProxyArgs_DedupBodies(t *testing.T) {
+	cfg, err := config.FromProxyArgs([]string{"--target", "http://x", "--dedup-bodies"}, config.Load())
+	if err != nil || !cfg.DedupBodies {
+		t.Fatalf("Expected dedup enabled, got %v err=%v", cfg.DedupBodies, err)
+	}
+
+	t.Setenv("RWND_DEDUP_BODIES", "maybe")
+	if _, err := config.FromProxyArgs([]string{"--target", "http://x"}, config.Load()); err == nil {
+		t.Fatalf("Expected error for invalid RWND_DEDUP_BODIES")
+	}
+}
+
+func TestFromTailArgs(t *testing.T) {
+	cfg, err := config.FromTailArgs([]string{"--status", "4xx,500", "--method", "POST", "-n", "3", "--tui"}, config.Load())
+	if err != nil {
+		t.Fatalf("FromTailArgs: %v", err)
+	}
+	if len(cfg.FilterStatus) != 2 || cfg.FilterMethod != "POST" || cfg.Lines != 3 || cfg.Format != "tui" {
+		t.Fatalf("Unexpected tail config: %+v", cfg)
+	}
+	if _, err := config.FromTailArgs([]string{"--json", "--tui"}, config.Load()); err == nil {
+		t.Fatalf("Expected error combining --json and --tui")
+	}
+}
+
+func TestFromProxyArgs_Durability(t *testing.T) {
+	cfg, err := config.FromProxyArgs([]string{"--target", "http://x"}, config.Load())
+	if err != nil || cfg.Sync != "never" || cfg.Recover != "skip" {
+		t.Fatalf("Expected never/skip defaults, got %q/%q err=%v", cfg.Sync, cfg.Recover, err)
+	}
+	cfg, err = config.FromProxyArgs([]string{"--target", "http://x", "--sync", "always", "--recover", "truncate"}, config.Load())
+	if err != nil || cfg.Sync != "always" || cfg.Recover != "truncate" {
+		t.Fatalf("Expected always/truncate, got %q/%q err=%v", cfg.Sync, cfg.Recover, err)
+	}
+	if _, err := config.FromProxyArgs([]string{"--target", "http://x", "--sync", "sometimes"}, config.Load()); err == nil {
+		t.Fatalf("Expected error for unknown sync policy")
+	}
+}
+
+func TestFromProxyArgs_Encrypt(t *testing.T) {
+	cfg, err := config.FromProxyArgs([]string{"--target", "http://x", "--encrypt"}, config.Load())
+	if err != nil || !cfg.Encrypt {
+		t.Fatalf("Expected encryption enabled, got %v err=%v", cfg.Encrypt, err)
+	}
+
+	t.Setenv("RWND_ENCRYPT", "true")
+	cfg, err = config.FromProxyArgs([]string{"--target", "http://x"}, config.Load())
+	if err != nil || !cfg.Encrypt {
+		t.Fatalf("Expected RWND_ENCRYPT to enable encryption, got %v err=%v", cfg.Encrypt, err)
+	}
+	cfg, err = config.FromProxyArgs([]string{"--target", "http://x", "--encrypt=false"}, config.Load())
+	if err != nil || cfg.Encrypt {
+		t.Fatalf("Expected the flag to override RWND_ENCRYPT, got %v err=%v", cfg.Encrypt, err)
+	}
+}
+
+func TestFromLogsArgs_DecryptOut(t *testing.T) {
+	cfg, err := config.FromLogsArgs("decrypt", []string{"--out", "plain.jsonl", "3"}, config.Load())
+	if err != nil || cfg.OutPath != "plain.jsonl" || len(cfg.Args) != 1 {
+		t.Fatalf("Unexpected decrypt config: %+v err=%v", cfg, err)
+	}
+}
+
+func TestFilter_Layers(t *testing.T) {
+	path := writeConfig(t, `
+profiles:
+  default:
+    filters:
+      query: status >= 500
+`)
+	cfg, err := config.FromReplayArgs([]string{"--config", path}, config.Load())
+	if err != nil || cfg.Filter != "status >= 500" {
+		t.Fatalf("Expected profile filter, got %q err=%v", cfg.Filter, err)
+	}
+
+	t.Setenv("RWND_FILTER", "tag = auth")
+	cfg, err = config.FromExportArgs([]string{"--config", path}, config.Load())
+	if err != nil || cfg.Filter != "tag = auth" {
+		t.Fatalf("Expected RWND_FILTER to override the profile, got %q err=%v", cfg.Filter, err)
+	}
+
+	cfg, err = config.FromTailArgs([]string{"--config", path, "--filter", "method = POST"}, config.Load())
+	if err != nil || cfg.Filter != "method = POST" {
+		t.Fatalf("Expected --filter to win, got %q err=%v", cfg.Filter, err)
+	}
+}
+
+func TestFromGrepArgs(t *testing.T) {
+	cfg, err := config.FromGrepArgs([]string{"-i", "--in", "req.body,resp.body", "-C", "10", "--ids", "ord-1"}, config.Load())
+	if err != nil {
+		t.Fatalf("FromGrepArgs: %v", err)
+	}
+	if !cfg.IgnoreCase || len(cfg.SearchFields) != 2 || cfg.Context != 10 || cfg.Format != "ids" || cfg.Args[0] != "ord-1" {
+		t.Fatalf("Unexpected grep config: %+v", cfg)
+	}
+	if _, err := config.FromGrepArgs([]string{"--filter", "status = 5xx"}, config.Load()); err != nil {
+		t.Fatalf("Expected --filter alone to be enough, got %v", err)
+	}
+	if _, err := config.FromGrepArgs(nil, config.Load()); err == nil {
+		t.Fatalf("Expected error without a pattern or filter")
+	}
+	if _, err := config.FromGrepArgs([]string{"a", "b"}, config.Load()); err == nil {
+		t.Fatalf("Expected error with two patterns")
+	}
+}
+
+func TestFromStatsArgs(t *testing.T) {
+	cfg, err := config.FromStatsArgs([]string{"--markdown", "--sort", "p99", "--filter", "status = 5xx"}, config.Load())
+	if err != nil {
+		t.Fatalf("FromStatsArgs: %v", err)
+	}
+	if cfg.Format != "markdown" || cfg.SortBy != "p99" || cfg.Filter != "status = 5xx" {
+		t.Fatalf("Unexpected stats config: %+v", cfg)
+	}
+	if cfg, _ := config.FromStatsArgs(nil, config.Load()); cfg.SortBy != "count" || cfg.Format != "" {
+		t.Fatalf("Expected count order and a table by default, got %+v", cfg)
+	}
+	if _, err := config.FromStatsArgs([]string{"--json", "--markdown"}, config.Load()); err == nil {
+		t.Fatalf("Expected error combining --json and --markdown")
+	}
+	if _, err := config.FromStatsArgs([]string{"--sort", "size"}, config.Load()); err == nil {
+		t.Fatalf("Expected error for an unknown sort column")
+	}
+}
+
+func TestFromInferArgs(t *testing.T) {
+	cfg, err := config.FromInferArgs("openapi", []string{"--out", "api.json", "--title", "Orders"}, config.Load())
+	if err != nil {
+		t.Fatalf("FromInferArgs: %v", err)
+	}
+	if cfg.OutPath != "api.json" || cfg.Title != "Orders" || cfg.Format != "json" {
+		t.Fatalf("Unexpected infer config: %+v", cfg)
+	}
+	if cfg, _ := config.FromInferArgs("openapi", []string{"--out", "api.yaml"}, config.Load()); cfg.Format != "" {
+		t.Fatalf("Expected YAML for a .yaml output, got %q", cfg.Format)
+	}
+}
+
+func TestFromValidateArgs(t *testing.T) {
+	cfg, err := config.FromValidateArgs([]string{"--spec", "openapi.yaml", "--replay", "--target", "http://localhost:4000"}, config.Load())
+	if err != nil {
+		t.Fatalf("FromValidateArgs: %v", err)
+	}
+	if cfg.SpecPath != "openapi.yaml" || !cfg.Replay || cfg.ReplayTarget.Host != "localhost:4000" {
+		t.Fatalf("Unexpected validate config: %+v", cfg)
+	}
+	if _, err := config.FromValidateArgs(nil, config.Load()); err == nil {
+		t.Fatalf("Expected error without --spec")
+	}
+	if _, err := config.FromValidateArgs([]string{"--spec", "a.yaml", "--target", "http://localhost:4000"}, config.Load()); err == nil {
+		t.Fatalf("Expected error for --target without --replay")
+	}
+	if _, err := config.FromValidateArgs([]string{"--spec", "a.yaml", "--capture", "chain.yaml"}, config.Load()); err == nil {
+		t.Fatalf("Expected error for --capture without --replay")
+	}
+	if _, err := config.FromValidateArgs([]string{"--spec", "a.yaml", "--drop-cookies"}, config.Load()); err == nil {
+		t.Fatalf("Expected error for --drop-cookies without --replay")
+	}
+	if _, err := config.FromValidateArgs([]string{"--spec", "a.yaml", "--auth-env", "TOKEN"}, config.Load()); err == nil {
+		t.Fatalf("Expected error for --auth-env without --replay")
+	}
+
+	t.Setenv("RWND_REPLAY_TARGET", "http://staging:8080")
+	if _, err := config.FromValidateArgs([]string{"--spec", "a.yaml"}, config.Load()); err != nil {
+		t.Fatalf("A replay target from the environment shouldn't need --replay: %v", err)
+	}
+}
+
+func TestFromCompareArgs(t *testing.T) {
+	cfg, err := config.FromCompareArgs([]string{"--a", "http://old:3000", "--b", "http://new:3000", "--ignore-header", "Server", "--examples", "5"}, config.Load())
+	if err != nil {
+		t.Fatalf("FromCompareArgs: %v", err)
+	}
+	if cfg.CompareA.Host != "old:3000" || cfg.CompareB.Host != "new:3000" || cfg.Examples != 5 || len(cfg.IgnoreHeaders) != 1 {
+		t.Fatalf("Unexpected compare config: %+v", cfg)
+	}
+	for _, args := range [][]string{
+		{"--a", "http://old:3000"},
+		{"--a", "http://old:3000", "--b", "new:3000"},
+		{"--a", "http://old:3000", "--b", "http://new:3000", "--examples", "-1"},
+	} {
+		if _, err := config.FromCompareArgs(args, config.Load()); err == nil {
+			t.Errorf("Expected an error for %v", args)
+		}
+	}
+}
+
+func TestFromProxyArgs_Shadow(t *testing.T) {
+	t.Setenv("RWND_SHADOW_QUEUE", "20")
+	cfg, err := config.FromProxyArgs([]string{"--target", "http://x", "--shadow", "http://shadow:4000"}, config.Load())
+	if err != nil || cfg.ShadowURL == nil || cfg.ShadowURL.Host != "shadow:4000" || cfg.ShadowQueue != 20 {
+		t.Fatalf("Unexpected shadow config: %v %d err=%v", cfg.ShadowURL, cfg.ShadowQueue, err)
+	}
+	if _, err := config.FromProxyArgs([]string{"--target", "http://x", "--shadow", "shadow:4000"}, config.Load()); err == nil {
+		t.Fatalf("Expected error for a shadow URL without a host")
+	}
+	if _, err := config.FromProxyArgs([]string{"--target", "http://x", "--shadow-queue", "-1"}, config.Load()); err == nil {
+		t.Fatalf("Expected error for a negative shadow queue")
+	}
+}
+
+func TestFromProxyArgs_Faults(t *testing.T) {
+	t.Setenv("RWND_FAULTS", "env-faults.yaml")
+	cfg, err := config.FromProxyArgs([]string{"--target", "http://x", "--fault-seed", "42"}, config.Load())
+	if err != nil || cfg.FaultsPath != "env-faults.yaml" || cfg.FaultSeed != 42 {
+		t.Fatalf("Unexpected fault config: %q %d err=%v", cfg.FaultsPath, cfg.FaultSeed, err)
+	}
+	t.Setenv("RWND_FAULTS", "")
+	if _, err := config.FromProxyArgs([]string{"--target", "http://x", "--fault-seed", "42"}, config.Load()); err == nil {
+		t.Fatalf("Expected error for --fault-seed without --faults")
+	}
+}
+
+func TestFromChaosArgs(t *testing.T) {
+	cfg, err := config.FromChaosArgs(nil, config.Load())
+	if err != nil || cfg.Shuffle != 8 || cfg.Duplicate != 0.1 || cfg.Retry != 0.1 || cfg.Jitter != 0 || cfg.ChaosSeed != 0 {
+		t.Fatalf("Unexpected chaos defaults: %+v err=%v", cfg, err)
+	}
+	cfg, err = config.FromChaosArgs([]string{"--seed", "42", "--shuffle", "1", "--duplicate", "0", "--jitter", "50ms"}, config.Load())
+	if err != nil || cfg.ChaosSeed != 42 || cfg.Shuffle != 1 || cfg.Duplicate != 0 || cfg.Jitter != 50*time.Millisecond {
+		t.Fatalf("Unexpected chaos config: %+v err=%v", cfg, err)
+	}
+	for _, args := range [][]string{
+		{"--shuffle", "-1"},
+		{"--duplicate", "1.5"},
+		{"--retry", "-0.1"},
+		{"--jitter", "-1s"},
+		{"some.jsonl"},
+	} {
+		if _, err := config.FromChaosArgs(args, config.Load()); err == nil {
+			t.Errorf("Expected an error for %v", args)
+		}
+	}
+}
@@ -0,0 +1,437 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultConfigPath is read when no --config flag or RWND_CONFIG is given.
+const DefaultConfigPath = ".rwnd/config.yaml"
+
+// File is the layout of a config.yaml file.
+type File struct {
+	DefaultProfile string             `yaml:"default_profile"`
+	Profiles       map[string]Profile `yaml:"profiles"`
+}
+
+// Profile is a named set of settings selected with --profile.
+type Profile struct {
+	Listen   string        `yaml:"listen"`
+	Target   string        `yaml:"target"`
+	Log      string        `yaml:"log"`
+	Compress string        `yaml:"compress"` // "gzip" or "zstd"
+	Format   string        `yaml:"format"`   // "json" or "binary"
+	Encrypt  bool          `yaml:"encrypt"`
+	Rules    string        `yaml:"rules"`
+	Faults   string        `yaml:"faults"` // Fault rules file the proxy injects failures with
+	Redact   RedactConfig  `yaml:"redact"`
+	Filters  FilterConfig  `yaml:"filters"`
+	Capture  CaptureConfig `yaml:"capture"`
+	Rotate   RotateConfig  `yaml:"rotate"`
+	Durable  DurableConfig `yaml:"durability"`
+	Shadow   ShadowConfig  `yaml:"shadow"`
+	Replay   ReplayConfig  `yaml:"replay"`
+	Diff     DiffConfig    `yaml:"diff"`
+}
+
+// RedactConfig lists values scrubbed from records before they are stored.
+type RedactConfig struct {
+	Headers []string `yaml:"headers"`
+}
+
+// FilterConfig narrows which records replay, export and tail look at.
+type FilterConfig struct {
+	Tags  []string `yaml:"tags"`
+	Query string   `yaml:"query"` // Filter expression, same syntax as --filter
+}
+
+// CaptureConfig limits what the proxy records.
+type CaptureConfig struct {
+	MaxBodyBytes int64 `yaml:"max_body_bytes"`
+	DedupBodies  bool  `yaml:"dedup_bodies"`
+}
+
+// RotateConfig controls when the proxy starts a new log part.
+type RotateConfig struct {
+	MaxSize    string `yaml:"max_size"` // "100MB"
+	MaxRecords int    `yaml:"max_records"`
+	MaxAge     string `yaml:"max_age"` // "1h", "1d"
+}
+
+// DurableConfig controls how the proxy protects logs against crashes.
+type DurableConfig struct {
+	Sync    string `yaml:"sync"`    // "always", "interval" or "never"
+	Recover string `yaml:"recover"` // "skip" or "truncate"
+}
+
+// ShadowConfig mirrors proxied requests to a second target.
+type ShadowConfig struct {
+	Target string `yaml:"target"`
+	Queue  int    `yaml:"queue"` // Mirrored requests waiting before more are dropped
+}
+
+// ReplayConfig holds replay specific settings.
+type ReplayConfig struct {
+	Target      string     `yaml:"target"`       // Overrides the scheme and host of recorded URLs
+	Mutations   string     `yaml:"mutations"`    // Mutations file applied before requests are replayed
+	Captures    string     `yaml:"captures"`     // Capture rules chaining values between replayed requests
+	CookieJar   bool       `yaml:"cookie_jar"`   // Keep cookies set by replayed responses
+	DropCookies bool       `yaml:"drop_cookies"` // Only send the jar's cookies
+	Auth        AuthConfig `yaml:"auth"`
+}
+
+// AuthConfig picks how replayed requests are authorized. Set one of Env,
+// Command or Login.
+type AuthConfig struct {
+	Env     string `yaml:"env"`     // Env var holding the token
+	Command string `yaml:"command"` // Shell command printing the token
+	Login   string `yaml:"login"`   // Filter expression picking the log's login request
+	Token   string `yaml:"token"`   // json:<path>, header:<name> or regex:<expr> in the login response
+	Scheme  string `yaml:"scheme"`  // Prefix for bare tokens, "Bearer" if empty
+}
+
+// DiffConfig lists response fields ignored when comparing responses.
+type DiffConfig struct {
+	IgnoreHeaders []string `yaml:"ignore_headers"`
+	IgnoreJSON    []string `yaml:"ignore_json"`
+}
+
+// LoadFile reads a config file from disk.
+func LoadFile(path string) (File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return File{}, err
+	}
+	var f File
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return File{}, fmt.Errorf("Config parse error in %s: %w", path, err)
+	}
+	return f, nil
+}
+
+// ------------
+
+func applyFileAndEnv(cfg AppConfig, configPath, profile string) (AppConfig, error) {
+	// Layers the config file profile and then env vars on top of cfg
+	if configPath == "" {
+		configPath = os.Getenv("RWND_CONFIG")
+	}
+	if profile == "" {
+		profile = os.Getenv("RWND_PROFILE")
+	}
+
+	explicit := configPath != ""
+	if !explicit {
+		configPath = DefaultConfigPath
+	}
+
+	file, err := LoadFile(configPath)
+	switch {
+	case err == nil:
+		cfg, err = applyProfile(cfg, file, profile)
+		if err != nil {
+			return AppConfig{}, err
+		}
+	case errors.Is(err, fs.ErrNotExist) && !explicit:
+		// No config file is fine, just defaults
+		if profile != "" {
+			return AppConfig{}, fmt.Errorf("Profile %q requested but %s does not exist", profile, configPath)
+		}
+	default:
+		return AppConfig{}, err
+	}
+
+	return applyEnv(cfg)
+}
+
+func applyProfile(cfg AppConfig, file File, name string) (AppConfig, error) {
+	if name == "" {
+		name = file.DefaultProfile
+	}
+	if name == "" {
+		if _, ok := file.Profiles["default"]; !ok {
+			return cfg, nil
+		}
+		name = "default"
+	}
+
+	p, ok := file.Profiles[name]
+	if !ok {
+		return AppConfig{}, fmt.Errorf("Unknown profile %q", name)
+	}
+	cfg.Profile = name
+
+	if p.Listen != "" {
+		cfg.ListenAddr = p.Listen
+	}
+	if p.Target != "" {
+		u, err := url.Parse(p.Target)
+		if err != nil {
+			return AppConfig{}, fmt.Errorf("Profile %s: Invalid target URL: %v", name, err)
+		}
+		cfg.TargetURL = u
+	}
+	if p.Log != "" {
+		cfg.LogPath = p.Log
+	}
+	if p.Compress != "" {
+		cfg.Compress = p.Compress
+	}
+	if p.Format != "" {
+		cfg.LogFormat = p.Format
+	}
+	if p.Encrypt {
+		cfg.Encrypt = true
+	}
+	if p.Rules != "" {
+		cfg.RulesPath = p.Rules
+	}
+	if len(p.Redact.Headers) > 0 {
+		cfg.RedactHeaders = p.Redact.Headers
+	}
+	if len(p.Filters.Tags) > 0 {
+		cfg.Tags = p.Filters.Tags
+	}
+	if p.Filters.Query != "" {
+		cfg.Filter = p.Filters.Query
+	}
+	if p.Capture.MaxBodyBytes > 0 {
+		cfg.MaxBodyBytes = p.Capture.MaxBodyBytes
+	}
+	if p.Capture.DedupBodies {
+		cfg.DedupBodies = true
+	}
+	if p.Rotate.MaxSize != "" {
+		n, err := parseSize(p.Rotate.MaxSize)
+		if err != nil {
+			return AppConfig{}, fmt.Errorf("Profile %s: rotate.max_size: %v", name, err)
+		}
+		cfg.RotateBytes = n
+	}
+	if p.Rotate.MaxRecords > 0 {
+		cfg.RotateRecords = p.Rotate.MaxRecords
+	}
+	if p.Rotate.MaxAge != "" {
+		d, err := parseAge(p.Rotate.MaxAge)
+		if err != nil {
+			return AppConfig{}, fmt.Errorf("Profile %s: rotate.max_age: %v", name, err)
+		}
+		cfg.RotateAge = d
+	}
+	if p.Durable.Sync != "" {
+		cfg.Sync = p.Durable.Sync
+	}
+	if p.Durable.Recover != "" {
+		cfg.Recover = p.Durable.Recover
+	}
+	if p.Shadow.Target != "" {
+		u, err := url.Parse(p.Shadow.Target)
+		if err != nil {
+			return AppConfig{}, fmt.Errorf("Profile %s: Invalid shadow target URL: %v", name, err)
+		}
+		cfg.ShadowURL = u
+	}
+	if p.Shadow.Queue > 0 {
+		cfg.ShadowQueue = p.Shadow.Queue
+	}
+	if p.Faults != "" {
+		cfg.FaultsPath = p.Faults
+	}
+	if p.Replay.Target != "" {
+		u, err := url.Parse(p.Replay.Target)
+		if err != nil {
+			return AppConfig{}, fmt.Errorf("Profile %s: Invalid replay target URL: %v", name, err)
+		}
+		cfg.ReplayTarget = u
+	}
+	if p.Replay.Mutations != "" {
+		cfg.MutatePath = p.Replay.Mutations
+	}
+	if p.Replay.Captures != "" {
+		cfg.CapturePath = p.Replay.Captures
+	}
+	if p.Replay.CookieJar {
+		cfg.CookieJar = true
+	}
+	if p.Replay.DropCookies {
+		cfg.DropCookies = true
+	}
+	if a := p.Replay.Auth; a.Env != "" || a.Command != "" || a.Login != "" {
+		if (a.Env != "" && a.Command != "") || (a.Env != "" && a.Login != "") || (a.Command != "" && a.Login != "") {
+			return AppConfig{}, fmt.Errorf("Profile %s: replay.auth: set one of env, command or login", name)
+		}
+		cfg.AuthEnv, cfg.AuthCommand, cfg.AuthLogin, cfg.AuthToken = a.Env, a.Command, a.Login, a.Token
+	}
+	if p.Replay.Auth.Scheme != "" {
+		cfg.AuthScheme = p.Replay.Auth.Scheme
+	}
+	if len(p.Diff.IgnoreHeaders) > 0 {
+		cfg.IgnoreHeaders = p.Diff.IgnoreHeaders
+	}
+	if len(p.Diff.IgnoreJSON) > 0 {
+		cfg.IgnoreJSON = p.Diff.IgnoreJSON
+	}
+
+	return cfg, nil
+}
+
+func applyEnv(cfg AppConfig) (AppConfig, error) {
+	if v := os.Getenv("RWND_LISTEN"); v != "" {
+		cfg.ListenAddr = v
+	}
+	if v := os.Getenv("RWND_TARGET"); v != "" {
+		u, err := url.Parse(v)
+		if err != nil {
+			return AppConfig{}, fmt.Errorf("RWND_TARGET: Invalid target URL: %v", err)
+		}
+		cfg.TargetURL = u
+	}
+	if v := os.Getenv("RWND_LOG"); v != "" {
+		cfg.LogPath = v
+	}
+	if v := os.Getenv("RWND_COMPRESS"); v != "" {
+		cfg.Compress = v
+	}
+	if v := os.Getenv("RWND_FORMAT"); v != "" {
+		cfg.LogFormat = v
+	}
+	if v := os.Getenv("RWND_ENCRYPT"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return AppConfig{}, fmt.Errorf("RWND_ENCRYPT: %v", err)
+		}
+		cfg.Encrypt = b
+	}
+	if v := os.Getenv("RWND_RULES"); v != "" {
+		cfg.RulesPath = v
+	}
+	if v := os.Getenv("RWND_REDACT_HEADERS"); v != "" {
+		cfg.RedactHeaders = splitList(v)
+	}
+	if v := os.Getenv("RWND_TAGS"); v != "" {
+		cfg.Tags = splitList(v)
+	}
+	if v := os.Getenv("RWND_FILTER"); v != "" {
+		cfg.Filter = v
+	}
+	if v := os.Getenv("RWND_MAX_BODY_BYTES"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return AppConfig{}, fmt.Errorf("RWND_MAX_BODY_BYTES: %v", err)
+		}
+		cfg.MaxBodyBytes = n
+	}
+	if v := os.Getenv("RWND_DEDUP_BODIES"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return AppConfig{}, fmt.Errorf("RWND_DEDUP_BODIES: %v", err)
+		}
+		cfg.DedupBodies = b
+	}
+	if v := os.Getenv("RWND_ROTATE_SIZE"); v != "" {
+		n, err := parseSize(v)
+		if err != nil {
+			return AppConfig{}, fmt.Errorf("RWND_ROTATE_SIZE: %v", err)
+		}
+		cfg.RotateBytes = n
+	}
+	if v := os.Getenv("RWND_ROTATE_RECORDS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return AppConfig{}, fmt.Errorf("RWND_ROTATE_RECORDS: %v", err)
+		}
+		cfg.RotateRecords = n
+	}
+	if v := os.Getenv("RWND_ROTATE_AGE"); v != "" {
+		d, err := parseAge(v)
+		if err != nil {
+			return AppConfig{}, fmt.Errorf("RWND_ROTATE_AGE: %v", err)
+		}
+		cfg.RotateAge = d
+	}
+	if v := os.Getenv("RWND_SYNC"); v != "" {
+		cfg.Sync = v
+	}
+	if v := os.Getenv("RWND_RECOVER"); v != "" {
+		cfg.Recover = v
+	}
+	if v := os.Getenv("RWND_SHADOW"); v != "" {
+		u, err := url.Parse(v)
+		if err != nil {
+			return AppConfig{}, fmt.Errorf("RWND_SHADOW: Invalid URL: %v", err)
+		}
+		cfg.ShadowURL = u
+	}
+	if v := os.Getenv("RWND_SHADOW_QUEUE"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return AppConfig{}, fmt.Errorf("RWND_SHADOW_QUEUE: %v", err)
+		}
+		cfg.ShadowQueue = n
+	}
+	if v := os.Getenv("RWND_FAULTS"); v != "" {
+		cfg.FaultsPath = v
+	}
+	if v := os.Getenv("RWND_FAULT_SEED"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return AppConfig{}, fmt.Errorf("RWND_FAULT_SEED: %v", err)
+		}
+		cfg.FaultSeed = n
+	}
+	if v := os.Getenv("RWND_REPLAY_TARGET"); v != "" {
+		u, err := url.Parse(v)
+		if err != nil {
+			return AppConfig{}, fmt.Errorf("RWND_REPLAY_TARGET: Invalid URL: %v", err)
+		}
+		cfg.ReplayTarget = u
+	}
+	if v := os.Getenv("RWND_REPLAY_MUTATIONS"); v != "" {
+		cfg.MutatePath = v
+	}
+	if v := os.Getenv("RWND_REPLAY_CAPTURES"); v != "" {
+		cfg.CapturePath = v
+	}
+	if v := os.Getenv("RWND_REPLAY_AUTH_ENV"); v != "" {
+		cfg.AuthEnv, cfg.AuthCommand, cfg.AuthLogin, cfg.AuthToken = v, "", "", ""
+	}
+	if v := os.Getenv("RWND_REPLAY_AUTH_COMMAND"); v != "" {
+		cfg.AuthEnv, cfg.AuthCommand, cfg.AuthLogin, cfg.AuthToken = "", v, "", ""
+	}
+	if v := os.Getenv("RWND_REPLAY_AUTH_LOGIN"); v != "" {
+		cfg.AuthEnv, cfg.AuthCommand, cfg.AuthLogin = "", "", v
+	}
+	if v := os.Getenv("RWND_REPLAY_AUTH_TOKEN"); v != "" {
+		cfg.AuthToken = v
+	}
+	if v := os.Getenv("RWND_REPLAY_AUTH_SCHEME"); v != "" {
+		cfg.AuthScheme = v
+	}
+	if v := os.Getenv("RWND_REPLAY_COOKIE_JAR"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return AppConfig{}, fmt.Errorf("RWND_REPLAY_COOKIE_JAR: %v", err)
+		}
+		cfg.CookieJar = b
+	}
+	if v := os.Getenv("RWND_REPLAY_DROP_COOKIES"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return AppConfig{}, fmt.Errorf("RWND_REPLAY_DROP_COOKIES: %v", err)
+		}
+		cfg.DropCookies = b
+	}
+	if v := os.Getenv("RWND_IGNORE_HEADERS"); v != "" {
+		cfg.IgnoreHeaders = splitList(v)
+	}
+	if v := os.Getenv("RWND_IGNORE_JSON"); v != "" {
+		cfg.IgnoreJSON = splitList(v)
+	}
+	return cfg, nil
+}
@@ -0,0 +1,65 @@
+package datastore_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/BarrettBr/RWND/internal/datastore"
+)
+
+// Compare the JSON and binary record paths:
+//
+//	go test ./internal/datastore -bench . -benchmem
+
+func benchmarkAppend(b *testing.B, ext string) {
+	path := filepath.Join(b.TempDir(), "bench"+ext)
+	fs, err := datastore.NewFileStore(path, 0)
+	if err != nil {
+		b.Fatalf("NewFileStore: %v", err)
+	}
+	defer func() { _ = fs.Close() }()
+
+	rec := sampleRecord(1)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rec.ID = uint64(i)
+		if err := fs.Append(rec); err != nil {
+			b.Fatalf("Append: %v", err)
+		}
+	}
+}
+
+func benchmarkStream(b *testing.B, ext string) {
+	const records = 1000
+	path := filepath.Join(b.TempDir(), "bench"+ext)
+	fs, err := datastore.NewFileStore(path, 0)
+	if err != nil {
+		b.Fatalf("NewFileStore: %v", err)
+	}
+	defer func() { _ = fs.Close() }()
+	for i := uint64(0); i < records; i++ {
+		if err := fs.Append(sampleRecord(i)); err != nil {
+			b.Fatalf("Append: %v", err)
+		}
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		out, errCh := fs.Stream()
+		n := 0
+		for range out {
+			n++
+		}
+		if err := <-errCh; err != nil || n != records {
+			b.Fatalf("Stream read %d records, err=%v", n, err)
+		}
+	}
+	b.ReportMetric(float64(records), "records/op")
+}
+
+func BenchmarkAppend_JSON(b *testing.B)   { benchmarkAppend(b, ".jsonl") }
+func BenchmarkAppend_Binary(b *testing.B) { benchmarkAppend(b, ".rwb") }
+func BenchmarkStream_JSON(b *testing.B)   { benchmarkStream(b, ".jsonl") }
+func BenchmarkStream_Binary(b *testing.B) { benchmarkStream(b, ".rwb") }
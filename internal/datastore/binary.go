@@ -0,0 +1,482 @@
+package datastore
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/BarrettBr/RWND/internal/model"
+)
+
+// Format names an on-disk record encoding.
+type Format string
+
+const (
+	FormatJSON   Format = "json"
+	FormatBinary Format = "binary"
+)
+
+// BinaryExt is the extension of binary logs, before any compression suffix.
+const BinaryExt = ".rwb"
+
+// Ext returns the log file extension for the format.
+func (f Format) Ext() string {
+	if f == FormatBinary {
+		return BinaryExt
+	}
+	return ".jsonl"
+}
+
+// ParseFormat turns a flag value into a Format.
+func ParseFormat(value string) (Format, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", "json", "jsonl":
+		return FormatJSON, nil
+	case "binary", "bin", "rwb":
+		return FormatBinary, nil
+	default:
+		return FormatJSON, fmt.Errorf("Unknown log format %q (use json or binary)", value)
+	}
+}
+
+// formatFor picks the record encoding from a log path's extension.
+func formatFor(path string) Format {
+	path = strings.TrimSuffix(path, EncryptExt)
+	path = strings.TrimSuffix(path, compressionFor(path).Ext())
+	if strings.HasSuffix(path, BinaryExt) {
+		return FormatBinary
+	}
+	return FormatJSON
+}
+
+// recordEncoder writes records and session headers in one on-disk format.
+type recordEncoder interface {
+	Encode(v any) error
+	EncodeHeader(h model.SessionHeader) error
+}
+
+// recordDecoder reads records in one on-disk format. Session headers are consumed
+// along the way and used to migrate older records. Records that can't be decoded
+// are reported as *CorruptRecordError.
+type recordDecoder interface {
+	Decode(v any) error
+	Header() (model.SessionHeader, bool) // Latest header read, false before any or for headerless logs
+}
+
+func newRecordEncoder(f Format, w io.Writer) recordEncoder {
+	if f == FormatBinary {
+		return &binaryEncoder{w: w}
+	}
+	return &jsonRecordEncoder{enc: json.NewEncoder(w)}
+}
+
+func newRecordDecoder(f Format, r io.Reader) recordDecoder {
+	if f == FormatBinary {
+		return &binaryDecoder{r: &offsetReader{r: bufio.NewReader(r)}}
+	}
+	return &jsonLineDecoder{r: bufio.NewReader(r)}
+}
+
+// ------------
+
+// Binary layout
+//
+// A binary log is a sequence of frames, one per record:
+//
+//	uvarint(payload length) payload
+//
+// The payload is a list of fields, each encoded as
+//
+//	uvarint(tag) uvarint(value length) value
+//
+// Readers skip tags they don't know, so new fields can be added without
+// breaking older logs or older readers. Bodies are stored raw instead of the
+// base64 JSON uses.
+const (
+	tagID        = 1
+	tagTimestamp = 2 // varint unix nanoseconds
+	tagLatency   = 3 // varint nanoseconds
+	tagTag       = 4 // repeated, one per tag
+	tagParentID  = 5
+
+	tagReqMethod    = 10
+	tagReqURL       = 11
+	tagReqHeader    = 12 // repeated, uvarint(len name) name value
+	tagReqBody      = 13
+	tagReqTruncated = 14
+	tagReqBodyRef   = 15
+
+	tagRespStatus    = 20
+	tagRespHeader    = 21
+	tagRespBody      = 22
+	tagRespTruncated = 23
+	tagRespBodyRef   = 24
+
+	tagShadowTarget    = 30 // Present whenever the record has a shadow
+	tagShadowLatency   = 31
+	tagShadowStatus    = 32
+	tagShadowHeader    = 33
+	tagShadowBody      = 34
+	tagShadowTruncated = 35
+	tagShadowError     = 36
+	tagShadowDiff      = 37 // repeated, one per difference
+
+	tagFault = 40 // repeated, JSON encoded model.Fault
+
+	tagHeader = 100 // JSON encoded model.SessionHeader, only field of a header frame
+)
+
+// maxFrameSize guards against allocating huge buffers when reading a corrupt length.
+const maxFrameSize = 1 << 30
+
+type binaryEncoder struct {
+	w       io.Writer
+	payload []byte
+	frame   []byte
+}
+
+func (e *binaryEncoder) Encode(v any) error {
+	rec, ok := v.(model.Record)
+	if !ok {
+		if p, isPtr := v.(*model.Record); isPtr && p != nil {
+			rec = *p
+		} else {
+			return fmt.Errorf("Binary encoder only supports model.Record, got %T", v)
+		}
+	}
+
+	p := e.payload[:0]
+	p = appendUvarintField(p, tagID, rec.ID)
+	if !rec.Timestamp.IsZero() {
+		p = appendVarintField(p, tagTimestamp, rec.Timestamp.UnixNano())
+	}
+	if rec.Latency != 0 {
+		p = appendVarintField(p, tagLatency, int64(rec.Latency))
+	}
+	for _, tag := range rec.Tags {
+		p = appendBytesField(p, tagTag, []byte(tag))
+	}
+	if rec.ParentID != 0 {
+		p = appendUvarintField(p, tagParentID, rec.ParentID)
+	}
+
+	p = appendStringField(p, tagReqMethod, rec.Request.Method)
+	p = appendStringField(p, tagReqURL, rec.Request.URL)
+	p = appendHeaders(p, tagReqHeader, rec.Request.Headers)
+	if len(rec.Request.Body) > 0 {
+		p = appendBytesField(p, tagReqBody, rec.Request.Body)
+	}
+	if rec.Request.Truncated {
+		p = appendUvarintField(p, tagReqTruncated, 1)
+	}
+	p = appendStringField(p, tagReqBodyRef, rec.Request.BodyRef)
+
+	p = appendVarintField(p, tagRespStatus, int64(rec.Response.Status))
+	p = appendHeaders(p, tagRespHeader, rec.Response.Headers)
+	if len(rec.Response.Body) > 0 {
+		p = appendBytesField(p, tagRespBody, rec.Response.Body)
+	}
+	if rec.Response.Truncated {
+		p = appendUvarintField(p, tagRespTruncated, 1)
+	}
+	p = appendStringField(p, tagRespBodyRef, rec.Response.BodyRef)
+
+	if s := rec.Shadow; s != nil {
+		p = appendBytesField(p, tagShadowTarget, []byte(s.Target))
+		if s.Latency != 0 {
+			p = appendVarintField(p, tagShadowLatency, int64(s.Latency))
+		}
+		if s.Response.Status != 0 {
+			p = appendVarintField(p, tagShadowStatus, int64(s.Response.Status))
+		}
+		p = appendHeaders(p, tagShadowHeader, s.Response.Headers)
+		if len(s.Response.Body) > 0 {
+			p = appendBytesField(p, tagShadowBody, s.Response.Body)
+		}
+		if s.Response.Truncated {
+			p = appendUvarintField(p, tagShadowTruncated, 1)
+		}
+		p = appendStringField(p, tagShadowError, s.Error)
+		for _, d := range s.Differences {
+			p = appendBytesField(p, tagShadowDiff, []byte(d))
+		}
+	}
+	for _, f := range rec.Faults {
+		data, err := json.Marshal(f)
+		if err != nil {
+			return err
+		}
+		p = appendBytesField(p, tagFault, data)
+	}
+	e.payload = p
+
+	f := binary.AppendUvarint(e.frame[:0], uint64(len(p)))
+	f = append(f, p...)
+	e.frame = f
+
+	_, err := e.w.Write(f)
+	return err
+}
+
+func (e *binaryEncoder) EncodeHeader(h model.SessionHeader) error {
+	data, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+	p := appendBytesField(e.payload[:0], tagHeader, data)
+	e.payload = p
+
+	f := binary.AppendUvarint(e.frame[:0], uint64(len(p)))
+	f = append(f, p...)
+	e.frame = f
+
+	_, err = e.w.Write(f)
+	return err
+}
+
+func appendBytesField(p []byte, tag uint64, value []byte) []byte {
+	p = binary.AppendUvarint(p, tag)
+	p = binary.AppendUvarint(p, uint64(len(value)))
+	return append(p, value...)
+}
+
+func appendStringField(p []byte, tag uint64, value string) []byte {
+	if value == "" {
+		return p
+	}
+	p = binary.AppendUvarint(p, tag)
+	p = binary.AppendUvarint(p, uint64(len(value)))
+	return append(p, value...)
+}
+
+func appendUvarintField(p []byte, tag uint64, value uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], value)
+	return appendBytesField(p, tag, tmp[:n])
+}
+
+func appendVarintField(p []byte, tag uint64, value int64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(tmp[:], value)
+	return appendBytesField(p, tag, tmp[:n])
+}
+
+func appendHeaders(p []byte, tag uint64, headers http.Header) []byte {
+	for name, values := range headers {
+		for _, v := range values {
+			var tmp [binary.MaxVarintLen64]byte
+			n := binary.PutUvarint(tmp[:], uint64(len(name)))
+			field := make([]byte, 0, n+len(name)+len(v))
+			field = append(field, tmp[:n]...)
+			field = append(field, name...)
+			field = append(field, v...)
+			p = appendBytesField(p, tag, field)
+		}
+	}
+	return p
+}
+
+// ------------
+
+type binaryDecoder struct {
+	r         *offsetReader
+	buf       []byte
+	lost      bool // A frame length was unreadable, so the next frame can't be found
+	header    model.SessionHeader
+	hasHeader bool
+}
+
+func (d *binaryDecoder) Header() (model.SessionHeader, bool) {
+	return d.header, d.hasHeader
+}
+
+func (d *binaryDecoder) Decode(v any) error {
+	rec, ok := v.(*model.Record)
+	if !ok || rec == nil {
+		return fmt.Errorf("Binary decoder only supports *model.Record, got %T", v)
+	}
+	for {
+		isHeader, err := d.decodeFrame(rec)
+		if err != nil || !isHeader {
+			return err
+		}
+	}
+}
+
+func (d *binaryDecoder) decodeFrame(rec *model.Record) (bool, error) {
+	// Reads one frame into rec, or into d.header if it is a header frame
+	if d.lost {
+		return false, io.EOF
+	}
+
+	start := d.r.n
+	size, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		if err == io.EOF && d.r.n == start {
+			return false, io.EOF
+		}
+		if err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF) {
+			return false, &CorruptRecordError{Offset: start, Torn: true, Err: io.ErrUnexpectedEOF}
+		}
+		d.lost = true
+		return false, &CorruptRecordError{Offset: start, Err: err}
+	}
+	if size > maxFrameSize {
+		d.lost = true
+		return false, &CorruptRecordError{Offset: start, Err: fmt.Errorf("frame length %d is too large", size)}
+	}
+	if cap(d.buf) < int(size) {
+		d.buf = make([]byte, size)
+	}
+	frame := d.buf[:size]
+	if _, err := io.ReadFull(d.r, frame); err != nil {
+		if err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF) {
+			return false, &CorruptRecordError{Offset: start, Torn: true, Err: io.ErrUnexpectedEOF}
+		}
+		return false, err
+	}
+
+	if tag, n := binary.Uvarint(frame); n > 0 && tag == tagHeader {
+		var h model.SessionHeader
+		if _, value, ok := splitBinaryField(frame); !ok || json.Unmarshal(value, &h) != nil {
+			return false, &CorruptRecordError{Offset: start, Err: fmt.Errorf("bad session header")}
+		}
+		d.header, d.hasHeader = h, true
+		return true, nil
+	}
+
+	*rec = model.Record{}
+	if err := decodeBinaryPayload(frame, rec); err != nil {
+		return false, &CorruptRecordError{Offset: start, Err: err}
+	}
+	return false, nil
+}
+
+func splitBinaryField(p []byte) (uint64, []byte, bool) {
+	// Returns the tag and value of the first field in p
+	tag, n := binary.Uvarint(p)
+	if n <= 0 {
+		return 0, nil, false
+	}
+	size, m := binary.Uvarint(p[n:])
+	if m <= 0 || uint64(len(p)-n-m) < size {
+		return 0, nil, false
+	}
+	return tag, p[n+m : n+m+int(size)], true
+}
+
+func decodeBinaryPayload(p []byte, rec *model.Record) error {
+	for len(p) > 0 {
+		tag, n := binary.Uvarint(p)
+		if n <= 0 {
+			return fmt.Errorf("Binary record: bad field tag")
+		}
+		p = p[n:]
+		size, n := binary.Uvarint(p)
+		if n <= 0 || uint64(len(p)-n) < size {
+			return fmt.Errorf("Binary record: bad field length for tag %d", tag)
+		}
+		value := p[n : n+int(size)]
+		p = p[n+int(size):]
+
+		if err := setBinaryField(rec, tag, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func setBinaryField(rec *model.Record, tag uint64, value []byte) error {
+	switch tag {
+	case tagID:
+		rec.ID, _ = binary.Uvarint(value)
+	case tagTimestamp:
+		ns, _ := binary.Varint(value)
+		rec.Timestamp = time.Unix(0, ns).UTC()
+	case tagLatency:
+		ns, _ := binary.Varint(value)
+		rec.Latency = time.Duration(ns)
+	case tagTag:
+		rec.Tags = append(rec.Tags, string(value))
+	case tagParentID:
+		rec.ParentID, _ = binary.Uvarint(value)
+	case tagReqMethod:
+		rec.Request.Method = string(value)
+	case tagReqURL:
+		rec.Request.URL = string(value)
+	case tagReqHeader:
+		return addBinaryHeader(&rec.Request.Headers, value)
+	case tagReqBody:
+		rec.Request.Body = append([]byte(nil), value...)
+	case tagReqTruncated:
+		rec.Request.Truncated = len(value) > 0 && value[0] != 0
+	case tagReqBodyRef:
+		rec.Request.BodyRef = string(value)
+	case tagRespStatus:
+		status, _ := binary.Varint(value)
+		rec.Response.Status = int(status)
+	case tagRespHeader:
+		return addBinaryHeader(&rec.Response.Headers, value)
+	case tagRespBody:
+		rec.Response.Body = append([]byte(nil), value...)
+	case tagRespTruncated:
+		rec.Response.Truncated = len(value) > 0 && value[0] != 0
+	case tagRespBodyRef:
+		rec.Response.BodyRef = string(value)
+	case tagShadowTarget:
+		shadowOf(rec).Target = string(value)
+	case tagShadowLatency:
+		ns, _ := binary.Varint(value)
+		shadowOf(rec).Latency = time.Duration(ns)
+	case tagShadowStatus:
+		status, _ := binary.Varint(value)
+		shadowOf(rec).Response.Status = int(status)
+	case tagShadowHeader:
+		return addBinaryHeader(&shadowOf(rec).Response.Headers, value)
+	case tagShadowBody:
+		shadowOf(rec).Response.Body = append([]byte(nil), value...)
+	case tagShadowTruncated:
+		shadowOf(rec).Response.Truncated = len(value) > 0 && value[0] != 0
+	case tagShadowError:
+		shadowOf(rec).Error = string(value)
+	case tagShadowDiff:
+		s := shadowOf(rec)
+		s.Differences = append(s.Differences, string(value))
+	case tagFault:
+		var f model.Fault
+		if err := json.Unmarshal(value, &f); err != nil {
+			return fmt.Errorf("Binary record: bad fault field: %w", err)
+		}
+		rec.Faults = append(rec.Faults, f)
+	default:
+		// Unknown field from a newer writer, skip it
+	}
+	return nil
+}
+
+func shadowOf(rec *model.Record) *model.Shadow {
+	if rec.Shadow == nil {
+		rec.Shadow = &model.Shadow{}
+	}
+	return rec.Shadow
+}
+
+func addBinaryHeader(headers *http.Header, value []byte) error {
+	nameLen, n := binary.Uvarint(value)
+	if n <= 0 || uint64(len(value)-n) < nameLen {
+		return fmt.Errorf("Binary record: bad header field")
+	}
+	name := string(value[n : n+int(nameLen)])
+	if *headers == nil {
+		*headers = make(http.Header)
+	}
+	// Keys are stored as recorded, so skip canonicalization like JSON decoding does
+	(*headers)[name] = append((*headers)[name], string(value[n+int(nameLen):]))
+	return nil
+}
@@ -0,0 +1,185 @@
+package datastore
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/BarrettBr/RWND/internal/model"
+)
+
+// dedupMinBytes is the smallest body moved to the blob store.
+// Smaller bodies cost more as a file plus a reference than inline.
+const dedupMinBytes = 256
+
+const (
+	blobRefPrefix      = "sha256:"
+	keyedBlobRefPrefix = "hmac-sha256:" // Bodies of encrypted sessions
+)
+
+// blobNameInfo derives the key blob names of encrypted sessions are computed with.
+var blobNameInfo = []byte("rwnd-blob-name-v1")
+
+// BlobStore keeps bodies content addressed on disk, one file per unique body.
+// Blobs live under dir/<first two hex chars>/<full hex hash>.
+type BlobStore struct {
+	dir  string
+	mu   sync.Mutex
+	seen map[string]struct{} // Hashes known to be on disk, saves a stat per Put
+	key  []byte              // Blobs are encrypted and named by a keyed hash when set
+}
+
+// NewBlobStore returns a BlobStore rooted at dir. The directory is created on first Put.
+func NewBlobStore(dir string) *BlobStore {
+	return &BlobStore{dir: dir, seen: make(map[string]struct{})}
+}
+
+// Put stores body if it isn't already present and returns its reference.
+func (b *BlobStore) Put(body []byte) (string, error) {
+	prefix, hash := b.name(body)
+	ref := prefix + hash
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.seen[hash]; ok {
+		return ref, nil
+	}
+
+	path := b.blobPath(hash)
+	if _, err := os.Stat(path); err == nil {
+		b.seen[hash] = struct{}{}
+		return ref, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", err
+	}
+	data := body
+	if b.key != nil {
+		sealed, err := Seal(b.key, body)
+		if err != nil {
+			return "", err
+		}
+		data = sealed
+	}
+	// Write to a temp file and rename so readers never see a partial blob
+	tmp, err := os.CreateTemp(filepath.Dir(path), hash+".tmp-*")
+	if err != nil {
+		return "", err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+
+	b.seen[hash] = struct{}{}
+	return ref, nil
+}
+
+// Get returns the body stored under ref.
+// The body must hash to ref, so a blob swapped for another one is caught.
+func (b *BlobStore) Get(ref string) ([]byte, error) {
+	hash, ok := strings.CutPrefix(ref, b.refPrefix())
+	if !ok || len(hash) != sha256.Size*2 {
+		return nil, fmt.Errorf("Invalid body reference %q", ref)
+	}
+	body, err := os.ReadFile(b.blobPath(hash))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("Body %s is missing from %s", ref, b.dir)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if b.key != nil {
+		if body, err = Unseal(b.key, body); err != nil {
+			return nil, err
+		}
+	}
+	if _, got := b.name(body); got != hash {
+		return nil, fmt.Errorf("Body %s in %s doesn't match its reference", ref, b.dir)
+	}
+	return body, nil
+}
+
+func (b *BlobStore) refPrefix() string {
+	if b.key == nil {
+		return blobRefPrefix
+	}
+	return keyedBlobRefPrefix
+}
+
+func (b *BlobStore) name(body []byte) (prefix, hash string) {
+	// A plain hash of an encrypted body would let anyone who can list the directory
+	// see which bodies are the same and check guesses, so those are named by an HMAC
+	// under a key derived from the log key
+	if b.key == nil {
+		sum := sha256.Sum256(body)
+		return blobRefPrefix, hex.EncodeToString(sum[:])
+	}
+	derive := hmac.New(sha256.New, b.key)
+	derive.Write(blobNameInfo)
+	mac := hmac.New(sha256.New, derive.Sum(nil))
+	mac.Write(body)
+	return keyedBlobRefPrefix, hex.EncodeToString(mac.Sum(nil))
+}
+
+func (b *BlobStore) blobPath(hash string) string {
+	return filepath.Join(b.dir, hash[:2], hash)
+}
+
+// ------------
+
+func (b *BlobStore) dedupe(rec *model.Record) error {
+	// Moves large bodies out of the record and leaves references behind
+	if len(rec.Request.Body) >= dedupMinBytes {
+		ref, err := b.Put(rec.Request.Body)
+		if err != nil {
+			return err
+		}
+		rec.Request.Body, rec.Request.BodyRef = nil, ref
+	}
+	if len(rec.Response.Body) >= dedupMinBytes {
+		ref, err := b.Put(rec.Response.Body)
+		if err != nil {
+			return err
+		}
+		rec.Response.Body, rec.Response.BodyRef = nil, ref
+	}
+	return nil
+}
+
+func (b *BlobStore) hydrate(rec *model.Record) error {
+	// Puts referenced bodies back so readers see complete records
+	if rec.Request.BodyRef != "" {
+		body, err := b.Get(rec.Request.BodyRef)
+		if err != nil {
+			return err
+		}
+		rec.Request.Body, rec.Request.BodyRef = body, ""
+	}
+	if rec.Response.BodyRef != "" {
+		body, err := b.Get(rec.Response.BodyRef)
+		if err != nil {
+			return err
+		}
+		rec.Response.Body, rec.Response.BodyRef = body, ""
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package datastore
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression names a log compression codec.
+type Compression string
+
+const (
+	CompressNone Compression = ""
+	CompressGzip Compression = "gzip"
+	CompressZstd Compression = "zstd"
+)
+
+// Ext returns the file extension suffix for the codec, "" for none.
+func (c Compression) Ext() string {
+	switch c {
+	case CompressGzip:
+		return ".gz"
+	case CompressZstd:
+		return ".zst"
+	default:
+		return ""
+	}
+}
+
+// ParseCompression turns a flag value into a Compression.
+func ParseCompression(value string) (Compression, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", "none", "off":
+		return CompressNone, nil
+	case "gzip", "gz":
+		return CompressGzip, nil
+	case "zstd", "zst":
+		return CompressZstd, nil
+	default:
+		return CompressNone, fmt.Errorf("Unknown compression %q (use gzip or zstd)", value)
+	}
+}
+
+// compressionFor picks the codec from a log path's extension.
+func compressionFor(path string) Compression {
+	path = strings.TrimSuffix(path, EncryptExt)
+	switch {
+	case strings.HasSuffix(path, ".gz"):
+		return CompressGzip
+	case strings.HasSuffix(path, ".zst"):
+		return CompressZstd
+	default:
+		return CompressNone
+	}
+}
+
+// flushWriter is a write layer that buffers internally and can be flushed and closed.
+type flushWriter interface {
+	io.Writer
+	Flush() error
+	Close() error
+}
+
+// nopFlushWriter is the write layer used for uncompressed files.
+type nopFlushWriter struct{ io.Writer }
+
+func (nopFlushWriter) Flush() error { return nil }
+func (nopFlushWriter) Close() error { return nil }
+
+func newCompressWriter(c Compression, w io.Writer) flushWriter {
+	if c == CompressNone {
+		return nopFlushWriter{w}
+	}
+	return &lazyCompressWriter{codec: c, w: w}
+}
+
+// lazyCompressWriter only starts a gzip member / zstd frame once something is written.
+// Stores opened just to read would otherwise append an empty member on Close.
+// Appending to an existing compressed file starts a new member / frame,
+// both formats read concatenated streams back as one.
+type lazyCompressWriter struct {
+	codec Compression
+	w     io.Writer
+	inner flushWriter
+}
+
+func (l *lazyCompressWriter) Write(p []byte) (int, error) {
+	if l.inner == nil {
+		switch l.codec {
+		case CompressGzip:
+			l.inner = gzip.NewWriter(l.w)
+		case CompressZstd:
+			zw, err := zstd.NewWriter(l.w, zstd.WithEncoderConcurrency(1))
+			if err != nil {
+				return 0, err
+			}
+			l.inner = zw
+		}
+	}
+	return l.inner.Write(p)
+}
+
+func (l *lazyCompressWriter) Flush() error {
+	if l.inner == nil {
+		return nil
+	}
+	return l.inner.Flush()
+}
+
+func (l *lazyCompressWriter) Close() error {
+	if l.inner == nil {
+		return nil
+	}
+	return l.inner.Close()
+}
+
+func newDecompressReader(c Compression, r io.Reader) (io.ReadCloser, error) {
+	switch c {
+	case CompressGzip:
+		zr, err := gzip.NewReader(r)
+		if err == io.EOF {
+			// Empty file, nothing written yet
+			return io.NopCloser(strings.NewReader("")), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &openTailReader{r: zr, close: zr.Close}, nil
+	case CompressZstd:
+		zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return &openTailReader{r: zr, close: func() error { zr.Close(); return nil }}, nil
+	default:
+		return io.NopCloser(r), nil
+	}
+}
+
+// openTailReader ends the stream cleanly at the end of the flushed data.
+// A file that is still being written has no gzip trailer / final zstd block yet,
+// so the decompressor reports io.ErrUnexpectedEOF after the last complete flush.
+type openTailReader struct {
+	r     io.Reader
+	close func() error
+	open  bool // The stream ended without its trailer
+}
+
+func (t *openTailReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		t.open = true
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (t *openTailReader) Close() error {
+	return t.close()
+}
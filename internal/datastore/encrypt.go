@@ -0,0 +1,366 @@
+package datastore
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+// EncryptExt is the outermost extension of encrypted logs, e.g. ".jsonl.gz.enc".
+const EncryptExt = ".enc"
+
+// DefaultKeyFile is read when neither RWND_LOG_KEY nor RWND_LOG_KEY_FILE is set.
+const DefaultKeyFile = ".rwnd/log.key"
+
+// KeySize is the length of a log key, AES-256.
+const KeySize = 32
+
+// Encrypted file layout
+//
+// An encrypted file is a sequence of streams, one per store that appended to it.
+// A stream starts with 'S' and an 8 byte random nonce prefix, followed by chunks:
+//
+//	'C' uint32(ciphertext length) ciphertext
+//
+// Each chunk is AES-256-GCM sealed with the nonce prefix and a 4 byte chunk counter,
+// so chunks can't be reordered or moved between streams without failing to open.
+// A chunk is sealed whenever the store flushes. Closing the store seals a last chunk
+// marked 'F' with its own additional data, so a stream cut at a chunk boundary is
+// told apart from one that was finished.
+const (
+	streamMarker    = 'S'
+	chunkMarker     = 'C'
+	finalMarker     = 'F'
+	noncePrefixSize = 8
+	maxChunkSize    = 64 * 1024
+)
+
+var (
+	encryptAAD = []byte("rwnd-log-v1")
+	finalAAD   = []byte("rwnd-log-v1-final")
+)
+
+// errTornStream ends an encrypted stream that stops before its final chunk, a write
+// that never finished. It wraps io.ErrUnexpectedEOF so decoders report a torn record.
+var errTornStream = fmt.Errorf("Encrypted stream ends before its final chunk: %w", io.ErrUnexpectedEOF)
+
+// LoadKey returns the log key from RWND_LOG_KEY, the file named by RWND_LOG_KEY_FILE,
+// or DefaultKeyFile, in that order. Keys are 32 bytes, written as hex or base64.
+func LoadKey() ([]byte, error) {
+	if v := os.Getenv("RWND_LOG_KEY"); v != "" {
+		key, err := parseKey([]byte(v))
+		if err != nil {
+			return nil, fmt.Errorf("RWND_LOG_KEY: %v", err)
+		}
+		return key, nil
+	}
+
+	path, explicit := os.Getenv("RWND_LOG_KEY_FILE"), true
+	if path == "" {
+		path, explicit = DefaultKeyFile, false
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) && !explicit {
+		return nil, fmt.Errorf("No log key: set RWND_LOG_KEY or RWND_LOG_KEY_FILE, or create %s", DefaultKeyFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+	key, err := parseKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return key, nil
+}
+
+func parseKey(data []byte) ([]byte, error) {
+	if len(data) == KeySize {
+		return data, nil
+	}
+	text := strings.TrimSpace(string(data))
+	if key, err := hex.DecodeString(text); err == nil && len(key) == KeySize {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(text); err == nil && len(key) == KeySize {
+		return key, nil
+	}
+	return nil, fmt.Errorf("Log key must be %d bytes as hex or base64", KeySize)
+}
+
+func isEncrypted(path string) bool {
+	return strings.HasSuffix(path, EncryptExt)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// ------------
+
+// encryptWriter buffers plaintext and seals it into chunks on Flush, Close or
+// when the buffer fills. The stream header is only written with the first chunk.
+type encryptWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	prefix  [noncePrefixSize]byte
+	counter uint32
+	started bool
+	buf     []byte
+	out     []byte
+}
+
+func newEncryptWriter(key []byte, w io.Writer) (*encryptWriter, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	e := &encryptWriter{w: w, aead: aead}
+	if _, err := rand.Read(e.prefix[:]); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), maxChunkSize-len(e.buf))
+		e.buf = append(e.buf, p[:n]...)
+		p = p[n:]
+		written += n
+		if len(e.buf) == maxChunkSize {
+			if err := e.Flush(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+func (e *encryptWriter) Flush() error {
+	if len(e.buf) == 0 {
+		return nil
+	}
+	return e.seal(chunkMarker, encryptAAD)
+}
+
+func (e *encryptWriter) seal(marker byte, aad []byte) error {
+	out := e.out[:0]
+	if !e.started {
+		out = append(out, streamMarker)
+		out = append(out, e.prefix[:]...)
+	}
+
+	nonce := e.nonce(e.counter)
+	sealed := e.aead.Seal(nil, nonce, e.buf, aad)
+	out = append(out, marker)
+	out = binary.BigEndian.AppendUint32(out, uint32(len(sealed)))
+	out = append(out, sealed...)
+	e.out = out
+
+	// One write per chunk so a crash leaves at most one partial chunk
+	if _, err := e.w.Write(out); err != nil {
+		return err
+	}
+	e.started = true
+	e.counter++
+	e.buf = e.buf[:0]
+	return nil
+}
+
+func (e *encryptWriter) Close() error {
+	// Seals what is left as the final chunk, which may be empty. A writer that never
+	// wrote anything leaves no stream behind
+	if !e.started && len(e.buf) == 0 {
+		return nil
+	}
+	return e.seal(finalMarker, finalAAD)
+}
+
+func (e *encryptWriter) nonce(counter uint32) []byte {
+	nonce := make([]byte, e.aead.NonceSize())
+	copy(nonce, e.prefix[:])
+	binary.BigEndian.PutUint32(nonce[noncePrefixSize:], counter)
+	return nonce
+}
+
+// ------------
+
+// decryptReader opens chunks back into plaintext. A stream that stops before its
+// final chunk is a write that never finished and ends with errTornStream, or like
+// EOF on the live tail Follow reads, where it is only the end of what was flushed.
+type decryptReader struct {
+	r       io.Reader
+	aead    cipher.AEAD
+	prefix  [noncePrefixSize]byte
+	counter uint32
+	started bool
+	final   bool // The current stream's final chunk was read
+	live    bool
+	plain   []byte
+	pending []byte
+}
+
+func newDecryptReader(key []byte, r io.Reader) (*decryptReader, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &decryptReader{r: r, aead: aead}, nil
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.pending) == 0 {
+		if err := d.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.pending)
+	d.pending = d.pending[n:]
+	return n, nil
+}
+
+func (d *decryptReader) next() error {
+	// Reads the next chunk, handling any stream headers in front of it
+	var marker [1]byte
+	if _, err := io.ReadFull(d.r, marker[:]); err != nil {
+		if err == io.EOF && d.started && !d.final {
+			return d.torn(err)
+		}
+		return err
+	}
+
+	switch marker[0] {
+	case streamMarker:
+		if d.started && !d.final {
+			return fmt.Errorf("Encrypted stream ends before its final chunk and another follows, log is likely corrupt")
+		}
+		if _, err := io.ReadFull(d.r, d.prefix[:]); err != nil {
+			return d.torn(err)
+		}
+		d.counter, d.started, d.final = 0, true, false
+		return nil
+	case chunkMarker, finalMarker:
+		if !d.started {
+			return fmt.Errorf("Encrypted log is missing its stream header")
+		}
+		if d.final {
+			return fmt.Errorf("Encrypted chunk after the end of its stream, log is likely corrupt")
+		}
+		var size [4]byte
+		if _, err := io.ReadFull(d.r, size[:]); err != nil {
+			return d.torn(err)
+		}
+		n := binary.BigEndian.Uint32(size[:])
+		if n > maxChunkSize+uint32(d.aead.Overhead()) {
+			return fmt.Errorf("Encrypted chunk of %d bytes is too large, log is likely corrupt", n)
+		}
+		sealed := make([]byte, n)
+		if _, err := io.ReadFull(d.r, sealed); err != nil {
+			return d.torn(err)
+		}
+
+		aad := encryptAAD
+		if marker[0] == finalMarker {
+			aad = finalAAD
+		}
+		nonce := make([]byte, d.aead.NonceSize())
+		copy(nonce, d.prefix[:])
+		binary.BigEndian.PutUint32(nonce[noncePrefixSize:], d.counter)
+		plain, err := d.aead.Open(d.plain[:0], nonce, sealed, aad)
+		if err != nil {
+			return fmt.Errorf("Log decryption failed: wrong key or corrupted file")
+		}
+		d.counter++
+		d.final = marker[0] == finalMarker
+		d.plain = plain
+		d.pending = plain
+		return nil
+	default:
+		return fmt.Errorf("Encrypted log has an unknown block marker %q", marker[0])
+	}
+}
+
+func (d *decryptReader) torn(err error) error {
+	// The file ends inside a stream
+	if err != io.EOF && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return err
+	}
+	if d.live {
+		return io.EOF
+	}
+	return errTornStream
+}
+
+// ------------
+
+// Seal encrypts data with key as a single stream, the same layout as an
+// encrypted log. Blobs and search indexes of encrypted logs are sealed with it.
+func Seal(key, data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := newEncryptWriter(key, &buf)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Unseal decrypts data sealed with Seal.
+func Unseal(key, data []byte) ([]byte, error) {
+	r, err := newDecryptReader(key, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+// ------------
+
+func keyFor(path string) ([]byte, error) {
+	// Only encrypted logs need a key, the rest can be read without one
+	if !isEncrypted(path) {
+		return nil, nil
+	}
+	return LoadKey()
+}
+
+func openLogReader(path string, key []byte, r io.Reader) (io.ReadCloser, error) {
+	// Undoes the write layers of a log file: encryption, then compression
+	return openLogLayers(path, key, r, false)
+}
+
+func openLogLayers(path string, key []byte, r io.Reader, live bool) (io.ReadCloser, error) {
+	// With live set, an encrypted stream without its final chunk ends like EOF
+	if isEncrypted(path) {
+		if key == nil {
+			return nil, fmt.Errorf("%s is encrypted and no key was given", path)
+		}
+		dr, err := newDecryptReader(key, r)
+		if err != nil {
+			return nil, err
+		}
+		dr.live = live
+		r = dr
+	}
+	return newDecompressReader(compressionFor(path), r)
+}
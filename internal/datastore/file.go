@@ -3,23 +3,95 @@ package datastore
 
 import (
 	"bufio"
-	"encoding/json"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/BarrettBr/RWND/internal/logpath"
 	"github.com/BarrettBr/RWND/internal/model"
 )
 
-// FileStore writes and reads records from a JSONL file.
+// FileOptions configures a FileStore.
+type FileOptions struct {
+	FlushInterval time.Duration // How often buffered writes are pushed to disk, 0 disables the flush loop
+	Rotate        RotateOptions
+	DedupBodies   bool // Store large bodies once by hash in the session's .blobs directory
+	Sync          SyncPolicy
+	Recovery      Recovery
+	Header        *model.SessionHeader // Written ahead of the first record of each part, a minimal one if nil
+	Key           []byte               // Key for paths ending in .enc, LoadKey is used if nil
+}
+
+// SyncPolicy controls how often a FileStore forces records to stable storage.
+type SyncPolicy string
+
+const (
+	SyncNever    SyncPolicy = ""         // Flush every FlushInterval and leave fsync to the OS
+	SyncInterval SyncPolicy = "interval" // Flush and fsync every FlushInterval
+	SyncAlways   SyncPolicy = "always"   // Flush and fsync after every record
+)
+
+// ParseSyncPolicy turns a flag value into a SyncPolicy.
+func ParseSyncPolicy(value string) (SyncPolicy, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", "never", "none":
+		return SyncNever, nil
+	case "interval":
+		return SyncInterval, nil
+	case "always":
+		return SyncAlways, nil
+	default:
+		return SyncNever, fmt.Errorf("Unknown sync policy %q (use always, interval or never)", value)
+	}
+}
+
+// RotateOptions controls when a FileStore starts a new part file. Zero values disable a limit.
+type RotateOptions struct {
+	MaxBytes   int64         // Rotate once the current part reaches this size on disk
+	MaxRecords int           // Rotate once the current part holds this many records
+	MaxAge     time.Duration // Rotate once the current part has been open this long
+}
+
+func (r RotateOptions) enabled() bool {
+	return r.MaxBytes > 0 || r.MaxRecords > 0 || r.MaxAge > 0
+}
+
+// FileStore writes and reads records from a JSONL file, or a binary log for paths ending in .rwb.
+// Each run that appends to a part first writes a session header, readers skip headers
+// and migrate records written under older schema versions.
+// Paths ending in .gz or .zst are compressed on write and decompressed in Stream.
+// Paths ending in .enc are encrypted after compression, bodies in the BlobStore as well.
+// With rotation enabled a session spans several numbered part files that are read back as one.
+// With body dedup enabled, records reference bodies in a sidecar BlobStore and Stream puts them back.
 type FileStore struct {
-	path string        // Path of file
-	mu   sync.Mutex    // Used for RW
-	file *os.File      // Used to hold the file itself
-	buf  *bufio.Writer // Hold a buffered writer to lower total writes
-	enc  *json.Encoder // Used to encoder / feed to buffer
+	path  string        // Path of the first part of the session
+	mu    sync.Mutex    // Used for RW
+	file  *os.File      // Used to hold the current part file itself
+	comp  flushWriter   // Compression layer between the buffer and the file
+	crypt flushWriter   // Encryption layer between compression and the file
+	key   []byte        // Set for encrypted logs
+	buf   *bufio.Writer // Hold a buffered writer to lower total writes
+	enc   recordEncoder // Used to encoder / feed to buffer
+
+	blobs *BlobStore // Set when bodies are deduplicated on write
+	sync  SyncPolicy
+
+	header        model.SessionHeader
+	headerPending bool // The current part hasn't had a header written by this store yet
+	checkTail     bool // The current part held data before this store opened it and may end torn
+
+	rotate  RotateOptions
+	part    int       // Current part number, 1 is the session path itself
+	written int64     // Bytes of the current part on disk
+	records int       // Records written to the current part by this store
+	opened  time.Time // When the current part was opened
+
+	readOnly bool // Opened by OpenFileStore, no part is open for writing
+	closed   bool
 
 	flushInterval time.Duration
 	stopFlush     chan struct{}
@@ -30,50 +102,287 @@ type FileStore struct {
 
 // NewFileStore creates a FileStore at the given path and starts the flush loop.
 func NewFileStore(path string, flushInterval time.Duration) (*FileStore, error) {
+	return NewFileStoreWithOptions(path, FileOptions{FlushInterval: flushInterval})
+}
+
+// OpenFileStore opens an existing log for reading only. Unlike NewFileStore it fails
+// when path has no session parts, and never opens a part for writing, so logs on
+// read-only mounts or still being written by the proxy can be read. Append fails.
+func OpenFileStore(path string) (*FileStore, error) {
+	parts, err := logpath.SessionFiles(logpath.PartPath(path, 1))
+	if err != nil {
+		return nil, err
+	}
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("%s: %w", path, os.ErrNotExist)
+	}
+
+	var key []byte
+	if isEncrypted(path) {
+		if key, err = LoadKey(); err != nil {
+			return nil, err
+		}
+	}
+
+	return &FileStore{
+		path:      logpath.PartPath(path, 1),
+		part:      logpath.PartNumber(parts[len(parts)-1]),
+		key:       key,
+		header:    defaultHeader(),
+		readOnly:  true,
+		stopFlush: make(chan struct{}),
+	}, nil
+}
+
+// NewFileStoreWithOptions creates a FileStore at the given path with the given options.
+// If the session already has rotated parts, writing continues in the latest one.
+func NewFileStoreWithOptions(path string, opts FileOptions) (*FileStore, error) {
 	// Check if Directory exists and make it if not
 	dir := filepath.Dir(path)
-	if err := os.MkdirAll(dir, 0755); err != nil {
+	if err := os.MkdirAll(dir, 0700); err != nil {
 		return nil, err
 	}
 
+	key := opts.Key
+	if isEncrypted(path) && key == nil {
+		var err error
+		if key, err = LoadKey(); err != nil {
+			return nil, err
+		}
+	}
+
+	fs := &FileStore{
+		path:          logpath.PartPath(path, 1),
+		rotate:        opts.Rotate,
+		part:          1,
+		sync:          opts.Sync,
+		key:           key,
+		header:        defaultHeader(),
+		flushInterval: opts.FlushInterval,
+		stopFlush:     make(chan struct{}),
+	}
+	if opts.Header != nil {
+		fs.header = *opts.Header
+	}
+	if opts.DedupBodies {
+		fs.blobs = fs.newBlobStore()
+	}
+
+	if parts, err := logpath.SessionFiles(fs.path); err == nil && len(parts) > 0 {
+		fs.part = logpath.PartNumber(parts[len(parts)-1])
+	}
+
+	truncated := false
+	if part := logpath.PartPath(fs.path, fs.part); opts.Recovery == RecoverTruncate && !isLayered(part) {
+		if err := truncateTorn(part); err != nil {
+			return nil, err
+		}
+		truncated = true
+	}
+
+	if err := fs.openPart(); err != nil {
+		return nil, err
+	}
+	// A truncated part ends on a record boundary, there is nothing left to check
+	fs.checkTail = fs.checkTail && !truncated
+
+	fs.startFlushLoop()
+
+	return fs, nil
+}
+
+func (fs *FileStore) openPart() error {
+	// Opens the current part for appending and resets the rotation counters
+	path := logpath.PartPath(fs.path, fs.part)
+
 	// Open file as append only and open / create if it doesn't exist
-	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
+	// Counted below compression and encryption, so rotation sizes are bytes on disk
+	disk := &countingWriter{w: f, n: &fs.written}
+	var crypt flushWriter = nopFlushWriter{disk}
+	if fs.key != nil {
+		if crypt, err = newEncryptWriter(fs.key, disk); err != nil {
+			f.Close()
+			return err
+		}
+	}
+
+	var size int64
+	if info, err := f.Stat(); err == nil {
+		size = info.Size()
+	}
+
+	comp := newCompressWriter(compressionFor(path), crypt)
+
 	const memorySize = 64 * 1024
 
-	buf := bufio.NewWriterSize(f, memorySize)
-	enc := json.NewEncoder(buf)
+	fs.file = f
+	fs.crypt = crypt
+	fs.comp = comp
+	fs.buf = bufio.NewWriterSize(comp, memorySize)
+	fs.enc = newRecordEncoder(formatFor(path), fs.buf)
+	fs.written = size
+	fs.records = 0
+	fs.opened = time.Now()
+	// Written with the first record so stores opened only to read leave the file alone
+	fs.headerPending = true
+	fs.checkTail = size > 0
+	return nil
+}
 
-	fs := &FileStore{
-		path:          path,
-		file:          f,
-		buf:           buf,
-		enc:           enc,
-		flushInterval: flushInterval,
-		stopFlush:     make(chan struct{}),
+func (fs *FileStore) shouldRotate() bool {
+	size := fs.size()
+	if !fs.rotate.enabled() || (size == 0 && fs.records == 0) {
+		return false
+	}
+	if fs.rotate.MaxBytes > 0 && size >= fs.rotate.MaxBytes {
+		return true
+	}
+	if fs.rotate.MaxRecords > 0 && fs.records >= fs.rotate.MaxRecords {
+		return true
 	}
+	return fs.rotate.MaxAge > 0 && time.Since(fs.opened) >= fs.rotate.MaxAge
+}
 
-	fs.startFlushLoop()
+func (fs *FileStore) size() int64 {
+	// Caller holds fs.mu. Bytes of the current part on disk, plus what is still buffered for
+	// plain logs where it goes to the file as is. Compressed and encrypted data only counts
+	// once it is flushed, there is no telling its size before.
+	if isLayered(logpath.PartPath(fs.path, fs.part)) {
+		return fs.written
+	}
+	return fs.written + int64(fs.buf.Buffered())
+}
 
-	return fs, nil
+func (fs *FileStore) flushLocked() error {
+	// Caller holds fs.mu. Pushes buffered records through compression and encryption to the file.
+	if err := fs.buf.Flush(); err != nil {
+		return err
+	}
+	if err := fs.comp.Flush(); err != nil {
+		return err
+	}
+	return fs.crypt.Flush()
+}
+
+func (fs *FileStore) flushForRead() error {
+	// Caller holds fs.mu. Makes what this store wrote visible to readers of the file,
+	// read-only stores have nothing to flush.
+	if fs.closed || (!fs.readOnly && fs.file == nil) {
+		return os.ErrClosed
+	}
+	if fs.readOnly {
+		return nil
+	}
+	return fs.flushLocked()
+}
+
+func (fs *FileStore) syncLocked() error {
+	// Caller holds fs.mu. Flushes and forces the current part to disk.
+	if err := fs.flushLocked(); err != nil {
+		return err
+	}
+	return fs.file.Sync()
+}
+
+func (fs *FileStore) closeLocked() error {
+	// Caller holds fs.mu. Flushes and closes the current part.
+	flushErr := fs.buf.Flush()
+	compErr := fs.comp.Close()
+	cryptErr := fs.crypt.Close()
+	var syncErr error
+	if fs.sync != SyncNever {
+		syncErr = fs.file.Sync()
+	}
+	closeErr := fs.file.Close()
+	fs.file, fs.crypt, fs.comp, fs.buf, fs.enc = nil, nil, nil, nil, nil
+
+	if flushErr != nil {
+		return flushErr
+	}
+	if compErr != nil {
+		return compErr
+	}
+	if cryptErr != nil {
+		return cryptErr
+	}
+	if syncErr != nil {
+		return syncErr
+	}
+	return closeErr
+}
+
+func (fs *FileStore) rotatePart() error {
+	// Caller holds fs.mu. Finishes the current part and starts the next one.
+	if err := fs.closeLocked(); err != nil {
+		return err
+	}
+	fs.part++
+	return fs.openPart()
 }
 
-// Append writes a record to the file.
+// Append writes a record to the file, rotating to a new part first if a limit was reached.
 func (fs *FileStore) Append(rec model.Record) error {
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 
-	if fs.file == nil || fs.enc == nil {
+	if fs.readOnly {
+		return fmt.Errorf("%s was opened read-only", fs.path)
+	}
+	if fs.closed || fs.file == nil || fs.enc == nil {
 		return os.ErrClosed
 	}
 
-	return fs.enc.Encode(rec)
+	if fs.shouldRotate() {
+		if err := fs.rotatePart(); err != nil {
+			return err
+		}
+	}
+
+	if fs.checkTail {
+		// Appending behind a torn tail would merge the new records into it or hide them from readers
+		torn, err := endsTorn(logpath.PartPath(fs.path, fs.part), fs.key)
+		if err != nil {
+			return err
+		}
+		fs.checkTail = false
+		if torn {
+			if err := fs.rotatePart(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if fs.headerPending {
+		if err := fs.enc.EncodeHeader(stampHeader(fs.header, fs.part)); err != nil {
+			return err
+		}
+		fs.headerPending = false
+	}
+
+	if fs.blobs != nil {
+		if err := fs.blobs.dedupe(&rec); err != nil {
+			return err
+		}
+	}
+
+	if err := fs.enc.Encode(rec); err != nil {
+		return err
+	}
+	fs.records++
+
+	if fs.sync == SyncAlways {
+		return fs.syncLocked()
+	}
+	return nil
 }
 
 // Stream returns a channel of records and a channel of errors.
+// Every rotation part of the session is read in order.
 func (fs *FileStore) Stream() (<-chan model.Record, <-chan error) {
 	// Iterate through logs streaming 1 at a time to the replay engine
 	out := make(chan model.Record)
@@ -81,13 +390,7 @@ func (fs *FileStore) Stream() (<-chan model.Record, <-chan error) {
 
 	// Push bufferred writes to the file before reading just to ensure it has something to read
 	fs.mu.Lock()
-	if fs.file == nil || fs.buf == nil {
-		fs.mu.Unlock()
-		errCh <- os.ErrClosed
-		close(out)
-		return out, errCh
-	}
-	flushErr := fs.buf.Flush()
+	flushErr := fs.flushForRead()
 	fs.mu.Unlock()
 
 	if flushErr != nil {
@@ -96,37 +399,73 @@ func (fs *FileStore) Stream() (<-chan model.Record, <-chan error) {
 		return out, errCh
 	}
 
+	parts, err := logpath.SessionFiles(fs.path)
+	if err != nil {
+		errCh <- err
+		close(out)
+		return out, errCh
+	}
+
 	// Anonymous function that runs in a separate goroutine
 	// this will stream out logs 1 at a time to the replay engine and clean up the channels upon exiting
 	go func() {
 		defer close(out)
 		defer close(errCh)
 
-		f, err := os.Open(fs.path)
-		if err != nil {
-			errCh <- err
-			return
-		}
-		defer f.Close()
-
-		decoder := json.NewDecoder(f)
-		for {
-			var rec model.Record
-			if err := decoder.Decode(&rec); err != nil {
-				if err == io.EOF {
-					return
-				}
+		// Readers always resolve references, whether or not this store writes them
+		blobs := fs.newBlobStore()
+		for _, part := range parts {
+			if err := streamFile(part, fs.key, blobs, out); err != nil {
 				errCh <- err
 				return
 			}
-
-			out <- rec
 		}
 	}()
 
 	return out, errCh
 }
 
+func streamFile(path string, key []byte, blobs *BlobStore, out chan<- model.Record) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r, err := openLogReader(path, key, f)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	decoder := newRecordDecoder(formatFor(path), r)
+	for {
+		var rec model.Record
+		if err := decoder.Decode(&rec); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			// A torn last record is a write cut short, everything before it is fine
+			if cre, ok := asCorrupt(err, path); ok && cre.Torn {
+				return nil
+			}
+			return err
+		}
+		if err := blobs.hydrate(&rec); err != nil {
+			return err
+		}
+
+		out <- rec
+	}
+}
+
+func (fs *FileStore) newBlobStore() *BlobStore {
+	// Bodies of an encrypted session are encrypted with the same key
+	blobs := NewBlobStore(logpath.BlobDir(fs.path))
+	blobs.key = fs.key
+	return blobs
+}
+
 func (fs *FileStore) startFlushLoop() {
 	if fs.flushInterval <= 0 {
 		return
@@ -145,7 +484,11 @@ func (fs *FileStore) startFlushLoop() {
 					fs.mu.Unlock()
 					return
 				}
-				_ = fs.buf.Flush()
+				if fs.sync == SyncInterval {
+					_ = fs.syncLocked()
+				} else {
+					_ = fs.flushLocked()
+				}
 				fs.mu.Unlock()
 			case <-fs.stopFlush:
 				return
@@ -159,20 +502,22 @@ func (fs *FileStore) Close() error {
 	fs.stopOnce.Do(func() { close(fs.stopFlush) })
 
 	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.closed = true
 	if fs.file == nil {
-		fs.mu.Unlock()
 		return nil
 	}
-	buf := fs.buf
-	file := fs.file
-	fs.file, fs.buf, fs.enc = nil, nil, nil
-	fs.mu.Unlock()
+	return fs.closeLocked()
+}
 
-	flushErr := buf.Flush()
-	closeErr := file.Close()
+// countingWriter tracks how many bytes pass through it into the part file.
+type countingWriter struct {
+	w io.Writer
+	n *int64
+}
 
-	if flushErr != nil {
-		return flushErr
-	}
-	return closeErr
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	*c.n += int64(n)
+	return n, err
 }
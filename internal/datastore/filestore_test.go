@@ -1,12 +1,20 @@
 package datastore_test
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/BarrettBr/RWND/internal/datastore"
+	"github.com/BarrettBr/RWND/internal/logpath"
 	"github.com/BarrettBr/RWND/internal/model"
 )
 
@@ -135,3 +143,999 @@ func TestFileStore_Close_Idempotent(t *testing.T) {
 		t.Fatalf("Second Close: %v", err)
 	}
 }
+
+func streamAll(t *testing.T, fs *datastore.FileStore) []model.Record {
+	t.Helper()
+	out, errCh := fs.Stream()
+	var got []model.Record
+	for rec := range out {
+		got = append(got, rec)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("Stream error: %v", err)
+	}
+	return got
+}
+
+func TestFileStore_RotatesByRecordCount(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "003_20250101T000000Z_listen-8080.jsonl")
+
+	fs, err := datastore.NewFileStoreWithOptions(path, datastore.FileOptions{
+		Rotate: datastore.RotateOptions{MaxRecords: 2},
+	})
+	if err != nil {
+		t.Fatalf("NewFileStoreWithOptions: %v", err)
+	}
+	defer func() { _ = fs.Close() }()
+
+	for i := 1; i <= 5; i++ {
+		if err := fs.Append(model.Record{ID: uint64(i)}); err != nil {
+			t.Fatalf("Append %d: %v", i, err)
+		}
+	}
+
+	for _, name := range []string{
+		"003_20250101T000000Z_listen-8080.jsonl",
+		"003_20250101T000000Z_listen-8080_part-002.jsonl",
+		"003_20250101T000000Z_listen-8080_part-003.jsonl",
+	} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Fatalf("Expected part %s: %v", name, err)
+		}
+	}
+
+	got := streamAll(t, fs)
+	if len(got) != 5 {
+		t.Fatalf("Expected 5 records across parts, got %d", len(got))
+	}
+	for i, rec := range got {
+		if rec.ID != uint64(i+1) {
+			t.Fatalf("Expected continuous IDs, got %d at %d", rec.ID, i)
+		}
+	}
+}
+
+func TestFileStore_RotatesBySize(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "size.jsonl")
+
+	fs, err := datastore.NewFileStoreWithOptions(path, datastore.FileOptions{
+		Rotate: datastore.RotateOptions{MaxBytes: 1},
+	})
+	if err != nil {
+		t.Fatalf("NewFileStoreWithOptions: %v", err)
+	}
+	defer func() { _ = fs.Close() }()
+
+	for i := 0; i < 3; i++ {
+		if err := fs.Append(model.Record{}); err != nil {
+			t.Fatalf("Append %d: %v", i, err)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "size_part-003.jsonl")); err != nil {
+		t.Fatalf("Expected a part per record: %v", err)
+	}
+	if got := len(streamAll(t, fs)); got != 3 {
+		t.Fatalf("Expected 3 records, got %d", got)
+	}
+}
+
+func TestFileStore_RotatesCompressedByDiskSize(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "size.jsonl.gz")
+
+	fs, err := datastore.NewFileStoreWithOptions(path, datastore.FileOptions{
+		Rotate: datastore.RotateOptions{MaxBytes: 4096},
+		Sync:   datastore.SyncAlways,
+	})
+	if err != nil {
+		t.Fatalf("NewFileStoreWithOptions: %v", err)
+	}
+	defer func() { _ = fs.Close() }()
+
+	// Each body is 10KB of plaintext but compresses to a few bytes
+	var rec model.Record
+	rec.Response.Body = bytes.Repeat([]byte("a"), 10*1024)
+	for i := 0; i < 10; i++ {
+		if err := fs.Append(rec); err != nil {
+			t.Fatalf("Append %d: %v", i, err)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "size_part-002.jsonl.gz")); err == nil {
+		t.Fatalf("Expected one part, the compressed log is far below 4096 bytes")
+	}
+	if got := len(streamAll(t, fs)); got != 10 {
+		t.Fatalf("Expected 10 records, got %d", got)
+	}
+}
+
+func TestFileStore_ReopenContinuesLatestPart(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "reopen.jsonl")
+	opts := datastore.FileOptions{Rotate: datastore.RotateOptions{MaxRecords: 1}}
+
+	fs, err := datastore.NewFileStoreWithOptions(path, opts)
+	if err != nil {
+		t.Fatalf("NewFileStoreWithOptions: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := fs.Append(model.Record{}); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+	}
+	if err := fs.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// Opening any part reads the whole series
+	fs, err = datastore.NewFileStore(filepath.Join(dir, "reopen_part-002.jsonl"), 0)
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	defer func() { _ = fs.Close() }()
+
+	if got := len(streamAll(t, fs)); got != 2 {
+		t.Fatalf("Expected 2 records across the series, got %d", got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "reopen_part-003.jsonl")); err == nil {
+		t.Fatalf("Reopening should not start a new part")
+	}
+}
+
+func TestFileStore_CompressedRoundTrip(t *testing.T) {
+	for _, ext := range []string{".jsonl.gz", ".jsonl.zst"} {
+		t.Run(ext, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "001_log"+ext)
+
+			fs, err := datastore.NewFileStore(path, 0)
+			if err != nil {
+				t.Fatalf("NewFileStore: %v", err)
+			}
+			rec := model.Record{ID: 1}
+			rec.Response.Body = []byte(`{"hello":"world"}`)
+			if err := fs.Append(rec); err != nil {
+				t.Fatalf("Append: %v", err)
+			}
+
+			// Readable while the writer is still open
+			if got := streamAll(t, fs); len(got) != 1 || string(got[0].Response.Body) != `{"hello":"world"}` {
+				t.Fatalf("unexpected records while open: %+v", got)
+			}
+			if err := fs.Close(); err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+
+			raw, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("read raw: %v", err)
+			}
+			if bytes.Contains(raw, []byte("hello")) {
+				t.Fatalf("expected compressed file, found plain text")
+			}
+
+			// Appending in a second run adds a new member / frame
+			fs, err = datastore.NewFileStore(path, 0)
+			if err != nil {
+				t.Fatalf("reopen: %v", err)
+			}
+			defer func() { _ = fs.Close() }()
+			if err := fs.Append(model.Record{ID: 2}); err != nil {
+				t.Fatalf("Append: %v", err)
+			}
+			if got := streamAll(t, fs); len(got) != 2 || got[1].ID != 2 {
+				t.Fatalf("unexpected records after reopen: %+v", got)
+			}
+		})
+	}
+}
+
+func TestFileStore_ReadOnlyOpenLeavesCompressedFileAlone(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "ro.jsonl.gz")
+
+	fs, err := datastore.NewFileStore(path, 0)
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	if err := fs.Append(model.Record{ID: 1}); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	if err := fs.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	before, _ := os.Stat(path)
+
+	fs, err = datastore.NewFileStore(path, 0)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	streamAll(t, fs)
+	if err := fs.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	after, _ := os.Stat(path)
+	if before.Size() != after.Size() {
+		t.Fatalf("expected read only open to leave the file at %d bytes, got %d", before.Size(), after.Size())
+	}
+}
+
+func sampleRecord(id uint64) model.Record {
+	rec := model.Record{
+		ID:        id,
+		Timestamp: time.Date(2025, 1, 2, 3, 4, 5, 6, time.UTC),
+		Latency:   42 * time.Millisecond,
+		Tags:      []string{"slow", "auth"},
+		ParentID:  3,
+	}
+	rec.Request.Method = "POST"
+	rec.Request.URL = "http://localhost:3000/api/users?id=7"
+	rec.Request.Headers = map[string][]string{"Content-Type": {"application/json"}, "Accept": {"a", "b"}}
+	rec.Request.Body = []byte(`{"name":"rwnd"}`)
+	rec.Request.Truncated = true
+	rec.Response.Status = 201
+	rec.Response.Headers = map[string][]string{"Content-Type": {"application/json"}}
+	rec.Response.Body = bytes.Repeat([]byte("x"), 512)
+	rec.Shadow = &model.Shadow{
+		Target:      "http://localhost:4000",
+		Latency:     7 * time.Millisecond,
+		Response:    model.Response{Status: 500, Headers: map[string][]string{"X-Shadow": {"1"}}, Body: []byte("boom")},
+		Differences: []string{`status: "201" -> "500"`},
+	}
+	rec.Faults = []model.Fault{{Rule: "slow", Kind: "latency", Detail: "250ms"}, {Rule: "cut", Kind: "truncate"}}
+	return rec
+}
+
+func TestFileStore_BinaryRoundTrip(t *testing.T) {
+	for _, ext := range []string{".rwb", ".rwb.zst"} {
+		t.Run(ext, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "001_log"+ext)
+
+			fs, err := datastore.NewFileStore(path, 0)
+			if err != nil {
+				t.Fatalf("NewFileStore: %v", err)
+			}
+			defer func() { _ = fs.Close() }()
+
+			want := []model.Record{sampleRecord(1), {ID: 2}}
+			for _, rec := range want {
+				if err := fs.Append(rec); err != nil {
+					t.Fatalf("Append: %v", err)
+				}
+			}
+
+			got := streamAll(t, fs)
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("binary round trip mismatch\n got: %+v\nwant: %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestFileStore_BinaryIsSmallerThanJSON(t *testing.T) {
+	dir := t.TempDir()
+	sizes := map[string]int64{}
+	for _, ext := range []string{".jsonl", ".rwb"} {
+		path := filepath.Join(dir, "log"+ext)
+		fs, err := datastore.NewFileStore(path, 0)
+		if err != nil {
+			t.Fatalf("NewFileStore: %v", err)
+		}
+		for i := uint64(1); i <= 10; i++ {
+			if err := fs.Append(sampleRecord(i)); err != nil {
+				t.Fatalf("Append: %v", err)
+			}
+		}
+		if err := fs.Close(); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+		info, _ := os.Stat(path)
+		sizes[ext] = info.Size()
+	}
+	if sizes[".rwb"] >= sizes[".jsonl"] {
+		t.Fatalf("expected binary log to be smaller than JSONL, got %v", sizes)
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	if f, err := datastore.ParseFormat("binary"); err != nil || f.Ext() != ".rwb" {
+		t.Fatalf("ParseFormat(binary) = %q, %v", f, err)
+	}
+	if f, err := datastore.ParseFormat(""); err != nil || f.Ext() != ".jsonl" {
+		t.Fatalf("ParseFormat(\"\") = %q, %v", f, err)
+	}
+	if _, err := datastore.ParseFormat("xml"); err == nil {
+		t.Fatalf("Expected error for unknown format")
+	}
+}
+
+func TestFileStore_DedupBodies(t *testing.T) {
+	for _, ext := range []string{".jsonl", ".rwb"} {
+		t.Run(ext, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "001_log"+ext)
+
+			fs, err := datastore.NewFileStoreWithOptions(path, datastore.FileOptions{DedupBodies: true})
+			if err != nil {
+				t.Fatalf("NewFileStoreWithOptions: %v", err)
+			}
+			defer func() { _ = fs.Close() }()
+
+			var want []model.Record
+			for i := uint64(1); i <= 5; i++ {
+				rec := sampleRecord(i)
+				want = append(want, rec)
+				if err := fs.Append(rec); err != nil {
+					t.Fatalf("Append: %v", err)
+				}
+			}
+			if err := fs.Close(); err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+
+			// The response body is the only body over the dedup threshold, so one blob
+			var blobs []string
+			_ = filepath.WalkDir(filepath.Join(dir, "001_log.blobs"), func(p string, d os.DirEntry, err error) error {
+				if err == nil && !d.IsDir() {
+					blobs = append(blobs, p)
+				}
+				return nil
+			})
+			if len(blobs) != 1 {
+				t.Fatalf("expected 1 blob, got %v", blobs)
+			}
+			raw, _ := os.ReadFile(path)
+			if bytes.Contains(raw, bytes.Repeat([]byte("x"), 512)) {
+				t.Fatalf("expected body to be stored out of line")
+			}
+
+			fs, err = datastore.NewFileStore(path, 0)
+			if err != nil {
+				t.Fatalf("reopen: %v", err)
+			}
+			if got := streamAll(t, fs); !reflect.DeepEqual(got, want) {
+				t.Fatalf("rehydrated records mismatch\n got: %+v\nwant: %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestFileStore_MissingBlobErrors(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "log.jsonl")
+
+	fs, err := datastore.NewFileStoreWithOptions(path, datastore.FileOptions{DedupBodies: true})
+	if err != nil {
+		t.Fatalf("NewFileStoreWithOptions: %v", err)
+	}
+	defer func() { _ = fs.Close() }()
+	if err := fs.Append(sampleRecord(1)); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	if err := os.RemoveAll(filepath.Join(dir, "log.blobs")); err != nil {
+		t.Fatalf("RemoveAll: %v", err)
+	}
+
+	out, errCh := fs.Stream()
+	for range out {
+	}
+	if err := <-errCh; err == nil {
+		t.Fatalf("expected an error for a missing blob")
+	}
+}
+
+func TestFileStore_FollowSeesNewRecordsAcrossParts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "001_log.jsonl")
+
+	writer, err := datastore.NewFileStoreWithOptions(path, datastore.FileOptions{
+		FlushInterval: 10 * time.Millisecond,
+		Rotate:        datastore.RotateOptions{MaxRecords: 2},
+	})
+	if err != nil {
+		t.Fatalf("NewFileStoreWithOptions: %v", err)
+	}
+	defer func() { _ = writer.Close() }()
+	if err := writer.Append(model.Record{ID: 1}); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+
+	reader, err := datastore.OpenFileStore(path)
+	if err != nil {
+		t.Fatalf("OpenFileStore: %v", err)
+	}
+	defer func() { _ = reader.Close() }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	out, errCh := reader.Follow(ctx)
+
+	next := func() model.Record {
+		t.Helper()
+		select {
+		case rec := <-out:
+			return rec
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for a followed record")
+			return model.Record{}
+		}
+	}
+
+	if rec := next(); rec.ID != 1 {
+		t.Fatalf("expected existing record 1, got %d", rec.ID)
+	}
+
+	// Records 3 and 4 land in part 2
+	for id := uint64(2); id <= 4; id++ {
+		if err := writer.Append(model.Record{ID: id}); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+		if rec := next(); rec.ID != id {
+			t.Fatalf("expected followed record %d, got %d", id, rec.ID)
+		}
+	}
+
+	cancel()
+	for range out {
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("Follow error: %v", err)
+	}
+}
+
+func TestFileStore_StreamSkipsTornLastRecord(t *testing.T) {
+	for _, ext := range []string{".jsonl", ".rwb"} {
+		t.Run(ext, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "log"+ext)
+			fs, err := datastore.NewFileStore(path, 0)
+			if err != nil {
+				t.Fatalf("NewFileStore: %v", err)
+			}
+			for i := uint64(1); i <= 2; i++ {
+				if err := fs.Append(sampleRecord(i)); err != nil {
+					t.Fatalf("Append: %v", err)
+				}
+			}
+			if err := fs.Close(); err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+
+			// Simulate a crash halfway through writing the second record
+			info, _ := os.Stat(path)
+			good := info.Size()
+			data, _ := os.ReadFile(path)
+			if err := os.WriteFile(path, data[:good-40], 0644); err != nil {
+				t.Fatalf("WriteFile: %v", err)
+			}
+
+			fs, err = datastore.NewFileStore(path, 0)
+			if err != nil {
+				t.Fatalf("reopen: %v", err)
+			}
+			if got := streamAll(t, fs); len(got) != 1 || got[0].ID != 1 {
+				t.Fatalf("expected the torn record to be skipped, got %d records", len(got))
+			}
+			_ = fs.Close()
+
+			res, err := datastore.Verify(path)
+			if err != nil {
+				t.Fatalf("Verify: %v", err)
+			}
+			if res.Records != 1 || len(res.Corrupt) != 1 || !res.Corrupt[0].Torn || res.Corrupt[0].Offset == 0 {
+				t.Fatalf("unexpected verify result: %+v", res)
+			}
+
+			// Truncate recovery cuts the torn record before appending
+			fs, err = datastore.NewFileStoreWithOptions(path, datastore.FileOptions{Recovery: datastore.RecoverTruncate})
+			if err != nil {
+				t.Fatalf("reopen with truncate: %v", err)
+			}
+			if err := fs.Append(sampleRecord(3)); err != nil {
+				t.Fatalf("Append: %v", err)
+			}
+			got := streamAll(t, fs)
+			if len(got) != 2 || got[1].ID != 3 {
+				t.Fatalf("expected records 1 and 3 after truncation, got %d records", len(got))
+			}
+			_ = fs.Close()
+
+			if res, err := datastore.Verify(path); err != nil || len(res.Corrupt) != 0 || res.Records != 2 {
+				t.Fatalf("expected a clean log after truncation, got %+v %v", res, err)
+			}
+		})
+	}
+}
+
+func TestFileStore_SkipRecoveryAppendsToNewPart(t *testing.T) {
+	for _, ext := range []string{".jsonl", ".rwb"} {
+		t.Run(ext, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "log"+ext)
+			fs, err := datastore.NewFileStore(path, 0)
+			if err != nil {
+				t.Fatalf("NewFileStore: %v", err)
+			}
+			for i := uint64(1); i <= 2; i++ {
+				if err := fs.Append(sampleRecord(i)); err != nil {
+					t.Fatalf("Append: %v", err)
+				}
+			}
+			_ = fs.Close()
+
+			data, _ := os.ReadFile(path)
+			torn := data[:len(data)-40]
+			if err := os.WriteFile(path, torn, 0600); err != nil {
+				t.Fatalf("WriteFile: %v", err)
+			}
+
+			fs, err = datastore.NewFileStoreWithOptions(path, datastore.FileOptions{Recovery: datastore.RecoverSkip})
+			if err != nil {
+				t.Fatalf("reopen: %v", err)
+			}
+			if err := fs.Append(sampleRecord(3)); err != nil {
+				t.Fatalf("Append: %v", err)
+			}
+			got := streamAll(t, fs)
+			_ = fs.Close()
+			if len(got) != 2 || got[0].ID != 1 || got[1].ID != 3 {
+				t.Fatalf("expected records 1 and 3, got %d records", len(got))
+			}
+
+			if data, _ := os.ReadFile(path); !bytes.Equal(data, torn) {
+				t.Fatalf("skip recovery modified the torn part")
+			}
+			if _, err := os.Stat(logpath.PartPath(path, 2)); err != nil {
+				t.Fatalf("expected the append to start a new part: %v", err)
+			}
+		})
+	}
+}
+
+func TestVerify_ReportsCorruptLinesAndContinues(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log.jsonl")
+	content := `{"ID":1}` + "\n" + `{"ID":2,"Request":` + "\n" + `{"ID":3}` + "\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	res, err := datastore.Verify(path)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if res.Records != 2 || len(res.Corrupt) != 1 {
+		t.Fatalf("unexpected verify result: %+v", res)
+	}
+	if c := res.Corrupt[0]; c.Torn || c.Offset != int64(len(`{"ID":1}`)+1) {
+		t.Fatalf("unexpected corruption report: %+v", c)
+	}
+
+	// Corruption in the middle is still an error for Stream
+	fs, err := datastore.NewFileStore(path, 0)
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	defer func() { _ = fs.Close() }()
+	out, errCh := fs.Stream()
+	for range out {
+	}
+	if err := <-errCh; err == nil {
+		t.Fatalf("expected Stream to fail on a corrupt middle record")
+	}
+}
+
+func TestFileStore_SyncAlwaysWritesThrough(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log.jsonl")
+	fs, err := datastore.NewFileStoreWithOptions(path, datastore.FileOptions{Sync: datastore.SyncAlways})
+	if err != nil {
+		t.Fatalf("NewFileStoreWithOptions: %v", err)
+	}
+	defer func() { _ = fs.Close() }()
+	if err := fs.Append(model.Record{ID: 1}); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+
+	// Nothing is left in the buffer, so a crash now would keep the record
+	info, err := os.Stat(path)
+	if err != nil || info.Size() == 0 {
+		t.Fatalf("expected the record on disk right after Append, size=%d err=%v", info.Size(), err)
+	}
+}
+
+func TestFileStore_WritesSessionHeaderPerPart(t *testing.T) {
+	for _, ext := range []string{".jsonl", ".rwb.gz"} {
+		t.Run(ext, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "001_log"+ext)
+			fs, err := datastore.NewFileStoreWithOptions(path, datastore.FileOptions{
+				Rotate: datastore.RotateOptions{MaxRecords: 1},
+				Header: &model.SessionHeader{
+					Target:  "http://localhost:3000",
+					Capture: model.CaptureOptions{MaxBodyBytes: 1024},
+				},
+			})
+			if err != nil {
+				t.Fatalf("NewFileStoreWithOptions: %v", err)
+			}
+			for i := uint64(1); i <= 2; i++ {
+				if err := fs.Append(model.Record{ID: i}); err != nil {
+					t.Fatalf("Append: %v", err)
+				}
+			}
+			if got := streamAll(t, fs); len(got) != 2 || got[0].ID != 1 || got[1].ID != 2 {
+				t.Fatalf("expected headers to be skipped, got %+v", got)
+			}
+			if err := fs.Close(); err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+
+			for part := 1; part <= 2; part++ {
+				h, ok, err := datastore.ReadHeader(logpath.PartPath(path, part))
+				if err != nil || !ok {
+					t.Fatalf("ReadHeader part %d: ok=%v err=%v", part, ok, err)
+				}
+				if h.Schema != model.SchemaVersion || h.Part != part || h.Target != "http://localhost:3000" ||
+					h.Capture.MaxBodyBytes != 1024 || h.Version == "" || h.Started.IsZero() {
+					t.Fatalf("unexpected header for part %d: %+v", part, h)
+				}
+			}
+		})
+	}
+}
+
+func TestReadHeader_LegacyLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "old.jsonl")
+	legacy := `{"ID":1,"Timestamp":"2025-01-01T00:00:00Z","Request":{"Method":"GET","URL":"/a","Headers":null,"Body":null},"Response":{"Status":200,"Headers":null,"Body":null}}` + "\n"
+	if err := os.WriteFile(path, []byte(legacy), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	if _, ok, err := datastore.ReadHeader(path); err != nil || ok {
+		t.Fatalf("expected no header for a legacy log, ok=%v err=%v", ok, err)
+	}
+
+	// Appending to a legacy log adds a header in front of the new records only
+	fs, err := datastore.NewFileStore(path, 0)
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	defer func() { _ = fs.Close() }()
+	if err := fs.Append(model.Record{ID: 2}); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	got := streamAll(t, fs)
+	if len(got) != 2 || got[0].Request.URL != "/a" || got[1].ID != 2 {
+		t.Fatalf("unexpected records: %+v", got)
+	}
+}
+
+const testKeyA = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+const testKeyB = "1f1e1d1c1b1a191817161514131211100f0e0d0c0b0a09080706050403020100"
+
+func mustKey(t *testing.T) []byte {
+	t.Helper()
+	key, err := datastore.LoadKey()
+	if err != nil {
+		t.Fatalf("LoadKey: %v", err)
+	}
+	return key
+}
+
+func TestFileStore_EncryptedRoundTrip(t *testing.T) {
+	for _, ext := range []string{".jsonl.enc", ".rwb.zst.enc"} {
+		t.Run(ext, func(t *testing.T) {
+			t.Setenv("RWND_LOG_KEY", testKeyA)
+			path := filepath.Join(t.TempDir(), "001_log"+ext)
+
+			// Two stores append to the file, each starts its own encrypted stream
+			want := []model.Record{sampleRecord(1), sampleRecord(2)}
+			for _, rec := range want {
+				fs, err := datastore.NewFileStoreWithOptions(path, datastore.FileOptions{DedupBodies: true})
+				if err != nil {
+					t.Fatalf("NewFileStore: %v", err)
+				}
+				if err := fs.Append(rec); err != nil {
+					t.Fatalf("Append: %v", err)
+				}
+				if err := fs.Close(); err != nil {
+					t.Fatalf("Close: %v", err)
+				}
+			}
+
+			fs, err := datastore.NewFileStore(path, 0)
+			if err != nil {
+				t.Fatalf("reopen: %v", err)
+			}
+			defer func() { _ = fs.Close() }()
+			if got := streamAll(t, fs); !reflect.DeepEqual(got, want) {
+				t.Fatalf("encrypted round trip mismatch\n got: %+v\nwant: %+v", got, want)
+			}
+
+			raw, _ := os.ReadFile(path)
+			if bytes.Contains(raw, []byte("localhost")) {
+				t.Fatalf("log holds plaintext")
+			}
+			info, err := os.Stat(path)
+			if err != nil || info.Mode().Perm() != 0600 {
+				t.Fatalf("expected a 0600 log, got %v err=%v", info.Mode().Perm(), err)
+			}
+			blobs, _ := filepath.Glob(filepath.Join(logpath.BlobDir(path), "*", "*"))
+			if len(blobs) != 1 {
+				t.Fatalf("expected one blob, got %v", blobs)
+			}
+			if blob, _ := os.ReadFile(blobs[0]); bytes.Contains(blob, []byte("xxxx")) {
+				t.Fatalf("blob holds plaintext")
+			}
+			// A plain hash as the name would let anyone check a guessed body against it
+			sum := sha256.Sum256(want[0].Response.Body)
+			if filepath.Base(blobs[0]) == hex.EncodeToString(sum[:]) {
+				t.Fatalf("blob of an encrypted log is named by the hash of its body")
+			}
+
+			// A blob swapped for another one with the same key fails to hydrate
+			other, err := datastore.Seal(mustKey(t), bytes.Repeat([]byte("y"), 512))
+			if err != nil {
+				t.Fatalf("Seal: %v", err)
+			}
+			if err := os.WriteFile(blobs[0], other, 0600); err != nil {
+				t.Fatalf("WriteFile: %v", err)
+			}
+			out, errCh := fs.Stream()
+			for range out {
+			}
+			if err := <-errCh; err == nil {
+				t.Fatalf("expected an error for a swapped blob")
+			}
+
+			if h, ok, err := datastore.ReadHeader(path); err != nil || !ok || h.Part != 1 {
+				t.Fatalf("ReadHeader: %+v %v %v", h, ok, err)
+			}
+		})
+	}
+}
+
+func TestFileStore_EncryptedWrongKey(t *testing.T) {
+	t.Setenv("RWND_LOG_KEY", testKeyA)
+	path := filepath.Join(t.TempDir(), "log.jsonl.enc")
+	fs, err := datastore.NewFileStore(path, 0)
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	if err := fs.Append(sampleRecord(1)); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	_ = fs.Close()
+
+	t.Setenv("RWND_LOG_KEY", testKeyB)
+	fs, err = datastore.NewFileStore(path, 0)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer func() { _ = fs.Close() }()
+	out, errCh := fs.Stream()
+	for range out {
+		t.Fatalf("expected no records with the wrong key")
+	}
+	if err := <-errCh; err == nil || !strings.Contains(err.Error(), "wrong key") {
+		t.Fatalf("expected a wrong key error, got %v", err)
+	}
+
+	t.Setenv("RWND_LOG_KEY", "")
+	t.Setenv("RWND_LOG_KEY_FILE", filepath.Join(t.TempDir(), "missing.key"))
+	if _, err := datastore.NewFileStore(path, 0); err == nil {
+		t.Fatalf("expected an error without a key")
+	}
+}
+
+func TestFileStore_EncryptedSkipsTornChunk(t *testing.T) {
+	t.Setenv("RWND_LOG_KEY", testKeyA)
+	path := filepath.Join(t.TempDir(), "log.jsonl.enc")
+	// SyncAlways seals every record in its own chunk
+	fs, err := datastore.NewFileStoreWithOptions(path, datastore.FileOptions{Sync: datastore.SyncAlways})
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	for i := uint64(1); i <= 2; i++ {
+		if err := fs.Append(sampleRecord(i)); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+	}
+	// Everything up to here is what a crash would leave, Close adds the final chunk
+	unfinished, _ := os.ReadFile(path)
+	_ = fs.Close()
+
+	if res, err := datastore.Verify(path); err != nil || res.Records != 2 || len(res.Corrupt) != 0 {
+		t.Fatalf("expected a clean log after Close, got %+v %v", res, err)
+	}
+
+	cases := map[string]struct {
+		data []byte
+		want int
+	}{
+		"cut inside a chunk":      {unfinished[:len(unfinished)-10], 1},
+		"cut at a chunk boundary": {unfinished, 2},
+	}
+	for name, c := range cases {
+		if err := os.WriteFile(path, c.data, 0600); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+
+		fs, err := datastore.NewFileStore(path, 0)
+		if err != nil {
+			t.Fatalf("reopen: %v", err)
+		}
+		got := streamAll(t, fs)
+		_ = fs.Close()
+		if len(got) != c.want {
+			t.Fatalf("%s: expected %d records before the torn tail, got %d", name, c.want, len(got))
+		}
+
+		res, err := datastore.Verify(path)
+		if err != nil || res.Records != c.want || len(res.Corrupt) != 1 || !res.Corrupt[0].Torn {
+			t.Fatalf("%s: expected verify to report the stream as torn, got %+v %v", name, res, err)
+		}
+	}
+}
+
+func TestFileStore_FollowToleratesUnfinishedEncryptedTail(t *testing.T) {
+	t.Setenv("RWND_LOG_KEY", testKeyA)
+	path := filepath.Join(t.TempDir(), "001_log.jsonl.enc")
+
+	writer, err := datastore.NewFileStoreWithOptions(path, datastore.FileOptions{Sync: datastore.SyncAlways})
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	defer func() { _ = writer.Close() }()
+	for i := uint64(1); i <= 2; i++ {
+		if err := writer.Append(sampleRecord(i)); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+	}
+
+	// The writer is still open, its stream has no final chunk yet
+	reader, err := datastore.OpenFileStore(path)
+	if err != nil {
+		t.Fatalf("OpenFileStore: %v", err)
+	}
+	defer func() { _ = reader.Close() }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	out, errCh := reader.Follow(ctx)
+	for i := uint64(1); i <= 2; i++ {
+		select {
+		case rec := <-out:
+			if rec.ID != i {
+				t.Fatalf("expected followed record %d, got %d", i, rec.ID)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for a followed record")
+		}
+	}
+	cancel()
+	for range out {
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("Follow error: %v", err)
+	}
+}
+
+func TestFileStore_AppendAfterTornLayeredTail(t *testing.T) {
+	t.Setenv("RWND_LOG_KEY", testKeyA)
+	for _, ext := range []string{".jsonl.gz", ".jsonl.zst", ".jsonl.enc", ".rwb.zst.enc"} {
+		t.Run(ext, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "log"+ext)
+			fs, err := datastore.NewFileStoreWithOptions(path, datastore.FileOptions{Sync: datastore.SyncAlways})
+			if err != nil {
+				t.Fatalf("NewFileStore: %v", err)
+			}
+			for i := uint64(1); i <= 2; i++ {
+				if err := fs.Append(sampleRecord(i)); err != nil {
+					t.Fatalf("Append: %v", err)
+				}
+			}
+
+			// Simulate a crash: the stream was never closed and the last write was cut short
+			data, _ := os.ReadFile(path)
+			_ = fs.Close()
+			if err := os.WriteFile(path, data[:len(data)-10], 0600); err != nil {
+				t.Fatalf("WriteFile: %v", err)
+			}
+
+			fs, err = datastore.NewFileStoreWithOptions(path, datastore.FileOptions{Recovery: datastore.RecoverTruncate})
+			if err != nil {
+				t.Fatalf("reopen: %v", err)
+			}
+			if err := fs.Append(sampleRecord(3)); err != nil {
+				t.Fatalf("Append: %v", err)
+			}
+			_ = fs.Close()
+
+			fs, err = datastore.NewFileStore(path, 0)
+			if err != nil {
+				t.Fatalf("reopen: %v", err)
+			}
+			defer func() { _ = fs.Close() }()
+			got := streamAll(t, fs)
+			if len(got) != 2 || got[0].ID != 1 || got[1].ID != 3 {
+				t.Fatalf("expected records 1 and 3, got %d records", len(got))
+			}
+			if _, err := os.Stat(logpath.PartPath(path, 2)); err != nil {
+				t.Fatalf("expected the append to start a new part: %v", err)
+			}
+		})
+	}
+}
+
+func TestOpenFileStore_MissingLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing.jsonl")
+	if _, err := datastore.OpenFileStore(path); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected a not exist error, got %v", err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("OpenFileStore created the log: %v", err)
+	}
+}
+
+func TestOpenFileStore_ReadsWithoutWriting(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log.jsonl")
+	fs, err := datastore.NewFileStore(path, 0)
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	if err := fs.Append(sampleRecord(1)); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	_ = fs.Close()
+	before, _ := os.ReadFile(path)
+
+	// No write permission needed, the log is only ever opened for reading
+	if err := os.Chmod(path, 0400); err != nil {
+		t.Fatalf("Chmod: %v", err)
+	}
+	ro, err := datastore.OpenFileStore(path)
+	if err != nil {
+		t.Fatalf("OpenFileStore: %v", err)
+	}
+	if got := streamAll(t, ro); len(got) != 1 || got[0].ID != 1 {
+		t.Fatalf("expected record 1, got %d records", len(got))
+	}
+	if err := ro.Append(sampleRecord(2)); err == nil {
+		t.Fatalf("expected Append on a read-only store to fail")
+	}
+	if err := ro.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if _, errCh := ro.Stream(); !errors.Is(<-errCh, os.ErrClosed) {
+		t.Fatalf("expected Stream after Close to fail")
+	}
+
+	if after, _ := os.ReadFile(path); !bytes.Equal(before, after) {
+		t.Fatalf("read-only store modified the log")
+	}
+}
+
+func TestUnseal_DetectsTruncation(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, datastore.KeySize)
+	sealed, err := datastore.Seal(key, []byte("body"))
+	if err != nil {
+		t.Fatalf("Seal: %v", err)
+	}
+	if got, err := datastore.Unseal(key, sealed); err != nil || string(got) != "body" {
+		t.Fatalf("Unseal: %q %v", got, err)
+	}
+	// Only the stream header left, and the final chunk cut short
+	for _, n := range []int{9, len(sealed) - 1} {
+		if _, err := datastore.Unseal(key, sealed[:n]); err == nil {
+			t.Fatalf("expected an error for data cut to %d bytes", n)
+		}
+	}
+}
+
+func TestWithLayout_FollowsPath(t *testing.T) {
+	recorded := model.CaptureOptions{Format: "json", Compress: "gzip", MaxBodyBytes: 1024, Rules: "rules.yaml"}
+	got := datastore.WithLayout(recorded, "out.rwb.zst.enc", true)
+	want := model.CaptureOptions{Format: "binary", Compress: "zstd", Encrypt: true, DedupBodies: true, MaxBodyBytes: 1024, Rules: "rules.yaml"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("WithLayout = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,126 @@
+package datastore
+
+import (
+	"context"
+	"io"
+	"os"
+	"time"
+
+	"github.com/BarrettBr/RWND/internal/logpath"
+	"github.com/BarrettBr/RWND/internal/model"
+)
+
+// followPollInterval is how often Follow checks for new data at the end of the log.
+const followPollInterval = 200 * time.Millisecond
+
+// Follow streams every record of the session like Stream, then keeps waiting for
+// records appended later, by this store or another process, until ctx is canceled.
+// When the writer rotates, Follow finishes the current part and moves to the next.
+// Records appear once the writer flushes, every FlushInterval for the proxy.
+func (fs *FileStore) Follow(ctx context.Context) (<-chan model.Record, <-chan error) {
+	out := make(chan model.Record)
+	errCh := make(chan error, 1)
+
+	fs.mu.Lock()
+	flushErr := fs.flushForRead()
+	fs.mu.Unlock()
+
+	if flushErr != nil {
+		errCh <- flushErr
+		close(out)
+		close(errCh)
+		return out, errCh
+	}
+
+	go func() {
+		defer close(out)
+		defer close(errCh)
+
+		blobs := fs.newBlobStore()
+		for part := 1; ; part++ {
+			err := followFile(ctx, logpath.PartPath(fs.path, part), logpath.PartPath(fs.path, part+1), fs.key, blobs, out)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				errCh <- err
+				return
+			}
+		}
+	}()
+
+	return out, errCh
+}
+
+func followFile(ctx context.Context, path, next string, key []byte, blobs *BlobStore, out chan<- model.Record) error {
+	// Reads one part, blocking at its end until the next part shows up
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r, err := openLogLayers(path, key, &followReader{ctx: ctx, r: f, next: next}, true)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	decoder := newRecordDecoder(formatFor(path), r)
+	for {
+		var rec model.Record
+		if err := decoder.Decode(&rec); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			// Only reached once the part is finished, so a torn record is a write cut short
+			if cre, ok := asCorrupt(err, path); ok && cre.Torn {
+				return nil
+			}
+			return err
+		}
+		if err := blobs.hydrate(&rec); err != nil {
+			return err
+		}
+
+		select {
+		case out <- rec:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// followReader turns EOF into a wait for more data. It only reports EOF once the
+// file is finished, which is when the writer has rotated to the next part, or once
+// ctx is canceled.
+type followReader struct {
+	ctx  context.Context
+	r    io.Reader
+	next string // Path of the following part
+}
+
+func (f *followReader) Read(p []byte) (int, error) {
+	for {
+		n, err := f.r.Read(p)
+		if n > 0 || err != io.EOF {
+			return n, err
+		}
+
+		if _, statErr := os.Stat(f.next); statErr == nil {
+			// The writer closes a part before opening the next, so one more read
+			// picks up anything flushed between our last read and the rotation
+			n, err = f.r.Read(p)
+			if n > 0 {
+				return n, nil
+			}
+			return 0, io.EOF
+		}
+
+		select {
+		case <-f.ctx.Done():
+			return 0, io.EOF
+		case <-time.After(followPollInterval):
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package datastore
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"time"
+
+	"github.com/BarrettBr/RWND/internal/model"
+	"github.com/BarrettBr/RWND/internal/version"
+)
+
+// headerKey marks the JSON line holding a session header: {"rwnd_header":{...}}
+const headerKey = "rwnd_header"
+
+var headerPrefix = []byte(`{"` + headerKey + `"`)
+
+type headerLine struct {
+	Header model.SessionHeader `json:"rwnd_header"`
+}
+
+// defaultHeader is written by stores that weren't given one, so every log written
+// by this build carries its schema version.
+func defaultHeader() model.SessionHeader {
+	return model.SessionHeader{Started: time.Now().UTC()}
+}
+
+func stampHeader(h model.SessionHeader, part int) model.SessionHeader {
+	// Fills in the fields the store owns
+	h.Schema = model.SchemaVersion
+	h.Version = version.String()
+	h.Part = part
+	if h.Started.IsZero() {
+		h.Started = time.Now().UTC()
+	}
+	return h
+}
+
+// WithLayout returns c with the fields that describe how a log at path is stored,
+// record format, compression, encryption and body dedup, set to match path and dedup.
+// Headers copied from another log keep what was recorded but not how it was stored.
+func WithLayout(c model.CaptureOptions, path string, dedup bool) model.CaptureOptions {
+	c.Format = string(formatFor(path))
+	c.Compress = string(compressionFor(path))
+	c.Encrypt = isEncrypted(path)
+	c.DedupBodies = dedup
+	return c
+}
+
+// ReadHeader returns the session header at the start of a log file.
+// ok is false for logs written before headers existed.
+func ReadHeader(path string) (h model.SessionHeader, ok bool, err error) {
+	key, err := keyFor(path)
+	if err != nil {
+		return h, false, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return h, false, err
+	}
+	defer f.Close()
+
+	r, err := openLogReader(path, key, f)
+	if err != nil {
+		return h, false, err
+	}
+	defer r.Close()
+
+	// Decoding the first record consumes any header in front of it
+	decoder := newRecordDecoder(formatFor(path), r)
+	if err := decoder.Decode(&model.Record{}); err != nil && err != io.EOF {
+		if _, corrupt := asCorrupt(err, path); !corrupt {
+			return h, false, err
+		}
+	}
+	h, ok = decoder.Header()
+	return h, ok, nil
+}
+
+// ------------
+
+// jsonRecordEncoder writes one JSON object per line.
+type jsonRecordEncoder struct {
+	enc *json.Encoder
+}
+
+func (e *jsonRecordEncoder) Encode(v any) error {
+	return e.enc.Encode(v)
+}
+
+func (e *jsonRecordEncoder) EncodeHeader(h model.SessionHeader) error {
+	return e.enc.Encode(headerLine{Header: h})
+}
+
+// ------------
+
+// jsonMigrations upgrade a raw JSON record by one schema version, keyed by the
+// version they upgrade from. A nil step means the layouts are compatible.
+//
+// Schema 1 records only lack fields added since (Latency, Tags, Truncated, BodyRef),
+// which decode as zero values, so that step is empty. Binary logs were introduced at
+// schema 2 and evolve by adding field tags, so they need no migrations.
+var jsonMigrations = map[int]func(rec map[string]json.RawMessage) error{
+	1: nil,
+}
+
+func needsMigration(schema int) bool {
+	for v := schema; v < model.SchemaVersion; v++ {
+		if jsonMigrations[v] != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func migrateJSON(line []byte, schema int) ([]byte, error) {
+	// Runs every migration step from schema up to the current version
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(line, &raw); err != nil {
+		return nil, err
+	}
+	for v := schema; v < model.SchemaVersion; v++ {
+		if step := jsonMigrations[v]; step != nil {
+			if err := step(raw); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return json.Marshal(raw)
+}
+
+func isHeaderLine(line []byte) bool {
+	return bytes.HasPrefix(line, headerPrefix)
+}
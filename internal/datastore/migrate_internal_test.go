@@ -0,0 +1,41 @@
+package datastore
+
+import (
+	"bufio"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/BarrettBr/RWND/internal/model"
+)
+
+func TestJSONMigrationsApplyToOlderSchemas(t *testing.T) {
+	// Register a step that renames a field the way a real layout change would
+	orig := jsonMigrations[1]
+	jsonMigrations[1] = func(rec map[string]json.RawMessage) error {
+		if v, ok := rec["Seq"]; ok {
+			rec["ID"] = v
+			delete(rec, "Seq")
+		}
+		return nil
+	}
+	defer func() { jsonMigrations[1] = orig }()
+
+	legacy := `{"Seq":7}` + "\n"
+	current := `{"rwnd_header":{"Schema":2}}` + "\n" + `{"Seq":7,"ID":8}` + "\n"
+
+	var rec model.Record
+	d := newRecordDecoder(FormatJSON, bufio.NewReader(strings.NewReader(legacy)))
+	if err := d.Decode(&rec); err != nil || rec.ID != 7 {
+		t.Fatalf("expected the legacy record to be migrated, got %+v err=%v", rec, err)
+	}
+
+	rec = model.Record{}
+	d = newRecordDecoder(FormatJSON, bufio.NewReader(strings.NewReader(current)))
+	if err := d.Decode(&rec); err != nil || rec.ID != 8 {
+		t.Fatalf("expected a current schema record to be left alone, got %+v err=%v", rec, err)
+	}
+	if h, ok := d.Header(); !ok || h.Schema != 2 {
+		t.Fatalf("expected the header to be read, got %+v %v", h, ok)
+	}
+}
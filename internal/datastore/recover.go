@@ -0,0 +1,331 @@
+package datastore
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/BarrettBr/RWND/internal/logpath"
+	"github.com/BarrettBr/RWND/internal/model"
+)
+
+// Recovery controls what a FileStore does with a torn trailing record,
+// the partial write a killed proxy can leave at the end of a log.
+type Recovery string
+
+const (
+	RecoverSkip     Recovery = ""         // Readers ignore the torn record, appends go to a new part and the file is left alone
+	RecoverTruncate Recovery = "truncate" // Cut the torn record off before appending, compressed and encrypted logs get a new part
+)
+
+// ParseRecovery turns a flag value into a Recovery.
+func ParseRecovery(value string) (Recovery, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", "skip":
+		return RecoverSkip, nil
+	case "truncate":
+		return RecoverTruncate, nil
+	default:
+		return RecoverSkip, fmt.Errorf("Unknown recovery mode %q (use skip or truncate)", value)
+	}
+}
+
+// CorruptRecordError describes a record that could not be decoded.
+type CorruptRecordError struct {
+	Path   string
+	Offset int64 // Byte offset of the record, in the decompressed stream for compressed logs
+	Torn   bool  // The record is cut off by the end of the file
+	Err    error
+}
+
+func (e *CorruptRecordError) Error() string {
+	kind := "corrupt record"
+	if e.Torn {
+		kind = "torn record at end of file"
+	}
+	if e.Path == "" {
+		return fmt.Sprintf("offset %d: %s: %v", e.Offset, kind, e.Err)
+	}
+	return fmt.Sprintf("%s offset %d: %s: %v", e.Path, e.Offset, kind, e.Err)
+}
+
+func (e *CorruptRecordError) Unwrap() error { return e.Err }
+
+func asCorrupt(err error, path string) (*CorruptRecordError, bool) {
+	// Fills in the file path on decoder errors that describe a bad record
+	var cre *CorruptRecordError
+	if !errors.As(err, &cre) {
+		return nil, false
+	}
+	cre.Path = path
+	return cre, true
+}
+
+// ------------
+
+// VerifyResult is what Verify found in a log session.
+type VerifyResult struct {
+	Records int                   // Records that decoded cleanly
+	Corrupt []*CorruptRecordError // Every record that didn't, in file order
+}
+
+// Verify decodes every record of the session path belongs to and reports the ones
+// that are corrupt instead of stopping at the first. JSONL logs pick up again at the
+// next line. Binary logs continue with the next frame unless a frame length itself
+// is damaged, after which the rest of that part can't be read.
+func Verify(path string) (VerifyResult, error) {
+	var res VerifyResult
+
+	parts, err := logpath.SessionFiles(logpath.PartPath(path, 1))
+	if err != nil {
+		return res, err
+	}
+	if len(parts) == 0 {
+		return res, fmt.Errorf("%s: %w", path, os.ErrNotExist)
+	}
+
+	for _, part := range parts {
+		if err := verifyFile(part, &res); err != nil {
+			return res, err
+		}
+	}
+	return res, nil
+}
+
+func verifyFile(path string, res *VerifyResult) error {
+	key, err := keyFor(path)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r, err := openLogReader(path, key, f)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	decoder := newRecordDecoder(formatFor(path), r)
+	for {
+		err := decoder.Decode(&model.Record{})
+		if err == nil {
+			res.Records++
+			continue
+		}
+		if err == io.EOF {
+			return nil
+		}
+		cre, ok := asCorrupt(err, path)
+		if !ok {
+			return err
+		}
+		res.Corrupt = append(res.Corrupt, cre)
+		if cre.Torn {
+			return nil
+		}
+	}
+}
+
+// ------------
+
+func truncateTorn(path string) error {
+	// Cuts a torn trailing record off an uncompressed log so appends start on a clean boundary.
+	// Compressed and encrypted offsets don't map back to file offsets, Append moves on to a
+	// new part instead when endsTorn finds one of those logs unfinished, as it does for any
+	// log under RecoverSkip.
+	offset, torn, err := tornOffset(path)
+	if err != nil || !torn {
+		return err
+	}
+	return os.Truncate(path, offset)
+}
+
+func isLayered(path string) bool {
+	return compressionFor(path) != CompressNone || isEncrypted(path)
+}
+
+func endsTorn(path string, key []byte) (bool, error) {
+	// Reports whether a log ends in a write that never finished: a torn record, a cut off
+	// chunk, or a gzip member or zstd frame that was never closed. Readers stop there, so
+	// anything appended behind it would be lost.
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	zr, err := openLogReader(path, key, f)
+	if err != nil {
+		return false, err
+	}
+	defer zr.Close()
+
+	decoder := newRecordDecoder(formatFor(path), zr)
+	for {
+		err := decoder.Decode(&model.Record{})
+		if err == nil {
+			continue
+		}
+		if err == io.EOF {
+			break
+		}
+		cre, ok := asCorrupt(err, path)
+		if !ok {
+			return false, err
+		}
+		if cre.Torn {
+			return true, nil
+		}
+	}
+
+	t, ok := zr.(*openTailReader)
+	return ok && t.open, nil
+}
+
+func tornOffset(path string) (int64, bool, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	defer f.Close()
+
+	decoder := newRecordDecoder(formatFor(path), f)
+	for {
+		err := decoder.Decode(&model.Record{})
+		if err == nil {
+			continue
+		}
+		if err == io.EOF {
+			return 0, false, nil
+		}
+		cre, ok := asCorrupt(err, path)
+		if !ok {
+			return 0, false, err
+		}
+		if cre.Torn {
+			return cre.Offset, true, nil
+		}
+		// Corruption mid-file is left for logs verify to report
+	}
+}
+
+// ------------
+
+// jsonLineDecoder reads one JSON record per line, tracking offsets so bad lines
+// can be reported and skipped without losing the records after them.
+type jsonLineDecoder struct {
+	r         *bufio.Reader
+	offset    int64
+	line      []byte
+	header    model.SessionHeader
+	hasHeader bool
+}
+
+func (d *jsonLineDecoder) Header() (model.SessionHeader, bool) {
+	return d.header, d.hasHeader
+}
+
+func (d *jsonLineDecoder) schema() int {
+	// Logs without a header predate them
+	if !d.hasHeader {
+		return 1
+	}
+	return d.header.Schema
+}
+
+func (d *jsonLineDecoder) Decode(v any) error {
+	for {
+		start := d.offset
+		line, err := d.readLine()
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			// The layer below ended in the middle of a write
+			return &CorruptRecordError{Offset: start, Torn: true, Err: err}
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		trimmed := bytes.TrimSpace(line)
+		if len(trimmed) == 0 {
+			if err == io.EOF {
+				return io.EOF
+			}
+			continue
+		}
+
+		if isHeaderLine(trimmed) {
+			var hl headerLine
+			if uerr := json.Unmarshal(trimmed, &hl); uerr != nil {
+				return &CorruptRecordError{Offset: start, Torn: err == io.EOF, Err: uerr}
+			}
+			d.header, d.hasHeader = hl.Header, true
+			if err == io.EOF {
+				return io.EOF
+			}
+			continue
+		}
+
+		if needsMigration(d.schema()) {
+			migrated, merr := migrateJSON(trimmed, d.schema())
+			if merr != nil {
+				return &CorruptRecordError{Offset: start, Torn: err == io.EOF, Err: merr}
+			}
+			trimmed = migrated
+		}
+
+		if uerr := json.Unmarshal(trimmed, v); uerr != nil {
+			// The encoder ends every record with a newline, so a bad line without one
+			// is a write that was cut off
+			return &CorruptRecordError{Offset: start, Torn: err == io.EOF, Err: uerr}
+		}
+		return nil
+	}
+}
+
+func (d *jsonLineDecoder) readLine() ([]byte, error) {
+	d.line = d.line[:0]
+	for {
+		chunk, err := d.r.ReadSlice('\n')
+		d.line = append(d.line, chunk...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		d.offset += int64(len(d.line))
+		return d.line, err
+	}
+}
+
+// offsetReader counts bytes read so the binary decoder can report frame offsets.
+type offsetReader struct {
+	r *bufio.Reader
+	n int64
+}
+
+func (o *offsetReader) Read(p []byte) (int, error) {
+	n, err := o.r.Read(p)
+	o.n += int64(n)
+	return n, err
+}
+
+func (o *offsetReader) ReadByte() (byte, error) {
+	b, err := o.r.ReadByte()
+	if err == nil {
+		o.n++
+	}
+	return b, err
+}
@@ -0,0 +1,201 @@
+// Package diff compares responses while skipping fields that are expected to change.
+package diff
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/BarrettBr/RWND/internal/model"
+)
+
+// Rules lists what to ignore when comparing two responses.
+type Rules struct {
+	IgnoreHeaders []string // Header names, case insensitive
+	IgnoreJSON    []string // Dotted JSON paths such as "meta.requestId" or "items.*.updatedAt"
+}
+
+// Difference is one mismatch between two responses.
+type Difference struct {
+	Field string // "status", "header X-Foo", "body", "json data.id"
+	Old   string
+	New   string
+}
+
+func (d Difference) String() string {
+	return fmt.Sprintf("%s: %q -> %q", d.Field, d.Old, d.New)
+}
+
+// defaultIgnoredHeaders change on every response and never mean anything by themselves.
+var defaultIgnoredHeaders = []string{"Date", "Content-Length"}
+
+// Compare returns the differences between two responses after applying the rules.
+func Compare(old, new model.Response, rules Rules) []Difference {
+	var out []Difference
+
+	if old.Status != new.Status {
+		out = append(out, Difference{Field: "status", Old: fmt.Sprint(old.Status), New: fmt.Sprint(new.Status)})
+	}
+
+	out = append(out, compareHeaders(old.Headers, new.Headers, rules.IgnoreHeaders)...)
+	out = append(out, compareBodies(old.Body, new.Body, rules.IgnoreJSON)...)
+
+	return out
+}
+
+func compareHeaders(old, new http.Header, ignore []string) []Difference {
+	skip := make(map[string]bool)
+	for _, h := range append(append([]string{}, defaultIgnoredHeaders...), ignore...) {
+		skip[http.CanonicalHeaderKey(h)] = true
+	}
+
+	keys := make(map[string]bool)
+	for k := range old {
+		keys[http.CanonicalHeaderKey(k)] = true
+	}
+	for k := range new {
+		keys[http.CanonicalHeaderKey(k)] = true
+	}
+
+	names := make([]string, 0, len(keys))
+	for k := range keys {
+		if !skip[k] {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+
+	var out []Difference
+	for _, name := range names {
+		a := strings.Join(old.Values(name), ", ")
+		b := strings.Join(new.Values(name), ", ")
+		if a != b {
+			out = append(out, Difference{Field: "header " + name, Old: a, New: b})
+		}
+	}
+	return out
+}
+
+func compareBodies(old, new []byte, ignore []string) []Difference {
+	if bytes.Equal(old, new) {
+		return nil
+	}
+
+	// JSON bodies are compared structurally so ignore paths and key order don't matter
+	var a, b any
+	if json.Unmarshal(old, &a) == nil && json.Unmarshal(new, &b) == nil {
+		for _, path := range ignore {
+			a = dropPath(a, strings.Split(path, "."))
+			b = dropPath(b, strings.Split(path, "."))
+		}
+		var out []Difference
+		compareJSON("", a, b, &out)
+		return out
+	}
+
+	return []Difference{{Field: "body", Old: summarize(old), New: summarize(new)}}
+}
+
+func compareJSON(path string, a, b any, out *[]Difference) {
+	am, aok := a.(map[string]any)
+	bm, bok := b.(map[string]any)
+	if aok && bok {
+		keys := make(map[string]bool)
+		for k := range am {
+			keys[k] = true
+		}
+		for k := range bm {
+			keys[k] = true
+		}
+		names := make([]string, 0, len(keys))
+		for k := range keys {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		for _, k := range names {
+			compareJSON(joinPath(path, k), am[k], bm[k], out)
+		}
+		return
+	}
+
+	as, aok := a.([]any)
+	bs, bok := b.([]any)
+	if aok && bok && len(as) == len(bs) {
+		for i := range as {
+			compareJSON(joinPath(path, fmt.Sprint(i)), as[i], bs[i], out)
+		}
+		return
+	}
+
+	if !reflect.DeepEqual(a, b) {
+		field := "json " + path
+		if path == "" {
+			field = "json"
+		}
+		*out = append(*out, Difference{Field: field, Old: jsonString(a), New: jsonString(b)})
+	}
+}
+
+func dropPath(v any, parts []string) any {
+	// Removes the value at a dotted path. "*" matches every key or array index.
+	if len(parts) == 0 {
+		return v
+	}
+	head, rest := parts[0], parts[1:]
+
+	switch node := v.(type) {
+	case map[string]any:
+		for k, child := range node {
+			if head != "*" && k != head {
+				continue
+			}
+			if len(rest) == 0 {
+				delete(node, k)
+				continue
+			}
+			node[k] = dropPath(child, rest)
+		}
+	case []any:
+		for i, child := range node {
+			if head != "*" && head != fmt.Sprint(i) {
+				continue
+			}
+			if len(rest) == 0 {
+				node[i] = nil
+				continue
+			}
+			node[i] = dropPath(child, rest)
+		}
+	}
+	return v
+}
+
+func joinPath(base, key string) string {
+	if base == "" {
+		return key
+	}
+	return base + "." + key
+}
+
+func jsonString(v any) string {
+	if v == nil {
+		return "<missing>"
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
+
+func summarize(body []byte) string {
+	const max = 80
+	if len(body) > max {
+		return string(body[:max]) + "..."
+	}
+	return string(body)
+}
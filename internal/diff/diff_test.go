@@ -0,0 +1,60 @@
+package diff_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/BarrettBr/RWND/internal/diff"
+	"github.com/BarrettBr/RWND/internal/model"
+)
+
+func response(status int, body string, headers map[string]string) model.Response {
+	resp := model.Response{Status: status, Body: []byte(body), Headers: http.Header{}}
+	for k, v := range headers {
+		resp.Headers.Set(k, v)
+	}
+	return resp
+}
+
+func TestCompare_Identical(t *testing.T) {
+	a := response(200, "ok", map[string]string{"Date": "Mon", "X-A": "1"})
+	b := response(200, "ok", map[string]string{"Date": "Tue", "X-A": "1"})
+	if d := diff.Compare(a, b, diff.Rules{}); len(d) != 0 {
+		t.Fatalf("expected no differences, got %v", d)
+	}
+}
+
+func TestCompare_StatusHeadersAndBody(t *testing.T) {
+	a := response(200, "old", map[string]string{"X-A": "1"})
+	b := response(500, "new", map[string]string{"X-A": "2"})
+	d := diff.Compare(a, b, diff.Rules{})
+	if len(d) != 3 {
+		t.Fatalf("expected 3 differences, got %v", d)
+	}
+	if d[0].Field != "status" || d[1].Field != "header X-A" || d[2].Field != "body" {
+		t.Fatalf("unexpected fields: %v", d)
+	}
+}
+
+func TestCompare_IgnoreRules(t *testing.T) {
+	a := response(200, `{"id":1,"meta":{"requestId":"a"},"items":[{"at":1,"v":1}]}`, map[string]string{"X-Request-Id": "a"})
+	b := response(200, `{"meta":{"requestId":"b"},"id":1,"items":[{"at":2,"v":1}]}`, map[string]string{"X-Request-Id": "b"})
+
+	if d := diff.Compare(a, b, diff.Rules{}); len(d) != 3 {
+		t.Fatalf("expected 3 differences without rules, got %v", d)
+	}
+
+	rules := diff.Rules{IgnoreHeaders: []string{"x-request-id"}, IgnoreJSON: []string{"meta.requestId", "items.*.at"}}
+	if d := diff.Compare(a, b, rules); len(d) != 0 {
+		t.Fatalf("expected no differences with rules, got %v", d)
+	}
+}
+
+func TestCompare_JSONFieldPath(t *testing.T) {
+	a := response(200, `{"data":{"total":1}}`, nil)
+	b := response(200, `{"data":{"total":2}}`, nil)
+	d := diff.Compare(a, b, diff.Rules{})
+	if len(d) != 1 || d[0].Field != "json data.total" || d[0].Old != "1" || d[0].New != "2" {
+		t.Fatalf("unexpected differences: %v", d)
+	}
+}
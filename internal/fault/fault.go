@@ -0,0 +1,279 @@
+// Package fault injects failures into proxied traffic on purpose: added
+// latency, made up statuses, dropped connections and truncated or corrupted
+// bodies. Every random choice comes from one seeded RNG so a run can be
+// repeated.
+package fault
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/BarrettBr/RWND/internal/model"
+	"github.com/BarrettBr/RWND/internal/query"
+)
+
+// Tag is added to every record a fault was injected into.
+const Tag = "fault"
+
+// Where a drop fault closes the client connection.
+const (
+	DropRequest  = "request"  // Before the request is forwarded
+	DropResponse = "response" // After the upstream answered, without answering the client
+)
+
+// File is the on-disk fault rules layout.
+type File struct {
+	Seed   int64  `yaml:"seed"` // RNG seed, 0 picks one from the clock
+	Faults []Rule `yaml:"faults"`
+}
+
+// Rule injects faults into the requests it matches. Latency combines with any
+// other fault, Status and Drop exclude each other.
+type Rule struct {
+	Name        string   `yaml:"name"`
+	When        string   `yaml:"when"`        // Filter expression over the request, empty matches all
+	Probability *float64 `yaml:"probability"` // Chance a matching request gets the faults, 1 if unset
+	Latency     string   `yaml:"latency"`     // "500ms", or a range "100ms-2s" picked from uniformly
+	Status      int      `yaml:"status"`      // Answer with this status instead of forwarding
+	Body        string   `yaml:"body"`        // Body of the Status answer, the status text if empty
+	Drop        string   `yaml:"drop"`        // "request" or "response", see DropRequest and DropResponse
+	Truncate    *int     `yaml:"truncate"`    // Cut the response body after this many bytes
+	Corrupt     int      `yaml:"corrupt"`     // Flip this many random bytes of the response body
+
+	when       *query.Filter
+	minLatency time.Duration
+	maxLatency time.Duration
+}
+
+// Load reads a fault rules file from disk.
+func Load(path string) (File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return File{}, err
+	}
+	return Parse(data)
+}
+
+// Parse decodes a YAML fault rules document and checks every rule.
+func Parse(data []byte) (File, error) {
+	var f File
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return File{}, fmt.Errorf("Faults parse error: %w", err)
+	}
+	for i := range f.Faults {
+		r := &f.Faults[i]
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("#%d", i+1)
+		}
+		if err := r.compile(); err != nil {
+			return File{}, fmt.Errorf("Fault %s: %w", r.Name, err)
+		}
+	}
+	return f, nil
+}
+
+func (r *Rule) compile() error {
+	switch {
+	case r.Probability != nil && (*r.Probability < 0 || *r.Probability > 1):
+		return fmt.Errorf("probability must be between 0 and 1, got %v", *r.Probability)
+	case r.Status != 0 && (r.Status < 100 || r.Status > 599):
+		return fmt.Errorf("status must be between 100 and 599, got %d", r.Status)
+	case r.Drop != "" && r.Drop != DropRequest && r.Drop != DropResponse:
+		return fmt.Errorf("drop must be request or response, got %q", r.Drop)
+	case r.Status != 0 && r.Drop != "":
+		return fmt.Errorf("status and drop can't be combined")
+	case r.Truncate != nil && *r.Truncate < 0:
+		return fmt.Errorf("truncate can't be negative")
+	case r.Corrupt < 0:
+		return fmt.Errorf("corrupt can't be negative")
+	case r.Latency == "" && r.Status == 0 && r.Drop == "" && r.Truncate == nil && r.Corrupt == 0:
+		return fmt.Errorf("set latency, status, drop, truncate or corrupt")
+	}
+
+	if r.Latency != "" {
+		lo, hi, isRange := strings.Cut(r.Latency, "-")
+		from, err := time.ParseDuration(strings.TrimSpace(lo))
+		if err != nil {
+			return fmt.Errorf("latency: %w", err)
+		}
+		to := from
+		if isRange {
+			if to, err = time.ParseDuration(strings.TrimSpace(hi)); err != nil {
+				return fmt.Errorf("latency: %w", err)
+			}
+		}
+		if from < 0 || to < from {
+			return fmt.Errorf("latency range %q is empty", r.Latency)
+		}
+		r.minLatency, r.maxLatency = from, to
+	}
+
+	if r.When != "" {
+		q, err := query.Parse(r.When)
+		if err != nil {
+			return fmt.Errorf("when: %w", err)
+		}
+		r.when = q
+	}
+	return nil
+}
+
+// ------------
+
+// Injector decides which faults each request gets.
+type Injector struct {
+	rules []Rule
+	seed  int64
+
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+// NewInjector returns an injector for the rules of f. A non-zero seed
+// overrides the file's, and with neither the clock picks one.
+func NewInjector(f File, seed int64) *Injector {
+	if seed == 0 {
+		seed = f.Seed
+	}
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &Injector{rules: f.Faults, seed: seed, rng: rand.New(rand.NewSource(seed))}
+}
+
+// Seed returns the RNG seed, passing it back repeats the same choices for the
+// same requests in the same order.
+func (in *Injector) Seed() int64 {
+	return in.seed
+}
+
+// Pick decides the faults for a request. Only the request of rec is filled in
+// when the proxy asks, so rule filters on the response never match. It returns
+// nil when no fault applies.
+func (in *Injector) Pick(rec model.Record) *Plan {
+	// Every request draws from the shared RNG in arrival order, and gets its own
+	// RNG for choices made later, so concurrent responses don't change the sequence
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	var plan *Plan
+	for i := range in.rules {
+		r := &in.rules[i]
+		if r.when != nil && !r.when.Match(rec) {
+			continue
+		}
+		if r.Probability != nil && in.rng.Float64() >= *r.Probability {
+			continue
+		}
+		if plan == nil {
+			plan = &Plan{Truncate: -1, rng: rand.New(rand.NewSource(in.rng.Int63()))}
+		}
+		plan.add(r)
+	}
+	return plan
+}
+
+// ------------
+
+// Plan is the set of faults picked for one request.
+type Plan struct {
+	Latency  time.Duration
+	Status   int
+	Body     string
+	Drop     string
+	Truncate int // Response body bytes let through, -1 leaves the body whole
+	Corrupt  int // Response body bytes flipped
+
+	faults       []model.Fault
+	rng          *rand.Rand
+	truncateRule string
+	corruptRule  string
+}
+
+func (p *Plan) add(r *Rule) {
+	// Latencies add up, the first status or drop wins and the smallest truncation
+	// and the most corruption apply
+	if r.maxLatency > 0 {
+		d := r.minLatency
+		if r.maxLatency > r.minLatency {
+			d += time.Duration(p.rng.Int63n(int64(r.maxLatency - r.minLatency)))
+			d = d.Round(time.Millisecond)
+		}
+		p.Latency += d
+		p.note(r.Name, "latency", d.String())
+	}
+	if p.Status == 0 && p.Drop == "" {
+		switch {
+		case r.Status != 0:
+			p.Status, p.Body = r.Status, r.Body
+			if p.Body == "" {
+				p.Body = http.StatusText(r.Status)
+			}
+			p.note(r.Name, "status", strconv.Itoa(r.Status))
+		case r.Drop != "":
+			p.Drop = r.Drop
+			p.note(r.Name, "drop", r.Drop)
+		}
+	}
+	if r.Truncate != nil && (p.Truncate < 0 || *r.Truncate < p.Truncate) {
+		p.Truncate = *r.Truncate
+		p.truncateRule = r.Name
+	}
+	if r.Corrupt > p.Corrupt {
+		p.Corrupt = r.Corrupt
+		p.corruptRule = r.Name
+	}
+}
+
+func (p *Plan) note(rule, kind, detail string) {
+	p.faults = append(p.faults, model.Fault{Rule: rule, Kind: kind, Detail: detail})
+}
+
+// Faults returns what was injected so far, for the record.
+func (p *Plan) Faults() []model.Fault {
+	return p.faults
+}
+
+// TamperBody applies the plan's corruption and truncation to a response body
+// and returns the body the client gets. body isn't modified.
+func (p *Plan) TamperBody(body []byte) []byte {
+	out := body
+	if p.Corrupt > 0 && len(body) > 0 {
+		out = append([]byte(nil), body...)
+		picked := make(map[int]bool)
+		for len(picked) < min(p.Corrupt, len(out)) {
+			picked[p.rng.Intn(len(out))] = true
+		}
+		offsets := make([]int, 0, len(picked))
+		for off := range picked {
+			offsets = append(offsets, off)
+		}
+		slices.Sort(offsets)
+		parts := make([]string, len(offsets))
+		for i, off := range offsets {
+			// XOR with a non-zero byte so every picked byte really changes
+			out[off] ^= byte(1 + p.rng.Intn(255))
+			parts[i] = strconv.Itoa(off)
+		}
+		p.note(p.corruptRule, "corrupt", "bytes "+strings.Join(parts, ", "))
+	}
+	if p.Truncate >= 0 && p.Truncate < len(out) {
+		p.note(p.truncateRule, "truncate", fmt.Sprintf("kept %d of %d bytes", p.Truncate, len(out)))
+		out = out[:p.Truncate]
+	}
+	return out
+}
+
+// TampersBody reports whether TamperBody may change response bodies.
+func (p *Plan) TampersBody() bool {
+	return p.Corrupt > 0 || p.Truncate >= 0
+}
@@ -0,0 +1,123 @@
+package fault_test
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/BarrettBr/RWND/internal/fault"
+	"github.com/BarrettBr/RWND/internal/model"
+)
+
+func request(method, url string) model.Record {
+	var rec model.Record
+	rec.Request.Method = method
+	rec.Request.URL = url
+	return rec
+}
+
+func TestParse_Errors(t *testing.T) {
+	cases := map[string]string{
+		"probability":     "faults:\n  - status: 503\n    probability: 1.5\n",
+		"status":          "faults:\n  - status: 42\n",
+		"drop":            "faults:\n  - drop: sideways\n",
+		"status and drop": "faults:\n  - status: 503\n    drop: request\n",
+		"nothing":         "faults:\n  - when: method = GET\n",
+		"latency":         "faults:\n  - latency: 2s-1s\n",
+		"when":            "faults:\n  - status: 503\n    when: \"method =\"\n",
+	}
+	for name, doc := range cases {
+		if _, err := fault.Parse([]byte(doc)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestInjector_SameSeedSamePlans(t *testing.T) {
+	file, err := fault.Parse([]byte(`
+faults:
+  - name: slow
+    latency: 10ms-1s
+    probability: 0.5
+  - name: flaky
+    when: path ~ "^/orders"
+    status: 503
+    probability: 0.3
+`))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	run := func(seed int64) []string {
+		in := fault.NewInjector(file, seed)
+		var out []string
+		for i := 0; i < 50; i++ {
+			p := in.Pick(request("GET", "http://api/orders/1"))
+			if p == nil {
+				out = append(out, "-")
+				continue
+			}
+			for _, f := range p.Faults() {
+				out = append(out, f.Rule+" "+f.Kind+" "+f.Detail)
+			}
+		}
+		return out
+	}
+
+	first, again := run(7), run(7)
+	if !reflect.DeepEqual(first, again) {
+		t.Fatalf("Same seed picked different faults:\n%v\n%v", first, again)
+	}
+	if reflect.DeepEqual(first, run(8)) {
+		t.Fatalf("Different seeds picked the same faults")
+	}
+}
+
+func TestInjector_When(t *testing.T) {
+	file, err := fault.Parse([]byte("faults:\n  - when: method = POST\n    status: 503\n"))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	in := fault.NewInjector(file, 1)
+	if p := in.Pick(request("GET", "http://api/x")); p != nil {
+		t.Fatalf("GET should not match, got %+v", p)
+	}
+	p := in.Pick(request("POST", "http://api/x"))
+	if p == nil || p.Status != 503 || p.Body != "Service Unavailable" {
+		t.Fatalf("Unexpected plan %+v", p)
+	}
+	want := []model.Fault{{Rule: "#1", Kind: "status", Detail: "503"}}
+	if !reflect.DeepEqual(p.Faults(), want) {
+		t.Fatalf("Faults = %+v, want %+v", p.Faults(), want)
+	}
+}
+
+func TestPlan_TamperBody(t *testing.T) {
+	file, err := fault.Parse([]byte("faults:\n  - name: cut\n    truncate: 4\n  - name: flip\n    corrupt: 2\n"))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	body := []byte("0123456789")
+	p := fault.NewInjector(file, 3).Pick(request("GET", "http://api/x"))
+	if p == nil || !p.TampersBody() {
+		t.Fatalf("Expected a plan that tampers with the body")
+	}
+
+	out := p.TamperBody(body)
+	if string(body) != "0123456789" {
+		t.Fatalf("TamperBody modified its input: %q", body)
+	}
+	if len(out) != 4 {
+		t.Fatalf("Expected 4 bytes kept, got %d", len(out))
+	}
+	faults := p.Faults()
+	if len(faults) != 2 || faults[0].Rule != "flip" || faults[0].Kind != "corrupt" ||
+		faults[1] != (model.Fault{Rule: "cut", Kind: "truncate", Detail: "kept 4 of 10 bytes"}) {
+		t.Fatalf("Unexpected faults %+v", faults)
+	}
+
+	again := fault.NewInjector(file, 3).Pick(request("GET", "http://api/x")).TamperBody(body)
+	if !bytes.Equal(out, again) {
+		t.Fatalf("Same seed corrupted differently: %q vs %q", out, again)
+	}
+}
@@ -0,0 +1,159 @@
+package logpath
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// logNameRe splits names produced by buildLogFilename into their parts.
+var logNameRe = regexp.MustCompile(`^(\d{3,})_(\d{8}T\d{6}Z)_listen-([a-z0-9-]*?)(?:_target-([a-z0-9-]+))?(\..+)$`)
+
+// LogFile describes a numbered log file and the metadata encoded in its name.
+type LogFile struct {
+	Path   string
+	Name   string
+	Seq    int
+	Start  time.Time // Zero if the name has no timestamp
+	Listen string    // Sanitized listen address, "8080" for ":8080"
+	Target string    // Sanitized target host, "localhost-3000" for "localhost:3000"
+	Size   int64     // Total size of every part
+	Parts  []string  // Rotation parts in order, Parts[0] == Path
+	Blobs  string    // Deduplicated body directory, empty if the session has none
+	Index  string    // Search index directory, empty if the session has none
+	Edits  string    // Edited records directory, empty if the session has none
+}
+
+// ParseLogFilename extracts the metadata buildLogFilename encodes in a name.
+// Names that only carry the numbered prefix still parse with the other fields empty.
+func ParseLogFilename(name string) (LogFile, bool) {
+	m := logPrefixRe.FindStringSubmatch(name)
+	if len(m) != 2 {
+		return LogFile{}, false
+	}
+	seq, err := strconv.Atoi(m[1])
+	if err != nil {
+		return LogFile{}, false
+	}
+
+	lf := LogFile{Name: name, Seq: seq}
+	stem, _, ext := splitName(name)
+	if parts := logNameRe.FindStringSubmatch(stem + ext); parts != nil {
+		if t, err := time.Parse("20060102T150405Z", parts[2]); err == nil {
+			lf.Start = t
+		}
+		lf.Listen = parts[3]
+		lf.Target = parts[4]
+	}
+	return lf, true
+}
+
+// ListLogFiles returns the numbered log sessions in dir ordered by sequence number.
+// Rotated parts are folded into the session they belong to.
+func ListLogFiles(dir string) ([]LogFile, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	// ReadDir is sorted by name and part 1 sorts before its _part-NNN siblings
+	var out []LogFile
+	sessions := make(map[string]int)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		lf, ok := ParseLogFilename(entry.Name())
+		if !ok {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		var size int64
+		info, infoErr := entry.Info()
+		if infoErr == nil {
+			size = info.Size()
+		}
+
+		stem, _, ext := splitName(entry.Name())
+		if i, ok := sessions[stem+ext]; ok {
+			out[i].Parts = append(out[i].Parts, path)
+			out[i].Size += size
+			continue
+		}
+
+		lf.Path = path
+		lf.Size = size
+		lf.Parts = []string{path}
+		if lf.Start.IsZero() && infoErr == nil {
+			lf.Start = info.ModTime().UTC()
+		}
+		sessions[stem+ext] = len(out)
+		out = append(out, lf)
+	}
+
+	for i := range out {
+		sort.SliceStable(out[i].Parts, func(a, b int) bool {
+			return PartNumber(out[i].Parts[a]) < PartNumber(out[i].Parts[b])
+		})
+		out[i].Path = out[i].Parts[0]
+		out[i].Name = filepath.Base(out[i].Path)
+
+		if blobs := BlobDir(out[i].Path); dirExists(blobs) {
+			out[i].Blobs = blobs
+			out[i].Size += dirSize(blobs)
+		}
+		if index := IndexDir(out[i].Path); dirExists(index) {
+			out[i].Index = index
+			out[i].Size += dirSize(index)
+		}
+		if edits := EditsDir(out[i].Path); dirExists(edits) {
+			out[i].Edits = edits
+			out[i].Size += dirSize(edits)
+		}
+	}
+
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].Seq != out[j].Seq {
+			return out[i].Seq < out[j].Seq
+		}
+		return out[i].Name < out[j].Name
+	})
+	return out, nil
+}
+
+func dirExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+func dirSize(path string) int64 {
+	var total int64
+	_ = filepath.WalkDir(path, func(_ string, d os.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info, err := d.Info(); err == nil && !d.IsDir() {
+			total += info.Size()
+		}
+		return nil
+	})
+	return total
+}
+
+// FindLogFile returns the log file in dir with the given sequence number.
+func FindLogFile(dir string, seq int) (LogFile, error) {
+	files, err := ListLogFiles(dir)
+	if err != nil {
+		return LogFile{}, err
+	}
+	for _, lf := range files {
+		if lf.Seq == seq {
+			return lf, nil
+		}
+	}
+	return LogFile{}, fmt.Errorf("No log file numbered %03d in %s", seq, dir)
+}
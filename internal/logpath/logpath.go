@@ -14,20 +14,29 @@ import (
 
 var logPrefixRe = regexp.MustCompile(`^(\d{3})_`)
 
+// DefaultExt is the extension of uncompressed JSONL logs.
+const DefaultExt = ".jsonl"
+
 // ResolveRecordPath returns a log file path for recording.
 func ResolveRecordPath(path string, listenAddr string, target *url.URL) (string, error) {
+	return ResolveRecordPathWithExt(path, listenAddr, target, DefaultExt)
+}
+
+// ResolveRecordPathWithExt is ResolveRecordPath with the extension used for new
+// numbered files, e.g. ".jsonl.gz" for compressed logs.
+func ResolveRecordPathWithExt(path string, listenAddr string, target *url.URL, ext string) (string, error) {
 	// Returns a log file path. If path is a directory or has no extension,
 	// it creates a new numbered log file name under that directory.
 	// Used for rwnd proxy log file creation
 	if isDirPath(path) {
-		if err := os.MkdirAll(path, 0755); err != nil {
+		if err := os.MkdirAll(path, 0700); err != nil {
 			return "", err
 		}
 		next, err := nextLogNumber(path)
 		if err != nil {
 			return "", err
 		}
-		name := buildLogFilename(next, listenAddr, target)
+		name := buildLogFilename(next, listenAddr, target, ext)
 		return filepath.Join(path, name), nil
 	}
 
@@ -50,7 +59,7 @@ func ResolveReplayPath(path string) (string, error) {
 	return path, nil
 }
 
-func buildLogFilename(seq int, listenAddr string, target *url.URL) string {
+func buildLogFilename(seq int, listenAddr string, target *url.URL, ext string) string {
 	// Assembles the log filename with sequence, time, and metadata.
 	stamp := time.Now().UTC().Format("20060102T150405Z")
 	listen := sanitizeFilenamePart(listenAddr)
@@ -62,9 +71,9 @@ func buildLogFilename(seq int, listenAddr string, target *url.URL) string {
 		}
 	}
 	if targetStr != "" {
-		return fmt.Sprintf("%03d_%s_listen-%s_target-%s.jsonl", seq, stamp, listen, targetStr)
+		return fmt.Sprintf("%03d_%s_listen-%s_target-%s%s", seq, stamp, listen, targetStr, ext)
 	}
-	return fmt.Sprintf("%03d_%s_listen-%s.jsonl", seq, stamp, listen)
+	return fmt.Sprintf("%03d_%s_listen-%s%s", seq, stamp, listen, ext)
 }
 
 func isDirPath(path string) bool {
@@ -127,7 +136,8 @@ func latestLogFile(dir string) (string, error) {
 		if err != nil {
 			continue
 		}
-		if n > max {
+		// Rotated parts share their session's number, replay starts from part 1
+		if n > max || (n == max && PartNumber(entry.Name()) < PartNumber(name)) {
 			max = n
 			name = entry.Name()
 		}
@@ -100,3 +100,221 @@ func TestResolveReplayPath_NoLogsReturnsError(t *testing.T) {
 		t.Fatalf("expected error for empty log directory")
 	}
 }
+
+func TestParseLogFilename_ExtractsMetadata(t *testing.T) {
+	lf, ok := logpath.ParseLogFilename("003_20250102T030405Z_listen-8080_target-localhost-3000.jsonl")
+	if !ok {
+		t.Fatalf("expected name to parse")
+	}
+	if lf.Seq != 3 || lf.Listen != "8080" || lf.Target != "localhost-3000" {
+		t.Fatalf("unexpected metadata: %+v", lf)
+	}
+	if want := "2025-01-02T03:04:05Z"; lf.Start.Format("2006-01-02T15:04:05Z") != want {
+		t.Fatalf("expected start %s, got %s", want, lf.Start)
+	}
+
+	lf, ok = logpath.ParseLogFilename("004_20250102T030405Z_listen-8080.jsonl")
+	if !ok || lf.Target != "" || lf.Listen != "8080" {
+		t.Fatalf("unexpected metadata without target: %+v ok=%v", lf, ok)
+	}
+
+	if _, ok := logpath.ParseLogFilename("notes.jsonl"); ok {
+		t.Fatalf("expected unnumbered name to be rejected")
+	}
+}
+
+func TestListLogFiles_SortedBySequence(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"010_b.jsonl", "002_a.jsonl", "readme.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("xy"), 0644); err != nil {
+			t.Fatalf("write file: %v", err)
+		}
+	}
+
+	files, err := logpath.ListLogFiles(dir)
+	if err != nil {
+		t.Fatalf("ListLogFiles: %v", err)
+	}
+	if len(files) != 2 || files[0].Seq != 2 || files[1].Seq != 10 {
+		t.Fatalf("unexpected files: %+v", files)
+	}
+	if files[0].Size != 2 || files[0].Start.IsZero() {
+		t.Fatalf("expected size and fallback start time, got %+v", files[0])
+	}
+
+	if _, err := logpath.FindLogFile(dir, 10); err != nil {
+		t.Fatalf("FindLogFile: %v", err)
+	}
+	if _, err := logpath.FindLogFile(dir, 7); err == nil {
+		t.Fatalf("expected error for missing log number")
+	}
+}
+
+func TestPartPath_AndSessionFiles(t *testing.T) {
+	dir := t.TempDir()
+	base := filepath.Join(dir, "004_20250101T000000Z_listen-8080.jsonl")
+
+	if got := logpath.PartPath(base, 1); got != base {
+		t.Fatalf("expected part 1 to be the base path, got %s", got)
+	}
+	part2 := logpath.PartPath(base, 2)
+	if filepath.Base(part2) != "004_20250101T000000Z_listen-8080_part-002.jsonl" {
+		t.Fatalf("unexpected part 2 path: %s", part2)
+	}
+	if logpath.PartNumber(part2) != 2 || logpath.PartNumber(base) != 1 {
+		t.Fatalf("unexpected part numbers")
+	}
+
+	for _, p := range []string{logpath.PartPath(base, 10), part2, base, filepath.Join(dir, "005_other.jsonl")} {
+		if err := os.WriteFile(p, []byte("x"), 0644); err != nil {
+			t.Fatalf("write file: %v", err)
+		}
+	}
+
+	parts, err := logpath.SessionFiles(part2)
+	if err != nil {
+		t.Fatalf("SessionFiles: %v", err)
+	}
+	if len(parts) != 3 || parts[0] != base || parts[1] != part2 || logpath.PartNumber(parts[2]) != 10 {
+		t.Fatalf("unexpected session files: %v", parts)
+	}
+
+	files, err := logpath.ListLogFiles(dir)
+	if err != nil {
+		t.Fatalf("ListLogFiles: %v", err)
+	}
+	if len(files) != 2 || len(files[0].Parts) != 3 || files[0].Size != 3 || files[0].Path != base {
+		t.Fatalf("expected parts folded into one session, got %+v", files)
+	}
+
+	latest, err := logpath.ResolveReplayPath(dir)
+	if err != nil || latest != filepath.Join(dir, "005_other.jsonl") {
+		t.Fatalf("unexpected latest: %s %v", latest, err)
+	}
+}
+
+func TestCompressedExtensions(t *testing.T) {
+	dir := t.TempDir()
+
+	got, err := logpath.ResolveRecordPathWithExt(dir, ":8080", nil, ".jsonl.gz")
+	if err != nil {
+		t.Fatalf("ResolveRecordPathWithExt: %v", err)
+	}
+	pattern := regexp.MustCompile(`[/\\]001_\d{8}T\d{6}Z_listen-8080\.jsonl\.gz$`)
+	if !pattern.MatchString(got) {
+		t.Fatalf("unexpected path: %s", got)
+	}
+	if err := os.WriteFile(got, []byte("x"), 0644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	if err := os.WriteFile(logpath.PartPath(got, 2), []byte("x"), 0644); err != nil {
+		t.Fatalf("write part: %v", err)
+	}
+	if !regexp.MustCompile(`_part-002\.jsonl\.gz$`).MatchString(logpath.PartPath(got, 2)) {
+		t.Fatalf("unexpected part path: %s", logpath.PartPath(got, 2))
+	}
+
+	next, err := logpath.ResolveRecordPathWithExt(dir, ":8080", nil, ".jsonl.zst")
+	if err != nil || !regexp.MustCompile(`[/\\]002_.*\.jsonl\.zst$`).MatchString(next) {
+		t.Fatalf("expected numbering to continue past compressed logs, got %s %v", next, err)
+	}
+
+	latest, err := logpath.ResolveReplayPath(dir)
+	if err != nil || latest != got {
+		t.Fatalf("expected latest compressed log %s, got %s %v", got, latest, err)
+	}
+
+	lf, ok := logpath.ParseLogFilename(filepath.Base(got))
+	if !ok || lf.Listen != "8080" || lf.Start.IsZero() {
+		t.Fatalf("expected compressed name to parse, got %+v", lf)
+	}
+}
+
+func TestBlobDir_FoldedIntoSession(t *testing.T) {
+	dir := t.TempDir()
+	base := filepath.Join(dir, "003_20250101T000000Z_listen-8080.jsonl.gz")
+
+	blobs := logpath.BlobDir(logpath.PartPath(base, 2))
+	if blobs != filepath.Join(dir, "003_20250101T000000Z_listen-8080.blobs") {
+		t.Fatalf("unexpected blob dir: %s", blobs)
+	}
+
+	if err := os.WriteFile(base, []byte("x"), 0644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(blobs, "ab"), 0755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(blobs, "ab", "abcd"), []byte("body"), 0644); err != nil {
+		t.Fatalf("write blob: %v", err)
+	}
+
+	files, err := logpath.ListLogFiles(dir)
+	if err != nil {
+		t.Fatalf("ListLogFiles: %v", err)
+	}
+	if len(files) != 1 || files[0].Blobs != blobs || files[0].Size != 5 {
+		t.Fatalf("expected blob dir counted with its session, got %+v", files)
+	}
+
+	latest, err := logpath.ResolveReplayPath(dir)
+	if err != nil || latest != base {
+		t.Fatalf("expected blob dir to be skipped, got %s %v", latest, err)
+	}
+}
+
+func TestIndexDir_FoldedIntoSession(t *testing.T) {
+	dir := t.TempDir()
+	base := filepath.Join(dir, "004_20250101T000000Z_listen-8080.rwb")
+
+	index := logpath.IndexDir(logpath.PartPath(base, 3))
+	if index != filepath.Join(dir, "004_20250101T000000Z_listen-8080.index") {
+		t.Fatalf("unexpected index dir: %s", index)
+	}
+
+	if err := os.WriteFile(base, []byte("x"), 0644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	if err := os.MkdirAll(index, 0755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(index, "trigrams"), []byte("idx"), 0644); err != nil {
+		t.Fatalf("write index: %v", err)
+	}
+
+	files, err := logpath.ListLogFiles(dir)
+	if err != nil {
+		t.Fatalf("ListLogFiles: %v", err)
+	}
+	if len(files) != 1 || files[0].Index != index || files[0].Size != 4 {
+		t.Fatalf("expected index dir counted with its session, got %+v", files)
+	}
+}
+
+func TestEditsDir_FoldedIntoSession(t *testing.T) {
+	dir := t.TempDir()
+	base := filepath.Join(dir, "005_20250101T000000Z_listen-8080.jsonl")
+
+	edits := logpath.EditsDir(logpath.PartPath(base, 2))
+	if edits != filepath.Join(dir, "005_20250101T000000Z_listen-8080.edits") {
+		t.Fatalf("unexpected edits dir: %s", edits)
+	}
+
+	if err := os.WriteFile(base, []byte("x"), 0644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	if err := os.MkdirAll(edits, 0755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(edits, "edits.jsonl"), []byte("{}\n"), 0644); err != nil {
+		t.Fatalf("write edits: %v", err)
+	}
+
+	files, err := logpath.ListLogFiles(dir)
+	if err != nil {
+		t.Fatalf("ListLogFiles: %v", err)
+	}
+	if len(files) != 1 || files[0].Edits != edits || files[0].Size != 4 {
+		t.Fatalf("expected edits dir counted with its session, got %+v", files)
+	}
+}
@@ -0,0 +1,110 @@
+package logpath
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// partRe matches the suffix rotated files carry between the stem and the extension.
+var partRe = regexp.MustCompile(`_part-(\d{3,})$`)
+
+// splitName breaks a file name into stem, rotation part and extension.
+// "003_x_part-002.jsonl" -> ("003_x", 2, ".jsonl"). Unrotated names are part 1.
+func splitName(name string) (string, int, string) {
+	stem, ext := name, ""
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		stem, ext = name[:i], name[i:]
+	}
+	part := 1
+	if m := partRe.FindStringSubmatch(stem); m != nil {
+		if n, err := strconv.Atoi(m[1]); err == nil {
+			part = n
+			stem = strings.TrimSuffix(stem, m[0])
+		}
+	}
+	return stem, part, ext
+}
+
+// PartPath returns the path of rotation part n of the session that starts at path.
+// Part 1 is the path itself, later parts keep the name and add a _part-NNN suffix.
+func PartPath(path string, part int) string {
+	dir, name := filepath.Split(path)
+	stem, _, ext := splitName(name)
+	if part <= 1 {
+		return filepath.Join(dir, stem+ext)
+	}
+	return filepath.Join(dir, fmt.Sprintf("%s_part-%03d%s", stem, part, ext))
+}
+
+// PartNumber returns the rotation part number encoded in path.
+func PartNumber(path string) int {
+	_, part, _ := splitName(filepath.Base(path))
+	return part
+}
+
+// BlobDir returns the sidecar directory holding deduplicated bodies for the session path belongs to.
+// "003_x_part-002.jsonl.gz" -> "003_x.blobs".
+func BlobDir(path string) string {
+	dir, name := filepath.Split(path)
+	stem, _, _ := splitName(name)
+	return filepath.Join(dir, stem+".blobs")
+}
+
+// IndexDir returns the sidecar directory holding the search index for the session path belongs to.
+// "003_x_part-002.jsonl.gz" -> "003_x.index".
+func IndexDir(path string) string {
+	dir, name := filepath.Split(path)
+	stem, _, _ := splitName(name)
+	return filepath.Join(dir, stem+".index")
+}
+
+// EditsDir returns the sidecar directory holding edited variants of the session's records.
+// "003_x_part-002.jsonl.gz" -> "003_x.edits".
+func EditsDir(path string) string {
+	dir, name := filepath.Split(path)
+	stem, _, _ := splitName(name)
+	return filepath.Join(dir, stem+".edits")
+}
+
+// SessionFiles returns every existing rotation part of the session path belongs to,
+// in part order. Any part can be passed in.
+func SessionFiles(path string) ([]string, error) {
+	dir, name := filepath.Split(path)
+	stem, _, ext := splitName(name)
+	if dir == "" {
+		dir = "."
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	type part struct {
+		n    int
+		path string
+	}
+	var parts []part
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		s, n, e := splitName(entry.Name())
+		if s != stem || e != ext {
+			continue
+		}
+		parts = append(parts, part{n: n, path: filepath.Join(filepath.Dir(path), entry.Name())})
+	}
+
+	sort.Slice(parts, func(i, j int) bool { return parts[i].n < parts[j].n })
+	out := make([]string, 0, len(parts))
+	for _, p := range parts {
+		out = append(out, p.path)
+	}
+	return out, nil
+}
@@ -0,0 +1,36 @@
+package model
+
+import "time"
+
+// SchemaVersion is the record layout this build writes.
+//
+//	1: Logs written before session headers existed
+//	2: Session header line, Latency, Tags, Truncated and BodyRef fields
+const SchemaVersion = 2
+
+// SessionHeader describes who wrote a log part and how. It is stored ahead of
+// the records of every part.
+type SessionHeader struct {
+	Schema  int
+	Version string // RWND version that wrote the log
+	Started time.Time
+	Part    int
+	Target  string `json:",omitempty"`
+	Listen  string `json:",omitempty"`
+	Capture CaptureOptions
+}
+
+// CaptureOptions are the proxy settings that shaped the recorded records.
+type CaptureOptions struct {
+	Format        string   `json:",omitempty"`
+	Compress      string   `json:",omitempty"`
+	Encrypt       bool     `json:",omitempty"`
+	RedactHeaders []string `json:",omitempty"`
+	MaxBodyBytes  int64    `json:",omitempty"`
+	DedupBodies   bool     `json:",omitempty"`
+	Rules         string   `json:",omitempty"`
+	Sync          string   `json:",omitempty"`
+	Shadow        string   `json:",omitempty"` // Target requests were mirrored to
+	Faults        string   `json:",omitempty"` // Fault rules file
+	FaultSeed     int64    `json:",omitempty"` // Seed of the fault RNG, reuse it to repeat the run
+}
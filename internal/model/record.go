@@ -10,17 +10,65 @@ import (
 type Record struct {
 	ID        uint64
 	Timestamp time.Time
+	Latency   time.Duration // Time from receiving the request to receiving the upstream response
+	Tags      []string      // Labels attached by invariant rules
+	ParentID  uint64        `json:",omitempty"` // Record this one was edited from, 0 for captured traffic
 
-	Request struct {
-		Method  string
-		URL     string
-		Headers http.Header
-		Body    []byte
+	Request  Request
+	Response Response
+	Shadow   *Shadow `json:",omitempty"` // Mirrored copy of the request, when the proxy had a shadow target
+	Faults   []Fault `json:",omitempty"` // Failures the proxy injected, see package fault
+}
+
+// Request is the captured client request.
+type Request struct {
+	Method    string
+	URL       string
+	Headers   http.Header
+	Body      []byte
+	BodyRef   string `json:",omitempty"` // Content hash of a deduplicated body, Body is empty on disk when set
+	Truncated bool   // Body was cut at the capture limit
+}
+
+// Response is the captured upstream response.
+type Response struct {
+	Status    int
+	Headers   http.Header
+	Body      []byte
+	BodyRef   string `json:",omitempty"` // Content hash of a deduplicated body, Body is empty on disk when set
+	Truncated bool   // Body was cut at the capture limit
+}
+
+// Shadow is what a shadow target answered to a mirrored copy of the request.
+type Shadow struct {
+	Target      string
+	Latency     time.Duration
+	Response    Response
+	Error       string   `json:",omitempty"` // Why there is no shadow response: the request failed or wasn't mirrored
+	Differences []string `json:",omitempty"` // Where Response differs from the record's, "status: \"200\" -> \"500\""
+}
+
+// Fault is a failure the proxy injected into an exchange on purpose.
+type Fault struct {
+	Rule   string // Name of the fault rule
+	Kind   string // "latency", "status", "drop", "truncate" or "corrupt"
+	Detail string `json:",omitempty"` // "250ms", "503", "response", "kept 10 of 512 bytes", "bytes 3, 17"
+}
+
+// HasTag reports whether the record carries the given tag.
+func (r Record) HasTag(tag string) bool {
+	for _, t := range r.Tags {
+		if t == tag {
+			return true
+		}
 	}
+	return false
+}
 
-	Response struct {
-		Status  int
-		Headers http.Header
-		Body    []byte
+// AddTag attaches a tag to the record if it isn't already present.
+func (r *Record) AddTag(tag string) {
+	if tag == "" || r.HasTag(tag) {
+		return
 	}
+	r.Tags = append(r.Tags, tag)
 }
@@ -0,0 +1,254 @@
+// Package mutate rewrites recorded requests before they are replayed, for
+// what-if comparisons against the recorded responses.
+package mutate
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/BarrettBr/RWND/internal/model"
+	"github.com/BarrettBr/RWND/internal/query"
+)
+
+// File is the on-disk mutations file layout.
+type File struct {
+	Mutations []Mutation `yaml:"mutations"`
+}
+
+// Mutation is a set of changes applied to a request. Every set field applies, in
+// the order the fields are listed here.
+type Mutation struct {
+	Name          string            `yaml:"name"`
+	When          string            `yaml:"when"` // Filter expression the record must match, empty matches all
+	Method        string            `yaml:"method"`
+	Path          string            `yaml:"path"`
+	SetHeaders    map[string]string `yaml:"set_headers"`
+	RemoveHeaders []string          `yaml:"remove_headers"`
+	SetQuery      map[string]string `yaml:"set_query"`
+	RemoveQuery   []string          `yaml:"remove_query"`
+	JSONPatch     []PatchOp         `yaml:"json_patch"` // RFC 6902 operations on the JSON body
+
+	when *query.Filter
+}
+
+// Load reads a mutations file from disk.
+func Load(path string) ([]Mutation, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// Parse decodes a YAML mutations document and compiles its when filters.
+func Parse(data []byte) ([]Mutation, error) {
+	var f File
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("Mutations parse error: %w", err)
+	}
+	for i := range f.Mutations {
+		m := &f.Mutations[i]
+		if err := m.compile(); err != nil {
+			return nil, fmt.Errorf("Mutation %s: %w", m.label(i), err)
+		}
+	}
+	return f.Mutations, nil
+}
+
+func (m *Mutation) compile() error {
+	if m.When != "" {
+		q, err := query.Parse(m.When)
+		if err != nil {
+			return fmt.Errorf("when: %w", err)
+		}
+		m.when = q
+	}
+	if m.Path != "" && !strings.HasPrefix(m.Path, "/") {
+		return fmt.Errorf("path must start with /, got %q", m.Path)
+	}
+	for _, op := range m.JSONPatch {
+		if err := op.check(); err != nil {
+			return fmt.Errorf("json_patch: %w", err)
+		}
+	}
+	return nil
+}
+
+func (m *Mutation) label(i int) string {
+	if m.Name != "" {
+		return m.Name
+	}
+	return fmt.Sprintf("#%d", i+1)
+}
+
+// Matches reports whether the mutation applies to rec.
+func (m *Mutation) Matches(rec model.Record) bool {
+	return m.when == nil || m.when.Match(rec)
+}
+
+// ------------
+
+// Apply returns a copy of rec with every matching mutation applied to its
+// request, and a line describing each change made.
+func Apply(rec model.Record, mutations []Mutation) (model.Record, []string, error) {
+	out := rec
+	out.Request.Headers = rec.Request.Headers.Clone()
+	if out.Request.Headers == nil {
+		out.Request.Headers = make(http.Header)
+	}
+
+	var changes []string
+	for i := range mutations {
+		m := &mutations[i]
+		if !m.Matches(rec) {
+			continue
+		}
+		c, err := m.apply(&out.Request)
+		if err != nil {
+			return model.Record{}, nil, fmt.Errorf("Mutation %s: %w", m.label(i), err)
+		}
+		changes = append(changes, c...)
+	}
+	return out, changes, nil
+}
+
+func (m *Mutation) apply(req *model.Request) ([]string, error) {
+	var changes []string
+	if m.Method != "" {
+		req.Method = strings.ToUpper(m.Method)
+		changes = append(changes, "method "+req.Method)
+	}
+
+	if m.Path != "" || len(m.SetQuery) > 0 || len(m.RemoveQuery) > 0 {
+		u, err := url.Parse(req.URL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid request URL: %w", err)
+		}
+		if m.Path != "" {
+			u.Path, u.RawPath = m.Path, ""
+			changes = append(changes, "path "+m.Path)
+		}
+		q := u.Query()
+		for _, name := range sortedKeys(m.SetQuery) {
+			q.Set(name, m.SetQuery[name])
+			changes = append(changes, fmt.Sprintf("query %s=%s", name, m.SetQuery[name]))
+		}
+		for _, name := range m.RemoveQuery {
+			q.Del(name)
+			changes = append(changes, "query "+name+" removed")
+		}
+		if len(m.SetQuery) > 0 || len(m.RemoveQuery) > 0 {
+			u.RawQuery = q.Encode()
+		}
+		req.URL = u.String()
+	}
+
+	for _, name := range sortedKeys(m.SetHeaders) {
+		req.Headers.Set(name, m.SetHeaders[name])
+		changes = append(changes, fmt.Sprintf("header %s: %s", http.CanonicalHeaderKey(name), m.SetHeaders[name]))
+	}
+	for _, name := range m.RemoveHeaders {
+		req.Headers.Del(name)
+		changes = append(changes, "header "+http.CanonicalHeaderKey(name)+" removed")
+	}
+
+	if len(m.JSONPatch) > 0 {
+		body, err := applyPatch(req.Body, m.JSONPatch)
+		if err != nil {
+			return nil, err
+		}
+		req.Body = body
+		for _, op := range m.JSONPatch {
+			changes = append(changes, op.String())
+		}
+	}
+	return changes, nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ------------
+
+// ParseLine parses one interactive mutation:
+//
+//	method PUT
+//	path /v2/orders
+//	header Authorization: Bearer other
+//	-header Cookie
+//	query limit=500
+//	-query debug
+//	json /items/0/qty = 0      replace a value, the value is JSON or else a string
+//	json+ /items/- = {"sku":"B"}  add a value
+//	-json /coupon              remove a value
+func ParseLine(line string) (Mutation, error) {
+	verb, rest, _ := strings.Cut(strings.TrimSpace(line), " ")
+	rest = strings.TrimSpace(rest)
+	if rest == "" {
+		return Mutation{}, fmt.Errorf("Expected a value after %q", verb)
+	}
+
+	var m Mutation
+	switch strings.ToLower(verb) {
+	case "method":
+		m.Method = rest
+	case "path":
+		m.Path = rest
+	case "header":
+		name, value, ok := strings.Cut(rest, ":")
+		if !ok {
+			return Mutation{}, fmt.Errorf("Expected header Name: value")
+		}
+		m.SetHeaders = map[string]string{strings.TrimSpace(name): strings.TrimSpace(value)}
+	case "-header":
+		m.RemoveHeaders = []string{rest}
+	case "query":
+		name, value, ok := strings.Cut(rest, "=")
+		if !ok {
+			return Mutation{}, fmt.Errorf("Expected query name=value")
+		}
+		m.SetQuery = map[string]string{strings.TrimSpace(name): strings.TrimSpace(value)}
+	case "-query":
+		m.RemoveQuery = []string{rest}
+	case "json", "json+":
+		path, raw, ok := strings.Cut(rest, "=")
+		if !ok {
+			return Mutation{}, fmt.Errorf("Expected %s /pointer = value", verb)
+		}
+		op := PatchOp{Op: "replace", Path: strings.TrimSpace(path), Value: lineValue(strings.TrimSpace(raw))}
+		if verb == "json+" {
+			op.Op = "add"
+		}
+		m.JSONPatch = []PatchOp{op}
+	case "-json":
+		m.JSONPatch = []PatchOp{{Op: "remove", Path: rest}}
+	default:
+		return Mutation{}, fmt.Errorf("Unknown mutation %q (use method, path, header, -header, query, -query, json, json+ or -json)", verb)
+	}
+	if err := m.compile(); err != nil {
+		return Mutation{}, err
+	}
+	return m, nil
+}
+
+func lineValue(raw string) any {
+	if json.Valid([]byte(raw)) {
+		if v, err := decodeJSON([]byte(raw)); err == nil {
+			return v
+		}
+	}
+	return raw
+}
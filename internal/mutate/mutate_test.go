@@ -0,0 +1,123 @@
+package mutate_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/BarrettBr/RWND/internal/model"
+	"github.com/BarrettBr/RWND/internal/mutate"
+)
+
+func sampleRecord() model.Record {
+	var rec model.Record
+	rec.Request.Method = "POST"
+	rec.Request.URL = "http://localhost:3000/orders?debug=1&page=2"
+	rec.Request.Headers = http.Header{"Cookie": {"s=1"}, "Content-Type": {"application/json"}}
+	rec.Request.Body = []byte(`{"id":12345678901234567,"items":[{"sku":"a","qty":2}],"coupon":"X"}`)
+	rec.Response.Status = 201
+	return rec
+}
+
+func TestApply(t *testing.T) {
+	muts, err := mutate.Parse([]byte(`
+mutations:
+  - name: v2 without cookies
+    when: method = POST
+    path: /v2/orders
+    set_headers: {Authorization: Bearer other}
+    remove_headers: [cookie]
+    set_query: {page: "3"}
+    remove_query: [debug]
+    json_patch:
+      - {op: replace, path: /items/0/qty, value: 0}
+      - {op: add, path: /items/-, value: {sku: b, qty: 1}}
+      - {op: remove, path: /coupon}
+      - {op: copy, from: /items/0/sku, path: /first}
+      - {op: test, path: /first, value: a}
+  - name: never
+    when: method = GET
+    method: DELETE
+`))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	rec := sampleRecord()
+	out, changes, err := mutate.Apply(rec, muts)
+	if err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	if out.Request.Method != "POST" || out.Request.URL != "http://localhost:3000/v2/orders?page=3" {
+		t.Errorf("Unexpected request line: %s %s", out.Request.Method, out.Request.URL)
+	}
+	if out.Request.Headers.Get("Authorization") != "Bearer other" || out.Request.Headers.Get("Cookie") != "" {
+		t.Errorf("Unexpected headers: %v", out.Request.Headers)
+	}
+	want := `{"first":"a","id":12345678901234567,"items":[{"qty":0,"sku":"a"},{"qty":1,"sku":"b"}]}`
+	if string(out.Request.Body) != want {
+		t.Errorf("Unexpected body:\n got %s\nwant %s", out.Request.Body, want)
+	}
+	if len(changes) != 10 {
+		t.Errorf("Expected 10 changes, got %d: %v", len(changes), changes)
+	}
+	if rec.Request.Headers.Get("Cookie") != "s=1" {
+		t.Errorf("Apply changed the original record")
+	}
+}
+
+func TestApply_PatchErrors(t *testing.T) {
+	for _, doc := range []string{
+		`{op: replace, path: /missing, value: 1}`,
+		`{op: remove, path: /items/5}`,
+		`{op: test, path: /coupon, value: "Y"}`,
+		`{op: move, from: /nope, path: /x}`,
+	} {
+		muts, err := mutate.Parse([]byte("mutations:\n  - json_patch: [" + doc + "]\n"))
+		if err != nil {
+			t.Fatalf("Parse %s: %v", doc, err)
+		}
+		if _, _, err := mutate.Apply(sampleRecord(), muts); err == nil {
+			t.Errorf("%s: expected an error", doc)
+		}
+	}
+	if _, err := mutate.Parse([]byte("mutations:\n  - json_patch: [{op: swap, path: /a}]\n")); err == nil {
+		t.Errorf("Expected an error for an unknown op")
+	}
+}
+
+func TestParseLine(t *testing.T) {
+	lines := []string{
+		"method put",
+		"header X-Debug: on",
+		"-header Cookie",
+		"query page=9",
+		`json /items/0 = {"sku":"z"}`,
+		"json /coupon = HALF OFF",
+		"json+ /items/0 = 1",
+		"-json /id",
+	}
+	var muts []mutate.Mutation
+	for _, line := range lines {
+		m, err := mutate.ParseLine(line)
+		if err != nil {
+			t.Fatalf("ParseLine(%q): %v", line, err)
+		}
+		muts = append(muts, m)
+	}
+	out, _, err := mutate.Apply(sampleRecord(), muts)
+	if err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	if out.Request.Method != "PUT" || out.Request.Headers.Get("X-Debug") != "on" {
+		t.Errorf("Unexpected request: %+v", out.Request)
+	}
+	if want := `{"coupon":"HALF OFF","items":[1,{"sku":"z"}]}`; string(out.Request.Body) != want {
+		t.Errorf("Unexpected body:\n got %s\nwant %s", out.Request.Body, want)
+	}
+
+	for _, bad := range []string{"method", "header NoColon", "query nope", "json /a", "path relative", "teleport /x"} {
+		if _, err := mutate.ParseLine(bad); err == nil {
+			t.Errorf("ParseLine(%q): expected an error", bad)
+		}
+	}
+}
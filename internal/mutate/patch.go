@@ -0,0 +1,297 @@
+package mutate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PatchOp is one RFC 6902 JSON Patch operation. Paths are JSON pointers, "/items/0/qty".
+type PatchOp struct {
+	Op    string `yaml:"op"` // add, remove, replace, move, copy or test
+	Path  string `yaml:"path"`
+	From  string `yaml:"from"` // Source pointer of move and copy
+	Value any    `yaml:"value"`
+}
+
+func (op PatchOp) String() string {
+	switch op.Op {
+	case "remove":
+		return "json remove " + op.Path
+	case "move", "copy":
+		return fmt.Sprintf("json %s %s to %s", op.Op, op.From, op.Path)
+	default:
+		value, _ := json.Marshal(op.Value)
+		return fmt.Sprintf("json %s %s = %s", op.Op, op.Path, value)
+	}
+}
+
+func (op PatchOp) check() error {
+	switch op.Op {
+	case "add", "remove", "replace", "test":
+	case "move", "copy":
+		if _, err := pointer(op.From); err != nil {
+			return fmt.Errorf("%s from: %w", op.Op, err)
+		}
+	default:
+		return fmt.Errorf("unknown op %q (use add, remove, replace, move, copy or test)", op.Op)
+	}
+	if _, err := pointer(op.Path); err != nil {
+		return fmt.Errorf("%s: %w", op.Op, err)
+	}
+	return nil
+}
+
+func pointer(p string) ([]string, error) {
+	// Splits a JSON pointer into unescaped tokens, "" is the whole document
+	if p == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(p, "/") {
+		return nil, fmt.Errorf("pointer %q must start with /", p)
+	}
+	tokens := strings.Split(p[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(t, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+func applyPatch(body []byte, ops []PatchOp) ([]byte, error) {
+	var doc any
+	if len(bytes.TrimSpace(body)) > 0 {
+		var err error
+		if doc, err = decodeJSON(body); err != nil {
+			return nil, fmt.Errorf("json_patch: body isn't JSON: %w", err)
+		}
+	}
+
+	for _, op := range ops {
+		var err error
+		if doc, err = applyOp(doc, op); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(doc); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+func decodeJSON(data []byte) (any, error) {
+	// Numbers stay json.Number so large IDs survive a round trip
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	err := dec.Decode(&v)
+	return v, err
+}
+
+func copyValue(v any) (any, error) {
+	// Values are copied before they go into a document so later ops on the
+	// document can't change the mutation or another part of the document
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON(data)
+}
+
+func applyOp(doc any, op PatchOp) (any, error) {
+	path, _ := pointer(op.Path)
+	switch op.Op {
+	case "add", "replace":
+		value, err := copyValue(op.Value)
+		if err != nil {
+			return nil, err
+		}
+		return put(doc, path, value, op.Op == "add")
+	case "remove":
+		doc, _, err := remove(doc, path)
+		return doc, err
+	case "move":
+		from, _ := pointer(op.From)
+		doc, value, err := remove(doc, from)
+		if err != nil {
+			return nil, err
+		}
+		return put(doc, path, value, true)
+	case "copy":
+		from, _ := pointer(op.From)
+		value, err := get(doc, from)
+		if err != nil {
+			return nil, err
+		}
+		if value, err = copyValue(value); err != nil {
+			return nil, err
+		}
+		return put(doc, path, value, true)
+	case "test":
+		value, err := get(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !sameJSON(value, op.Value) {
+			return nil, fmt.Errorf("test failed")
+		}
+		return doc, nil
+	}
+	return nil, fmt.Errorf("unknown op %q", op.Op)
+}
+
+func sameJSON(a, b any) bool {
+	// Compares through float64 so 1, 1.0 and json.Number("1") are equal
+	canon := func(v any) string {
+		data, _ := json.Marshal(v)
+		var out any
+		_ = json.Unmarshal(data, &out)
+		data, _ = json.Marshal(out)
+		return string(data)
+	}
+	return canon(a) == canon(b)
+}
+
+func get(doc any, path []string) (any, error) {
+	for _, key := range path {
+		child, err := childOf(doc, key)
+		if err != nil {
+			return nil, err
+		}
+		doc = child
+	}
+	return doc, nil
+}
+
+func childOf(node any, key string) (any, error) {
+	switch n := node.(type) {
+	case map[string]any:
+		v, ok := n[key]
+		if !ok {
+			return nil, fmt.Errorf("no member %q", key)
+		}
+		return v, nil
+	case []any:
+		i, err := index(key, len(n))
+		if err != nil {
+			return nil, err
+		}
+		return n[i], nil
+	}
+	return nil, fmt.Errorf("can't look up %q in a %s", key, kind(node))
+}
+
+func index(key string, n int) (int, error) {
+	i, err := strconv.Atoi(key)
+	if err != nil || i < 0 || i >= n || (len(key) > 1 && key[0] == '0') {
+		return 0, fmt.Errorf("index %q out of range for %d items", key, n)
+	}
+	return i, nil
+}
+
+func kind(v any) string {
+	switch v.(type) {
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case nil:
+		return "null"
+	}
+	return "value"
+}
+
+func update(node any, path []string, fn func(container any, key string) (any, error)) (any, error) {
+	// Walks to the parent of path, lets fn change it and stores the result back up
+	// the chain, arrays change length so every level is reassigned
+	if len(path) == 1 {
+		return fn(node, path[0])
+	}
+	child, err := childOf(node, path[0])
+	if err != nil {
+		return nil, err
+	}
+	child, err = update(child, path[1:], fn)
+	if err != nil {
+		return nil, err
+	}
+	switch n := node.(type) {
+	case map[string]any:
+		n[path[0]] = child
+	case []any:
+		i, _ := strconv.Atoi(path[0])
+		n[i] = child
+	}
+	return node, nil
+}
+
+func put(doc any, path []string, value any, add bool) (any, error) {
+	// add creates members and inserts array items, replace only overwrites
+	if len(path) == 0 {
+		return value, nil
+	}
+	return update(doc, path, func(container any, key string) (any, error) {
+		switch n := container.(type) {
+		case map[string]any:
+			if _, ok := n[key]; !ok && !add {
+				return nil, fmt.Errorf("no member %q to replace", key)
+			}
+			n[key] = value
+			return n, nil
+		case []any:
+			if add {
+				if key == "-" {
+					return append(n, value), nil
+				}
+				i, err := index(key, len(n)+1)
+				if err != nil {
+					return nil, err
+				}
+				n = append(n, nil)
+				copy(n[i+1:], n[i:])
+				n[i] = value
+				return n, nil
+			}
+			i, err := index(key, len(n))
+			if err != nil {
+				return nil, err
+			}
+			n[i] = value
+			return n, nil
+		}
+		return nil, fmt.Errorf("can't set %q in a %s", key, kind(container))
+	})
+}
+
+func remove(doc any, path []string) (any, any, error) {
+	if len(path) == 0 {
+		return nil, nil, fmt.Errorf("can't remove the whole document")
+	}
+	var removed any
+	doc, err := update(doc, path, func(container any, key string) (any, error) {
+		switch n := container.(type) {
+		case map[string]any:
+			v, ok := n[key]
+			if !ok {
+				return nil, fmt.Errorf("no member %q to remove", key)
+			}
+			removed = v
+			delete(n, key)
+			return n, nil
+		case []any:
+			i, err := index(key, len(n))
+			if err != nil {
+				return nil, err
+			}
+			removed = n[i]
+			return append(n[:i], n[i+1:]...), nil
+		}
+		return nil, fmt.Errorf("can't remove %q from a %s", key, kind(container))
+	})
+	return doc, removed, err
+}
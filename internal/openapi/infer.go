@@ -0,0 +1,438 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BarrettBr/RWND/internal/model"
+	"github.com/BarrettBr/RWND/internal/route"
+)
+
+// skipRequestHeaders aren't documented as header parameters, they are set by
+// clients and proxies or described elsewhere in the document.
+var skipRequestHeaders = map[string]bool{
+	"Accept": true, "Accept-Encoding": true, "Accept-Language": true, "Authorization": true,
+	"Connection": true, "Content-Length": true, "Content-Type": true, "Cookie": true,
+	"Host": true, "Origin": true, "Referer": true, "Te": true, "Transfer-Encoding": true,
+	"Upgrade": true, "User-Agent": true, "X-Forwarded-For": true, "X-Forwarded-Host": true,
+	"X-Forwarded-Proto": true, "X-Real-Ip": true,
+}
+
+// skipResponseHeaders are transport details rather than part of an API.
+var skipResponseHeaders = map[string]bool{
+	"Connection": true, "Content-Length": true, "Content-Type": true, "Date": true,
+	"Keep-Alive": true, "Server": true, "Transfer-Encoding": true, "Vary": true,
+}
+
+// Builder infers an OpenAPI document from recorded records.
+type Builder struct {
+	records int
+	servers map[string]bool
+	ops     map[string]*opStats // "path method" -> what was seen
+}
+
+type opStats struct {
+	path, method string
+	count        int
+	query        map[string]*seenSchema
+	headers      map[string]*seenSchema
+	bodies       int
+	content      map[string]*Schema // Request media type -> schema
+	responses    map[int]*respStats
+}
+
+type respStats struct {
+	count   int
+	headers map[string]*seenSchema
+	content map[string]*Schema
+}
+
+// seenSchema is a parameter or header with how many records carried it.
+type seenSchema struct {
+	seen   int
+	schema *Schema
+}
+
+// NewBuilder returns an empty Builder.
+func NewBuilder() *Builder {
+	return &Builder{servers: make(map[string]bool), ops: make(map[string]*opStats)}
+}
+
+// Records returns how many records were added.
+func (b *Builder) Records() int {
+	return b.records
+}
+
+// Add folds one record into the document.
+func (b *Builder) Add(rec model.Record) {
+	u, err := url.Parse(rec.Request.URL)
+	if err != nil {
+		return
+	}
+	b.records++
+	if u.Host != "" {
+		b.servers[u.Scheme+"://"+u.Host] = true
+	}
+
+	path := route.Template(u.Path)
+	key := path + " " + rec.Request.Method
+	op, ok := b.ops[key]
+	if !ok {
+		op = &opStats{
+			path:      path,
+			method:    rec.Request.Method,
+			query:     make(map[string]*seenSchema),
+			headers:   make(map[string]*seenSchema),
+			content:   make(map[string]*Schema),
+			responses: make(map[int]*respStats),
+		}
+		b.ops[key] = op
+	}
+	op.count++
+
+	for name, values := range u.Query() {
+		addSeen(op.query, name, valuesSchema(values))
+	}
+	for name := range rec.Request.Headers {
+		if !skipRequestHeaders[http.CanonicalHeaderKey(name)] {
+			addSeen(op.headers, http.CanonicalHeaderKey(name), &Schema{Type: "string"})
+		}
+	}
+	if len(rec.Request.Body) > 0 {
+		op.bodies++
+		media, schema := bodySchema(rec.Request.Headers.Get("Content-Type"), rec.Request.Body, rec.Request.Truncated)
+		op.content[media] = merge(op.content[media], schema)
+	}
+
+	resp, ok := op.responses[rec.Response.Status]
+	if !ok {
+		resp = &respStats{headers: make(map[string]*seenSchema), content: make(map[string]*Schema)}
+		op.responses[rec.Response.Status] = resp
+	}
+	resp.count++
+	for name := range rec.Response.Headers {
+		if !skipResponseHeaders[http.CanonicalHeaderKey(name)] {
+			addSeen(resp.headers, http.CanonicalHeaderKey(name), &Schema{Type: "string"})
+		}
+	}
+	if len(rec.Response.Body) > 0 {
+		media, schema := bodySchema(rec.Response.Headers.Get("Content-Type"), rec.Response.Body, rec.Response.Truncated)
+		resp.content[media] = merge(resp.content[media], schema)
+	}
+}
+
+func addSeen(m map[string]*seenSchema, name string, schema *Schema) {
+	s, ok := m[name]
+	if !ok {
+		s = &seenSchema{}
+		m[name] = s
+	}
+	s.seen++
+	s.schema = merge(s.schema, schema)
+}
+
+// Document returns the OpenAPI document of every record added so far.
+func (b *Builder) Document(info Info) *Document {
+	doc := &Document{OpenAPI: Version, Info: info, Paths: make(map[string]*PathItem)}
+	for server := range b.servers {
+		doc.Servers = append(doc.Servers, Server{URL: server})
+	}
+	sort.Slice(doc.Servers, func(i, j int) bool { return doc.Servers[i].URL < doc.Servers[j].URL })
+
+	for _, op := range b.ops {
+		path, params := pathParams(op.path)
+		item, ok := doc.Paths[path]
+		if !ok {
+			item = &PathItem{}
+			doc.Paths[path] = item
+		}
+		item.SetOperation(op.method, op.operation(params))
+	}
+	return doc
+}
+
+func (op *opStats) operation(params []*Parameter) *Operation {
+	out := &Operation{Parameters: params, Responses: make(map[string]*Response), Seen: op.count}
+	out.Parameters = append(out.Parameters, seenParams("query", op.query, op.count)...)
+	out.Parameters = append(out.Parameters, seenParams("header", op.headers, op.count)...)
+
+	if len(op.content) > 0 {
+		out.RequestBody = &RequestBody{Required: op.bodies == op.count, Content: mediaTypes(op.content)}
+	}
+
+	for status, resp := range op.responses {
+		r := &Response{Description: statusDescription(status)}
+		if len(resp.content) > 0 {
+			r.Content = mediaTypes(resp.content)
+		}
+		for name, h := range resp.headers {
+			if r.Headers == nil {
+				r.Headers = make(map[string]*Header)
+			}
+			r.Headers[name] = &Header{Required: h.seen == resp.count, Schema: h.schema}
+		}
+		out.Responses[strconv.Itoa(status)] = r
+	}
+	return out
+}
+
+func seenParams(in string, seen map[string]*seenSchema, count int) []*Parameter {
+	// Parameters carried by every record of the operation are required
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	out := make([]*Parameter, 0, len(names))
+	for _, name := range names {
+		s := seen[name]
+		schema := s.schema
+		if schema.mixed || schema.Type == "" {
+			schema = &Schema{Type: "string"}
+		}
+		out = append(out, &Parameter{Name: name, In: in, Required: s.seen == count, Schema: schema})
+	}
+	return out
+}
+
+func pathParams(tmpl string) (string, []*Parameter) {
+	// Placeholders repeat in a template ("/users/{id}/orders/{id}") but parameter
+	// names must be unique in a path, later ones are numbered: {id}, {id2}
+	segments := strings.Split(tmpl, "/")
+	var params []*Parameter
+	used := make(map[string]int)
+	for i, seg := range segments {
+		var schema *Schema
+		switch seg {
+		case route.ID:
+			schema = &Schema{Type: "integer"}
+		case route.UUID:
+			schema = &Schema{Type: "string", Format: "uuid"}
+		default:
+			continue
+		}
+		name := strings.Trim(seg, "{}")
+		used[name]++
+		if n := used[name]; n > 1 {
+			name += strconv.Itoa(n)
+		}
+		segments[i] = "{" + name + "}"
+		params = append(params, &Parameter{Name: name, In: "path", Required: true, Schema: schema})
+	}
+	return strings.Join(segments, "/"), params
+}
+
+func mediaTypes(content map[string]*Schema) map[string]*MediaType {
+	out := make(map[string]*MediaType, len(content))
+	for media, schema := range content {
+		out[media] = &MediaType{Schema: complete(schema)}
+	}
+	return out
+}
+
+func complete(s *Schema) *Schema {
+	// Arrays only ever seen empty still need an items schema, any value fits it
+	if s == nil {
+		return nil
+	}
+	if s.Type == "array" && s.Items == nil {
+		s.Items = &Schema{}
+	}
+	complete(s.Items)
+	for _, p := range s.Properties {
+		complete(p)
+	}
+	return s
+}
+
+func statusDescription(status int) string {
+	if text := http.StatusText(status); text != "" {
+		return text
+	}
+	return fmt.Sprintf("Status %d", status)
+}
+
+// ------------
+
+func bodySchema(contentType string, body []byte, truncated bool) (string, *Schema) {
+	// Returns the media type of a body and the schema inferred from it. A body cut
+	// at the capture limit only contributes its media type.
+	media := bodyMediaType(contentType, body)
+	if truncated {
+		return media, nil
+	}
+
+	switch {
+	case media == "application/json" || strings.HasSuffix(media, "+json"):
+		dec := json.NewDecoder(bytes.NewReader(body))
+		dec.UseNumber()
+		var v any
+		if dec.Decode(&v) != nil {
+			return media, nil
+		}
+		return media, inferSchema(v)
+	case media == "application/x-www-form-urlencoded":
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			return media, nil
+		}
+		s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+		for name, values := range form {
+			s.Properties[name] = valuesSchema(values)
+			s.Required = append(s.Required, name)
+		}
+		sort.Strings(s.Required)
+		return media, s
+	case strings.HasPrefix(media, "text/"):
+		return media, &Schema{Type: "string"}
+	default:
+		return media, &Schema{Type: "string", Format: "binary"}
+	}
+}
+
+func bodyMediaType(contentType string, body []byte) string {
+	// Bodies recorded without a usable Content-Type count as JSON if they parse, binary otherwise
+	media, _, err := mime.ParseMediaType(contentType)
+	if err != nil || media == "" {
+		media = "application/octet-stream"
+		if json.Valid(body) {
+			media = "application/json"
+		}
+	}
+	return media
+}
+
+func valuesSchema(values []string) *Schema {
+	// A repeated query or form value is an array of its values
+	var s *Schema
+	for _, v := range values {
+		s = merge(s, scalarSchema(v))
+	}
+	if len(values) > 1 {
+		return &Schema{Type: "array", Items: s}
+	}
+	return s
+}
+
+func scalarSchema(v string) *Schema {
+	if _, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return &Schema{Type: "integer"}
+	}
+	if _, err := strconv.ParseFloat(v, 64); err == nil {
+		return &Schema{Type: "number"}
+	}
+	if v == "true" || v == "false" {
+		return &Schema{Type: "boolean"}
+	}
+	return stringSchema(v)
+}
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+func stringSchema(v string) *Schema {
+	s := &Schema{Type: "string"}
+	if uuidPattern.MatchString(v) {
+		s.Format = "uuid"
+	} else if _, err := time.Parse(time.RFC3339Nano, v); err == nil {
+		s.Format = "date-time"
+	} else if _, err := time.Parse(time.DateOnly, v); err == nil {
+		s.Format = "date"
+	}
+	return s
+}
+
+func inferSchema(v any) *Schema {
+	// v is decoded with UseNumber so integers and numbers can be told apart
+	switch v := v.(type) {
+	case nil:
+		return &Schema{Nullable: true}
+	case bool:
+		return &Schema{Type: "boolean"}
+	case json.Number:
+		if strings.ContainsAny(v.String(), ".eE") {
+			return &Schema{Type: "number"}
+		}
+		return &Schema{Type: "integer"}
+	case string:
+		return stringSchema(v)
+	case []any:
+		// Items stay nil for an empty array so later samples decide them
+		var items *Schema
+		for _, item := range v {
+			items = merge(items, inferSchema(item))
+		}
+		return &Schema{Type: "array", Items: items}
+	case map[string]any:
+		s := &Schema{Type: "object", Properties: make(map[string]*Schema, len(v))}
+		for name, child := range v {
+			s.Properties[name] = inferSchema(child)
+			s.Required = append(s.Required, name)
+		}
+		sort.Strings(s.Required)
+		return s
+	}
+	return &Schema{mixed: true}
+}
+
+func isNullOnly(s *Schema) bool {
+	return s.Type == "" && !s.mixed
+}
+
+func merge(a, b *Schema) *Schema {
+	// Combines the schemas of two samples into one both match. a is updated in
+	// place, b is not used afterwards.
+	switch {
+	case a == nil:
+		return b
+	case b == nil:
+		return a
+	}
+	nullable := a.Nullable || b.Nullable
+	switch {
+	case isNullOnly(a):
+		b.Nullable = true
+		return b
+	case isNullOnly(b):
+		a.Nullable = true
+		return a
+	case a.mixed || b.mixed:
+		return &Schema{mixed: true, Nullable: nullable}
+	}
+
+	if a.Type != b.Type {
+		if (a.Type == "integer" || a.Type == "number") && (b.Type == "integer" || b.Type == "number") {
+			return &Schema{Type: "number", Nullable: nullable}
+		}
+		return &Schema{mixed: true, Nullable: nullable}
+	}
+
+	a.Nullable = nullable
+	switch a.Type {
+	case "string":
+		if a.Format != b.Format {
+			a.Format = ""
+		}
+	case "array":
+		a.Items = merge(a.Items, b.Items)
+	case "object":
+		for name, s := range b.Properties {
+			a.Properties[name] = merge(a.Properties[name], s)
+		}
+		// Only properties every sample had stay required
+		a.Required = slices.DeleteFunc(a.Required, func(name string) bool {
+			return !slices.Contains(b.Required, name)
+		})
+	}
+	return a
+}
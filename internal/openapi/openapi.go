@@ -0,0 +1,281 @@
+// Package openapi reads, writes, infers and validates against OpenAPI 3 documents.
+// Only the parts of the specification RWND works with are modeled, other keys are
+// ignored on load.
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Version is the OpenAPI version of inferred documents.
+const Version = "3.0.3"
+
+// Document is an OpenAPI document.
+type Document struct {
+	OpenAPI    string               `yaml:"openapi" json:"openapi"`
+	Info       Info                 `yaml:"info" json:"info"`
+	Servers    []Server             `yaml:"servers,omitempty" json:"servers,omitempty"`
+	Paths      map[string]*PathItem `yaml:"paths" json:"paths"`
+	Components *Components          `yaml:"components,omitempty" json:"components,omitempty"`
+}
+
+// Info describes the API.
+type Info struct {
+	Title       string `yaml:"title" json:"title"`
+	Description string `yaml:"description,omitempty" json:"description,omitempty"`
+	Version     string `yaml:"version" json:"version"`
+}
+
+// Server is a base URL the API is served from.
+type Server struct {
+	URL string `yaml:"url" json:"url"`
+}
+
+// PathItem holds the operations of one path template.
+type PathItem struct {
+	Parameters []*Parameter `yaml:"parameters,omitempty" json:"parameters,omitempty"`
+	Get        *Operation   `yaml:"get,omitempty" json:"get,omitempty"`
+	Put        *Operation   `yaml:"put,omitempty" json:"put,omitempty"`
+	Post       *Operation   `yaml:"post,omitempty" json:"post,omitempty"`
+	Delete     *Operation   `yaml:"delete,omitempty" json:"delete,omitempty"`
+	Options    *Operation   `yaml:"options,omitempty" json:"options,omitempty"`
+	Head       *Operation   `yaml:"head,omitempty" json:"head,omitempty"`
+	Patch      *Operation   `yaml:"patch,omitempty" json:"patch,omitempty"`
+	Trace      *Operation   `yaml:"trace,omitempty" json:"trace,omitempty"`
+}
+
+// Operation returns the operation for an HTTP method, nil if the path has none.
+func (p *PathItem) Operation(method string) *Operation {
+	if slot := p.slot(method); slot != nil {
+		return *slot
+	}
+	return nil
+}
+
+// SetOperation sets the operation for an HTTP method, unknown methods are ignored.
+func (p *PathItem) SetOperation(method string, op *Operation) {
+	if slot := p.slot(method); slot != nil {
+		*slot = op
+	}
+}
+
+func (p *PathItem) slot(method string) **Operation {
+	switch strings.ToUpper(method) {
+	case "GET":
+		return &p.Get
+	case "PUT":
+		return &p.Put
+	case "POST":
+		return &p.Post
+	case "DELETE":
+		return &p.Delete
+	case "OPTIONS":
+		return &p.Options
+	case "HEAD":
+		return &p.Head
+	case "PATCH":
+		return &p.Patch
+	case "TRACE":
+		return &p.Trace
+	}
+	return nil
+}
+
+// Operation is one method of a path.
+type Operation struct {
+	Summary     string               `yaml:"summary,omitempty" json:"summary,omitempty"`
+	OperationID string               `yaml:"operationId,omitempty" json:"operationId,omitempty"`
+	Parameters  []*Parameter         `yaml:"parameters,omitempty" json:"parameters,omitempty"`
+	RequestBody *RequestBody         `yaml:"requestBody,omitempty" json:"requestBody,omitempty"`
+	Responses   map[string]*Response `yaml:"responses" json:"responses"`
+	Seen        int                  `yaml:"x-rwnd-seen,omitempty" json:"x-rwnd-seen,omitempty"` // Records an inferred operation was built from
+}
+
+// Parameter is a path, query, header or cookie parameter.
+type Parameter struct {
+	Ref      string  `yaml:"$ref,omitempty" json:"$ref,omitempty"`
+	Name     string  `yaml:"name" json:"name"`
+	In       string  `yaml:"in" json:"in"`
+	Required bool    `yaml:"required,omitempty" json:"required,omitempty"`
+	Schema   *Schema `yaml:"schema,omitempty" json:"schema,omitempty"`
+}
+
+// RequestBody lists the accepted request media types.
+type RequestBody struct {
+	Ref      string                `yaml:"$ref,omitempty" json:"$ref,omitempty"`
+	Required bool                  `yaml:"required,omitempty" json:"required,omitempty"`
+	Content  map[string]*MediaType `yaml:"content,omitempty" json:"content,omitempty"`
+}
+
+// Response describes one status code of an operation.
+type Response struct {
+	Ref         string                `yaml:"$ref,omitempty" json:"$ref,omitempty"`
+	Description string                `yaml:"description" json:"description"`
+	Headers     map[string]*Header    `yaml:"headers,omitempty" json:"headers,omitempty"`
+	Content     map[string]*MediaType `yaml:"content,omitempty" json:"content,omitempty"`
+}
+
+// Header is a response header.
+type Header struct {
+	Required bool    `yaml:"required,omitempty" json:"required,omitempty"`
+	Schema   *Schema `yaml:"schema,omitempty" json:"schema,omitempty"`
+}
+
+// MediaType holds the schema of one content type.
+type MediaType struct {
+	Schema *Schema `yaml:"schema,omitempty" json:"schema,omitempty"`
+}
+
+// Schema is a JSON schema as OpenAPI 3.0 uses it.
+type Schema struct {
+	Ref                  string             `yaml:"$ref,omitempty" json:"$ref,omitempty"`
+	Type                 string             `yaml:"type,omitempty" json:"type,omitempty"`
+	Format               string             `yaml:"format,omitempty" json:"format,omitempty"`
+	Nullable             bool               `yaml:"nullable,omitempty" json:"nullable,omitempty"`
+	Enum                 []any              `yaml:"enum,omitempty" json:"enum,omitempty"`
+	Properties           map[string]*Schema `yaml:"properties,omitempty" json:"properties,omitempty"`
+	Required             []string           `yaml:"required,omitempty" json:"required,omitempty"`
+	AdditionalProperties *Additional        `yaml:"additionalProperties,omitempty" json:"additionalProperties,omitempty"`
+	Items                *Schema            `yaml:"items,omitempty" json:"items,omitempty"`
+	OneOf                []*Schema          `yaml:"oneOf,omitempty" json:"oneOf,omitempty"`
+	AnyOf                []*Schema          `yaml:"anyOf,omitempty" json:"anyOf,omitempty"`
+	AllOf                []*Schema          `yaml:"allOf,omitempty" json:"allOf,omitempty"`
+
+	types []string // OpenAPI 3.1 list of several non-null types, Type is empty then
+	mixed bool     // Inferred from samples of different types, matches anything
+}
+
+// UnmarshalYAML reads a schema, accepting the OpenAPI 3.1 form of type: a list of
+// type names where "null" stands for nullable.
+func (s *Schema) UnmarshalYAML(node *yaml.Node) error {
+	var types []string
+	nullable := false
+	if node.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if key.Value != "type" || value.Kind != yaml.SequenceNode {
+				continue
+			}
+			for _, t := range value.Content {
+				if t.Value == "null" {
+					nullable = true
+				} else {
+					types = append(types, t.Value)
+				}
+			}
+			// Decode the rest as usual with the list swapped for its only type, if any
+			single := ""
+			if len(types) == 1 {
+				single = types[0]
+			}
+			node.Content[i+1] = &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: single}
+		}
+	}
+
+	type plain Schema
+	if err := node.Decode((*plain)(s)); err != nil {
+		return err
+	}
+	s.Nullable = s.Nullable || nullable
+	if len(types) > 1 {
+		s.types = types
+	}
+	return nil
+}
+
+// Additional is the additionalProperties of an object schema: false forbids
+// properties the schema doesn't list, a schema constrains them.
+type Additional struct {
+	Forbidden bool
+	Schema    *Schema
+}
+
+// UnmarshalYAML reads either form of additionalProperties.
+func (a *Additional) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		var allowed bool
+		if err := node.Decode(&allowed); err != nil {
+			return err
+		}
+		a.Forbidden = !allowed
+		return nil
+	}
+	a.Schema = &Schema{}
+	return node.Decode(a.Schema)
+}
+
+// MarshalYAML writes additionalProperties back in its spec form.
+func (a *Additional) MarshalYAML() (any, error) {
+	if a.Schema != nil {
+		return a.Schema, nil
+	}
+	return !a.Forbidden, nil
+}
+
+// MarshalJSON writes additionalProperties back in its spec form.
+func (a *Additional) MarshalJSON() ([]byte, error) {
+	if a.Schema != nil {
+		return json.Marshal(a.Schema)
+	}
+	return json.Marshal(!a.Forbidden)
+}
+
+// Components holds the reusable parts $ref points at.
+type Components struct {
+	Schemas       map[string]*Schema      `yaml:"schemas,omitempty" json:"schemas,omitempty"`
+	Parameters    map[string]*Parameter   `yaml:"parameters,omitempty" json:"parameters,omitempty"`
+	RequestBodies map[string]*RequestBody `yaml:"requestBodies,omitempty" json:"requestBodies,omitempty"`
+	Responses     map[string]*Response    `yaml:"responses,omitempty" json:"responses,omitempty"`
+}
+
+// ------------
+
+// Marshal encodes the document as YAML, or as indented JSON when asJSON is set.
+func Marshal(doc *Document, asJSON bool) ([]byte, error) {
+	if asJSON {
+		data, err := json.MarshalIndent(doc, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(data, '\n'), nil
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Load reads an OpenAPI 3 document from a YAML or JSON file and resolves its
+// local $refs.
+func Load(path string) (*Document, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// JSON is valid YAML, one decoder covers both
+	var doc Document
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("Invalid OpenAPI document %s: %v", path, err)
+	}
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		return nil, fmt.Errorf("%s is not an OpenAPI 3 document (openapi: %q)", path, doc.OpenAPI)
+	}
+	if err := resolveRefs(&doc); err != nil {
+		return nil, fmt.Errorf("Invalid OpenAPI document %s: %v", path, err)
+	}
+	return &doc, nil
+}
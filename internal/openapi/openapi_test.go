@@ -0,0 +1,234 @@
+package openapi_test
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/BarrettBr/RWND/internal/model"
+	"github.com/BarrettBr/RWND/internal/openapi"
+)
+
+func record(method, url string, status int, reqBody, respBody string) model.Record {
+	var rec model.Record
+	rec.Request.Method = method
+	rec.Request.URL = url
+	rec.Request.Headers = http.Header{"User-Agent": {"test"}}
+	if reqBody != "" {
+		rec.Request.Headers.Set("Content-Type", "application/json")
+		rec.Request.Body = []byte(reqBody)
+	}
+	rec.Response.Status = status
+	rec.Response.Headers = http.Header{"Content-Type": {"application/json; charset=utf-8"}, "Date": {"today"}}
+	rec.Response.Body = []byte(respBody)
+	return rec
+}
+
+func TestBuilder_Document(t *testing.T) {
+	b := openapi.NewBuilder()
+	get1 := record("GET", "http://api.local/users/1/orders/7?expand=items&limit=5", 200,
+		"", `{"id":7,"total":10,"note":null,"tags":[],"created":"2025-01-02T10:00:00Z"}`)
+	get1.Request.Headers.Set("X-Tenant", "acme")
+	b.Add(get1)
+	b.Add(record("GET", "http://api.local/users/2/orders/8?limit=10", 200,
+		"", `{"id":8,"total":12.5,"note":"gift","tags":["a"],"created":"2025-01-03T10:00:00Z"}`))
+	b.Add(record("GET", "http://api.local/users/2/orders/9", 404, "", `{"error":"not found"}`))
+	b.Add(record("POST", "http://api.local/users/2/orders", 201, `{"sku":"A-1","qty":2}`, `{"id":10}`))
+
+	doc := b.Document(openapi.Info{Title: "Test", Version: "1"})
+	if doc.OpenAPI != openapi.Version || len(doc.Servers) != 1 || doc.Servers[0].URL != "http://api.local" {
+		t.Fatalf("Unexpected document header: %+v", doc)
+	}
+
+	item := doc.Paths["/users/{id}/orders/{id2}"]
+	if item == nil {
+		t.Fatalf("Missing templated path, got %v", doc.Paths)
+	}
+	get := item.Operation("GET")
+	if get == nil || get.Seen != 3 || get.RequestBody != nil {
+		t.Fatalf("Unexpected GET operation: %+v", get)
+	}
+
+	params := map[string]*openapi.Parameter{}
+	for _, p := range get.Parameters {
+		params[p.In+":"+p.Name] = p
+	}
+	if p := params["path:id2"]; p == nil || !p.Required || p.Schema.Type != "integer" {
+		t.Errorf("Unexpected path parameter: %+v", p)
+	}
+	if p := params["query:limit"]; p == nil || p.Required || p.Schema.Type != "integer" {
+		t.Errorf("Unexpected limit parameter: %+v", p)
+	}
+	if p := params["header:X-Tenant"]; p == nil || p.Required {
+		t.Errorf("Unexpected header parameter: %+v", p)
+	}
+	if params["header:User-Agent"] != nil {
+		t.Errorf("Standard headers shouldn't be parameters")
+	}
+
+	ok := get.Responses["200"]
+	if ok == nil || ok.Content["application/json"] == nil || len(ok.Headers) != 0 {
+		t.Fatalf("Unexpected 200 response: %+v", ok)
+	}
+	s := ok.Content["application/json"].Schema
+	if s.Type != "object" || !slices.Equal(s.Required, []string{"created", "id", "note", "tags", "total"}) {
+		t.Fatalf("Unexpected body schema: %+v", s)
+	}
+	if p := s.Properties["total"]; p.Type != "number" {
+		t.Errorf("integer and number samples should merge to number, got %+v", p)
+	}
+	if p := s.Properties["note"]; p.Type != "string" || !p.Nullable {
+		t.Errorf("null and string samples should merge to a nullable string, got %+v", p)
+	}
+	if p := s.Properties["tags"]; p.Type != "array" || p.Items == nil || p.Items.Type != "string" {
+		t.Errorf("Unexpected array schema: %+v", p)
+	}
+	if p := s.Properties["created"]; p.Format != "date-time" {
+		t.Errorf("Expected a date-time format, got %+v", p)
+	}
+	if get.Responses["404"] == nil || get.Responses["404"].Description != "Not Found" {
+		t.Errorf("Missing 404 response: %+v", get.Responses)
+	}
+
+	post := doc.Paths["/users/{id}/orders"].Operation("POST")
+	if post == nil || post.RequestBody == nil || !post.RequestBody.Required {
+		t.Fatalf("Unexpected POST operation: %+v", post)
+	}
+	if body := post.RequestBody.Content["application/json"].Schema; body.Properties["qty"].Type != "integer" {
+		t.Errorf("Unexpected request schema: %+v", body)
+	}
+
+	out, err := openapi.Marshal(doc, false)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	if !strings.HasPrefix(string(out), "openapi: 3.0.3\n") || !strings.Contains(string(out), "x-rwnd-seen: 3") {
+		t.Errorf("Unexpected YAML:\n%s", out)
+	}
+}
+
+const testSpec = `
+openapi: 3.1.0
+info: {title: Orders, version: "1"}
+servers:
+  - url: https://api.example.com/v1
+paths:
+  /orders/{id}:
+    parameters:
+      - name: id
+        in: path
+        required: true
+        schema: {type: integer}
+    get:
+      parameters:
+        - $ref: '#/components/parameters/Tenant'
+      responses:
+        "200":
+          description: OK
+          content:
+            application/json:
+              schema: {$ref: '#/components/schemas/Order'}
+        4XX:
+          $ref: '#/components/responses/Error'
+  /orders/latest:
+    get:
+      responses:
+        "200": {description: OK}
+  /orders:
+    post:
+      requestBody:
+        required: true
+        content:
+          application/json:
+            schema: {$ref: '#/components/schemas/Order'}
+      responses:
+        "201": {description: Created}
+components:
+  parameters:
+    Tenant: {name: X-Tenant, in: header, required: true, schema: {type: string}}
+  responses:
+    Error:
+      description: Error
+      content:
+        application/json:
+          schema:
+            type: object
+            required: [error]
+            properties: {error: {type: string}}
+  schemas:
+    Order:
+      type: object
+      required: [id, status]
+      additionalProperties: false
+      properties:
+        id: {type: integer}
+        status: {type: string, enum: [open, shipped]}
+        note: {type: [string, "null"]}
+        items:
+          type: array
+          items: {$ref: '#/components/schemas/Order'}
+`
+
+func TestValidator_Validate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "openapi.yaml")
+	if err := os.WriteFile(path, []byte(testSpec), 0600); err != nil {
+		t.Fatal(err)
+	}
+	doc, err := openapi.Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	v := openapi.NewValidator(doc)
+
+	tenant := func(rec model.Record) model.Record {
+		rec.Request.Headers.Set("X-Tenant", "acme")
+		return rec
+	}
+	cases := []struct {
+		name string
+		rec  model.Record
+		want []string // Violation kinds, in order
+	}{
+		{"valid", tenant(record("GET", "https://api.example.com/v1/orders/7", 200, "", `{"id":7,"status":"open","note":null}`)), nil},
+		{"concrete path wins", record("GET", "http://localhost/orders/latest", 200, "", ""), nil},
+		{"unknown path", record("GET", "http://localhost/users/1", 200, "", ""), []string{openapi.UnknownPath}},
+		{"unknown method", record("DELETE", "http://localhost/orders/1", 200, "", ""), []string{openapi.UnknownOperation}},
+		{"undeclared status", tenant(record("GET", "http://localhost/orders/1", 500, "", "")), []string{openapi.UndeclaredStatus}},
+		{"range status", tenant(record("GET", "http://localhost/orders/1", 404, "", `{"message":"gone"}`)), []string{openapi.MissingRequired}},
+		{"bad path parameter and missing header", record("GET", "http://localhost/orders/abc", 200, "", `{"id":1,"status":"open"}`),
+			[]string{openapi.MissingRequired, openapi.SchemaMismatch}},
+		{"schema mismatches", tenant(record("GET", "http://localhost/orders/1", 200, "",
+			`{"id":"1","status":"lost","extra":true,"items":[{"id":2}]}`)),
+			[]string{openapi.SchemaMismatch, openapi.SchemaMismatch, openapi.MissingRequired, openapi.SchemaMismatch}},
+		{"missing body", record("POST", "http://localhost/orders", 201, "", ""), []string{openapi.MissingRequired}},
+	}
+	for _, tc := range cases {
+		var got []string
+		for _, violation := range v.Validate(tc.rec) {
+			got = append(got, violation.Kind)
+		}
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("%s: got %v, want %v (%v)", tc.name, got, tc.want, v.Validate(tc.rec))
+		}
+	}
+}
+
+func TestLoad_Errors(t *testing.T) {
+	dir := t.TempDir()
+	for name, spec := range map[string]string{
+		"swagger.yaml": "swagger: '2.0'\n",
+		"missing.yaml": "openapi: 3.0.0\npaths:\n  /a:\n    get:\n      responses:\n        '200': {$ref: '#/components/responses/Nope'}\n",
+		"remote.yaml":  "openapi: 3.0.0\npaths:\n  /a:\n    get:\n      parameters: [{$ref: 'other.yaml#/P'}]\n",
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(spec), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := openapi.Load(path); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package openapi
+
+import (
+	"fmt"
+	"strings"
+)
+
+// refResolver replaces $ref objects with the components they point at. Schemas
+// may refer to themselves, so each one is walked once.
+type refResolver struct {
+	c    Components
+	seen map[*Schema]bool
+	err  error
+}
+
+func resolveRefs(doc *Document) error {
+	r := &refResolver{seen: make(map[*Schema]bool)}
+	if doc.Components != nil {
+		r.c = *doc.Components
+	}
+
+	for _, item := range doc.Paths {
+		if item == nil {
+			continue
+		}
+		r.parameters(item.Parameters)
+		for _, op := range []*Operation{item.Get, item.Put, item.Post, item.Delete, item.Options, item.Head, item.Patch, item.Trace} {
+			if op == nil {
+				continue
+			}
+			r.parameters(op.Parameters)
+			if op.RequestBody != nil {
+				op.RequestBody = r.requestBody(op.RequestBody)
+			}
+			for code, resp := range op.Responses {
+				if resp != nil {
+					op.Responses[code] = r.response(resp)
+				}
+			}
+		}
+	}
+	return r.err
+}
+
+func (r *refResolver) target(ref, kind string) (string, bool) {
+	// Returns the component name of a local "#/components/<kind>/<name>" ref
+	name, ok := strings.CutPrefix(ref, "#/components/"+kind+"/")
+	if !ok || name == "" || strings.Contains(name, "/") {
+		r.fail(fmt.Errorf("unsupported $ref %q, only #/components/%s/<name> refs are resolved here", ref, kind))
+		return "", false
+	}
+	// JSON pointer escapes
+	return strings.NewReplacer("~1", "/", "~0", "~").Replace(name), true
+}
+
+func (r *refResolver) fail(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+func (r *refResolver) parameters(params []*Parameter) {
+	for i, p := range params {
+		for hops := 0; p != nil && p.Ref != ""; hops++ {
+			name, ok := r.target(p.Ref, "parameters")
+			if !ok || hops > 16 {
+				return
+			}
+			if p = r.c.Parameters[name]; p == nil {
+				r.fail(fmt.Errorf("$ref to missing parameter %q", name))
+				return
+			}
+		}
+		if p != nil {
+			r.schema(&p.Schema)
+		}
+		params[i] = p
+	}
+}
+
+func (r *refResolver) requestBody(b *RequestBody) *RequestBody {
+	for hops := 0; b.Ref != ""; hops++ {
+		name, ok := r.target(b.Ref, "requestBodies")
+		if !ok || hops > 16 {
+			return b
+		}
+		next := r.c.RequestBodies[name]
+		if next == nil {
+			r.fail(fmt.Errorf("$ref to missing request body %q", name))
+			return b
+		}
+		b = next
+	}
+	r.content(b.Content)
+	return b
+}
+
+func (r *refResolver) response(resp *Response) *Response {
+	for hops := 0; resp.Ref != ""; hops++ {
+		name, ok := r.target(resp.Ref, "responses")
+		if !ok || hops > 16 {
+			return resp
+		}
+		next := r.c.Responses[name]
+		if next == nil {
+			r.fail(fmt.Errorf("$ref to missing response %q", name))
+			return resp
+		}
+		resp = next
+	}
+	r.content(resp.Content)
+	for _, h := range resp.Headers {
+		if h != nil {
+			r.schema(&h.Schema)
+		}
+	}
+	return resp
+}
+
+func (r *refResolver) content(content map[string]*MediaType) {
+	for _, mt := range content {
+		if mt != nil {
+			r.schema(&mt.Schema)
+		}
+	}
+}
+
+func (r *refResolver) schema(slot **Schema) {
+	s := *slot
+	for hops := 0; s != nil && s.Ref != ""; hops++ {
+		name, ok := r.target(s.Ref, "schemas")
+		if !ok || hops > 16 {
+			return
+		}
+		next := r.c.Schemas[name]
+		if next == nil {
+			r.fail(fmt.Errorf("$ref to missing schema %q", name))
+			return
+		}
+		s = next
+	}
+	*slot = s
+	if s == nil || r.seen[s] {
+		return
+	}
+	r.seen[s] = true
+
+	for name, p := range s.Properties {
+		r.schema(&p)
+		s.Properties[name] = p
+	}
+	r.schema(&s.Items)
+	if s.AdditionalProperties != nil {
+		r.schema(&s.AdditionalProperties.Schema)
+	}
+	for _, list := range [][]*Schema{s.OneOf, s.AnyOf, s.AllOf} {
+		for i := range list {
+			r.schema(&list[i])
+		}
+	}
+}
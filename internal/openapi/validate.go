@@ -0,0 +1,500 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BarrettBr/RWND/internal/model"
+)
+
+// Kinds of contract violation.
+const (
+	UnknownPath       = "unknown-path"       // No path of the spec matches the request
+	UnknownOperation  = "unknown-operation"  // The path doesn't declare the request method
+	UndeclaredStatus  = "undeclared-status"  // The operation doesn't declare the response status
+	SchemaMismatch    = "schema"             // A value doesn't match its schema
+	MissingRequired   = "missing-required"   // A required parameter, header, body or property is absent
+	UndeclaredContent = "undeclared-content" // A body's media type isn't declared
+)
+
+// Violation is one way a record breaks the spec.
+type Violation struct {
+	Kind    string
+	Message string
+}
+
+func (v Violation) String() string {
+	return v.Kind + ": " + v.Message
+}
+
+// Validator checks records against a document.
+type Validator struct {
+	paths []pathMatcher
+	bases []string // Path prefixes of the document's servers, longest first
+}
+
+// pathMatcher matches request paths against one path template.
+type pathMatcher struct {
+	tmpl   string
+	re     *regexp.Regexp
+	names  []string // Path parameter names, in order
+	params int
+	item   *PathItem
+}
+
+var templateParam = regexp.MustCompile(`\{[^{}/]+\}`)
+
+// NewValidator returns a Validator for doc.
+func NewValidator(doc *Document) *Validator {
+	v := &Validator{}
+	for tmpl, item := range doc.Paths {
+		if item == nil {
+			continue
+		}
+		m := pathMatcher{tmpl: tmpl, item: item}
+		var pattern strings.Builder
+		last := 0
+		for _, loc := range templateParam.FindAllStringIndex(tmpl, -1) {
+			pattern.WriteString(regexp.QuoteMeta(tmpl[last:loc[0]]))
+			pattern.WriteString("([^/]+)")
+			m.names = append(m.names, tmpl[loc[0]+1:loc[1]-1])
+			last = loc[1]
+		}
+		pattern.WriteString(regexp.QuoteMeta(tmpl[last:]))
+		m.re = regexp.MustCompile("^" + pattern.String() + "$")
+		m.params = len(m.names)
+		v.paths = append(v.paths, m)
+	}
+
+	// Concrete paths win over templated ones, "/users/me" before "/users/{id}"
+	sort.Slice(v.paths, func(i, j int) bool {
+		a, b := v.paths[i], v.paths[j]
+		if a.params != b.params {
+			return a.params < b.params
+		}
+		return a.tmpl < b.tmpl
+	})
+
+	for _, s := range doc.Servers {
+		if u, err := url.Parse(s.URL); err == nil {
+			if base := strings.TrimRight(u.Path, "/"); base != "" && !slices.Contains(v.bases, base) {
+				v.bases = append(v.bases, base)
+			}
+		}
+	}
+	sort.Slice(v.bases, func(i, j int) bool { return len(v.bases[i]) > len(v.bases[j]) })
+	return v
+}
+
+// Validate returns every way rec's request and response break the document.
+func (v *Validator) Validate(rec model.Record) []Violation {
+	var out []Violation
+	add := func(kind, format string, args ...any) {
+		out = append(out, Violation{Kind: kind, Message: fmt.Sprintf(format, args...)})
+	}
+
+	u, err := url.Parse(rec.Request.URL)
+	if err != nil {
+		add(UnknownPath, "Invalid request URL %q", rec.Request.URL)
+		return out
+	}
+	m, values := v.match(u.Path)
+	if m == nil {
+		add(UnknownPath, "No path in the spec matches %s", u.Path)
+		return out
+	}
+	op := m.item.Operation(rec.Request.Method)
+	if op == nil {
+		add(UnknownOperation, "%s isn't declared for %s", rec.Request.Method, m.tmpl)
+		return out
+	}
+	where := rec.Request.Method + " " + m.tmpl
+
+	// Request parameters, operation level ones override path level ones
+	params := make(map[string]*Parameter)
+	for _, list := range [][]*Parameter{m.item.Parameters, op.Parameters} {
+		for _, p := range list {
+			if p != nil && p.Ref == "" {
+				params[p.In+" "+p.Name] = p
+			}
+		}
+	}
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	query := u.Query()
+	for _, k := range keys {
+		p := params[k]
+		var raw []string
+		switch p.In {
+		case "path":
+			if val, ok := values[p.Name]; ok {
+				if unescaped, err := url.PathUnescape(val); err == nil {
+					val = unescaped
+				}
+				raw = []string{val}
+			}
+		case "query":
+			raw = query[p.Name]
+		case "header":
+			raw = rec.Request.Headers.Values(p.Name)
+		case "cookie":
+			if c, err := (&http.Request{Header: rec.Request.Headers}).Cookie(p.Name); err == nil {
+				raw = []string{c.Value}
+			}
+		}
+		if len(raw) == 0 {
+			if p.Required {
+				add(MissingRequired, "%s: %s parameter %s is required", where, p.In, p.Name)
+			}
+			continue
+		}
+		label := fmt.Sprintf("%s parameter %s", p.In, p.Name)
+		for _, msg := range checkValue(p.Schema, paramValue(p.Schema, raw), label) {
+			out = append(out, Violation{Kind: msg.kind, Message: where + ": " + msg.text})
+		}
+	}
+
+	if body := op.RequestBody; body != nil {
+		if len(rec.Request.Body) == 0 {
+			if body.Required {
+				add(MissingRequired, "%s: request body is required", where)
+			}
+		} else if !rec.Request.Truncated {
+			out = append(out, checkBody(where+": request", body.Content, rec.Request.Headers, rec.Request.Body, "req.json")...)
+		}
+	}
+
+	resp := findResponse(op.Responses, rec.Response.Status)
+	if resp == nil {
+		add(UndeclaredStatus, "%s: status %d isn't declared", where, rec.Response.Status)
+		return out
+	}
+	label := fmt.Sprintf("%s: response %d", where, rec.Response.Status)
+	names := make([]string, 0, len(resp.Headers))
+	for name := range resp.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		h := resp.Headers[name]
+		raw := rec.Response.Headers.Values(name)
+		if h == nil || strings.EqualFold(name, "Content-Type") {
+			continue
+		}
+		if len(raw) == 0 {
+			if h.Required {
+				add(MissingRequired, "%s: header %s is required", label, name)
+			}
+			continue
+		}
+		for _, msg := range checkValue(h.Schema, paramValue(h.Schema, raw), "header "+name) {
+			out = append(out, Violation{Kind: msg.kind, Message: label + ": " + msg.text})
+		}
+	}
+	if len(rec.Response.Body) > 0 && !rec.Response.Truncated && len(resp.Content) > 0 {
+		out = append(out, checkBody(label, resp.Content, rec.Response.Headers, rec.Response.Body, "resp.json")...)
+	}
+	return out
+}
+
+func (v *Validator) match(path string) (*pathMatcher, map[string]string) {
+	// Tries the path as is and with each server base path cut off
+	candidates := []string{path}
+	for _, base := range v.bases {
+		if rest, ok := strings.CutPrefix(path, base); ok && (rest == "" || rest[0] == '/') {
+			candidates = append(candidates, "/"+strings.TrimPrefix(rest, "/"))
+		}
+	}
+	for _, p := range candidates {
+		for i := range v.paths {
+			m := &v.paths[i]
+			sub := m.re.FindStringSubmatch(p)
+			if sub == nil {
+				continue
+			}
+			values := make(map[string]string, len(m.names))
+			for j, name := range m.names {
+				values[name] = sub[j+1]
+			}
+			return m, values
+		}
+	}
+	return nil, nil
+}
+
+func findResponse(responses map[string]*Response, status int) *Response {
+	// Exact code first, then a range like 4XX, then default
+	code := strconv.Itoa(status)
+	if r := responses[code]; r != nil {
+		return r
+	}
+	for k, r := range responses {
+		if len(k) == 3 && strings.EqualFold(k[1:], "XX") && k[0] == code[0] {
+			return r
+		}
+	}
+	return responses["default"]
+}
+
+func checkBody(label string, content map[string]*MediaType, headers http.Header, body []byte, root string) []Violation {
+	contentType := headers.Get("Content-Type")
+	media := bodyMediaType(contentType, body)
+	mt, ok := mediaType(content, media)
+	if !ok {
+		declared := make([]string, 0, len(content))
+		for k := range content {
+			declared = append(declared, k)
+		}
+		sort.Strings(declared)
+		return []Violation{{Kind: UndeclaredContent, Message: fmt.Sprintf("%s content type %s isn't declared (%s)", label, media, strings.Join(declared, ", "))}}
+	}
+	if mt == nil || mt.Schema == nil || !(media == "application/json" || strings.HasSuffix(media, "+json")) {
+		return nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		return []Violation{{Kind: SchemaMismatch, Message: fmt.Sprintf("%s body isn't valid JSON: %v", label, err)}}
+	}
+	var out []Violation
+	for _, msg := range checkValue(mt.Schema, doc, root) {
+		out = append(out, Violation{Kind: msg.kind, Message: label + ": " + msg.text})
+	}
+	return out
+}
+
+func mediaType(content map[string]*MediaType, media string) (*MediaType, bool) {
+	// Exact media type, then type/*, then */*. No content declared accepts anything.
+	if len(content) == 0 {
+		return nil, true
+	}
+	if mt, ok := content[media]; ok {
+		return mt, true
+	}
+	for k, mt := range content {
+		if k2, _, err := mime.ParseMediaType(k); err == nil && k2 == media {
+			return mt, true
+		}
+	}
+	if major, _, ok := strings.Cut(media, "/"); ok {
+		if mt, ok := content[major+"/*"]; ok {
+			return mt, true
+		}
+	}
+	mt, ok := content["*/*"]
+	return mt, ok
+}
+
+// ------------
+
+// problem is a schema violation before it is labeled with where it happened.
+type problem struct {
+	kind, text string
+}
+
+func paramValue(s *Schema, raw []string) any {
+	// Parameters and headers are text, values are converted to what the schema
+	// expects so they can be checked like JSON. Values that don't convert stay
+	// strings and fail the type check.
+	if s != nil && s.Type == "array" {
+		var parts []string
+		for _, r := range raw {
+			parts = append(parts, strings.Split(r, ",")...)
+		}
+		items := make([]any, len(parts))
+		for i, p := range parts {
+			items[i] = scalarValue(s.Items, p)
+		}
+		return items
+	}
+	return scalarValue(s, raw[0])
+}
+
+func scalarValue(s *Schema, raw string) any {
+	if s == nil {
+		return raw
+	}
+	switch s.Type {
+	case "integer", "number":
+		if _, err := strconv.ParseFloat(raw, 64); err == nil {
+			return json.Number(raw)
+		}
+	case "boolean":
+		if b, err := strconv.ParseBool(raw); err == nil {
+			return b
+		}
+	}
+	return raw
+}
+
+func checkValue(s *Schema, v any, path string) []problem {
+	var out []problem
+	validateValue(s, v, path, &out)
+	return out
+}
+
+func validateValue(s *Schema, v any, path string, out *[]problem) {
+	if s == nil {
+		return
+	}
+	for _, sub := range s.AllOf {
+		validateValue(sub, v, path, out)
+	}
+	if len(s.AnyOf) > 0 && countMatches(s.AnyOf, v, path) == 0 {
+		*out = append(*out, problem{SchemaMismatch, fmt.Sprintf("%s matches none of the anyOf schemas", path)})
+	}
+	if len(s.OneOf) > 0 {
+		if n := countMatches(s.OneOf, v, path); n != 1 {
+			*out = append(*out, problem{SchemaMismatch, fmt.Sprintf("%s matches %d of the oneOf schemas, expected exactly one", path, n)})
+		}
+	}
+
+	types := s.types
+	if s.Type != "" {
+		types = []string{s.Type}
+	}
+	if v == nil {
+		if len(types) > 0 && !s.Nullable {
+			*out = append(*out, problem{SchemaMismatch, fmt.Sprintf("%s: expected %s, got null", path, strings.Join(types, " or "))})
+		}
+		return
+	}
+	if len(types) > 0 && !hasType(types, v) {
+		*out = append(*out, problem{SchemaMismatch, fmt.Sprintf("%s: expected %s, got %s", path, strings.Join(types, " or "), describe(v))})
+		return
+	}
+	if len(s.Enum) > 0 && !inEnum(s.Enum, v) {
+		*out = append(*out, problem{SchemaMismatch, fmt.Sprintf("%s: %s isn't one of the allowed values", path, describe(v))})
+	}
+
+	switch v := v.(type) {
+	case map[string]any:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				*out = append(*out, problem{MissingRequired, fmt.Sprintf("%s.%s is required", path, name)})
+			}
+		}
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if prop, ok := s.Properties[name]; ok {
+				validateValue(prop, v[name], path+"."+name, out)
+				continue
+			}
+			switch extra := s.AdditionalProperties; {
+			case extra == nil:
+			case extra.Forbidden:
+				*out = append(*out, problem{SchemaMismatch, fmt.Sprintf("%s.%s isn't an allowed property", path, name)})
+			default:
+				validateValue(extra.Schema, v[name], path+"."+name, out)
+			}
+		}
+	case []any:
+		for i, item := range v {
+			validateValue(s.Items, item, path+"."+strconv.Itoa(i), out)
+		}
+	}
+}
+
+func countMatches(schemas []*Schema, v any, path string) int {
+	n := 0
+	for _, sub := range schemas {
+		if len(checkValue(sub, v, path)) == 0 {
+			n++
+		}
+	}
+	return n
+}
+
+func hasType(types []string, v any) bool {
+	for _, t := range types {
+		switch v := v.(type) {
+		case map[string]any:
+			if t == "object" {
+				return true
+			}
+		case []any:
+			if t == "array" {
+				return true
+			}
+		case string:
+			if t == "string" {
+				return true
+			}
+		case bool:
+			if t == "boolean" {
+				return true
+			}
+		case json.Number:
+			if t == "number" {
+				return true
+			}
+			if f, err := v.Float64(); t == "integer" && err == nil && f == math.Trunc(f) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func inEnum(enum []any, v any) bool {
+	key := enumKey(v)
+	for _, e := range enum {
+		if enumKey(e) == key {
+			return true
+		}
+	}
+	return false
+}
+
+func enumKey(v any) string {
+	// Numbers from the spec and from bodies decode to different types, compare their values
+	switch n := v.(type) {
+	case json.Number:
+		if f, err := n.Float64(); err == nil {
+			return "n:" + strconv.FormatFloat(f, 'g', -1, 64)
+		}
+	case int:
+		return "n:" + strconv.FormatFloat(float64(n), 'g', -1, 64)
+	case float64:
+		return "n:" + strconv.FormatFloat(n, 'g', -1, 64)
+	case string:
+		return "s:" + n
+	}
+	return fmt.Sprintf("%T:%v", v, v)
+}
+
+func describe(v any) string {
+	switch v := v.(type) {
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case string:
+		if len(v) > 40 {
+			v = v[:40] + "..."
+		}
+		return strconv.Quote(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,61 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/BarrettBr/RWND/internal/fault"
+)
+
+func (c *capture) injectRequestFaults(w http.ResponseWriter, r *http.Request, l Logger) bool {
+	// Applies the faults that happen before the request is forwarded: latency,
+	// a made up status and dropping the request. It reports whether the request
+	// was answered here
+	p := c.faults
+	if p.Latency > 0 {
+		t := time.NewTimer(p.Latency)
+		select {
+		case <-t.C:
+		case <-r.Context().Done():
+			// Client gave up, forwarding fails and ErrorHandler records it
+			t.Stop()
+		}
+	}
+
+	switch {
+	case p.Status != 0:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(p.Status)
+		_, _ = io.WriteString(w, p.Body)
+
+		c.rec.Response.Status = p.Status
+		c.rec.Response.Headers = w.Header().Clone()
+		c.rec.Response.Body = []byte(p.Body)
+		c.rec.Timestamp = time.Now().UTC()
+		c.rec.Latency = time.Since(c.start)
+		c.log(l, nil)
+		return true
+	case p.Drop == fault.DropRequest:
+		c.rec.Timestamp = time.Now().UTC()
+		c.rec.Latency = time.Since(c.start)
+		c.log(l, nil)
+		// Closes the client connection without an answer
+		panic(http.ErrAbortHandler)
+	}
+	return false
+}
+
+func tamperResponse(resp *http.Response, p *fault.Plan) error {
+	// Replaces the upstream body with the corrupted or truncated one. The
+	// upstream Content-Length is left alone, so a truncated body ends the
+	// connection early like a real cut would
+	data, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(p.TamperBody(data)))
+	return nil
+}
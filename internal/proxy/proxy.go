@@ -11,6 +11,8 @@ import (
 	"net/url"
 	"time"
 
+	"github.com/BarrettBr/RWND/internal/diff"
+	"github.com/BarrettBr/RWND/internal/fault"
 	"github.com/BarrettBr/RWND/internal/model"
 )
 
@@ -24,11 +26,24 @@ type Options struct {
 	ListenAddr string
 	Target     *url.URL
 	Logger     Logger
+
+	RedactHeaders []string // Header values replaced in the record, traffic is untouched
+	MaxBodyBytes  int64    // Body capture limit per request/response, 0 captures everything
+
+	Shadow      *url.URL   // Every request is also mirrored here and both responses are recorded
+	ShadowQueue int        // Mirrored requests waiting for the shadow, more are dropped. 0 uses 100
+	ShadowDiff  diff.Rules // Fields ignored when comparing the shadow response to the primary one
+
+	Faults *fault.Injector // Injects failures into matching requests, nil forwards everything untouched
 }
 
+// redactedValue replaces the values of redacted headers in records.
+const redactedValue = "[REDACTED]"
+
 // Proxy is a reverse proxy server that records traffic.
 type Proxy struct {
-	srv *http.Server
+	srv    *http.Server
+	shadow *shadower
 }
 
 // New constructs a Proxy using the provided options.
@@ -45,6 +60,10 @@ func New(opts Options) (*Proxy, error) {
 	}
 
 	rp := httputil.NewSingleHostReverseProxy(opts.Target)
+	var shadow *shadower
+	if opts.Shadow != nil {
+		shadow = newShadower(opts)
+	}
 
 	// Capture / Log the response inside the same record that the request came from
 	rp.ModifyResponse = func(resp *http.Response) error {
@@ -58,21 +77,33 @@ func New(opts Options) (*Proxy, error) {
 			return nil
 		}
 
-		// Capture body, close stream and then recreate it since it was a stream it will be gone upon read
-		bodyBytes, err := io.ReadAll(resp.Body)
+		if cap.faults != nil && cap.faults.TampersBody() {
+			if err := tamperResponse(resp, cap.faults); err != nil {
+				return err
+			}
+		}
+
+		// Capture body and then recreate it since it was a stream it will be gone upon read
+		bodyBytes, body, truncated, err := captureBody(resp.Body, opts.MaxBodyBytes)
 		if err != nil {
 			return err
 		}
-		_ = resp.Body.Close()
-		resp.Body = io.NopCloser(bytes.NewReader(bodyBytes))
+		resp.Body = body
 
 		cap.rec.Response.Status = resp.StatusCode
-		cap.rec.Response.Headers = resp.Header.Clone()
+		cap.rec.Response.Headers = redact(resp.Header.Clone(), opts.RedactHeaders)
 		cap.rec.Response.Body = bodyBytes
+		cap.rec.Response.Truncated = truncated
 		cap.rec.Timestamp = time.Now().UTC()
+		cap.rec.Latency = time.Since(cap.start)
 
-		opts.Logger.Log(cap.rec)
+		cap.log(opts.Logger, shadow)
 
+		if cap.faults != nil && cap.faults.Drop == fault.DropResponse {
+			// Closes the client connection instead of answering
+			_ = resp.Body.Close()
+			panic(http.ErrAbortHandler)
+		}
 		return nil
 	}
 
@@ -84,7 +115,8 @@ func New(opts Options) (*Proxy, error) {
 			cap.rec.Response.Status = http.StatusBadGateway
 			cap.rec.Response.Body = []byte(err.Error())
 			cap.rec.Timestamp = time.Now().UTC()
-			opts.Logger.Log(cap.rec)
+			cap.rec.Latency = time.Since(cap.start)
+			cap.log(opts.Logger, shadow)
 		}
 		http.Error(w, "bad gateway", http.StatusBadGateway)
 	}
@@ -93,15 +125,15 @@ func New(opts Options) (*Proxy, error) {
 
 	// Handle request logging
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
 		// Read and copy body like we did with responses above
 		// however request bodies are optional so we guard clause it
 		var reqBody []byte
+		var reqTruncated bool
 		if r.Body != nil {
-			reqBody, _ = io.ReadAll(r.Body)
-			_ = r.Body.Close()
-
 			// Restore the request body so the upstream STILL receives it
-			r.Body = io.NopCloser(bytes.NewReader(reqBody))
+			reqBody, r.Body, reqTruncated, _ = captureBody(r.Body, opts.MaxBodyBytes)
 		}
 
 		// Create a record
@@ -116,10 +148,21 @@ func New(opts Options) (*Proxy, error) {
 		if r.Host != "" {
 			rec.Request.Headers.Set("Host", r.Host)
 		}
+		rec.Request.Headers = redact(rec.Request.Headers, opts.RedactHeaders)
 		rec.Request.Body = reqBody
+		rec.Request.Truncated = reqTruncated
 
 		// Attach record to context of the request
-		cap := &capture{rec: rec}
+		cap := &capture{rec: rec, start: start}
+		if opts.Faults != nil {
+			cap.faults = opts.Faults.Pick(rec)
+			if cap.faults != nil && cap.injectRequestFaults(w, r, opts.Logger) {
+				return
+			}
+		}
+		if shadow != nil {
+			shadow.mirror(cap, r)
+		}
 		ctx := context.WithValue(r.Context(), captureKey{}, cap)
 		rp.ServeHTTP(w, r.WithContext(ctx))
 	})
@@ -130,7 +173,7 @@ func New(opts Options) (*Proxy, error) {
 		ReadHeaderTimeout: 5 * time.Second,
 	}
 
-	return &Proxy{srv: server}, nil
+	return &Proxy{srv: server, shadow: shadow}, nil
 }
 
 // Run starts the proxy server.
@@ -153,7 +196,46 @@ func (p *Proxy) Shutdown(ctx context.Context) error {
 		return nil
 	}
 
-	return p.srv.Shutdown(ctx)
+	err := p.srv.Shutdown(ctx)
+	if p.shadow != nil {
+		p.shadow.close()
+	}
+	return err
+}
+
+func captureBody(body io.ReadCloser, limit int64) ([]byte, io.ReadCloser, bool, error) {
+	// Reads a body for the record and returns a replacement that still yields every byte.
+	// With a limit only the first limit bytes are kept in memory, the rest streams through.
+	if limit <= 0 {
+		data, err := io.ReadAll(body)
+		_ = body.Close()
+		return data, io.NopCloser(bytes.NewReader(data)), false, err
+	}
+
+	data, err := io.ReadAll(io.LimitReader(body, limit+1))
+	if err != nil {
+		_ = body.Close()
+		return nil, io.NopCloser(bytes.NewReader(data)), false, err
+	}
+	if int64(len(data)) <= limit {
+		_ = body.Close()
+		return data, io.NopCloser(bytes.NewReader(data)), false, nil
+	}
+
+	rest := struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(data), body), body}
+	return data[:limit], rest, true, nil
+}
+
+func redact(headers http.Header, names []string) http.Header {
+	for _, name := range names {
+		if _, ok := headers[http.CanonicalHeaderKey(name)]; ok {
+			headers.Set(name, redactedValue)
+		}
+	}
+	return headers
 }
 
 // Internal types used for context capture
@@ -161,5 +243,21 @@ func (p *Proxy) Shutdown(ctx context.Context) error {
 type captureKey struct{}
 
 type capture struct {
-	rec model.Record
+	rec    model.Record
+	start  time.Time
+	shadow *shadowJob  // Set when the request was mirrored, the record is logged once both responses are in
+	faults *fault.Plan // Faults picked for the request, nil when none apply
+}
+
+func (c *capture) log(l Logger, s *shadower) {
+	if c.faults != nil && len(c.faults.Faults()) > 0 {
+		c.rec.Faults = c.faults.Faults()
+		c.rec.AddTag(fault.Tag)
+	}
+	if c.shadow != nil {
+		rec := c.rec
+		s.join(c.shadow, &rec, nil)
+		return
+	}
+	l.Log(c.rec)
 }
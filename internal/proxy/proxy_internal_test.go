@@ -2,12 +2,15 @@ package proxy
 
 import (
 	"bytes"
+	"context"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"testing"
 	"time"
 
+	"github.com/BarrettBr/RWND/internal/fault"
 	"github.com/BarrettBr/RWND/internal/model"
 )
 
@@ -72,3 +75,227 @@ func TestProxy_LogsRecord(t *testing.T) {
 		t.Fatalf("timed out waiting for log record")
 	}
 }
+
+func TestProxy_RedactsAndTruncates(t *testing.T) {
+	var upstreamBody string
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		upstreamBody = string(data)
+		_, _ = w.Write([]byte("0123456789"))
+	}))
+	defer target.Close()
+
+	targetURL, err := url.Parse(target.URL)
+	if err != nil {
+		t.Fatalf("parse target: %v", err)
+	}
+
+	logger := &captureLogger{recCh: make(chan model.Record, 1)}
+	pxy, err := New(Options{
+		ListenAddr:    ":0",
+		Target:        targetURL,
+		Logger:        logger,
+		RedactHeaders: []string{"authorization"},
+		MaxBodyBytes:  4,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/test", bytes.NewBufferString("abcdefgh"))
+	req.Header.Set("Authorization", "Bearer secret")
+	rr := httptest.NewRecorder()
+
+	pxy.srv.Handler.ServeHTTP(rr, req)
+
+	if rr.Body.String() != "0123456789" {
+		t.Fatalf("client should receive the full body, got %q", rr.Body.String())
+	}
+	if upstreamBody != "abcdefgh" {
+		t.Fatalf("upstream should receive the full body, got %q", upstreamBody)
+	}
+
+	select {
+	case rec := <-logger.recCh:
+		if got := rec.Request.Headers.Get("Authorization"); got != redactedValue {
+			t.Fatalf("expected redacted Authorization, got %q", got)
+		}
+		if string(rec.Request.Body) != "abcd" || !rec.Request.Truncated {
+			t.Fatalf("expected truncated request body, got %q truncated=%v", rec.Request.Body, rec.Request.Truncated)
+		}
+		if string(rec.Response.Body) != "0123" || !rec.Response.Truncated {
+			t.Fatalf("expected truncated response body, got %q truncated=%v", rec.Response.Body, rec.Response.Truncated)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for log record")
+	}
+}
+
+func TestProxy_MirrorsToShadow(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"total":10}`))
+	}))
+	defer target.Close()
+	var shadowGot string
+	var shadowHeaders http.Header
+	shadowSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		shadowGot = r.Method + " " + r.URL.String() + " " + string(data)
+		shadowHeaders = r.Header.Clone()
+		_, _ = w.Write([]byte(`{"total":"10.0"}`))
+	}))
+	defer shadowSrv.Close()
+
+	targetURL, _ := url.Parse(target.URL)
+	shadowURL, _ := url.Parse(shadowSrv.URL)
+	logger := &captureLogger{recCh: make(chan model.Record, 1)}
+	pxy, err := New(Options{
+		ListenAddr: ":0",
+		Target:     targetURL,
+		Logger:     logger,
+		Shadow:     shadowURL,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer pxy.shadow.close()
+
+	req := httptest.NewRequest(http.MethodPost, "/orders?x=1", bytes.NewBufferString("ping"))
+	req.Header.Set("Connection", "X-Hop")
+	req.Header.Set("X-Hop", "1")
+	req.Header.Set("Keep-Alive", "timeout=5")
+	req.Header.Set("Proxy-Authorization", "Basic c2VjcmV0")
+	req.Header.Set("X-Kept", "1")
+	rr := httptest.NewRecorder()
+	pxy.srv.Handler.ServeHTTP(rr, req)
+	if rr.Body.String() != `{"total":10}` {
+		t.Fatalf("client should get the primary response, got %q", rr.Body.String())
+	}
+
+	select {
+	case rec := <-logger.recCh:
+		if shadowGot != "POST /orders?x=1 ping" {
+			t.Fatalf("Unexpected mirrored request %q", shadowGot)
+		}
+		for _, name := range []string{"X-Hop", "Keep-Alive", "Proxy-Authorization"} {
+			if shadowHeaders.Get(name) != "" {
+				t.Fatalf("Hop-by-hop header %s was mirrored", name)
+			}
+		}
+		if shadowHeaders.Get("X-Kept") != "1" {
+			t.Fatalf("Expected end-to-end headers to be mirrored, got %v", shadowHeaders)
+		}
+		if rec.Shadow == nil || rec.Shadow.Target != shadowSrv.URL || string(rec.Shadow.Response.Body) != `{"total":"10.0"}` {
+			t.Fatalf("Expected the shadow response in the record, got %+v", rec.Shadow)
+		}
+		if len(rec.Shadow.Differences) != 1 || !rec.HasTag(ShadowMismatchTag) {
+			t.Fatalf("Expected one flagged difference, got %v tags %v", rec.Shadow.Differences, rec.Tags)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for log record")
+	}
+}
+
+func TestProxy_ShadowAfterTimedOutShutdown(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("pong"))
+	}))
+	defer target.Close()
+	targetURL, _ := url.Parse(target.URL)
+
+	logger := &captureLogger{recCh: make(chan model.Record, 1)}
+	pxy, err := New(Options{
+		ListenAddr: ":0",
+		Target:     targetURL,
+		Logger:     logger,
+		Shadow:     targetURL,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	// The handler is stuck reading the request body when Shutdown gives up
+	body, write := io.Pipe()
+	req := httptest.NewRequest(http.MethodPost, "/orders", body)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		pxy.srv.Handler.ServeHTTP(httptest.NewRecorder(), req)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	<-ctx.Done()
+	_ = pxy.Shutdown(ctx)
+
+	_, _ = write.Write([]byte("ping"))
+	_ = write.Close()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for the handler")
+	}
+
+	select {
+	case rec := <-logger.recCh:
+		if rec.Shadow == nil || rec.Shadow.Error == "" || rec.Response.Status != http.StatusOK {
+			t.Fatalf("Expected the request served and marked not mirrored, got %d %+v", rec.Response.Status, rec.Shadow)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for log record")
+	}
+}
+
+func TestProxy_InjectsFaults(t *testing.T) {
+	upstreamHits := 0
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamHits++
+		_, _ = w.Write([]byte("0123456789"))
+	}))
+	defer target.Close()
+
+	file, err := fault.Parse([]byte(`
+faults:
+  - name: down
+    when: method = POST
+    status: 503
+  - name: cut
+    when: method = GET
+    truncate: 4
+`))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	targetURL, _ := url.Parse(target.URL)
+	logger := &captureLogger{recCh: make(chan model.Record, 1)}
+	pxy, err := New(Options{
+		ListenAddr: ":0",
+		Target:     targetURL,
+		Logger:     logger,
+		Faults:     fault.NewInjector(file, 1),
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+	pxy.srv.Handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/orders", bytes.NewBufferString("ping")))
+	rec := <-logger.recCh
+	if rr.Code != http.StatusServiceUnavailable || upstreamHits != 0 {
+		t.Fatalf("Expected a 503 without reaching upstream, got %d after %d hits", rr.Code, upstreamHits)
+	}
+	if rec.Response.Status != http.StatusServiceUnavailable || !rec.HasTag(fault.Tag) ||
+		len(rec.Faults) != 1 || rec.Faults[0] != (model.Fault{Rule: "down", Kind: "status", Detail: "503"}) {
+		t.Fatalf("Unexpected record %d %v %+v", rec.Response.Status, rec.Tags, rec.Faults)
+	}
+
+	rr = httptest.NewRecorder()
+	pxy.srv.Handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/orders", nil))
+	rec = <-logger.recCh
+	if rr.Body.String() != "0123" || string(rec.Response.Body) != "0123" {
+		t.Fatalf("Expected the body cut to 4 bytes, client got %q, record %q", rr.Body.String(), rec.Response.Body)
+	}
+	if len(rec.Faults) != 1 || rec.Faults[0].Detail != "kept 4 of 10 bytes" {
+		t.Fatalf("Unexpected faults %+v", rec.Faults)
+	}
+}
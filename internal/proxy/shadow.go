@@ -0,0 +1,219 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BarrettBr/RWND/internal/diff"
+	"github.com/BarrettBr/RWND/internal/model"
+)
+
+// ShadowMismatchTag is added to records whose shadow response differs from the
+// primary one, so they can be found with --tag or a filter.
+const ShadowMismatchTag = "shadow-mismatch"
+
+const (
+	defaultShadowQueue = 100
+	shadowWorkers      = 4
+	shadowTimeout      = 30 * time.Second
+)
+
+// shadower mirrors requests to a shadow target off the request path. Requests
+// wait in a bounded queue and are dropped when it is full, so a slow shadow
+// never holds up the client.
+type shadower struct {
+	target *url.URL
+	client *http.Client
+	queue  chan *shadowJob
+	opts   Options
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu     sync.Mutex // Guards sends on queue against close
+	closed bool
+}
+
+// shadowJob is one mirrored request. The primary record and the shadow result
+// finish in any order, whichever comes second logs the record.
+type shadowJob struct {
+	method  string
+	url     string
+	headers http.Header
+	body    []byte
+
+	mu     sync.Mutex
+	rec    *model.Record
+	result *model.Shadow
+}
+
+func newShadower(opts Options) *shadower {
+	size := opts.ShadowQueue
+	if size <= 0 {
+		size = defaultShadowQueue
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &shadower{
+		target: opts.Shadow,
+		client: &http.Client{Timeout: shadowTimeout},
+		queue:  make(chan *shadowJob, size),
+		opts:   opts,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	for i := 0; i < shadowWorkers; i++ {
+		s.wg.Add(1)
+		go s.run()
+	}
+	return s
+}
+
+func (s *shadower) mirror(cap *capture, r *http.Request) {
+	// Queues a copy of r without blocking. Requests that can't be mirrored get
+	// the reason recorded instead
+	if cap.rec.Request.Truncated {
+		cap.rec.Shadow = &model.Shadow{Target: s.target.String(), Error: "request body is over the capture limit, not mirrored"}
+		return
+	}
+
+	u := s.target.JoinPath(r.URL.Path)
+	u.RawQuery = r.URL.RawQuery
+	job := &shadowJob{
+		method:  r.Method,
+		url:     u.String(),
+		headers: removeHopHeaders(r.Header.Clone()),
+		body:    cap.rec.Request.Body,
+	}
+
+	// A Shutdown that timed out closes the queue while handlers still run
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		cap.rec.Shadow = &model.Shadow{Target: s.target.String(), Error: "proxy shut down before the request was mirrored"}
+		return
+	}
+	select {
+	case s.queue <- job:
+		cap.shadow = job
+	default:
+		cap.rec.Shadow = &model.Shadow{Target: s.target.String(), Error: "shadow queue is full, not mirrored"}
+	}
+}
+
+func (s *shadower) run() {
+	defer s.wg.Done()
+	for job := range s.queue {
+		s.join(job, nil, s.send(job))
+	}
+}
+
+func (s *shadower) send(job *shadowJob) *model.Shadow {
+	out := &model.Shadow{Target: s.target.String()}
+	if s.ctx.Err() != nil {
+		out.Error = "proxy shut down before the request was mirrored"
+		return out
+	}
+
+	req, err := http.NewRequestWithContext(s.ctx, job.method, job.url, bytes.NewReader(job.body))
+	if err != nil {
+		out.Error = err.Error()
+		return out
+	}
+	req.Header = job.headers
+
+	start := time.Now()
+	resp, err := s.client.Do(req)
+	if err != nil {
+		out.Latency = time.Since(start)
+		out.Error = err.Error()
+		return out
+	}
+	body, rest, truncated, err := captureBody(resp.Body, s.opts.MaxBodyBytes)
+	_ = rest.Close()
+	out.Latency = time.Since(start)
+	if err != nil {
+		out.Error = err.Error()
+		return out
+	}
+	out.Response = model.Response{
+		Status:    resp.StatusCode,
+		Headers:   redact(resp.Header.Clone(), s.opts.RedactHeaders),
+		Body:      body,
+		Truncated: truncated,
+	}
+	return out
+}
+
+func (s *shadower) join(job *shadowJob, rec *model.Record, result *model.Shadow) {
+	job.mu.Lock()
+	if rec != nil {
+		job.rec = rec
+	}
+	if result != nil {
+		job.result = result
+	}
+	ready := job.rec != nil && job.result != nil
+	job.mu.Unlock()
+	if !ready {
+		return
+	}
+
+	out := *job.rec
+	if job.result.Error == "" {
+		for _, d := range diff.Compare(out.Response, job.result.Response, s.opts.ShadowDiff) {
+			job.result.Differences = append(job.result.Differences, d.String())
+		}
+		if len(job.result.Differences) > 0 {
+			out.AddTag(ShadowMismatchTag)
+		}
+	}
+	out.Shadow = job.result
+	s.opts.Logger.Log(out)
+}
+
+func (s *shadower) close() {
+	// Stops accepting requests and cancels the ones in flight. Queued records
+	// are still logged, with the shadow marked as not mirrored
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.queue)
+	}
+	s.mu.Unlock()
+	s.cancel()
+	s.wg.Wait()
+}
+
+// hopHeaders only apply to one connection, the reverse proxy drops them as well.
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+func removeHopHeaders(h http.Header) http.Header {
+	// Headers named in Connection are hop-by-hop too
+	for _, value := range h.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			if name = textproto.TrimString(name); name != "" {
+				h.Del(name)
+			}
+		}
+	}
+	for _, name := range hopHeaders {
+		h.Del(name)
+	}
+	return h
+}
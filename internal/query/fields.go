@@ -0,0 +1,339 @@
+package query
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BarrettBr/RWND/internal/model"
+)
+
+func compileCond(field, orig, op, value string) (condNode, error) {
+	// field is lower cased for lookup, orig keeps the case of header names and JSON paths
+	switch field {
+	case "status":
+		iv, err := parseRange(value, parseStatus)
+		if err != nil {
+			return nil, err
+		}
+		return orderedCond(op, iv, func(rec model.Record) int64 { return int64(rec.Response.Status) })
+	case "id":
+		iv, err := parseRange(value, parseInt)
+		if err != nil {
+			return nil, err
+		}
+		return orderedCond(op, iv, func(rec model.Record) int64 { return int64(rec.ID) })
+	case "latency":
+		iv, err := parseRange(value, parseLatency)
+		if err != nil {
+			return nil, err
+		}
+		return orderedCond(op, iv, func(rec model.Record) int64 { return int64(rec.Latency) })
+	case "time":
+		iv, err := parseRange(value, parseTime)
+		if err != nil {
+			return nil, err
+		}
+		return orderedCond(op, iv, func(rec model.Record) int64 { return rec.Timestamp.UnixNano() })
+	}
+
+	if path, ok := cutPrefixFold(orig, "req.json."); ok {
+		return jsonCond(op, value, strings.Split(path, "."), func(rec model.Record) []byte { return rec.Request.Body })
+	}
+	if path, ok := cutPrefixFold(orig, "resp.json."); ok {
+		return jsonCond(op, value, strings.Split(path, "."), func(rec model.Record) []byte { return rec.Response.Body })
+	}
+
+	values, err := textField(field, orig)
+	if err != nil {
+		return nil, err
+	}
+	match, err := textMatcher(op, value, field == "method")
+	if err != nil {
+		return nil, err
+	}
+	return func(rec model.Record) bool {
+		for _, v := range values(rec) {
+			if match(v) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+func textField(field, orig string) (func(model.Record) []string, error) {
+	// Returns the values of a text field, a record matches if any of them does
+	if name, ok := cutPrefixFold(orig, "req.header."); ok {
+		return func(rec model.Record) []string { return headerValues(rec.Request.Headers, name) }, nil
+	}
+	if name, ok := cutPrefixFold(orig, "resp.header."); ok {
+		return func(rec model.Record) []string { return headerValues(rec.Response.Headers, name) }, nil
+	}
+
+	one := func(get func(model.Record) string) func(model.Record) []string {
+		return func(rec model.Record) []string { return []string{get(rec)} }
+	}
+	part := func(get func(*url.URL) string) func(model.Record) []string {
+		return func(rec model.Record) []string {
+			u, err := url.Parse(rec.Request.URL)
+			if err != nil {
+				return nil
+			}
+			return []string{get(u)}
+		}
+	}
+
+	switch field {
+	case "method":
+		return one(func(rec model.Record) string { return rec.Request.Method }), nil
+	case "url":
+		return one(func(rec model.Record) string { return rec.Request.URL }), nil
+	case "scheme":
+		return part(func(u *url.URL) string { return u.Scheme }), nil
+	case "host":
+		return part(func(u *url.URL) string { return u.Host }), nil
+	case "path":
+		return part(func(u *url.URL) string { return u.Path }), nil
+	case "query":
+		return part(func(u *url.URL) string { return u.RawQuery }), nil
+	case "req.body":
+		return one(func(rec model.Record) string { return string(rec.Request.Body) }), nil
+	case "resp.body":
+		return one(func(rec model.Record) string { return string(rec.Response.Body) }), nil
+	case "tag":
+		return func(rec model.Record) []string { return rec.Tags }, nil
+	default:
+		return nil, fmt.Errorf("unknown field")
+	}
+}
+
+func textMatcher(op, value string, fold bool) (func(string) bool, error) {
+	switch op {
+	case "=":
+		if fold {
+			return func(s string) bool { return strings.EqualFold(s, value) }, nil
+		}
+		return func(s string) bool { return s == value }, nil
+	case "contains":
+		return func(s string) bool { return strings.Contains(s, value) }, nil
+	case "~":
+		re, err := regexp.Compile(value)
+		if err != nil {
+			return nil, err
+		}
+		return re.MatchString, nil
+	default:
+		return nil, fmt.Errorf("%s doesn't work on text, use = != ~ !~ or contains", op)
+	}
+}
+
+func headerValues(headers map[string][]string, name string) []string {
+	var out []string
+	for k, values := range headers {
+		if strings.EqualFold(k, name) {
+			out = append(out, values...)
+		}
+	}
+	return out
+}
+
+func cutPrefixFold(s, prefix string) (string, bool) {
+	if len(s) > len(prefix) && strings.EqualFold(s[:len(prefix)], prefix) {
+		return s[len(prefix):], true
+	}
+	return "", false
+}
+
+// ------------
+
+// interval is an inclusive range of field values. A single value is an interval too,
+// 4xx covers 400-499 and a date covers the whole day.
+type interval struct {
+	lo, hi int64
+}
+
+func parseRange(value string, parse func(string) (interval, error)) (interval, error) {
+	lo, hi, ok := strings.Cut(value, "..")
+	if !ok {
+		return parse(value)
+	}
+	from, err := parse(lo)
+	if err != nil {
+		return interval{}, err
+	}
+	to, err := parse(hi)
+	if err != nil {
+		return interval{}, err
+	}
+	return interval{lo: from.lo, hi: to.hi}, nil
+}
+
+func orderedCond(op string, iv interval, get func(model.Record) int64) (condNode, error) {
+	var cmp func(v int64) bool
+	switch op {
+	case "=":
+		cmp = func(v int64) bool { return v >= iv.lo && v <= iv.hi }
+	case "<":
+		cmp = func(v int64) bool { return v < iv.lo }
+	case "<=":
+		cmp = func(v int64) bool { return v <= iv.hi }
+	case ">":
+		cmp = func(v int64) bool { return v > iv.hi }
+	case ">=":
+		cmp = func(v int64) bool { return v >= iv.lo }
+	default:
+		return nil, fmt.Errorf("%s doesn't work on this field, use = != < <= > or >=", op)
+	}
+	return func(rec model.Record) bool { return cmp(get(rec)) }, nil
+}
+
+func parseStatus(s string) (interval, error) {
+	if len(s) == 3 && strings.EqualFold(s[1:], "xx") {
+		d, err := strconv.Atoi(s[:1])
+		if err != nil {
+			return interval{}, fmt.Errorf("invalid status %q", s)
+		}
+		return interval{lo: int64(d) * 100, hi: int64(d)*100 + 99}, nil
+	}
+	return parseInt(s)
+}
+
+func parseInt(s string) (interval, error) {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return interval{}, fmt.Errorf("invalid number %q", s)
+	}
+	return interval{lo: n, hi: n}, nil
+}
+
+func parseLatency(s string) (interval, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return interval{}, fmt.Errorf("invalid duration %q", s)
+	}
+	return interval{lo: int64(d), hi: int64(d)}, nil
+}
+
+// timeLayouts are the accepted time values, each with the span one value covers.
+var timeLayouts = []struct {
+	layout string
+	span   time.Duration
+}{
+	{time.RFC3339Nano, 0},
+	{"2006-01-02T15:04:05", time.Second},
+	{"2006-01-02T15:04", time.Minute},
+	{"2006-01-02", 24 * time.Hour},
+}
+
+func parseTime(s string) (interval, error) {
+	// Times without a zone are local
+	for _, l := range timeLayouts {
+		t, err := time.ParseInLocation(l.layout, s, time.Local)
+		if err != nil {
+			continue
+		}
+		start := t.UnixNano()
+		if l.span == 0 {
+			return interval{lo: start, hi: start}, nil
+		}
+		return interval{lo: start, hi: t.Add(l.span).UnixNano() - 1}, nil
+	}
+	return interval{}, fmt.Errorf("invalid time %q, use 2006-01-02T15:04:05 or a prefix of it", s)
+}
+
+// ------------
+
+func jsonCond(op, value string, path []string, body func(model.Record) []byte) (condNode, error) {
+	// Numbers in the body compare as numbers, everything else as its JSON text
+	text, err := textMatcher(op, value, false)
+	if err != nil && !isOrderOp(op) {
+		return nil, err
+	}
+	num, numErr := strconv.ParseFloat(value, 64)
+	if isOrderOp(op) && op != "=" && numErr != nil {
+		return nil, fmt.Errorf("%s needs a number, got %q", op, value)
+	}
+
+	return func(rec model.Record) bool {
+		var doc any
+		if json.Unmarshal(body(rec), &doc) != nil {
+			return false
+		}
+		for _, v := range walkJSON(doc, path) {
+			if n, ok := v.(float64); ok && isOrderOp(op) && numErr == nil {
+				if compareFloat(op, n, num) {
+					return true
+				}
+				continue
+			}
+			if text != nil && text(jsonText(v)) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+func isOrderOp(op string) bool {
+	switch op {
+	case "=", "<", "<=", ">", ">=":
+		return true
+	}
+	return false
+}
+
+func compareFloat(op string, a, b float64) bool {
+	switch op {
+	case "=":
+		return a == b
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	case ">":
+		return a > b
+	default:
+		return a >= b
+	}
+}
+
+func jsonText(v any) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+func walkJSON(v any, path []string) []any {
+	// Returns every value at path, * matches any key or index
+	if len(path) == 0 {
+		return []any{v}
+	}
+	key, rest := path[0], path[1:]
+	var out []any
+	switch node := v.(type) {
+	case map[string]any:
+		if key == "*" {
+			for _, child := range node {
+				out = append(out, walkJSON(child, rest)...)
+			}
+		} else if child, ok := node[key]; ok {
+			out = walkJSON(child, rest)
+		}
+	case []any:
+		if key == "*" {
+			for _, child := range node {
+				out = append(out, walkJSON(child, rest)...)
+			}
+		} else if i, err := strconv.Atoi(key); err == nil && i >= 0 && i < len(node) {
+			out = walkJSON(node[i], rest)
+		}
+	}
+	return out
+}
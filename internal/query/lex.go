@@ -0,0 +1,96 @@
+package query
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type tokKind int
+
+const (
+	tokEOF tokKind = iota
+	tokWord
+	tokString
+	tokOp
+	tokLParen
+	tokRParen
+)
+
+type token struct {
+	kind tokKind
+	text string
+	pos  int
+}
+
+func (t token) isKeyword(kw string) bool {
+	return t.kind == tokWord && strings.EqualFold(t.text, kw)
+}
+
+const opChars = "=!<>~"
+
+func lex(src string) ([]token, error) {
+	var toks []token
+	i := 0
+	for i < len(src) {
+		c := src[i]
+		switch {
+		case unicode.IsSpace(rune(c)):
+			i++
+		case c == '(':
+			toks = append(toks, token{kind: tokLParen, text: "(", pos: i})
+			i++
+		case c == ')':
+			toks = append(toks, token{kind: tokRParen, text: ")", pos: i})
+			i++
+		case c == '"':
+			end, err := quotedEnd(src, i)
+			if err != nil {
+				return nil, err
+			}
+			text, err := strconv.Unquote(src[i:end])
+			if err != nil {
+				return nil, fmt.Errorf("Invalid filter: bad string at offset %d: %v", i, err)
+			}
+			toks = append(toks, token{kind: tokString, text: text, pos: i})
+			i = end
+		case strings.IndexByte(opChars, c) >= 0:
+			op, err := lexOp(src, i)
+			if err != nil {
+				return nil, err
+			}
+			toks = append(toks, token{kind: tokOp, text: op, pos: i})
+			i += len(op)
+		default:
+			start := i
+			for i < len(src) && !unicode.IsSpace(rune(src[i])) && !strings.ContainsRune("()\""+opChars, rune(src[i])) {
+				i++
+			}
+			toks = append(toks, token{kind: tokWord, text: src[start:i], pos: start})
+		}
+	}
+	return append(toks, token{kind: tokEOF, text: "end of filter", pos: len(src)}), nil
+}
+
+func quotedEnd(src string, start int) (int, error) {
+	// Returns the offset just past the closing quote of the string at start
+	for i := start + 1; i < len(src); i++ {
+		switch src[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1, nil
+		}
+	}
+	return 0, fmt.Errorf("Invalid filter: unterminated string at offset %d", start)
+}
+
+func lexOp(src string, i int) (string, error) {
+	for _, op := range []string{"!=", "!~", "<=", ">=", "=", "~", "<", ">"} {
+		if strings.HasPrefix(src[i:], op) {
+			return op, nil
+		}
+	}
+	return "", fmt.Errorf("Invalid filter: unknown operator at offset %d", i)
+}
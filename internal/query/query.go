@@ -0,0 +1,202 @@
+// Package query parses filter expressions over recorded traffic.
+//
+// An expression is a list of conditions joined with and, or, not and parentheses.
+// Conditions next to each other are joined with and:
+//
+//	method = POST path ~ ^/orders and status >= 500
+//	(tag = auth or resp.header.WWW-Authenticate ~ Bearer) and not status = 2xx
+//	resp.json.items.*.state = failed time = 2025-01-01T10:00..2025-01-01T11:00
+//
+// A condition is a field, an operator and a value. Values with spaces, parentheses
+// or operator characters are quoted like Go strings.
+//
+//	method, url, scheme, host, path, query    Request line parts
+//	req.header.<Name>, resp.header.<Name>     Header values, any value can match
+//	req.body, resp.body                       Bodies as text
+//	req.json.<path>, resp.json.<path>         JSON body values, * matches any key or index
+//	tag                                       Record tags, any tag can match
+//	status, id                                Numbers, status also takes 4xx
+//	latency                                   Durations such as 250ms
+//	time                                      Times such as 2025-01-01T10:00, a date alone is the whole day
+//
+// Text fields take = != ~ (regex) !~ and contains. Number, latency and time fields
+// take = != < <= > >= and a..b ranges.
+package query
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/BarrettBr/RWND/internal/model"
+)
+
+// Filter is a parsed expression.
+type Filter struct {
+	src  string
+	root node
+}
+
+// Parse compiles an expression into a Filter.
+func Parse(expr string) (*Filter, error) {
+	toks, err := lex(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, fmt.Errorf("Invalid filter: unexpected %q at offset %d", t.text, t.pos)
+	}
+	return &Filter{src: expr, root: root}, nil
+}
+
+// Match reports whether rec satisfies the expression.
+func (f *Filter) Match(rec model.Record) bool {
+	return f.root.match(rec)
+}
+
+// String returns the expression the filter was parsed from.
+func (f *Filter) String() string {
+	return f.src
+}
+
+// ------------
+
+type node interface {
+	match(rec model.Record) bool
+}
+
+type andNode struct{ left, right node }
+
+func (n andNode) match(rec model.Record) bool { return n.left.match(rec) && n.right.match(rec) }
+
+type orNode struct{ left, right node }
+
+func (n orNode) match(rec model.Record) bool { return n.left.match(rec) || n.right.match(rec) }
+
+type notNode struct{ inner node }
+
+func (n notNode) match(rec model.Record) bool { return !n.inner.match(rec) }
+
+type condNode func(rec model.Record) bool
+
+func (n condNode) match(rec model.Record) bool { return n(rec) }
+
+// ------------
+
+type parser struct {
+	toks []token
+	i    int
+}
+
+func (p *parser) peek() token { return p.toks[p.i] }
+
+func (p *parser) next() token {
+	t := p.toks[p.i]
+	if t.kind != tokEOF {
+		p.i++
+	}
+	return t
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().isKeyword("or") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		switch {
+		case t.isKeyword("and"):
+			p.next()
+		case t.kind == tokLParen, t.kind == tokWord && !t.isKeyword("or"):
+			// Conditions next to each other are joined with and
+		default:
+			return left, nil
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left, right}
+	}
+}
+
+func (p *parser) parseUnary() (node, error) {
+	t := p.peek()
+	switch {
+	case t.isKeyword("not"):
+		p.next()
+		inner, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{inner}, nil
+	case t.kind == tokLParen:
+		p.next()
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); closing.kind != tokRParen {
+			return nil, fmt.Errorf("Invalid filter: expected ) at offset %d", closing.pos)
+		}
+		return inner, nil
+	}
+	return p.parseCond()
+}
+
+func (p *parser) parseCond() (node, error) {
+	field := p.next()
+	if field.kind != tokWord || field.isKeyword("and") || field.isKeyword("or") {
+		return nil, fmt.Errorf("Invalid filter: expected a field at offset %d", field.pos)
+	}
+
+	op := p.next()
+	switch {
+	case op.kind == tokOp:
+	case op.isKeyword("contains"):
+		op.text = "contains"
+	default:
+		return nil, fmt.Errorf("Invalid filter: expected an operator after %s at offset %d", field.text, op.pos)
+	}
+
+	value := p.next()
+	if value.kind != tokWord && value.kind != tokString {
+		return nil, fmt.Errorf("Invalid filter: expected a value after %s %s at offset %d", field.text, op.text, value.pos)
+	}
+
+	// != and !~ are the negation of = and ~
+	negate := false
+	if op.text == "!=" || op.text == "!~" {
+		negate, op.text = true, op.text[1:]
+	}
+
+	cond, err := compileCond(strings.ToLower(field.text), field.text, op.text, value.text)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid filter: %s: %w", field.text, err)
+	}
+	if negate {
+		return notNode{cond}, nil
+	}
+	return cond, nil
+}
@@ -0,0 +1,94 @@
+package query_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/BarrettBr/RWND/internal/model"
+	"github.com/BarrettBr/RWND/internal/query"
+)
+
+func sampleRecord() model.Record {
+	rec := model.Record{
+		ID:        42,
+		Timestamp: time.Date(2025, 1, 2, 10, 30, 0, 0, time.Local),
+		Latency:   750 * time.Millisecond,
+		Tags:      []string{"slow", "auth"},
+	}
+	rec.Request.Method = "POST"
+	rec.Request.URL = "http://localhost:3000/orders/7?debug=1"
+	rec.Request.Headers = map[string][]string{"Content-Type": {"application/json"}}
+	rec.Request.Body = []byte(`{"items":[{"sku":"a","qty":2},{"sku":"b","qty":5}]}`)
+	rec.Response.Status = 503
+	rec.Response.Headers = map[string][]string{"Retry-After": {"30"}}
+	rec.Response.Body = []byte(`upstream timeout`)
+	return rec
+}
+
+func TestFilter_Match(t *testing.T) {
+	rec := sampleRecord()
+	cases := []struct {
+		expr string
+		want bool
+	}{
+		{`method = post`, true},
+		{`method = GET`, false},
+		{`method = POST path ~ ^/orders and status >= 500`, true},
+		{`method = POST and status < 500`, false},
+		{`status = 5xx`, true},
+		{`status != 5xx`, false},
+		{`status = 500..502`, false},
+		{`host = localhost:3000 scheme = http query contains debug`, true},
+		{`url ~ "/orders/[0-9]+"`, true},
+		{`req.header.content-type contains json`, true},
+		{`resp.header.Retry-After = 30`, true},
+		{`resp.header.X-Missing = 30`, false},
+		{`resp.body contains timeout`, true},
+		{`resp.body !~ timeout`, false},
+		{`req.json.items.1.qty > 4`, true},
+		{`req.json.items.*.sku = b`, true},
+		{`req.json.items.*.qty >= 6`, false},
+		{`req.json.missing = 1`, false},
+		{`tag = auth`, true},
+		{`tag = admin`, false},
+		{`id = 40..50`, true},
+		{`id > 42`, false},
+		{`latency > 500ms`, true},
+		{`time = 2025-01-02`, true},
+		{`time = 2025-01-02T10:00..2025-01-02T10:29`, false},
+		{`time >= 2025-01-02T10:30`, true},
+		{`tag = admin or (status = 5xx and not tag = slow)`, false},
+		{`tag = admin or (status = 5xx and not tag = fast)`, true},
+		{`NOT method = GET`, true},
+	}
+	for _, tc := range cases {
+		f, err := query.Parse(tc.expr)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tc.expr, err)
+		}
+		if got := f.Match(rec); got != tc.want {
+			t.Errorf("%q: got %v, want %v", tc.expr, got, tc.want)
+		}
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	for _, expr := range []string{
+		``,
+		`method`,
+		`method =`,
+		`color = red`,
+		`status ~ 5`,
+		`path > /a`,
+		`latency > fast`,
+		`(method = GET`,
+		`method = GET)`,
+		`path ~ "[a-"`,
+		`path = "open`,
+		`time = yesterday`,
+	} {
+		if _, err := query.Parse(expr); err == nil {
+			t.Errorf("Parse(%q): expected an error", expr)
+		}
+	}
+}
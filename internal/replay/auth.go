@@ -0,0 +1,109 @@
+package replay
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/BarrettBr/RWND/internal/capture"
+	"github.com/BarrettBr/RWND/internal/model"
+)
+
+// Auth supplies the Authorization header of replayed requests.
+type Auth interface {
+	// Authorization returns the header value to send. refresh is set after the
+	// last value got a 401. login replays a request the way Replay does, for
+	// providers that log in with a recorded request.
+	Authorization(login func(model.Record) (*model.Record, error), refresh bool) (string, error)
+}
+
+// authValue adds scheme to a bare token, values that already carry a scheme
+// ("Basic abc") are kept.
+func authValue(scheme, token string) string {
+	token = strings.TrimSpace(token)
+	if strings.Contains(token, " ") {
+		return token
+	}
+	if scheme == "" {
+		scheme = "Bearer"
+	}
+	return scheme + " " + token
+}
+
+// StaticAuth sends the same token every time, from an env var for example.
+type StaticAuth struct {
+	Token  string
+	Scheme string // Prefix for bare tokens, "Bearer" if empty
+}
+
+// Authorization returns the static token.
+func (a *StaticAuth) Authorization(_ func(model.Record) (*model.Record, error), _ bool) (string, error) {
+	return authValue(a.Scheme, a.Token), nil
+}
+
+// CommandAuth runs a shell command that prints a token, again on every refresh.
+type CommandAuth struct {
+	Command string
+	Scheme  string // Prefix for bare tokens, "Bearer" if empty
+
+	mu    sync.Mutex
+	value string
+}
+
+// Authorization returns the command's last token, running it first when needed.
+func (a *CommandAuth) Authorization(_ func(model.Record) (*model.Record, error), refresh bool) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.value != "" && !refresh {
+		return a.value, nil
+	}
+
+	out, err := exec.Command("sh", "-c", a.Command).Output()
+	if err != nil {
+		if exit, ok := err.(*exec.ExitError); ok && len(exit.Stderr) > 0 {
+			return "", fmt.Errorf("%s: %v: %s", a.Command, err, strings.TrimSpace(string(exit.Stderr)))
+		}
+		return "", fmt.Errorf("%s: %w", a.Command, err)
+	}
+	token := strings.TrimSpace(string(out))
+	if token == "" {
+		return "", fmt.Errorf("%s printed no token", a.Command)
+	}
+	a.value = authValue(a.Scheme, token)
+	return a.value, nil
+}
+
+// LoginAuth replays a recorded login request and takes the token from its
+// response, logging in again on every refresh.
+type LoginAuth struct {
+	Login  model.Record
+	Token  capture.Rule // Where the login response holds the token
+	Scheme string       // Prefix for bare tokens, "Bearer" if empty
+
+	mu    sync.Mutex
+	value string
+}
+
+// Authorization returns the token of the last login, logging in first when needed.
+func (a *LoginAuth) Authorization(login func(model.Record) (*model.Record, error), refresh bool) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.value != "" && !refresh {
+		return a.value, nil
+	}
+
+	resp, err := login(a.Login)
+	if err != nil {
+		return "", fmt.Errorf("login request #%d: %w", a.Login.ID, err)
+	}
+	if resp.Response.Status >= 400 {
+		return "", fmt.Errorf("login request #%d got status %d", a.Login.ID, resp.Response.Status)
+	}
+	token, ok := a.Token.Extract(resp.Response)
+	if !ok {
+		return "", fmt.Errorf("login request #%d response has no token at %s", a.Login.ID, a.Token.Name)
+	}
+	a.value = authValue(a.Scheme, token)
+	return a.value, nil
+}
@@ -0,0 +1,189 @@
+package replay
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/BarrettBr/RWND/internal/diff"
+	"github.com/BarrettBr/RWND/internal/model"
+)
+
+// Chaos perturbs a replay run to shake out ordering and idempotency bugs.
+// Every choice comes from Seed, so the same seed, log and options send the
+// same requests in the same order with the same delays.
+type Chaos struct {
+	Seed      int64
+	Shuffle   int           // Consecutive records reordered together, 0 or 1 keeps the recorded order
+	Duplicate float64       // Chance a request is sent twice in a row
+	Retry     float64       // Chance a request's first response is thrown away and it is sent again
+	Jitter    time.Duration // Upper bound of a random delay before each request
+}
+
+// ChaosStep is what happened to one record during a chaos run.
+type ChaosStep struct {
+	Record      model.Record      // As recorded
+	Index       int               // Position in the recorded order, from 1
+	Order       int               // Position it was sent at, from 1
+	Delay       time.Duration     // Jitter slept before sending
+	Retried     bool              // The first response was thrown away and the request sent again
+	Duplicated  bool              // The request was sent a second time after its response came back
+	Response    *model.Record     // The live response, nil when Err is set
+	Differences []diff.Difference // Where Response differs from the recorded response
+	Duplicate   []diff.Difference // Where the duplicate's response differs from Response
+	Err         error
+}
+
+// Chaos steps through the remaining records and replays each of them with
+// the perturbations of c, calling each with the outcome in the order requests
+// were sent. Records are only reordered within a window of c.Shuffle records,
+// and never ahead of an earlier record they may depend on: a write and a
+// request on the same or a nested path, or anything the auth provider, a
+// capture rule or the cookie jar takes values from.
+func (e *Engine) Chaos(c Chaos, each func(ChaosStep)) error {
+	rng := rand.New(rand.NewSource(c.Seed))
+	window := max(c.Shuffle, 1)
+	index, sent := 0, 0
+	for {
+		recs, err := e.stepN(window)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		order := make([]int, len(recs))
+		for i := range order {
+			order[i] = i
+		}
+		if window > 1 {
+			order = e.chaosOrder(recs, rng)
+		}
+		for _, i := range order {
+			sent++
+			step := e.chaosReplay(recs[i], c, rng)
+			step.Index, step.Order = index+i+1, sent
+			each(step)
+		}
+		index += len(recs)
+		if err != nil {
+			return nil
+		}
+	}
+}
+
+func (e *Engine) stepN(n int) ([]model.Record, error) {
+	// Steps up to n records, the error is io.EOF once the store ran out
+	recs := make([]model.Record, 0, n)
+	for len(recs) < n {
+		rec, err := e.Step()
+		if err != nil {
+			return recs, err
+		}
+		recs = append(recs, *rec)
+	}
+	return recs, nil
+}
+
+func (e *Engine) chaosOrder(recs []model.Record, rng *rand.Rand) []int {
+	// Picks the next record at random among those whose dependencies were sent
+	done := make([]bool, len(recs))
+	order := make([]int, 0, len(recs))
+	for len(order) < len(recs) {
+		var ready []int
+		for i := range recs {
+			if done[i] {
+				continue
+			}
+			free := true
+			for j := 0; j < i && free; j++ {
+				free = done[j] || !e.dependsOn(recs[j], recs[i])
+			}
+			if free {
+				ready = append(ready, i)
+			}
+		}
+		next := ready[rng.Intn(len(ready))]
+		done[next] = true
+		order = append(order, next)
+	}
+	return order
+}
+
+func (e *Engine) dependsOn(earlier, later model.Record) bool {
+	if e.feedsLaterRequests(earlier) || e.feedsLaterRequests(later) {
+		return true
+	}
+	if isSafeMethod(earlier.Request.Method) && isSafeMethod(later.Request.Method) {
+		return false
+	}
+	return pathsOverlap(earlier.Request.URL, later.Request.URL)
+}
+
+func (e *Engine) feedsLaterRequests(rec model.Record) bool {
+	// Records other requests take values from stay where they were recorded
+	if l, ok := e.opts.Auth.(*LoginAuth); ok && l.Login.ID == rec.ID {
+		return true
+	}
+	for i := range e.opts.Captures {
+		if e.opts.Captures[i].Matches(rec) {
+			return true
+		}
+	}
+	return e.client.Jar != nil && len(rec.Response.Headers.Values("Set-Cookie")) > 0
+}
+
+func (e *Engine) chaosReplay(rec model.Record, c Chaos, rng *rand.Rand) ChaosStep {
+	// Every random choice is drawn up front, so live responses can't change the
+	// sequence later records get
+	step := ChaosStep{Record: rec}
+	if c.Jitter > 0 {
+		step.Delay = time.Duration(rng.Int63n(int64(c.Jitter)))
+	}
+	step.Retried = c.Retry > 0 && rng.Float64() < c.Retry
+	step.Duplicated = c.Duplicate > 0 && rng.Float64() < c.Duplicate
+
+	time.Sleep(step.Delay)
+	if step.Retried {
+		// As if the response was lost on the way back, failures included
+		_, _ = e.Replay(rec)
+	}
+	live, err := e.Replay(rec)
+	if err != nil {
+		step.Err = err
+		return step
+	}
+	step.Response = live
+	step.Differences = diff.Compare(rec.Response, live.Response, e.opts.Diff)
+
+	if step.Duplicated {
+		again, err := e.Replay(rec)
+		if err != nil {
+			step.Err = fmt.Errorf("Duplicate: %w", err)
+			return step
+		}
+		step.Duplicate = diff.Compare(live.Response, again.Response, e.opts.Diff)
+	}
+	return step
+}
+
+func isSafeMethod(method string) bool {
+	switch strings.ToUpper(method) {
+	case "GET", "HEAD", "OPTIONS":
+		return true
+	}
+	return false
+}
+
+func pathsOverlap(a, b string) bool {
+	// Same path, or one nested under the other like /orders and /orders/7
+	ua, errA := url.Parse(a)
+	ub, errB := url.Parse(b)
+	if errA != nil || errB != nil {
+		return true
+	}
+	pa := strings.TrimSuffix(ua.Path, "/")
+	pb := strings.TrimSuffix(ub.Path, "/")
+	return pa == pb || strings.HasPrefix(pb, pa+"/") || strings.HasPrefix(pa, pb+"/")
+}
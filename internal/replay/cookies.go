@@ -0,0 +1,53 @@
+package replay
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+)
+
+func newJar() http.CookieJar {
+	// cookiejar.New only fails on bad options, and there are none
+	jar, _ := cookiejar.New(nil)
+	return jar
+}
+
+func (e *Engine) prepareCookies(req *http.Request) {
+	// The client adds the jar's cookies after the Cookie header, so recorded
+	// cookies the jar has a live value for are taken out first, or every
+	// recorded cookie when they're dropped
+	if e.client.Jar == nil || req.Header.Get("Cookie") == "" {
+		return
+	}
+	if e.opts.DropCookies {
+		req.Header.Del("Cookie")
+		e.chain = append(e.chain, "dropped the recorded Cookie header")
+		return
+	}
+
+	live := make(map[string]bool)
+	for _, c := range e.client.Jar.Cookies(req.URL) {
+		live[c.Name] = true
+	}
+	if len(live) == 0 {
+		return
+	}
+	recorded := req.Cookies()
+	req.Header.Del("Cookie")
+	for _, c := range recorded {
+		if live[c.Name] {
+			e.chain = append(e.chain, fmt.Sprintf("cookie %s from the jar instead of the recorded value", c.Name))
+			continue
+		}
+		req.AddCookie(c)
+	}
+}
+
+func (e *Engine) noteSetCookies(resp *http.Response) {
+	if e.client.Jar == nil {
+		return
+	}
+	for _, c := range resp.Cookies() {
+		e.chain = append(e.chain, fmt.Sprintf("cookie jar stored %s", c.Name))
+	}
+}
@@ -0,0 +1,164 @@
+package replay
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/BarrettBr/RWND/internal/model"
+)
+
+// editHelp heads the file opened in the editor. Leading # lines are skipped when
+// the file is read back.
+const editHelp = `# Edit the request below, then save and quit to replay it.
+# Leave it unchanged or empty the file to cancel.
+`
+
+// FormatRequest writes req as an HTTP message: the request line with the full
+// URL, one header per line, a blank line and the body.
+func FormatRequest(req model.Request) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s %s HTTP/1.1\n", req.Method, req.URL)
+
+	keys := make([]string, 0, len(req.Headers))
+	for k := range req.Headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range req.Headers[k] {
+			fmt.Fprintf(&b, "%s: %s\n", k, v)
+		}
+	}
+
+	b.WriteString("\n")
+	if len(req.Body) > 0 {
+		b.Write(req.Body)
+		b.WriteString("\n")
+	}
+	return b.Bytes()
+}
+
+// ParseRequest reads a request written by FormatRequest back. Leading # lines
+// are comments, the HTTP version is optional and the final newline of the body
+// is dropped since editors add one.
+func ParseRequest(data []byte) (model.Request, error) {
+	var req model.Request
+	r := bufio.NewReader(bytes.NewReader(data))
+
+	var line string
+	for {
+		l, err := r.ReadString('\n')
+		l = strings.TrimRight(l, "\r\n")
+		if strings.TrimSpace(l) != "" && !strings.HasPrefix(l, "#") {
+			line = l
+			break
+		}
+		if err != nil {
+			return req, fmt.Errorf("Missing request line")
+		}
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) < 2 || len(fields) > 3 || (len(fields) == 3 && !strings.HasPrefix(fields[2], "HTTP/")) {
+		return req, fmt.Errorf("Expected a request line like GET http://host/path HTTP/1.1, got %q", line)
+	}
+	req.Method = strings.ToUpper(fields[0])
+	req.URL = fields[1]
+
+	req.Headers = make(http.Header)
+	for {
+		l, err := r.ReadString('\n')
+		l = strings.TrimRight(l, "\r\n")
+		if l == "" {
+			if err != nil {
+				return req, nil
+			}
+			break
+		}
+		name, value, ok := strings.Cut(l, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			return req, fmt.Errorf("Expected a header like Name: value, got %q", l)
+		}
+		req.Headers.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+		if err != nil {
+			return req, nil
+		}
+	}
+
+	body := data[len(data)-r.Buffered():]
+	body = bytes.TrimSuffix(body, []byte("\n"))
+	body = bytes.TrimSuffix(body, []byte("\r"))
+	if len(body) > 0 {
+		req.Body = body
+	}
+	return req, nil
+}
+
+func editRequest(req model.Request) (*model.Request, error) {
+	// Opens req in $EDITOR and returns the edited request, or nil when the
+	// edit was cancelled
+	if !utf8.Valid(req.Body) {
+		return nil, fmt.Errorf("Request body isn't text, it can't be edited")
+	}
+
+	f, err := os.CreateTemp("", "rwnd-edit-*.http")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+
+	original := FormatRequest(req)
+	header := editHelp
+	if req.Truncated {
+		header += "# The recorded body was truncated at capture, only the captured part is below.\n"
+	}
+	if _, err := f.WriteString(header + string(original)); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+
+	editor := strings.Fields(os.Getenv("EDITOR"))
+	if len(editor) == 0 {
+		editor = []string{"vi"}
+	}
+	cmd := exec.Command(editor[0], append(editor[1:], f.Name())...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("Editor %s: %w", editor[0], err)
+	}
+
+	data, err := os.ReadFile(f.Name())
+	if err != nil {
+		return nil, err
+	}
+	edited, err := ParseRequest(data)
+	if err != nil {
+		if isBlank(data) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if bytes.Equal(FormatRequest(edited), original) {
+		return nil, nil
+	}
+	return &edited, nil
+}
+
+func isBlank(data []byte) bool {
+	for _, l := range strings.Split(string(data), "\n") {
+		if l = strings.TrimSpace(l); l != "" && !strings.HasPrefix(l, "#") {
+			return false
+		}
+	}
+	return true
+}
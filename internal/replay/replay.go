@@ -2,16 +2,22 @@
 package replay
 
 import (
+	"bufio"
 	"bytes"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"os"
+	"slices"
 	"sort"
 	"strings"
 	"time"
 
+	"github.com/BarrettBr/RWND/internal/capture"
+	"github.com/BarrettBr/RWND/internal/diff"
 	"github.com/BarrettBr/RWND/internal/model"
+	"github.com/BarrettBr/RWND/internal/mutate"
 )
 
 // Store streams recorded traffic to the replay engine.
@@ -19,10 +25,40 @@ type Store interface {
 	Stream() (<-chan model.Record, <-chan error)
 }
 
+// Options configures optional replay behavior.
+type Options struct {
+	// Filter, when set, skips every record it returns false for while stepping.
+	Filter func(model.Record) bool
+	// Target, when set, replaces the scheme and host of recorded URLs.
+	Target *url.URL
+	// Diff lists response fields ignored when comparing old and new responses.
+	Diff diff.Rules
+	// Mutations, when set, change matching requests before they are replayed.
+	Mutations []mutate.Mutation
+	// CookieJar keeps cookies set by replayed responses and sends them with
+	// later requests in place of recorded cookies of the same name.
+	CookieJar bool
+	// DropCookies leaves out recorded Cookie headers so only the jar's cookies
+	// are sent. It implies CookieJar.
+	DropCookies bool
+	// Auth, when set, supplies the Authorization header of replayed requests.
+	Auth Auth
+	// Captures pull values out of replayed responses that later replayed
+	// requests get in place of the recorded values.
+	Captures []capture.Rule
+	// SaveEdit, when set, stores a request edited with e and the response it got,
+	// and returns the stored record.
+	SaveEdit func(model.Record) (model.Record, error)
+}
+
 // Engine drives record stepping and replay.
 type Engine struct {
 	store  Store
 	client *http.Client
+	opts   Options
+	in     *bufio.Reader
+	vars   *capture.Vars
+	chain  []string // What the last Replay substituted and captured
 
 	recCh <-chan model.Record
 	errCh <-chan error
@@ -31,12 +67,25 @@ type Engine struct {
 
 // New initializes a replay engine for a given store.
 func New(store Store) (*Engine, error) {
+	return NewWithOptions(store, Options{})
+}
+
+// NewWithOptions initializes a replay engine for a given store and options.
+func NewWithOptions(store Store, opts Options) (*Engine, error) {
 	if store == nil {
 		return nil, fmt.Errorf("Store not defined")
 	}
 	engine := &Engine{
 		store:  store,
 		client: &http.Client{Timeout: 30 * time.Second},
+		opts:   opts,
+		in:     bufio.NewReader(os.Stdin),
+	}
+	if len(opts.Captures) > 0 {
+		engine.vars = capture.NewVars()
+	}
+	if opts.CookieJar || opts.DropCookies {
+		engine.client.Jar = newJar()
 	}
 	return engine, nil
 }
@@ -44,16 +93,22 @@ func New(store Store) (*Engine, error) {
 func printRequestPretty(rec model.Record) {
 	// printRequestPretty prints a request view.
 	fmt.Printf("Request #%d\n", rec.ID)
+	if len(rec.Tags) > 0 {
+		fmt.Printf("Tags: %s\n", strings.Join(rec.Tags, ", "))
+	}
+	if len(rec.Faults) > 0 {
+		// The recorded response is what the client got with these injected
+		fmt.Println("Injected faults:")
+		for _, f := range rec.Faults {
+			fmt.Printf("  %s: %s %s\n", f.Rule, f.Kind, f.Detail)
+		}
+	}
 	fmt.Printf("%s %s\n", rec.Request.Method, rec.Request.URL)
 	printHeaders(rec.Request.Headers)
 	printBody(rec.Request.Body)
 }
 
-func printResponsePretty(title string, resp struct {
-	Status  int
-	Headers http.Header
-	Body    []byte
-}) {
+func printResponsePretty(title string, resp model.Response) {
 	// printResponsePretty prints a response view.
 	fmt.Printf("%s\n", title)
 	fmt.Printf("Status: %d\n", resp.Status)
@@ -132,24 +187,158 @@ func (e *Engine) Step() (*model.Record, error) {
 				}
 				continue
 			}
+			if e.opts.Filter != nil && !e.opts.Filter(rec) {
+				continue
+			}
 			return &rec, nil
 		}
 	}
 }
 
-func (e *Engine) handleReplay(current *model.Record) {
+func (e *Engine) handleReplay(current *model.Record, extra []mutate.Mutation) {
 	if current == nil {
 		fmt.Println("No record to replay yet")
 		return
 	}
-	replayed, err := e.Replay(*current)
+
+	mutations := append(slices.Clone(e.opts.Mutations), extra...)
+	sent, changes, err := mutate.Apply(*current, mutations)
+	if err != nil {
+		fmt.Printf("Mutation error: %v\n", err)
+		return
+	}
+	replayed, err := e.Replay(sent)
+	if err != nil {
+		fmt.Printf("Replay error: %v\n", err)
+		return
+	}
+	e.printChain()
+
+	if len(changes) == 0 {
+		if len(mutations) > 0 {
+			fmt.Println("No mutations match this record, replayed as recorded")
+		}
+		printResponsePretty("Old Response", current.Response)
+		fmt.Println("---")
+		printResponsePretty("New Response", replayed.Response)
+	} else {
+		fmt.Println("Mutations:")
+		for _, c := range changes {
+			fmt.Printf("  %s\n", c)
+		}
+		fmt.Println("---")
+		printRequestPretty(*replayed)
+		fmt.Println("---")
+		printSideBySide("Recorded Response", current.Response, "Mutated Response", replayed.Response)
+	}
+	printDifferences(diff.Compare(current.Response, replayed.Response, e.opts.Diff))
+}
+
+func (e *Engine) handleEdit(current *model.Record) {
+	if current == nil {
+		fmt.Println("No record to edit yet")
+		return
+	}
+
+	req, err := editRequest(current.Request)
+	if err != nil {
+		fmt.Printf("Edit error: %v\n", err)
+		return
+	}
+	if req == nil {
+		fmt.Println("Edit cancelled")
+		return
+	}
+
+	edited := *current
+	edited.Request = *req
+	replayed, err := e.Replay(edited)
 	if err != nil {
 		fmt.Printf("Replay error: %v\n", err)
 		return
 	}
-	printResponsePretty("Old Response", current.Response)
+	e.printChain()
+
+	printRequestPretty(*replayed)
+	fmt.Println("---")
+	printSideBySide("Recorded Response", current.Response, "Edited Response", replayed.Response)
+	printDifferences(diff.Compare(current.Response, replayed.Response, e.opts.Diff))
+
+	if e.opts.SaveEdit == nil {
+		return
+	}
+	// Edits of an edit still point at the captured record
+	replayed.ParentID = current.ID
+	if current.ParentID != 0 {
+		replayed.ParentID = current.ParentID
+	}
+	saved, err := e.opts.SaveEdit(*replayed)
+	if err != nil {
+		fmt.Printf("Save error: %v\n", err)
+		return
+	}
+	fmt.Printf("Saved edit #%d of request #%d\n", saved.ID, saved.ParentID)
+}
+
+func (e *Engine) printChain() {
+	if len(e.chain) == 0 {
+		return
+	}
+	fmt.Println("Chained:")
+	for _, line := range e.chain {
+		fmt.Printf("  %s\n", line)
+	}
+	fmt.Println("---")
+}
+
+// mutateHelp lists the interactive mutation syntax.
+const mutateHelp = `  method PUT                        change the method
+  path /v2/orders                   change the path
+  header Name: value / -header Name set or remove a header
+  query name=value / -query name    set or remove a query parameter
+  json /items/0/qty = 0             replace a JSON body value (JSON, else a string)
+  json+ /items/- = {"sku":"B"}      add a JSON body value
+  -json /coupon                     remove a JSON body value`
+
+func (e *Engine) readMutations() ([]mutate.Mutation, bool) {
+	// Reads mutation lines until an empty one, ok is false if input ends first
+	fmt.Println("Enter mutations, one per line, then an empty line to replay (? for help)")
+	var out []mutate.Mutation
+	for {
+		fmt.Print("mutate> ")
+		line, err := e.readLine()
+		if line == "" {
+			return out, err == nil
+		}
+		if line == "?" {
+			fmt.Println(mutateHelp)
+			continue
+		}
+		m, perr := mutate.ParseLine(line)
+		if perr != nil {
+			fmt.Println(perr)
+			continue
+		}
+		out = append(out, m)
+	}
+}
+
+func (e *Engine) readLine() (string, error) {
+	line, err := e.in.ReadString('\n')
+	return strings.TrimSpace(line), err
+}
+
+func printDifferences(diffs []diff.Difference) {
+	// printDifferences prints a summary of what changed between responses
 	fmt.Println("---")
-	printResponsePretty("New Response", replayed.Response)
+	if len(diffs) == 0 {
+		fmt.Println("Responses match")
+		return
+	}
+	fmt.Printf("Differences (%d):\n", len(diffs))
+	for _, d := range diffs {
+		fmt.Printf("  %s\n", d)
+	}
 }
 
 // StepLoop runs the prompt for stepping and replaying.
@@ -160,15 +349,30 @@ func (e *Engine) StepLoop() error {
 
 	var current *model.Record
 	for {
-		fmt.Print("Press Enter for next, r to replay, q to quit > ")
-		var s string
-		_, _ = fmt.Scanln(&s)
+		fmt.Print("Press Enter for next, r to replay, m to mutate, e to edit, q to quit > ")
+		s, _ := e.readLine()
 		if s == "q" {
 			return nil
 		}
 
 		if s == "r" {
-			e.handleReplay(current)
+			e.handleReplay(current, nil)
+			continue
+		}
+
+		if s == "e" {
+			e.handleEdit(current)
+			continue
+		}
+
+		if s == "m" {
+			if current == nil {
+				fmt.Println("No record to replay yet")
+				continue
+			}
+			if extra, ok := e.readMutations(); ok {
+				e.handleReplay(current, extra)
+			}
 			continue
 		}
 
@@ -193,8 +397,32 @@ func (e *Engine) Reset() {
 	e.done = false
 }
 
-// Replay re-sends a recorded request and returns the new response.
+// Replay re-sends a recorded request and returns the new response. With
+// captures set, values captured from earlier replays replace their recorded
+// values in the request first, and the new response is captured from. With an
+// auth provider set, its Authorization value is sent instead of the recorded
+// one, and a 401 refreshes it and sends the request once more.
 func (e *Engine) Replay(rec model.Record) (*model.Record, error) {
+	e.chain = nil
+	auth := e.opts.Auth
+	if l, ok := auth.(*LoginAuth); ok && l.Login.ID == rec.ID {
+		// The login request itself goes out as recorded
+		auth = nil
+	}
+	return e.replay(rec, auth)
+}
+
+func (e *Engine) replay(rec model.Record, auth Auth) (*model.Record, error) {
+	if rec.Request.Truncated {
+		// Sending the captured part would replay a different request than was recorded
+		return nil, fmt.Errorf("Request body is over the capture limit, not replayed")
+	}
+	if e.vars != nil {
+		var subs []string
+		rec.Request, subs = e.vars.Apply(rec.Request)
+		e.chain = append(e.chain, subs...)
+	}
+
 	reqURL, err := url.Parse(rec.Request.URL)
 	if err != nil {
 		return nil, fmt.Errorf("Replay invalid request URL: %w", err)
@@ -202,25 +430,29 @@ func (e *Engine) Replay(rec model.Record) (*model.Record, error) {
 	if !reqURL.IsAbs() {
 		return nil, fmt.Errorf("Replay requires absolute request URL")
 	}
-
-	body := bytes.NewReader(rec.Request.Body)
-	req, err := http.NewRequest(rec.Request.Method, reqURL.String(), body)
-	if err != nil {
-		return nil, err
-	}
-
-	if rec.Request.Headers != nil {
-		req.Header = rec.Request.Headers.Clone()
+	if e.opts.Target != nil {
+		reqURL.Scheme = e.opts.Target.Scheme
+		reqURL.Host = e.opts.Target.Host
 	}
-	req.Header.Del("Content-Length")
-	req.Header.Del("Host")
-	req.Header.Del("Accept-Encoding")
-	req.ContentLength = int64(len(rec.Request.Body))
 
-	resp, err := e.client.Do(req)
+	resp, sent, err := e.send(rec.Request, reqURL, auth)
 	if err != nil {
 		return nil, err
 	}
+	if resp.StatusCode == http.StatusUnauthorized && auth != nil {
+		// Only worth sending again if the provider has something new
+		value, err := auth.Authorization(e.login, true)
+		if err == nil && value != sent {
+			resp.Body.Close()
+			e.chain = append(e.chain, "auth refreshed after a 401")
+			if resp, _, err = e.send(rec.Request, reqURL, auth); err != nil {
+				return nil, err
+			}
+		} else if err != nil {
+			e.chain = append(e.chain, fmt.Sprintf("auth refresh after a 401 failed: %v", err))
+		}
+	}
+	e.noteSetCookies(resp)
 	defer resp.Body.Close()
 
 	respBody, err := io.ReadAll(resp.Body)
@@ -233,6 +465,44 @@ func (e *Engine) Replay(rec model.Record) (*model.Record, error) {
 	replayed.Response.Headers = resp.Header.Clone()
 	replayed.Response.Body = respBody
 	replayed.Timestamp = time.Now().UTC()
+	replayed.Faults = nil // Nothing was injected into the live response
 
+	if e.vars != nil {
+		e.chain = append(e.chain, e.vars.Capture(e.opts.Captures, rec, replayed.Response)...)
+	}
 	return &replayed, nil
 }
+
+func (e *Engine) send(r model.Request, reqURL *url.URL, auth Auth) (*http.Response, string, error) {
+	// Builds and sends one request, returning the Authorization value it used
+	req, err := http.NewRequest(r.Method, reqURL.String(), bytes.NewReader(r.Body))
+	if err != nil {
+		return nil, "", err
+	}
+
+	if r.Headers != nil {
+		req.Header = r.Headers.Clone()
+	}
+	req.Header.Del("Content-Length")
+	req.Header.Del("Host")
+	req.Header.Del("Accept-Encoding")
+	req.ContentLength = int64(len(r.Body))
+
+	var value string
+	if auth != nil {
+		if value, err = auth.Authorization(e.login, false); err != nil {
+			return nil, "", fmt.Errorf("Auth: %w", err)
+		}
+		req.Header.Set("Authorization", value)
+	}
+	e.prepareCookies(req)
+
+	resp, err := e.client.Do(req)
+	return resp, value, err
+}
+
+func (e *Engine) login(rec model.Record) (*model.Record, error) {
+	// Replays an auth provider's login request, without auth so it can't loop
+	e.chain = append(e.chain, fmt.Sprintf("auth logging in with request #%d", rec.ID))
+	return e.replay(rec, nil)
+}
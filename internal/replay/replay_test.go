@@ -2,11 +2,17 @@ package replay_test
 
 import (
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
 
+	"github.com/BarrettBr/RWND/internal/capture"
 	"github.com/BarrettBr/RWND/internal/model"
 	"github.com/BarrettBr/RWND/internal/replay"
 )
@@ -89,6 +95,36 @@ func TestReplay_Replay_RequiresAbsoluteURL(t *testing.T) {
 	}
 }
 
+func TestReplay_Replay_RefusesTruncatedBody(t *testing.T) {
+	s := &fakeStore{
+		recCh: make(chan model.Record, 1),
+		errCh: make(chan error, 1),
+	}
+	e, err := replay.New(s)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	sent := false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sent = true
+	}))
+	defer ts.Close()
+
+	rec := model.Record{}
+	rec.Request.Method = "POST"
+	rec.Request.URL = ts.URL + "/upload"
+	rec.Request.Body = []byte("only the first part")
+	rec.Request.Truncated = true
+
+	if got, err := e.Replay(rec); err == nil || got != nil {
+		t.Fatalf("expected error for a truncated body, got rec=%v err=%v", got, err)
+	}
+	if sent {
+		t.Fatalf("truncated request was sent")
+	}
+}
+
 func TestReplay_Replay_SendsRequest(t *testing.T) {
 	s := &fakeStore{
 		recCh: make(chan model.Record, 1),
@@ -123,3 +159,293 @@ func TestReplay_Replay_SendsRequest(t *testing.T) {
 		t.Fatalf("expected header X-Test=ok")
 	}
 }
+
+func TestReplay_Step_SkipsFilteredRecords(t *testing.T) {
+	s := &fakeStore{
+		recCh: make(chan model.Record, 3),
+		errCh: make(chan error),
+	}
+	e, err := replay.NewWithOptions(s, replay.Options{
+		Filter: func(rec model.Record) bool { return rec.HasTag("keep") },
+	})
+	if err != nil {
+		t.Fatalf("NewWithOptions: %v", err)
+	}
+
+	s.recCh <- model.Record{ID: 1}
+	s.recCh <- model.Record{ID: 2, Tags: []string{"keep"}}
+	s.recCh <- model.Record{ID: 3}
+	close(s.recCh)
+	close(s.errCh)
+
+	rec, err := e.Step()
+	if err != nil || rec == nil || rec.ID != 2 {
+		t.Fatalf("expected record 2, got rec=%v err=%v", rec, err)
+	}
+	if rec, err := e.Step(); err != io.EOF || rec != nil {
+		t.Fatalf("expected io.EOF after filtered records, got rec=%v err=%v", rec, err)
+	}
+}
+
+func TestReplay_Replay_TargetOverride(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("override " + r.URL.Path))
+	}))
+	defer ts.Close()
+
+	target, _ := url.Parse(ts.URL)
+	e, err := replay.NewWithOptions(&fakeStore{}, replay.Options{Target: target})
+	if err != nil {
+		t.Fatalf("NewWithOptions: %v", err)
+	}
+
+	rec := model.Record{}
+	rec.Request.Method = "GET"
+	rec.Request.URL = "http://recorded.invalid/ping"
+
+	got, err := e.Replay(rec)
+	if err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	if string(got.Response.Body) != "override /ping" {
+		t.Fatalf("expected request at override target, got %q", string(got.Response.Body))
+	}
+}
+
+func TestParseRequest_RoundTrip(t *testing.T) {
+	req := model.Request{
+		Method:  "POST",
+		URL:     "http://localhost:3000/orders?debug=1",
+		Headers: http.Header{"Content-Type": {"application/json"}, "X-Tag": {"a", "b"}},
+		Body:    []byte("{\"qty\":2}\n\nline two"),
+	}
+
+	got, err := replay.ParseRequest(append([]byte("# comment\n\n"), replay.FormatRequest(req)...))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got.Method != req.Method || got.URL != req.URL || string(got.Body) != string(req.Body) {
+		t.Fatalf("Round trip mismatch: %+v", got)
+	}
+	if len(got.Headers["X-Tag"]) != 2 || got.Headers.Get("Content-Type") != "application/json" {
+		t.Fatalf("Unexpected headers: %v", got.Headers)
+	}
+
+	edited, err := replay.ParseRequest([]byte("put http://x/y\r\nAccept: */*\r\n"))
+	if err != nil || edited.Method != "PUT" || edited.Headers.Get("Accept") != "*/*" || edited.Body != nil {
+		t.Fatalf("Unexpected edited request: %+v err=%v", edited, err)
+	}
+
+	for _, bad := range []string{"", "# only a comment\n", "GET\n", "GET http://x HTTP/1.1\nno colon\n"} {
+		if _, err := replay.ParseRequest([]byte(bad)); err == nil {
+			t.Errorf("Expected an error for %q", bad)
+		}
+	}
+}
+
+func TestReplay_Replay_ChainsCapturedValues(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/login" {
+			_, _ = w.Write([]byte(`{"token":"live-token"}`))
+			return
+		}
+		_, _ = w.Write([]byte(r.Header.Get("Authorization")))
+	}))
+	defer ts.Close()
+
+	rules, err := capture.Parse([]byte("captures: [{name: token, json: token}]"))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	e, err := replay.NewWithOptions(&fakeStore{}, replay.Options{Captures: rules})
+	if err != nil {
+		t.Fatalf("NewWithOptions: %v", err)
+	}
+
+	login := model.Record{}
+	login.Request.Method = "POST"
+	login.Request.URL = ts.URL + "/login"
+	login.Response.Body = []byte(`{"token":"recorded-token"}`)
+	if _, err := e.Replay(login); err != nil {
+		t.Fatalf("Replay login: %v", err)
+	}
+
+	me := model.Record{}
+	me.Request.Method = "GET"
+	me.Request.URL = ts.URL + "/me"
+	me.Request.Headers = http.Header{"Authorization": {"Bearer recorded-token"}}
+	got, err := e.Replay(me)
+	if err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	if string(got.Response.Body) != "Bearer live-token" {
+		t.Fatalf("expected the captured token to be sent, got %q", got.Response.Body)
+	}
+	if me.Request.Headers.Get("Authorization") != "Bearer recorded-token" {
+		t.Fatalf("expected the recorded request left unchanged")
+	}
+}
+
+func TestReplay_Replay_CookieJar(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/login" {
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "live", Path: "/"})
+			return
+		}
+		_, _ = w.Write([]byte(r.Header.Get("Cookie")))
+	}))
+	defer ts.Close()
+
+	login := model.Record{}
+	login.Request.Method = "POST"
+	login.Request.URL = ts.URL + "/login"
+
+	me := model.Record{}
+	me.Request.Method = "GET"
+	me.Request.URL = ts.URL + "/me"
+	me.Request.Headers = http.Header{"Cookie": {"session=recorded; theme=dark"}}
+
+	for _, tc := range []struct {
+		opts replay.Options
+		want string
+	}{
+		{replay.Options{}, "session=recorded; theme=dark"},
+		{replay.Options{CookieJar: true}, "theme=dark; session=live"},
+		{replay.Options{DropCookies: true}, "session=live"},
+	} {
+		e, err := replay.NewWithOptions(&fakeStore{}, tc.opts)
+		if err != nil {
+			t.Fatalf("NewWithOptions: %v", err)
+		}
+		if _, err := e.Replay(login); err != nil {
+			t.Fatalf("Replay login: %v", err)
+		}
+		got, err := e.Replay(me)
+		if err != nil {
+			t.Fatalf("Replay: %v", err)
+		}
+		if string(got.Response.Body) != tc.want {
+			t.Errorf("%+v: expected cookies %q, got %q", tc.opts, tc.want, got.Response.Body)
+		}
+	}
+}
+
+func TestReplay_Replay_LoginAuthRefreshesOn401(t *testing.T) {
+	logins := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/login" {
+			logins++
+			_, _ = fmt.Fprintf(w, `{"access_token":"t%d"}`, logins)
+			return
+		}
+		// The first token is already stale
+		if r.Header.Get("Authorization") != "Bearer t2" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	token, err := capture.ParseRule("json:access_token")
+	if err != nil {
+		t.Fatalf("ParseRule: %v", err)
+	}
+	login := model.Record{ID: 1}
+	login.Request.Method = "POST"
+	login.Request.URL = ts.URL + "/login"
+	auth := &replay.LoginAuth{Login: login, Token: token}
+
+	e, err := replay.NewWithOptions(&fakeStore{}, replay.Options{Auth: auth})
+	if err != nil {
+		t.Fatalf("NewWithOptions: %v", err)
+	}
+
+	me := model.Record{}
+	me.Request.Method = "GET"
+	me.Request.URL = ts.URL + "/me"
+	me.Request.Headers = http.Header{"Authorization": {"Bearer recorded"}}
+	got, err := e.Replay(me)
+	if err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	if got.Response.Status != http.StatusOK || logins != 2 {
+		t.Fatalf("expected a refreshed login to succeed, got status %d after %d logins", got.Response.Status, logins)
+	}
+
+	// The refreshed token is reused
+	if _, err := e.Replay(me); err != nil || logins != 2 {
+		t.Fatalf("expected no new login, got %d logins err=%v", logins, err)
+	}
+
+	static, err := replay.NewWithOptions(&fakeStore{}, replay.Options{Auth: &replay.StaticAuth{Token: "t1"}})
+	if err != nil {
+		t.Fatalf("NewWithOptions: %v", err)
+	}
+	if got, err := static.Replay(me); err != nil || got.Response.Status != http.StatusUnauthorized {
+		t.Fatalf("expected a static token to stay unauthorized, got %+v err=%v", got, err)
+	}
+}
+
+func TestReplay_Chaos_SameSeedSameRun(t *testing.T) {
+	var mu sync.Mutex
+	var got []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		got = append(got, r.Method+" "+r.URL.Path)
+		mu.Unlock()
+	}))
+	defer ts.Close()
+
+	paths := []string{"GET /a", "GET /b", "POST /orders", "GET /orders/7", "GET /c", "GET /d", "GET /e", "GET /f"}
+	run := func(seed int64) ([]string, []replay.ChaosStep) {
+		s := &fakeStore{recCh: make(chan model.Record, len(paths)), errCh: make(chan error)}
+		for i, p := range paths {
+			method, path, _ := strings.Cut(p, " ")
+			rec := model.Record{ID: uint64(i + 1)}
+			rec.Request.Method = method
+			rec.Request.URL = ts.URL + path
+			rec.Response.Status = http.StatusOK
+			s.recCh <- rec
+		}
+		close(s.recCh)
+		close(s.errCh)
+
+		e, err := replay.New(s)
+		if err != nil {
+			t.Fatalf("New: %v", err)
+		}
+		got = nil
+		var steps []replay.ChaosStep
+		err = e.Chaos(replay.Chaos{Seed: seed, Shuffle: 8, Duplicate: 0.3, Retry: 0.3}, func(step replay.ChaosStep) {
+			steps = append(steps, step)
+		})
+		if err != nil {
+			t.Fatalf("Chaos: %v", err)
+		}
+		return append([]string(nil), got...), steps
+	}
+
+	first, steps := run(3)
+	again, _ := run(3)
+	if strings.Join(first, ",") != strings.Join(again, ",") {
+		t.Fatalf("Same seed sent different requests:\n%v\n%v", first, again)
+	}
+	if len(steps) != len(paths) {
+		t.Fatalf("Expected %d steps, got %d", len(paths), len(steps))
+	}
+
+	var order []uint64
+	for _, step := range steps {
+		if step.Err != nil || len(step.Differences) > 0 || len(step.Duplicate) > 0 {
+			t.Fatalf("Unexpected problem in step %+v", step)
+		}
+		order = append(order, step.Record.ID)
+	}
+	if slices.Index(order, 3) > slices.Index(order, 4) {
+		t.Fatalf("GET /orders/7 was sent before the POST /orders it may depend on: %v", order)
+	}
+	if slices.IsSorted(order) {
+		t.Fatalf("Expected seed 3 to shuffle the reads, got %v", order)
+	}
+}
@@ -0,0 +1,139 @@
+package replay
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/BarrettBr/RWND/internal/model"
+)
+
+// sideWidth is the width of each column of a side by side view.
+const sideWidth = 60
+
+func printSideBySide(leftTitle string, left model.Response, rightTitle string, right model.Response) {
+	fmt.Print(sideBySide(leftTitle, left, rightTitle, right))
+}
+
+func sideBySide(leftTitle string, left model.Response, rightTitle string, right model.Response) string {
+	// Rows pair the same part of both responses: status, each header by name,
+	// then body lines. JSON bodies are indented so their lines line up. Rows that
+	// differ are marked with |, rows only one side has with < or >.
+	rows := [][2]string{
+		{fmt.Sprintf("Status: %d", left.Status), fmt.Sprintf("Status: %d", right.Status)},
+	}
+
+	names := make(map[string]bool)
+	for k := range left.Headers {
+		names[k] = true
+	}
+	for k := range right.Headers {
+		names[k] = true
+	}
+	if len(names) > 0 {
+		rows = append(rows, [2]string{"Headers:", "Headers:"})
+		keys := make([]string, 0, len(names))
+		for k := range names {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			rows = append(rows, [2]string{headerCell(k, left.Headers[k]), headerCell(k, right.Headers[k])})
+		}
+	}
+
+	l, r := bodyLines(left.Body), bodyLines(right.Body)
+	if len(l) > 0 || len(r) > 0 {
+		rows = append(rows, [2]string{"Body:", "Body:"})
+		for i := 0; i < max(len(l), len(r)); i++ {
+			var row [2]string
+			if i < len(l) {
+				row[0] = "  " + l[i]
+			}
+			if i < len(r) {
+				row[1] = "  " + r[i]
+			}
+			rows = append(rows, row)
+		}
+	}
+
+	var b strings.Builder
+	writeSideRow(&b, leftTitle, rightTitle, ' ')
+	writeSideRow(&b, strings.Repeat("-", sideWidth), strings.Repeat("-", sideWidth), ' ')
+	for _, row := range rows {
+		mark := ' '
+		switch {
+		case row[0] == row[1]:
+		case row[1] == "":
+			mark = '<'
+		case row[0] == "":
+			mark = '>'
+		default:
+			mark = '|'
+		}
+		writeSideRow(&b, row[0], row[1], mark)
+	}
+	return b.String()
+}
+
+func headerCell(name string, values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return "  " + name + ": " + strings.Join(values, ", ")
+}
+
+func bodyLines(body []byte) []string {
+	if len(body) == 0 {
+		return nil
+	}
+	// Decoding and encoding again sorts object keys, so both sides line up
+	// whatever order the server wrote them in
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var v any
+	if dec.Decode(&v) == nil {
+		if pretty, err := json.MarshalIndent(v, "", "  "); err == nil {
+			body = pretty
+		}
+	}
+	return strings.Split(strings.TrimRight(string(body), "\n"), "\n")
+}
+
+func writeSideRow(b *strings.Builder, left, right string, mark rune) {
+	// Long cells wrap onto extra lines, the mark only shows on the first
+	lc, rc := wrap(left), wrap(right)
+	for i := 0; i < max(len(lc), len(rc)); i++ {
+		var l, r string
+		if i < len(lc) {
+			l = lc[i]
+		}
+		if i < len(rc) {
+			r = rc[i]
+		}
+		m := mark
+		if i > 0 {
+			m = ' '
+		}
+		pad := sideWidth - utf8.RuneCountInString(l)
+		line := fmt.Sprintf("%s%s %c %s", l, strings.Repeat(" ", max(pad, 0)), m, r)
+		b.WriteString(strings.TrimRight(line, " ") + "\n")
+	}
+}
+
+func wrap(s string) []string {
+	s = strings.ReplaceAll(s, "\t", "  ")
+	if utf8.RuneCountInString(s) <= sideWidth {
+		return []string{s}
+	}
+	var out []string
+	runes := []rune(s)
+	for len(runes) > sideWidth {
+		out = append(out, string(runes[:sideWidth]))
+		runes = runes[sideWidth:]
+	}
+	return append(out, string(runes))
+}
@@ -0,0 +1,80 @@
+// Package route groups request URLs into endpoint templates.
+package route
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Placeholders that replace identifier path segments.
+const (
+	ID   = "{id}"
+	UUID = "{uuid}"
+)
+
+// Template replaces path segments that look like identifiers with placeholders so
+// requests to the same endpoint group together.
+// "/users/42/orders/3f2c1a9e-8b7d-4c6e-9a5b-0d1e2f3a4b5c" -> "/users/{id}/orders/{uuid}".
+func Template(path string) string {
+	if path == "" {
+		return "/"
+	}
+	segments := strings.Split(path, "/")
+	for i, seg := range segments {
+		switch {
+		case isNumeric(seg):
+			segments[i] = ID
+		case isUUID(seg):
+			segments[i] = UUID
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// FromURL returns the template of a recorded request URL's path, dropping the query.
+func FromURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		// Fall back to cutting the query off by hand
+		path, _, _ := strings.Cut(raw, "?")
+		return Template(path)
+	}
+	return Template(u.Path)
+}
+
+func isNumeric(seg string) bool {
+	if seg == "" {
+		return false
+	}
+	for i := 0; i < len(seg); i++ {
+		if seg[i] < '0' || seg[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func isUUID(seg string) bool {
+	// 8-4-4-4-12 hex digits, any case
+	if len(seg) != 36 {
+		return false
+	}
+	for i := 0; i < len(seg); i++ {
+		c := seg[i]
+		switch i {
+		case 8, 13, 18, 23:
+			if c != '-' {
+				return false
+			}
+		default:
+			if !isHex(c) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func isHex(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
@@ -0,0 +1,40 @@
+package route_test
+
+import (
+	"testing"
+
+	"github.com/BarrettBr/RWND/internal/route"
+)
+
+func TestTemplate(t *testing.T) {
+	cases := map[string]string{
+		"":                    "/",
+		"/":                   "/",
+		"/health":             "/health",
+		"/users/42":           "/users/{id}",
+		"/users/42/orders/7/": "/users/{id}/orders/{id}/",
+		"/v2/items":           "/v2/items",
+		"/files/3F2C1A9E-8B7D-4C6E-9A5B-0D1E2F3A4B5C": "/files/{uuid}",
+		"/files/3f2c1a9e-8b7d-4c6e-9a5b-0d1e2f3a4b5":  "/files/3f2c1a9e-8b7d-4c6e-9a5b-0d1e2f3a4b5",
+		"/files/3f2c1a9e_8b7d-4c6e-9a5b-0d1e2f3a4b5c": "/files/3f2c1a9e_8b7d-4c6e-9a5b-0d1e2f3a4b5c",
+	}
+	for in, want := range cases {
+		if got := route.Template(in); got != want {
+			t.Errorf("Template(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestFromURL(t *testing.T) {
+	cases := map[string]string{
+		"http://localhost:3000/orders/991823?expand=items": "/orders/{id}",
+		"http://localhost:3000":                            "/",
+		"/relative/5?x=1":                                  "/relative/{id}",
+		"http://[bad/orders/5?x":                           "http://[bad/orders/{id}",
+	}
+	for in, want := range cases {
+		if got := route.FromURL(in); got != want {
+			t.Errorf("FromURL(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package rules
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/BarrettBr/RWND/internal/datastore"
+	"github.com/BarrettBr/RWND/internal/model"
+)
+
+// Store is the downstream store records are passed to after evaluation.
+type Store interface {
+	Append(model.Record) error
+}
+
+// Engine evaluates rules on every record before handing it to the next store.
+// It satisfies logger.Store so it can sit between the logger and the datastore.
+type Engine struct {
+	next  Store
+	rules []*compiled
+	alert io.Writer
+
+	mu    sync.Mutex
+	sides map[string]*datastore.FileStore // Side logs keyed by path
+}
+
+// New compiles the rules in cfg and wraps next.
+func New(cfg Config, next Store) (*Engine, error) {
+	if next == nil {
+		return nil, fmt.Errorf("Rules next store is required")
+	}
+
+	e := &Engine{
+		next:  next,
+		alert: os.Stdout,
+		sides: make(map[string]*datastore.FileStore),
+	}
+	for i, rule := range cfg.Rules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule-%d", i+1)
+		}
+		c, err := compile(rule)
+		if err != nil {
+			return nil, fmt.Errorf("Rule %s: %w", rule.Name, err)
+		}
+		e.rules = append(e.rules, c)
+	}
+	return e, nil
+}
+
+// SetAlertOutput changes where alert lines are written.
+func (e *Engine) SetAlertOutput(w io.Writer) {
+	e.alert = w
+}
+
+// Evaluate tags rec with every matching rule and returns the rules that matched.
+func (e *Engine) Evaluate(rec *model.Record) []Rule {
+	var matched []Rule
+	for _, c := range e.rules {
+		if !c.matches(*rec) {
+			continue
+		}
+		for _, tag := range c.rule.Tags {
+			rec.AddTag(tag)
+		}
+		matched = append(matched, c.rule)
+	}
+	return matched
+}
+
+// Append evaluates rules on rec, runs side effects and forwards it to the next store.
+func (e *Engine) Append(rec model.Record) error {
+	matched := e.Evaluate(&rec)
+
+	// Side effects run after tagging so side logs read the same as the main log
+	var sideErr error
+	for _, rule := range matched {
+		if rule.Alert {
+			fmt.Fprintf(e.alert, "rwnd alert [%s] #%d %s %s -> %d (%s)\n",
+				rule.Name, rec.ID, rec.Request.Method, rec.Request.URL, rec.Response.Status, rec.Latency.Round(time.Millisecond))
+		}
+		if rule.Log != "" {
+			if err := e.appendSide(rule.Log, rec); err != nil && sideErr == nil {
+				sideErr = fmt.Errorf("Rule %s side log: %w", rule.Name, err)
+			}
+		}
+	}
+
+	// A broken side log shouldn't cost us the record in the main log
+	if err := e.next.Append(rec); err != nil {
+		return err
+	}
+	return sideErr
+}
+
+func (e *Engine) appendSide(path string, rec model.Record) error {
+	e.mu.Lock()
+	side, ok := e.sides[path]
+	if !ok {
+		var err error
+		side, err = datastore.NewFileStore(path, 500*time.Millisecond)
+		if err != nil {
+			e.mu.Unlock()
+			return err
+		}
+		e.sides[path] = side
+	}
+	e.mu.Unlock()
+
+	return side.Append(rec)
+}
+
+// Close flushes and closes every side log. The next store is left to its owner.
+func (e *Engine) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var firstErr error
+	for path, side := range e.sides {
+		if err := side.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(e.sides, path)
+	}
+	return firstErr
+}
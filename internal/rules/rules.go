@@ -0,0 +1,300 @@
+// Package rules evaluates invariant rules against recorded traffic.
+package rules
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/BarrettBr/RWND/internal/model"
+)
+
+// Config is the on-disk rules file layout.
+type Config struct {
+	Rules []Rule `yaml:"rules"`
+}
+
+// Rule matches completed records and describes what to do with them.
+type Rule struct {
+	Name  string   `yaml:"name"`
+	Match Match    `yaml:"match"`
+	Tags  []string `yaml:"tags"`
+	Log   string   `yaml:"log"`   // Side log path matching records are also written to
+	Alert bool     `yaml:"alert"` // Print a line when the rule matches
+}
+
+// Match holds the conditions of a rule. Every set condition must hold.
+type Match struct {
+	Method          string            `yaml:"method"`
+	Path            string            `yaml:"path"`             // Regex over the request path
+	Status          []string          `yaml:"status"`           // "401", "4xx", ">=500", "500-599"
+	Latency         string            `yaml:"latency"`          // ">500ms", "<=1s"
+	RequestHeaders  map[string]string `yaml:"request_headers"`  // Header name -> regex
+	ResponseHeaders map[string]string `yaml:"response_headers"` // Header name -> regex
+	RequestBody     string            `yaml:"request_body"`     // Regex over the request body
+	ResponseBody    string            `yaml:"response_body"`    // Regex over the response body
+}
+
+// Load reads a rules file from disk.
+func Load(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+	return Parse(data)
+}
+
+// Parse decodes a YAML rules document.
+func Parse(data []byte) (Config, error) {
+	var cfg Config
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return Config{}, fmt.Errorf("Rules parse error: %w", err)
+	}
+	return cfg, nil
+}
+
+// Matcher compiles m into a predicate so commands can filter records the way rules match them.
+func Matcher(m Match) (func(model.Record) bool, error) {
+	c, err := compile(Rule{Match: m})
+	if err != nil {
+		return nil, err
+	}
+	return c.matches, nil
+}
+
+// ------------
+
+// compiled is a Rule with its patterns prepared for evaluation.
+type compiled struct {
+	rule     Rule
+	path     *regexp.Regexp
+	status   []statusMatcher
+	latency  *durationMatcher
+	reqHdrs  map[string]*regexp.Regexp
+	respHdrs map[string]*regexp.Regexp
+	reqBody  *regexp.Regexp
+	respBody *regexp.Regexp
+}
+
+func compile(rule Rule) (*compiled, error) {
+	c := &compiled{rule: rule}
+	var err error
+
+	if c.path, err = compileOptional(rule.Match.Path); err != nil {
+		return nil, fmt.Errorf("path: %w", err)
+	}
+	if c.reqBody, err = compileOptional(rule.Match.RequestBody); err != nil {
+		return nil, fmt.Errorf("request_body: %w", err)
+	}
+	if c.respBody, err = compileOptional(rule.Match.ResponseBody); err != nil {
+		return nil, fmt.Errorf("response_body: %w", err)
+	}
+	if c.reqHdrs, err = compileHeaders(rule.Match.RequestHeaders); err != nil {
+		return nil, fmt.Errorf("request_headers: %w", err)
+	}
+	if c.respHdrs, err = compileHeaders(rule.Match.ResponseHeaders); err != nil {
+		return nil, fmt.Errorf("response_headers: %w", err)
+	}
+
+	for _, s := range rule.Match.Status {
+		m, err := parseStatus(s)
+		if err != nil {
+			return nil, err
+		}
+		c.status = append(c.status, m)
+	}
+
+	if rule.Match.Latency != "" {
+		m, err := parseDuration(rule.Match.Latency)
+		if err != nil {
+			return nil, err
+		}
+		c.latency = &m
+	}
+
+	return c, nil
+}
+
+func compileOptional(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	return regexp.Compile(pattern)
+}
+
+func compileHeaders(in map[string]string) (map[string]*regexp.Regexp, error) {
+	if len(in) == 0 {
+		return nil, nil
+	}
+	out := make(map[string]*regexp.Regexp, len(in))
+	for name, pattern := range in {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		out[name] = re
+	}
+	return out, nil
+}
+
+func (c *compiled) matches(rec model.Record) bool {
+	m := c.rule.Match
+	if m.Method != "" && !strings.EqualFold(m.Method, rec.Request.Method) {
+		return false
+	}
+	if c.path != nil && !c.path.MatchString(requestPath(rec.Request.URL)) {
+		return false
+	}
+	if len(c.status) > 0 && !anyStatus(c.status, rec.Response.Status) {
+		return false
+	}
+	if c.latency != nil && !c.latency.match(rec.Latency) {
+		return false
+	}
+	if !headersMatch(c.reqHdrs, rec.Request.Headers) || !headersMatch(c.respHdrs, rec.Response.Headers) {
+		return false
+	}
+	if c.reqBody != nil && !c.reqBody.Match(rec.Request.Body) {
+		return false
+	}
+	if c.respBody != nil && !c.respBody.Match(rec.Response.Body) {
+		return false
+	}
+	return true
+}
+
+func requestPath(raw string) string {
+	// Strip scheme, host and query so path patterns stay simple
+	if i := strings.Index(raw, "://"); i >= 0 {
+		raw = raw[i+3:]
+		if j := strings.IndexByte(raw, '/'); j >= 0 {
+			raw = raw[j:]
+		} else {
+			raw = "/"
+		}
+	}
+	if i := strings.IndexByte(raw, '?'); i >= 0 {
+		raw = raw[:i]
+	}
+	return raw
+}
+
+func headersMatch(want map[string]*regexp.Regexp, headers map[string][]string) bool {
+	for name, re := range want {
+		found := false
+		for k, values := range headers {
+			if !strings.EqualFold(k, name) {
+				continue
+			}
+			for _, v := range values {
+				if re.MatchString(v) {
+					found = true
+					break
+				}
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// ------------
+
+type statusMatcher struct {
+	lo, hi int
+}
+
+func anyStatus(ms []statusMatcher, status int) bool {
+	for _, m := range ms {
+		if status >= m.lo && status <= m.hi {
+			return true
+		}
+	}
+	return false
+}
+
+func parseStatus(s string) (statusMatcher, error) {
+	// Accepts "401", "4xx", ">=500", ">499", "<400", "<=399" and "500-599"
+	s = strings.TrimSpace(strings.ToLower(s))
+	bad := fmt.Errorf("Invalid status matcher %q", s)
+
+	if len(s) == 3 && strings.HasSuffix(s, "xx") {
+		d, err := strconv.Atoi(s[:1])
+		if err != nil {
+			return statusMatcher{}, bad
+		}
+		return statusMatcher{lo: d * 100, hi: d*100 + 99}, nil
+	}
+	if lo, hi, ok := strings.Cut(s, "-"); ok {
+		l, err1 := strconv.Atoi(strings.TrimSpace(lo))
+		h, err2 := strconv.Atoi(strings.TrimSpace(hi))
+		if err1 != nil || err2 != nil {
+			return statusMatcher{}, bad
+		}
+		return statusMatcher{lo: l, hi: h}, nil
+	}
+
+	op, rest := splitOp(s)
+	n, err := strconv.Atoi(rest)
+	if err != nil {
+		return statusMatcher{}, bad
+	}
+	switch op {
+	case ">=":
+		return statusMatcher{lo: n, hi: 999}, nil
+	case ">":
+		return statusMatcher{lo: n + 1, hi: 999}, nil
+	case "<=":
+		return statusMatcher{lo: 0, hi: n}, nil
+	case "<":
+		return statusMatcher{lo: 0, hi: n - 1}, nil
+	default:
+		return statusMatcher{lo: n, hi: n}, nil
+	}
+}
+
+type durationMatcher struct {
+	op string
+	d  time.Duration
+}
+
+func parseDuration(s string) (durationMatcher, error) {
+	op, rest := splitOp(strings.TrimSpace(s))
+	if op == "" || op == "=" {
+		op = ">="
+	}
+	d, err := time.ParseDuration(rest)
+	if err != nil {
+		return durationMatcher{}, fmt.Errorf("Invalid latency matcher %q: %w", s, err)
+	}
+	return durationMatcher{op: op, d: d}, nil
+}
+
+func (m durationMatcher) match(d time.Duration) bool {
+	switch m.op {
+	case ">":
+		return d > m.d
+	case "<":
+		return d < m.d
+	case "<=":
+		return d <= m.d
+	default:
+		return d >= m.d
+	}
+}
+
+func splitOp(s string) (string, string) {
+	for _, op := range []string{">=", "<=", ">", "<", "="} {
+		if strings.HasPrefix(s, op) {
+			return op, strings.TrimSpace(s[len(op):])
+		}
+	}
+	return "", s
+}
@@ -0,0 +1,190 @@
+package rules_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BarrettBr/RWND/internal/datastore"
+	"github.com/BarrettBr/RWND/internal/model"
+	"github.com/BarrettBr/RWND/internal/rules"
+)
+
+type memStore struct {
+	recs []model.Record
+}
+
+func (s *memStore) Append(rec model.Record) error {
+	s.recs = append(s.recs, rec)
+	return nil
+}
+
+func newRecord(method, url string, status int, latency time.Duration) model.Record {
+	var rec model.Record
+	rec.ID = 1
+	rec.Request.Method = method
+	rec.Request.URL = url
+	rec.Response.Status = status
+	rec.Latency = latency
+	return rec
+}
+
+func TestParse_ReadsRules(t *testing.T) {
+	cfg, err := rules.Parse([]byte(`
+rules:
+  - name: unauthorized
+    match:
+      status: [401, "403"]
+    tags: [auth]
+    log: side.jsonl
+    alert: true
+`))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if len(cfg.Rules) != 1 {
+		t.Fatalf("expected 1 rule, got %d", len(cfg.Rules))
+	}
+	r := cfg.Rules[0]
+	if r.Name != "unauthorized" || len(r.Match.Status) != 2 || r.Match.Status[0] != "401" || !r.Alert || r.Log != "side.jsonl" {
+		t.Fatalf("unexpected rule: %+v", r)
+	}
+}
+
+func TestEngine_Evaluate_Matchers(t *testing.T) {
+	tests := []struct {
+		name  string
+		match rules.Match
+		rec   model.Record
+		want  bool
+	}{
+		{"exact status", rules.Match{Status: []string{"401"}}, newRecord("GET", "http://x/a", 401, 0), true},
+		{"status class", rules.Match{Status: []string{"5xx"}}, newRecord("GET", "http://x/a", 503, 0), true},
+		{"status range miss", rules.Match{Status: []string{"500-599"}}, newRecord("GET", "http://x/a", 404, 0), false},
+		{"status comparison", rules.Match{Status: []string{">=400"}}, newRecord("GET", "http://x/a", 404, 0), true},
+		{"latency over", rules.Match{Latency: ">500ms"}, newRecord("GET", "http://x/a", 200, time.Second), true},
+		{"latency under", rules.Match{Latency: ">500ms"}, newRecord("GET", "http://x/a", 200, 10*time.Millisecond), false},
+		{"method and path", rules.Match{Method: "post", Path: "^/orders/\\d+$"}, newRecord("POST", "http://x/orders/12?x=1", 200, 0), true},
+		{"path miss", rules.Match{Path: "^/orders"}, newRecord("GET", "http://x/users", 200, 0), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := rules.New(rules.Config{Rules: []rules.Rule{{Name: "r", Match: tt.match, Tags: []string{"hit"}}}}, &memStore{})
+			if err != nil {
+				t.Fatalf("New: %v", err)
+			}
+			rec := tt.rec
+			matched := e.Evaluate(&rec)
+			if got := len(matched) == 1; got != tt.want {
+				t.Fatalf("expected match=%v, got %v", tt.want, got)
+			}
+			if rec.HasTag("hit") != tt.want {
+				t.Fatalf("expected tag presence %v, got tags %v", tt.want, rec.Tags)
+			}
+		})
+	}
+}
+
+func TestEngine_Evaluate_HeadersAndBody(t *testing.T) {
+	e, err := rules.New(rules.Config{Rules: []rules.Rule{{
+		Name: "json-error",
+		Match: rules.Match{
+			ResponseHeaders: map[string]string{"content-type": "json"},
+			ResponseBody:    `"error"`,
+		},
+		Tags: []string{"json-error"},
+	}}}, &memStore{})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	rec := newRecord("GET", "http://x/a", 200, 0)
+	rec.Response.Headers = map[string][]string{"Content-Type": {"application/json"}}
+	rec.Response.Body = []byte(`{"error":"boom"}`)
+	if matched := e.Evaluate(&rec); len(matched) != 1 {
+		t.Fatalf("expected header/body rule to match")
+	}
+
+	rec.Response.Body = []byte(`{"ok":true}`)
+	rec.Tags = nil
+	if matched := e.Evaluate(&rec); len(matched) != 0 {
+		t.Fatalf("expected body mismatch to skip rule")
+	}
+}
+
+func TestNew_InvalidMatcher(t *testing.T) {
+	bad := []rules.Match{
+		{Status: []string{"abc"}},
+		{Latency: ">fast"},
+		{Path: "("},
+	}
+	for _, m := range bad {
+		if _, err := rules.New(rules.Config{Rules: []rules.Rule{{Match: m}}}, &memStore{}); err == nil {
+			t.Fatalf("expected error for %+v", m)
+		}
+	}
+}
+
+func TestEngine_Append_TagsSideLogsAndAlerts(t *testing.T) {
+	dir := t.TempDir()
+	sidePath := filepath.Join(dir, "unauthorized.jsonl")
+
+	next := &memStore{}
+	e, err := rules.New(rules.Config{Rules: []rules.Rule{{
+		Name:  "unauthorized",
+		Match: rules.Match{Status: []string{"401"}},
+		Tags:  []string{"auth"},
+		Log:   sidePath,
+		Alert: true,
+	}}}, next)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	var alerts bytes.Buffer
+	e.SetAlertOutput(&alerts)
+
+	if err := e.Append(newRecord("GET", "http://x/a", 401, 0)); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	if err := e.Append(newRecord("GET", "http://x/b", 200, 0)); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	if err := e.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	if len(next.recs) != 2 {
+		t.Fatalf("expected both records forwarded, got %d", len(next.recs))
+	}
+	if !next.recs[0].HasTag("auth") || next.recs[1].HasTag("auth") {
+		t.Fatalf("unexpected tags: %v / %v", next.recs[0].Tags, next.recs[1].Tags)
+	}
+	if !strings.Contains(alerts.String(), "[unauthorized]") || strings.Count(alerts.String(), "\n") != 1 {
+		t.Fatalf("unexpected alerts: %q", alerts.String())
+	}
+
+	if _, err := os.Stat(sidePath); err != nil {
+		t.Fatalf("expected side log: %v", err)
+	}
+	side, err := datastore.NewFileStore(sidePath, 0)
+	if err != nil {
+		t.Fatalf("open side log: %v", err)
+	}
+	defer side.Close()
+
+	recCh, errCh := side.Stream()
+	var got []model.Record
+	for rec := range recCh {
+		got = append(got, rec)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("Stream: %v", err)
+	}
+	if len(got) != 1 || !got[0].HasTag("auth") {
+		t.Fatalf("expected one tagged record in side log, got %+v", got)
+	}
+}
@@ -0,0 +1,269 @@
+package search
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp/syntax"
+	"slices"
+	"strings"
+
+	"github.com/BarrettBr/RWND/internal/datastore"
+	"github.com/BarrettBr/RWND/internal/model"
+)
+
+// indexMagic starts every index file, the last byte is the layout version.
+var indexMagic = []byte("RWNDIDX\x01")
+
+// Index maps every trigram of the searchable text of a log, lower cased, to the
+// records containing it. Records are numbered by their position in the log.
+// A pattern's literal parts must appear in a record for it to match, so the
+// index narrows a search down to the records holding all of their trigrams.
+type Index struct {
+	Records int   // Records indexed
+	Size    int64 // Size of the log when it was indexed, used to spot stale indexes
+
+	grams map[uint32][]uint32 // Trigram -> record numbers, ascending
+	seen  map[uint32]struct{} // Trigrams of the record being added
+}
+
+// NewIndex returns an empty Index for a log of the given size.
+func NewIndex(size int64) *Index {
+	return &Index{Size: size, grams: make(map[uint32][]uint32), seen: make(map[uint32]struct{})}
+}
+
+// Add indexes the next record of the log.
+func (ix *Index) Add(rec model.Record) {
+	n := uint32(ix.Records)
+	ix.Records++
+
+	clear(ix.seen)
+	for _, field := range AllFields {
+		for _, text := range fieldTexts(rec, field) {
+			forEachGram(strings.ToLower(text), func(g uint32) {
+				if _, ok := ix.seen[g]; ok {
+					return
+				}
+				ix.seen[g] = struct{}{}
+				ix.grams[g] = append(ix.grams[g], n)
+			})
+		}
+	}
+}
+
+// Candidates returns the numbers of the records that can match pattern, in order.
+// ok is false when the pattern has no literal text to narrow the search with,
+// every record has to be checked then.
+func (ix *Index) Candidates(pattern string) (recs []uint32, ok bool) {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return nil, false
+	}
+
+	var grams []uint32
+	for _, lit := range requiredLiterals(re.Simplify()) {
+		forEachGram(strings.ToLower(lit), func(g uint32) { grams = append(grams, g) })
+	}
+	if len(grams) == 0 {
+		return nil, false
+	}
+
+	// Intersect the rarest lists first so the working set stays small
+	slices.SortFunc(grams, func(a, b uint32) int { return len(ix.grams[a]) - len(ix.grams[b]) })
+	recs = ix.grams[grams[0]]
+	for _, g := range grams[1:] {
+		if len(recs) == 0 {
+			break
+		}
+		recs = intersect(recs, ix.grams[g])
+	}
+	return recs, true
+}
+
+func intersect(a, b []uint32) []uint32 {
+	var out []uint32
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			out = append(out, a[i])
+			i, j = i+1, j+1
+		}
+	}
+	return out
+}
+
+func forEachGram(text string, fn func(uint32)) {
+	for i := 0; i+3 <= len(text); i++ {
+		fn(uint32(text[i])<<16 | uint32(text[i+1])<<8 | uint32(text[i+2]))
+	}
+}
+
+func requiredLiterals(re *syntax.Regexp) []string {
+	// Returns literal strings every match of re contains. Case folded literals are
+	// only used when they are ASCII, the index lower cases bytes not Unicode.
+	switch re.Op {
+	case syntax.OpLiteral:
+		if re.Flags&syntax.FoldCase != 0 && !isASCII(re.Rune) {
+			return nil
+		}
+		return []string{string(re.Rune)}
+	case syntax.OpCapture, syntax.OpPlus:
+		return requiredLiterals(re.Sub[0])
+	case syntax.OpRepeat:
+		if re.Min >= 1 {
+			return requiredLiterals(re.Sub[0])
+		}
+	case syntax.OpConcat:
+		// Adjacent literals join into one longer run
+		var out []string
+		var run strings.Builder
+		for _, sub := range re.Sub {
+			if sub.Op == syntax.OpLiteral {
+				if lits := requiredLiterals(sub); len(lits) == 1 {
+					run.WriteString(lits[0])
+					continue
+				}
+			}
+			if run.Len() > 0 {
+				out = append(out, run.String())
+				run.Reset()
+			}
+			out = append(out, requiredLiterals(sub)...)
+		}
+		if run.Len() > 0 {
+			out = append(out, run.String())
+		}
+		return out
+	}
+	return nil
+}
+
+func isASCII(runes []rune) bool {
+	for _, r := range runes {
+		if r >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+// ------------
+
+// WriteFile saves the index to path, replacing it atomically. With a key the
+// index is sealed like an encrypted log, its trigrams give away most of the
+// log's text.
+func (ix *Index) WriteFile(path string, key []byte) error {
+	var buf bytes.Buffer
+	buf.Write(indexMagic)
+	buf.Write(binary.AppendUvarint(nil, uint64(ix.Records)))
+	buf.Write(binary.AppendVarint(nil, ix.Size))
+	buf.Write(binary.AppendUvarint(nil, uint64(len(ix.grams))))
+
+	keys := make([]uint32, 0, len(ix.grams))
+	for g := range ix.grams {
+		keys = append(keys, g)
+	}
+	slices.Sort(keys)
+
+	// Each list is delta encoded, record numbers are ascending
+	var scratch []byte
+	for _, g := range keys {
+		recs := ix.grams[g]
+		scratch = binary.BigEndian.AppendUint32(scratch[:0], g)
+		scratch = binary.AppendUvarint(scratch, uint64(len(recs)))
+		prev := uint32(0)
+		for _, r := range recs {
+			scratch = binary.AppendUvarint(scratch, uint64(r-prev))
+			prev = r
+		}
+		buf.Write(scratch)
+	}
+
+	data := buf.Bytes()
+	if key != nil {
+		sealed, err := datastore.Seal(key, data)
+		if err != nil {
+			return err
+		}
+		data = sealed
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// ReadIndex loads an index saved with WriteFile using the same key.
+func ReadIndex(path string, key []byte) (*Index, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if key != nil {
+		if data, err = datastore.Unseal(key, data); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	r := bytes.NewReader(data)
+
+	magic := make([]byte, len(indexMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || !bytes.Equal(magic, indexMagic) {
+		return nil, fmt.Errorf("%s is not an rwnd index", path)
+	}
+
+	bad := func(err error) error {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return fmt.Errorf("%s: corrupt index: %w", path, err)
+	}
+	records, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, bad(err)
+	}
+	size, err := binary.ReadVarint(r)
+	if err != nil {
+		return nil, bad(err)
+	}
+	count, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, bad(err)
+	}
+
+	ix := NewIndex(size)
+	ix.Records = int(records)
+	var gram [4]byte
+	for i := uint64(0); i < count; i++ {
+		if _, err := io.ReadFull(r, gram[:]); err != nil {
+			return nil, bad(err)
+		}
+		n, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, bad(err)
+		}
+		if n > records {
+			return nil, bad(fmt.Errorf("list of %d records in an index of %d", n, records))
+		}
+		recs := make([]uint32, n)
+		prev := uint32(0)
+		for j := range recs {
+			d, err := binary.ReadUvarint(r)
+			if err != nil {
+				return nil, bad(err)
+			}
+			prev += uint32(d)
+			recs[j] = prev
+		}
+		ix.grams[binary.BigEndian.Uint32(gram[:])] = recs
+	}
+	return ix, nil
+}
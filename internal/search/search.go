@@ -0,0 +1,150 @@
+// Package search finds text in recorded requests and responses.
+package search
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/BarrettBr/RWND/internal/model"
+)
+
+// Searchable fields, named like the matching query fields.
+const (
+	FieldURL        = "url"
+	FieldReqHeader  = "req.header"
+	FieldRespHeader = "resp.header"
+	FieldReqBody    = "req.body"
+	FieldRespBody   = "resp.body"
+)
+
+// AllFields is every searchable field, the default.
+var AllFields = []string{FieldURL, FieldReqHeader, FieldRespHeader, FieldReqBody, FieldRespBody}
+
+// Hit describes the matches in one field of a record.
+type Hit struct {
+	Field   string
+	Count   int    // Matches in the field, headers count every value
+	Context string // Text around the first match, on one line
+}
+
+// Searcher looks for a pattern in the chosen fields of records.
+type Searcher struct {
+	re      *regexp.Regexp
+	fields  []string
+	context int
+}
+
+// New returns a Searcher for re over fields, or every field if fields is empty.
+// context is how many bytes around a match Hit.Context keeps on each side.
+func New(re *regexp.Regexp, fields []string, context int) (*Searcher, error) {
+	if len(fields) == 0 {
+		fields = AllFields
+	}
+	for _, f := range fields {
+		if !isField(f) {
+			return nil, fmt.Errorf("Unknown search field %q (use %s)", f, strings.Join(AllFields, ", "))
+		}
+	}
+	return &Searcher{re: re, fields: fields, context: context}, nil
+}
+
+func isField(name string) bool {
+	for _, f := range AllFields {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Search returns a Hit for every field of rec the pattern matches, nil if none do.
+func (s *Searcher) Search(rec model.Record) []Hit {
+	var hits []Hit
+	for _, field := range s.fields {
+		var hit Hit
+		for _, text := range fieldTexts(rec, field) {
+			locs := s.re.FindAllStringIndex(text, -1)
+			if len(locs) == 0 {
+				continue
+			}
+			if hit.Count == 0 {
+				hit.Context = excerpt(text, locs[0][0], locs[0][1], s.context)
+			}
+			hit.Count += len(locs)
+		}
+		if hit.Count > 0 {
+			hit.Field = field
+			hits = append(hits, hit)
+		}
+	}
+	return hits
+}
+
+func fieldTexts(rec model.Record, field string) []string {
+	// Headers are searched as "Name: value", one text per value, in a stable order
+	switch field {
+	case FieldURL:
+		return []string{rec.Request.URL}
+	case FieldReqHeader:
+		return headerTexts(rec.Request.Headers)
+	case FieldRespHeader:
+		return headerTexts(rec.Response.Headers)
+	case FieldReqBody:
+		return []string{string(rec.Request.Body)}
+	case FieldRespBody:
+		return []string{string(rec.Response.Body)}
+	}
+	return nil
+}
+
+func headerTexts(headers map[string][]string) []string {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var out []string
+	for _, name := range names {
+		for _, v := range headers[name] {
+			out = append(out, name+": "+v)
+		}
+	}
+	return out
+}
+
+func excerpt(text string, start, end, context int) string {
+	// Cuts the match with context bytes on each side, on rune boundaries, and flattens it to one line
+	from, to := max(start-context, 0), min(end+context, len(text))
+	for from > 0 && !utf8.RuneStart(text[from]) {
+		from--
+	}
+	for to < len(text) && !utf8.RuneStart(text[to]) {
+		to++
+	}
+
+	var b strings.Builder
+	if from > 0 {
+		b.WriteString("...")
+	}
+	for _, r := range text[from:to] {
+		switch {
+		case r == utf8.RuneError:
+			b.WriteByte('.')
+		case unicode.IsSpace(r):
+			b.WriteByte(' ')
+		case !unicode.IsPrint(r):
+			b.WriteByte('.')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	if to < len(text) {
+		b.WriteString("...")
+	}
+	return b.String()
+}
@@ -0,0 +1,183 @@
+package search_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/BarrettBr/RWND/internal/datastore"
+	"github.com/BarrettBr/RWND/internal/model"
+	"github.com/BarrettBr/RWND/internal/search"
+)
+
+func orderRecord(id uint64, order string) model.Record {
+	rec := model.Record{ID: id}
+	rec.Request.Method = "POST"
+	rec.Request.URL = fmt.Sprintf("http://localhost:3000/orders?customer=%d", id)
+	rec.Request.Headers = map[string][]string{"X-Trace": {fmt.Sprintf("trace-%d", id)}}
+	rec.Request.Body = []byte(fmt.Sprintf(`{"order":%q,"note":"first line\nsecond line"}`, order))
+	rec.Response.Status = 201
+	rec.Response.Body = []byte(fmt.Sprintf(`{"id":%d,"status":"Created"}`, id))
+	return rec
+}
+
+func TestSearcher_Search(t *testing.T) {
+	rec := orderRecord(7, "ORD-991823")
+
+	s, err := search.New(regexp.MustCompile(`ORD-\d+`), nil, 6)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	hits := s.Search(rec)
+	if len(hits) != 1 || hits[0].Field != search.FieldReqBody || hits[0].Count != 1 {
+		t.Fatalf("unexpected hits: %+v", hits)
+	}
+	if hits[0].Context != `...der":"ORD-991823","not...` {
+		t.Fatalf("unexpected context: %q", hits[0].Context)
+	}
+
+	s, _ = search.New(regexp.MustCompile(`line`), []string{search.FieldReqBody}, 100)
+	if hits := s.Search(rec); len(hits) != 1 || hits[0].Count != 2 || strings.Contains(hits[0].Context, "\n") {
+		t.Fatalf("expected two matches on one line, got %+v", hits)
+	}
+
+	s, _ = search.New(regexp.MustCompile(`X-Trace: trace-7`), []string{search.FieldReqHeader}, 0)
+	if hits := s.Search(rec); len(hits) != 1 {
+		t.Fatalf("expected a header match, got %+v", hits)
+	}
+
+	s, _ = search.New(regexp.MustCompile(`Created`), []string{search.FieldReqBody}, 0)
+	if hits := s.Search(rec); hits != nil {
+		t.Fatalf("expected no match outside the chosen fields, got %+v", hits)
+	}
+
+	if _, err := search.New(regexp.MustCompile(`x`), []string{"cookies"}, 0); err == nil {
+		t.Fatalf("expected an error for an unknown field")
+	}
+}
+
+func TestIndex_CandidatesCoverEveryMatch(t *testing.T) {
+	var recs []model.Record
+	ix := search.NewIndex(123)
+	for i := uint64(0); i < 200; i++ {
+		rec := orderRecord(i, fmt.Sprintf("ORD-%06d", i*7919%1000003))
+		recs = append(recs, rec)
+		ix.Add(rec)
+	}
+
+	path := filepath.Join(t.TempDir(), "trigrams")
+	if err := ix.WriteFile(path, nil); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	loaded, err := search.ReadIndex(path, nil)
+	if err != nil {
+		t.Fatalf("ReadIndex: %v", err)
+	}
+	if loaded.Records != 200 || loaded.Size != 123 {
+		t.Fatalf("unexpected index header: %d records, size %d", loaded.Records, loaded.Size)
+	}
+
+	for _, pattern := range []string{
+		`ORD-000000`,
+		`(?i)ord-0079`,
+		`trace-1[0-9]+`,
+		`"status":"created"`,
+		`(?i)"status":"created"`,
+		`customer=19\b`,
+		`nothing-like-this`,
+	} {
+		cands, ok := loaded.Candidates(pattern)
+		if !ok {
+			t.Fatalf("%s: expected the index to narrow the search", pattern)
+		}
+		s, _ := search.New(regexp.MustCompile(pattern), nil, 0)
+		for i, rec := range recs {
+			if s.Search(rec) != nil && !slices.Contains(cands, uint32(i)) {
+				t.Fatalf("%s: record %d matches but isn't a candidate", pattern, i)
+			}
+		}
+	}
+
+	// Trigrams can come from different places in a record, so a few extra candidates are fine
+	if cands, _ := loaded.Candidates(`ORD-000000`); len(cands) == 0 || cands[0] != 0 || len(cands) > 10 {
+		t.Fatalf("expected a handful of candidates for a unique order, got %v", cands)
+	}
+	if _, ok := loaded.Candidates(`a.c|x`); ok {
+		t.Fatalf("expected no narrowing without a literal")
+	}
+}
+
+func TestIndex_EncryptedLogIndexIsSealed(t *testing.T) {
+	t.Setenv("RWND_LOG_KEY", strings.Repeat("ab", datastore.KeySize))
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "001_log.jsonl.enc")
+	store, err := datastore.NewFileStore(logPath, 0)
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	secret := "Bearer s3cr3t-t0ken"
+	rec := orderRecord(1, "ORD-424242")
+	rec.Request.Headers["Authorization"] = []string{secret}
+	if err := store.Append(rec); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	_ = store.Close()
+
+	// Index the log the way grep does, reading it back through the store
+	store, err = datastore.NewFileStore(logPath, 0)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer store.Close()
+	ix := search.NewIndex(1)
+	recs, errCh := store.Stream()
+	for r := range recs {
+		ix.Add(r)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("Stream: %v", err)
+	}
+
+	key, err := datastore.LoadKey()
+	if err != nil {
+		t.Fatalf("LoadKey: %v", err)
+	}
+	path := filepath.Join(dir, "trigrams")
+	if err := ix.WriteFile(path, key); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	for _, text := range []string{secret, "ORD-424242", "first line", "Created"} {
+		if bytes.Contains(data, []byte(text)) {
+			t.Fatalf("index holds %q in plaintext", text)
+		}
+		// Trigrams are stored as 4 byte keys, none of them may show up either
+		lower := strings.ToLower(text)
+		for i := 0; i+3 <= len(lower); i++ {
+			gram := binary.BigEndian.AppendUint32(nil, uint32(lower[i])<<16|uint32(lower[i+1])<<8|uint32(lower[i+2]))
+			if bytes.Contains(data, gram) {
+				t.Fatalf("index holds the trigram %q of %q in plaintext", lower[i:i+3], text)
+			}
+		}
+	}
+
+	if _, err := search.ReadIndex(path, nil); err == nil {
+		t.Fatalf("expected a sealed index to be unreadable without the key")
+	}
+	loaded, err := search.ReadIndex(path, key)
+	if err != nil {
+		t.Fatalf("ReadIndex: %v", err)
+	}
+	if cands, ok := loaded.Candidates(`s3cr3t`); !ok || !slices.Equal(cands, []uint32{0}) {
+		t.Fatalf("expected the sealed index to still narrow searches, got %v %v", cands, ok)
+	}
+}
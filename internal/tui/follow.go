@@ -0,0 +1,173 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/BarrettBr/RWND/internal/app"
+	rwndmodel "github.com/BarrettBr/RWND/internal/model"
+	"github.com/BarrettBr/RWND/internal/query"
+)
+
+// maxFollowRecords caps how many records the live view keeps in memory.
+const maxFollowRecords = 1000
+
+type recordMsg rwndmodel.Record
+
+type streamEndMsg struct{ err error }
+
+type followModel struct {
+	title  string
+	recCh  <-chan rwndmodel.Record
+	errCh  <-chan error
+	recs   []rwndmodel.Record
+	total  int
+	height int
+	paused bool
+	ended  bool
+	err    error
+
+	filter    *query.Filter // Narrows the records shown, / edits it
+	editing   bool
+	input     string
+	filterErr error
+}
+
+func waitForRecord(recCh <-chan rwndmodel.Record, errCh <-chan error) tea.Cmd {
+	return func() tea.Msg {
+		rec, ok := <-recCh
+		if !ok {
+			return streamEndMsg{err: <-errCh}
+		}
+		return recordMsg(rec)
+	}
+}
+
+func (m followModel) Init() tea.Cmd {
+	return waitForRecord(m.recCh, m.errCh)
+}
+
+func (m followModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		if m.editing {
+			return m.editFilter(msg), nil
+		}
+		switch msg.String() {
+		case "q", "esc", "ctrl+c":
+			return m, tea.Quit
+		case "p", " ":
+			m.paused = !m.paused
+		case "c":
+			m.recs = nil
+		case "/":
+			m.editing, m.input = true, ""
+			if m.filter != nil {
+				m.input = m.filter.String()
+			}
+		}
+	case tea.WindowSizeMsg:
+		m.height = msg.Height
+	case recordMsg:
+		m.total++
+		// While paused records are counted but the view stays put
+		if !m.paused {
+			m.recs = append(m.recs, rwndmodel.Record(msg))
+			if len(m.recs) > maxFollowRecords {
+				m.recs = m.recs[len(m.recs)-maxFollowRecords:]
+			}
+		}
+		return m, waitForRecord(m.recCh, m.errCh)
+	case streamEndMsg:
+		m.ended, m.err = true, msg.err
+	}
+	return m, nil
+}
+
+func (m followModel) editFilter(msg tea.KeyMsg) followModel {
+	// Typing a filter: enter applies it to kept and new records, esc leaves it as it was
+	switch msg.Type {
+	case tea.KeyEnter:
+		m.editing, m.filterErr = false, nil
+		if strings.TrimSpace(m.input) == "" {
+			m.filter = nil
+			return m
+		}
+		f, err := query.Parse(m.input)
+		if err != nil {
+			m.filterErr = err
+			return m
+		}
+		m.filter = f
+	case tea.KeyEsc, tea.KeyCtrlC:
+		m.editing = false
+	case tea.KeyBackspace:
+		if r := []rune(m.input); len(r) > 0 {
+			m.input = string(r[:len(r)-1])
+		}
+	case tea.KeySpace:
+		m.input += " "
+	case tea.KeyRunes:
+		m.input += string(msg.Runes)
+	}
+	return m
+}
+
+func (m followModel) View() string {
+	var b strings.Builder
+
+	state := "live"
+	switch {
+	case m.err != nil:
+		state = "error: " + m.err.Error()
+	case m.ended:
+		state = "stopped"
+	case m.paused:
+		state = "paused"
+	}
+	fmt.Fprintf(&b, "RWND tail  %s  (%d records, %s)\n", m.title, m.total, state)
+	switch {
+	case m.editing:
+		fmt.Fprintf(&b, "filter: %s_\n", m.input)
+	case m.filterErr != nil:
+		fmt.Fprintf(&b, "filter: %v\n", m.filterErr)
+	case m.filter != nil:
+		fmt.Fprintf(&b, "filter: %s\n", m.filter)
+	default:
+		b.WriteByte('\n')
+	}
+
+	var lines []string
+	for _, rec := range m.recs {
+		if m.filter == nil || m.filter.Match(rec) {
+			lines = append(lines, app.FormatRecordLine(rec))
+		}
+	}
+	// Keep the header and footer on screen, show the newest lines that fit
+	if room := m.height - 4; m.height > 0 && room > 0 && len(lines) > room {
+		lines = lines[len(lines)-room:]
+	}
+	for _, line := range lines {
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+
+	b.WriteString("\nq quit  p pause  c clear  / filter\n")
+	return b.String()
+}
+
+// RunFollow shows a live stream of records, such as one from app.FollowRecords,
+// until the user quits or the stream ends with an error.
+func RunFollow(title string, recCh <-chan rwndmodel.Record, errCh <-chan error) error {
+	p := tea.NewProgram(followModel{title: title, recCh: recCh, errCh: errCh}, tea.WithAltScreen())
+	final, err := p.Run()
+	if err != nil {
+		return err
+	}
+	if m, ok := final.(followModel); ok {
+		return m.err
+	}
+	return nil
+}
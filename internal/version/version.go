@@ -0,0 +1,20 @@
+// Package version reports the RWND build version.
+package version
+
+import "runtime/debug"
+
+// Version can be set at build time with
+//
+//	go build -ldflags "-X github.com/BarrettBr/RWND/internal/version.Version=v1.2.3" ./cmd/rwnd
+var Version = ""
+
+// String returns the build version, falling back to the module version and then "dev".
+func String() string {
+	if Version != "" {
+		return Version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	return "dev"
+}